@@ -70,12 +70,12 @@ func NewEntrypoint(logger *server.Logger, cfg *config.Config, reloader Reloader)
 		return nil, err
 	}
 
-	ep.lokiLogs, err = logs.New(prometheus.DefaultRegisterer, cfg.Logs, logger)
+	ep.lokiLogs, err = logs.New(prometheus.DefaultRegisterer, cfg.Logs, ep.promMetrics, logger)
 	if err != nil {
 		return nil, err
 	}
 
-	ep.tempoTraces, err = traces.New(ep.lokiLogs, ep.promMetrics.InstanceManager(), prometheus.DefaultRegisterer, cfg.Traces, cfg.Server.LogLevel.Logrus, cfg.Server.LogFormat)
+	ep.tempoTraces, err = traces.New(ep.lokiLogs, ep.promMetrics.InstanceManager(), ep.promMetrics, prometheus.DefaultRegisterer, cfg.Traces, cfg.Server.LogLevel.Logrus, cfg.Server.LogFormat)
 	if err != nil {
 		return nil, err
 	}
@@ -123,6 +123,7 @@ func (ep *Entrypoint) createIntegrationsGlobals(cfg *config.Config) (config.Inte
 			Scheme: "http",
 			Host:   cfg.Server.Flags.HTTP.InMemoryAddr,
 		},
+		AgentLogger: ep.log,
 	}, nil
 }
 
@@ -184,6 +185,7 @@ func (ep *Entrypoint) wire(mux *mux.Router, grpc *grpc.Server) {
 
 	ep.integrations.WireAPI(mux)
 	ep.lokiLogs.WireAPI(mux)
+	ep.tempoTraces.WireAPI(mux)
 
 	mux.HandleFunc("/-/healthy", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
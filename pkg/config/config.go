@@ -184,6 +184,25 @@ func (c *Config) Validate(fs *flag.FlagSet) error {
 		return err
 	}
 
+	// Deduplicating logs in ring mode uses the scraping service ring.
+	if c.Logs != nil && !c.Metrics.ServiceConfig.Enabled {
+		for _, ic := range c.Logs.Configs {
+			if ic.Dedup != nil && ic.Dedup.Mode == logs.DedupModeRing {
+				return fmt.Errorf("logs config %q uses %q dedup mode, which requires metrics.scraping_service to be enabled", ic.Name, logs.DedupModeRing)
+			}
+		}
+	}
+
+	// The cluster load balancing resolver finds agents using the scraping
+	// service ring.
+	if !c.Metrics.ServiceConfig.Enabled {
+		for _, tc := range c.Traces.Configs {
+			if tc.UsesClusterResolver() {
+				return fmt.Errorf("traces config %q uses the cluster load balancing resolver, which requires metrics.scraping_service to be enabled", tc.Name)
+			}
+		}
+	}
+
 	c.Metrics.ServiceConfig.APIEnableGetConfiguration = c.EnableConfigEndpoints
 
 	// Don't validate flags if there's no FlagSet. Used for testing.
@@ -328,6 +328,30 @@ traces:
 	}
 }
 
+func TestConfig_TracesClusterResolverRequiresScrapingService(t *testing.T) {
+	cfg := `
+traces:
+  configs:
+  - name: default
+    receivers:
+      jaeger:
+        protocols:
+          thrift_compact:
+    remote_write:
+    - endpoint: 127.0.0.1:80
+    load_balancing:
+      exporter:
+        insecure: true
+      resolver:
+        cluster:`
+
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	_, err := load(fs, []string{"-config.file", "test"}, func(_, _ string, _ bool, c *Config) error {
+		return LoadBytes([]byte(cfg), false, c)
+	})
+	require.EqualError(t, err, `error in config file: traces config "default" uses the cluster load balancing resolver, which requires metrics.scraping_service to be enabled`)
+}
+
 func TestConfig_TempoNameMigration(t *testing.T) {
 	input := util.Untab(`
 tempo:
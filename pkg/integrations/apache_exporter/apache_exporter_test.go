@@ -0,0 +1,185 @@
+package apache_exporter //nolint:golint
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+const testStatus = `localhost
+ServerVersion: Apache/2.4.41 (Ubuntu)
+ServerMPM: event
+Server Built: 2022-01-05T14:49:56
+CurrentTime: Monday, 14-Mar-2022 10:12:45 UTC
+RestartTime: Monday, 14-Mar-2022 09:12:45 UTC
+ParentServerConfigGeneration: 1
+ParentServerMPMGeneration: 0
+ServerUptimeSeconds: 3600
+ServerUptime: 1 hour
+Load1: 0.50
+Load5: 0.25
+Load15: 0.10
+Total Accesses: 1234
+Total kBytes: 5678
+Total Duration: 910
+CPUUser: .5
+CPUSystem: .25
+CPUChildrenUser: 0
+CPUChildrenSystem: 0
+CPULoad: .0208333
+Uptime: 3600
+ReqPerSec: .342778
+BytesPerSec: 1615.08
+BytesPerReq: 4711.78
+DurationPerReq: .737439
+BusyWorkers: 2
+IdleWorkers: 48
+Processes: 2
+Stopping: 0
+ConnsTotal: 3
+ConnsAsyncWriting: 0
+ConnsAsyncKeepAlive: 1
+ConnsAsyncClosing: 0
+Scoreboard: __W_K_R_____________________________________S_____......
+`
+
+func TestConfig_Unmarshal(t *testing.T) {
+	var c Config
+	err := yaml.UnmarshalStrict([]byte(`scrape_uri: http://web-1:8080/server-status?auto`), &c)
+	require.NoError(t, err)
+	require.Equal(t, DefaultConfig.Timeout, c.Timeout)
+
+	key, err := c.InstanceKey("agent:12345")
+	require.NoError(t, err)
+	require.Equal(t, "web-1:8080", key)
+}
+
+func TestCollector(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testStatus))
+	}))
+	defer srv.Close()
+
+	c := newCollector(log.NewNopLogger(), srv.Client(), srv.URL, time.Second)
+
+	expect := `
+# HELP apache_accesses_total Total requests served.
+# TYPE apache_accesses_total counter
+apache_accesses_total 1234
+# HELP apache_connections Number of connections by state.
+# TYPE apache_connections gauge
+apache_connections{state="closing"} 0
+apache_connections{state="keepalive"} 1
+apache_connections{state="total"} 3
+apache_connections{state="writing"} 0
+# HELP apache_cpuload Percentage of CPU used by the Apache server.
+# TYPE apache_cpuload gauge
+apache_cpuload 0.0208333
+# HELP apache_duration_ms_total Total time spent serving requests, in milliseconds.
+# TYPE apache_duration_ms_total counter
+apache_duration_ms_total 910
+# HELP apache_info Information about the Apache server.
+# TYPE apache_info gauge
+apache_info{mpm="event",version="Apache/2.4.41 (Ubuntu)"} 1
+# HELP apache_load System load averages.
+# TYPE apache_load gauge
+apache_load{interval="15min"} 0.1
+apache_load{interval="1min"} 0.5
+apache_load{interval="5min"} 0.25
+# HELP apache_processes Number of server processes by state.
+# TYPE apache_processes gauge
+apache_processes{state="all"} 2
+apache_processes{state="stopping"} 0
+# HELP apache_scoreboard Number of worker slots by scoreboard state.
+# TYPE apache_scoreboard gauge
+apache_scoreboard{state="closing"} 0
+apache_scoreboard{state="dns"} 0
+apache_scoreboard{state="graceful_stop"} 0
+apache_scoreboard{state="idle"} 46
+apache_scoreboard{state="idle_cleanup"} 0
+apache_scoreboard{state="keepalive"} 1
+apache_scoreboard{state="logging"} 0
+apache_scoreboard{state="open_slot"} 6
+apache_scoreboard{state="read"} 1
+apache_scoreboard{state="reply"} 1
+apache_scoreboard{state="startup"} 1
+# HELP apache_sent_kilobytes_total Total kilobytes sent.
+# TYPE apache_sent_kilobytes_total counter
+apache_sent_kilobytes_total 5678
+# HELP apache_up Whether the mod_status page of Apache could be scraped.
+# TYPE apache_up gauge
+apache_up 1
+# HELP apache_uptime_seconds_total Time the Apache server has been running.
+# TYPE apache_uptime_seconds_total counter
+apache_uptime_seconds_total 3600
+# HELP apache_workers Number of workers by state.
+# TYPE apache_workers gauge
+apache_workers{state="busy"} 2
+apache_workers{state="idle"} 48
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expect)))
+}
+
+func TestCollector_Minimal(t *testing.T) {
+	// Without ExtendedStatus, only a few fields are reported.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("BusyWorkers: 1\nIdleWorkers: 2\nScoreboard: W__\n"))
+	}))
+	defer srv.Close()
+
+	c := newCollector(log.NewNopLogger(), srv.Client(), srv.URL, time.Second)
+
+	expect := `
+# HELP apache_up Whether the mod_status page of Apache could be scraped.
+# TYPE apache_up gauge
+apache_up 1
+# HELP apache_workers Number of workers by state.
+# TYPE apache_workers gauge
+apache_workers{state="busy"} 1
+apache_workers{state="idle"} 2
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expect), "apache_up", "apache_workers"))
+}
+
+func TestCollector_Down(t *testing.T) {
+	tt := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{
+			name: "error status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+			},
+		},
+		{
+			name: "missing ?auto",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("<html><h1>Apache Server Status for localhost</h1></html>"))
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(tc.handler)
+			defer srv.Close()
+
+			c := newCollector(log.NewNopLogger(), srv.Client(), srv.URL, time.Second)
+
+			expect := `
+# HELP apache_up Whether the mod_status page of Apache could be scraped.
+# TYPE apache_up gauge
+apache_up 0
+`
+			require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expect)))
+		})
+	}
+}
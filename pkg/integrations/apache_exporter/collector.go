@@ -0,0 +1,203 @@
+package apache_exporter //nolint:golint
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scoreboardStates maps the characters of the mod_status scoreboard to the
+// state label of apache_scoreboard.
+var scoreboardStates = []struct {
+	char  rune
+	state string
+}{
+	{'_', "idle"},
+	{'S', "startup"},
+	{'R', "read"},
+	{'W', "reply"},
+	{'K', "keepalive"},
+	{'D', "dns"},
+	{'C', "closing"},
+	{'L', "logging"},
+	{'G', "graceful_stop"},
+	{'I', "idle_cleanup"},
+	{'.', "open_slot"},
+}
+
+// parseStatus parses the machine-readable mod_status page into a map of
+// keys to values. Lines which aren't key: value pairs, like the server name
+// on the first line, are ignored.
+func parseStatus(r io.Reader) (map[string]string, error) {
+	status := make(map[string]string)
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		key, value, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue
+		}
+		status[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	// Every version of mod_status reports the scoreboard, so use it to detect
+	// pages which aren't from mod_status or are missing ?auto.
+	if _, ok := status["Scoreboard"]; !ok {
+		return nil, fmt.Errorf("invalid mod_status page: scoreboard not found")
+	}
+	return status, nil
+}
+
+// collector scrapes the mod_status page of Apache on every collection.
+type collector struct {
+	log       log.Logger
+	client    *http.Client
+	scrapeURI string
+	timeout   time.Duration
+
+	up          *prometheus.Desc
+	info        *prometheus.Desc
+	uptime      *prometheus.Desc
+	accesses    *prometheus.Desc
+	sentKBytes  *prometheus.Desc
+	durationMs  *prometheus.Desc
+	cpuLoad     *prometheus.Desc
+	load        *prometheus.Desc
+	workers     *prometheus.Desc
+	processes   *prometheus.Desc
+	connections *prometheus.Desc
+	scoreboard  *prometheus.Desc
+}
+
+var _ prometheus.Collector = (*collector)(nil)
+
+func newCollector(l log.Logger, client *http.Client, scrapeURI string, timeout time.Duration) *collector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc("apache_"+name, help, labels, nil)
+	}
+
+	return &collector{
+		log:       l,
+		client:    client,
+		scrapeURI: scrapeURI,
+		timeout:   timeout,
+
+		up:          desc("up", "Whether the mod_status page of Apache could be scraped."),
+		info:        desc("info", "Information about the Apache server.", "version", "mpm"),
+		uptime:      desc("uptime_seconds_total", "Time the Apache server has been running."),
+		accesses:    desc("accesses_total", "Total requests served."),
+		sentKBytes:  desc("sent_kilobytes_total", "Total kilobytes sent."),
+		durationMs:  desc("duration_ms_total", "Total time spent serving requests, in milliseconds."),
+		cpuLoad:     desc("cpuload", "Percentage of CPU used by the Apache server."),
+		load:        desc("load", "System load averages.", "interval"),
+		workers:     desc("workers", "Number of workers by state.", "state"),
+		processes:   desc("processes", "Number of server processes by state.", "state"),
+		connections: desc("connections", "Number of connections by state.", "state"),
+		scoreboard:  desc("scoreboard", "Number of worker slots by scoreboard state.", "state"),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.up
+	ch <- c.info
+	ch <- c.uptime
+	ch <- c.accesses
+	ch <- c.sentKBytes
+	ch <- c.durationMs
+	ch <- c.cpuLoad
+	ch <- c.load
+	ch <- c.workers
+	ch <- c.processes
+	ch <- c.connections
+	ch <- c.scoreboard
+}
+
+// Collect implements prometheus.Collector.
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	status, err := c.scrape()
+	if err != nil {
+		level.Error(c.log).Log("msg", "failed to scrape Apache", "uri", c.scrapeURI, "err", err)
+		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 0)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 1)
+
+	// Most fields are only reported with ExtendedStatus enabled or by some
+	// MPMs, so fields which are missing or invalid are skipped.
+	emit := func(desc *prometheus.Desc, vt prometheus.ValueType, key string, labels ...string) {
+		raw, ok := status[key]
+		if !ok {
+			return
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			level.Debug(c.log).Log("msg", "ignoring invalid mod_status field", "field", key, "value", raw)
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(desc, vt, v, labels...)
+	}
+
+	if version, ok := status["ServerVersion"]; ok {
+		ch <- prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1, version, status["ServerMPM"])
+	}
+
+	emit(c.uptime, prometheus.CounterValue, "ServerUptimeSeconds")
+	emit(c.accesses, prometheus.CounterValue, "Total Accesses")
+	emit(c.sentKBytes, prometheus.CounterValue, "Total kBytes")
+	emit(c.durationMs, prometheus.CounterValue, "Total Duration")
+	emit(c.cpuLoad, prometheus.GaugeValue, "CPULoad")
+
+	emit(c.load, prometheus.GaugeValue, "Load1", "1min")
+	emit(c.load, prometheus.GaugeValue, "Load5", "5min")
+	emit(c.load, prometheus.GaugeValue, "Load15", "15min")
+
+	emit(c.workers, prometheus.GaugeValue, "BusyWorkers", "busy")
+	emit(c.workers, prometheus.GaugeValue, "IdleWorkers", "idle")
+
+	emit(c.processes, prometheus.GaugeValue, "Processes", "all")
+	emit(c.processes, prometheus.GaugeValue, "Stopping", "stopping")
+
+	emit(c.connections, prometheus.GaugeValue, "ConnsTotal", "total")
+	emit(c.connections, prometheus.GaugeValue, "ConnsAsyncWriting", "writing")
+	emit(c.connections, prometheus.GaugeValue, "ConnsAsyncKeepAlive", "keepalive")
+	emit(c.connections, prometheus.GaugeValue, "ConnsAsyncClosing", "closing")
+
+	scoreboard := status["Scoreboard"]
+	for _, s := range scoreboardStates {
+		count := strings.Count(scoreboard, string(s.char))
+		ch <- prometheus.MustNewConstMetric(c.scoreboard, prometheus.GaugeValue, float64(count), s.state)
+	}
+}
+
+func (c *collector) scrape() (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.scrapeURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return parseStatus(resp.Body)
+}
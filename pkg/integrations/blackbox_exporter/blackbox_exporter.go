@@ -0,0 +1,136 @@
+// Package blackbox_exporter embeds https://github.com/prometheus/blackbox_exporter
+// to probe targets over HTTP, TCP, DNS, ICMP and gRPC.
+package blackbox_exporter //nolint:golint
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/agent/pkg/integrations"
+	integrations_v2 "github.com/grafana/agent/pkg/integrations/v2"
+	blackbox_config "github.com/prometheus/blackbox_exporter/config"
+	"github.com/prometheus/common/model"
+)
+
+var (
+	// DefaultModules are modules which are available without being declared.
+	// Modules with the same name in the config override them.
+	DefaultModules = map[string]blackbox_config.Module{
+		"http_2xx": {
+			Prober:  "http",
+			Timeout: 5 * time.Second,
+			HTTP:    blackbox_config.DefaultHTTPProbe,
+		},
+		"tcp_connect": {
+			Prober:  "tcp",
+			Timeout: 5 * time.Second,
+			TCP:     blackbox_config.DefaultTCPProbe,
+		},
+		"icmp": {
+			Prober:  "icmp",
+			Timeout: 5 * time.Second,
+			ICMP:    blackbox_config.DefaultICMPProbe,
+		},
+	}
+)
+
+// targetNameRegexp matches valid target names. Names are used in the
+// metrics path of the target.
+var targetNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// Config controls the blackbox_exporter integration.
+type Config struct {
+	// Modules to probe targets with, by name. Modules use the format of the
+	// blackbox_exporter config file.
+	Modules map[string]blackbox_config.Module `yaml:"modules,omitempty"`
+	// Targets to probe.
+	Targets []Target `yaml:"targets,omitempty"`
+
+	// LogFailures sends a log line to a logs instance for every failed probe.
+	// Only supported by integrations-next.
+	LogFailures bool `yaml:"log_failures,omitempty"`
+	// LogsInstance is the logs instance failures are sent to. Defaults to the
+	// logs instance of integrations-next.
+	LogsInstance string `yaml:"logs_instance,omitempty"`
+}
+
+// Target is a target to probe.
+type Target struct {
+	// Name of the target, used as the value of the target label.
+	Name string `yaml:"name"`
+	// Address to probe. Its format depends on the prober of the module.
+	Address string `yaml:"address"`
+	// Module to probe the target with.
+	Module string `yaml:"module"`
+	// Labels to add to the metrics of the target.
+	Labels model.LabelSet `yaml:"labels,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler for Config.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = Config{}
+
+	type plain Config
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.validate()
+}
+
+func (c *Config) validate() error {
+	for name, m := range c.Modules {
+		if _, ok := probers[m.Prober]; !ok {
+			return fmt.Errorf("module %s has unknown prober %q", name, m.Prober)
+		}
+	}
+
+	names := make(map[string]struct{}, len(c.Targets))
+	for i, t := range c.Targets {
+		if !targetNameRegexp.MatchString(t.Name) {
+			return fmt.Errorf("target at index %d has invalid name %q", i, t.Name)
+		}
+		if _, exist := names[t.Name]; exist {
+			return fmt.Errorf("found multiple targets named %s", t.Name)
+		}
+		names[t.Name] = struct{}{}
+
+		if t.Address == "" {
+			return fmt.Errorf("target %s is missing an address", t.Name)
+		}
+		if _, ok := c.module(t.Module); !ok {
+			return fmt.Errorf("target %s uses unknown module %q", t.Name, t.Module)
+		}
+	}
+	return nil
+}
+
+// module returns the module called name, falling back to DefaultModules.
+func (c *Config) module(name string) (blackbox_config.Module, bool) {
+	if m, ok := c.Modules[name]; ok {
+		return m, true
+	}
+	m, ok := DefaultModules[name]
+	return m, ok
+}
+
+// Name returns the name of the integration that this config is for.
+func (c *Config) Name() string {
+	return "blackbox_exporter"
+}
+
+// InstanceKey returns the agentKey, since targets are probed from the agent.
+func (c *Config) InstanceKey(agentKey string) (string, error) {
+	return agentKey, nil
+}
+
+// NewIntegration converts this config into an instance of an integration.
+func (c *Config) NewIntegration(l log.Logger) (integrations.Integration, error) {
+	return New(l, c)
+}
+
+func init() {
+	integrations.RegisterIntegration(&Config{})
+	integrations_v2.RegisterLegacy(&Config{}, integrations_v2.TypeMultiplex, upgrade)
+}
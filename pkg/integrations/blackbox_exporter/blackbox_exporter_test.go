@@ -0,0 +1,242 @@
+package blackbox_exporter //nolint:golint
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-logfmt/logfmt"
+	"github.com/grafana/agent/pkg/util"
+	"github.com/miekg/dns"
+	blackbox_config "github.com/prometheus/blackbox_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConfig_Unmarshal(t *testing.T) {
+	cfgText := util.Untab(`
+modules:
+  http_post:
+    prober: http
+    http:
+      method: POST
+      fail_if_body_not_matches_regexp: ["ok"]
+  dns_a:
+    prober: dns
+    dns:
+      query_name: grafana.com
+      query_type: A
+targets:
+- name: grafana
+  address: https://grafana.com
+  module: http_2xx
+- name: api
+  address: https://api.example.com
+  module: http_post
+  labels:
+    team: api
+	`)
+
+	var cfg Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(cfgText), &cfg))
+
+	m, ok := cfg.module("http_post")
+	require.True(t, ok)
+	require.Equal(t, "POST", m.HTTP.Method)
+	require.True(t, m.HTTP.IPProtocolFallback, "defaults of blackbox_exporter should be applied")
+	require.Equal(t, "ok", m.HTTP.FailIfBodyNotMatchesRegexp[0].String())
+
+	m, ok = cfg.module("dns_a")
+	require.True(t, ok)
+	require.True(t, m.DNS.Recursion)
+
+	_, ok = cfg.module("http_2xx")
+	require.True(t, ok, "default modules should be available")
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tt := []struct {
+		name   string
+		cfg    string
+		expect string
+	}{
+		{
+			name: "unknown prober",
+			cfg: `
+modules:
+  test: {prober: ftp}`,
+			expect: `module test has unknown prober "ftp"`,
+		},
+		{
+			name: "missing query name",
+			cfg: `
+modules:
+  test: {prober: dns, dns: {query_type: A}}`,
+			expect: "query name must be set for DNS module",
+		},
+		{
+			name: "invalid target name",
+			cfg: `
+targets:
+- {name: "a/b", address: localhost, module: icmp}`,
+			expect: `target at index 0 has invalid name "a/b"`,
+		},
+		{
+			name: "duplicate target",
+			cfg: `
+targets:
+- {name: a, address: localhost, module: icmp}
+- {name: a, address: localhost, module: icmp}`,
+			expect: "found multiple targets named a",
+		},
+		{
+			name: "unknown module",
+			cfg: `
+targets:
+- {name: a, address: localhost, module: missing}`,
+			expect: `target a uses unknown module "missing"`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var cfg Config
+			err := yaml.UnmarshalStrict([]byte(tc.cfg), &cfg)
+			require.EqualError(t, err, tc.expect)
+		})
+	}
+}
+
+func TestProbeTimeout(t *testing.T) {
+	m := DefaultModules["http_2xx"]
+
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	require.Equal(t, 5*time.Second, probeTimeout(r, m))
+
+	r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "3")
+	require.Equal(t, 2500*time.Millisecond, probeTimeout(r, m))
+
+	m.Timeout = 0
+	r.Header.Del("X-Prometheus-Scrape-Timeout-Seconds")
+	require.Equal(t, defaultProbeTimeout-probeTimeoutOffset, probeTimeout(r, m))
+}
+
+func TestProbeHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = rw.Write([]byte("status: ok"))
+	}))
+	defer srv.Close()
+
+	m := DefaultModules["http_2xx"]
+
+	reg, success := probe(context.Background(), Target{Address: srv.URL}, m, m.Timeout, log.NewNopLogger())
+	require.True(t, success)
+	requireGauge(t, reg, "probe_success", 1)
+	requireGauge(t, reg, "probe_http_status_code", 200)
+
+	reg, success = probe(context.Background(), Target{Address: srv.URL + "/fail"}, m, m.Timeout, log.NewNopLogger())
+	require.False(t, success)
+	requireGauge(t, reg, "probe_success", 0)
+	requireGauge(t, reg, "probe_http_status_code", 500)
+
+	m.HTTP.FailIfBodyMatchesRegexp = []blackbox_config.Regexp{blackbox_config.MustNewRegexp("ok")}
+	pl := newProbeLogger(log.NewNopLogger(), Target{Name: "test", Module: "http_2xx"})
+	_, success = probe(context.Background(), Target{Address: srv.URL}, m, m.Timeout, pl)
+	require.False(t, success)
+	reason, err := logfmt.MarshalKeyvals(pl.Reason()...)
+	require.NoError(t, err)
+	require.Equal(t, `msg="Body matched regular expression" regexp=ok`, string(reason))
+}
+
+func TestProbeTCP(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := lis.Addr().String()
+
+	reg, success := probe(context.Background(), Target{Address: addr}, DefaultModules["tcp_connect"], 5*time.Second, log.NewNopLogger())
+	require.True(t, success)
+	requireGauge(t, reg, "probe_success", 1)
+	requireGauge(t, reg, "probe_ip_protocol", 4)
+
+	require.NoError(t, lis.Close())
+	_, success = probe(context.Background(), Target{Address: addr}, DefaultModules["tcp_connect"], 5*time.Second, log.NewNopLogger())
+	require.False(t, success)
+}
+
+func TestProbeDNS(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := &dns.Server{
+		PacketConn: pc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			resp := new(dns.Msg)
+			resp.SetReply(req)
+			if req.Question[0].Name != "grafana.com." {
+				resp.SetRcode(req, dns.RcodeNameError)
+			} else {
+				rr, _ := dns.NewRR("grafana.com. 60 IN A 127.0.0.1")
+				resp.Answer = append(resp.Answer, rr)
+			}
+			_ = w.WriteMsg(resp)
+		}),
+	}
+	go func() { _ = srv.ActivateAndServe() }()
+	defer func() { _ = srv.Shutdown() }()
+
+	m := blackbox_config.Module{Prober: "dns", Timeout: 5 * time.Second, DNS: blackbox_config.DefaultDNSProbe}
+	m.DNS.QueryName = "grafana.com"
+	m.DNS.QueryType = "A"
+
+	reg, success := probe(context.Background(), Target{Address: pc.LocalAddr().String()}, m, m.Timeout, log.NewNopLogger())
+	require.True(t, success)
+	requireGauge(t, reg, "probe_success", 1)
+	requireGauge(t, reg, "probe_dns_answer_rrs", 1)
+
+	m.DNS.QueryName = "example.com"
+	_, success = probe(context.Background(), Target{Address: pc.LocalAddr().String()}, m, m.Timeout, log.NewNopLogger())
+	require.False(t, success)
+}
+
+func TestIntegration_MetricsHandler(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	cfg := &Config{Targets: []Target{
+		{Name: "up", Address: srv.URL, Module: "http_2xx", Labels: model.LabelSet{"team": "a"}},
+		{Name: "down", Address: "127.0.0.1:1", Module: "tcp_connect"},
+	}}
+	i, err := New(log.NewNopLogger(), cfg)
+	require.NoError(t, err)
+	h, err := i.MetricsHandler()
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	require.Contains(t, rr.Body.String(), `probe_success{module="http_2xx",target="up",team="a"} 1`)
+	require.Contains(t, rr.Body.String(), `probe_success{module="tcp_connect",target="down"} 0`)
+}
+
+func requireGauge(t *testing.T, g prometheus.Gatherer, name string, expect float64) {
+	t.Helper()
+
+	families, err := g.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() == name {
+			require.Equal(t, expect, f.GetMetric()[0].GetGauge().GetValue(), name)
+			return
+		}
+	}
+	require.Fail(t, "metric not found", name)
+}
@@ -0,0 +1,73 @@
+package blackbox_exporter //nolint:golint
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/agent/pkg/integrations"
+	"github.com/grafana/agent/pkg/integrations/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Integration is the blackbox_exporter integration. Every target is probed
+// when the integration is scraped, with the metrics of each target having a
+// target and module label.
+type Integration struct {
+	log log.Logger
+	cfg *Config
+}
+
+// New creates a new blackbox_exporter integration.
+func New(l log.Logger, c *Config) (integrations.Integration, error) {
+	return &Integration{log: l, cfg: c}, nil
+}
+
+// MetricsHandler implements integrations.Integration.
+func (i *Integration) MetricsHandler() (http.Handler, error) {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var (
+			wg   sync.WaitGroup
+			regs = make(prometheus.Gatherers, len(i.cfg.Targets))
+		)
+		for idx, t := range i.cfg.Targets {
+			wg.Add(1)
+			go func(idx int, t Target) {
+				defer wg.Done()
+				m, _ := i.cfg.module(t.Module)
+				reg, _ := probe(r.Context(), t, m, probeTimeout(r, m), newProbeLogger(i.log, t))
+				regs[idx] = newLabeledGatherer(reg, targetLabels(t))
+			}(idx, t)
+		}
+		wg.Wait()
+
+		promhttp.HandlerFor(regs, promhttp.HandlerOpts{}).ServeHTTP(rw, r)
+	}), nil
+}
+
+// targetLabels returns the labels identifying the metrics of t.
+func targetLabels(t Target) prometheus.Labels {
+	labels := make(prometheus.Labels, len(t.Labels)+2)
+	for name, value := range t.Labels {
+		labels[string(name)] = string(value)
+	}
+	labels["target"] = t.Name
+	labels["module"] = t.Module
+	return labels
+}
+
+// ScrapeConfigs implements integrations.Integration.
+func (i *Integration) ScrapeConfigs() []config.ScrapeConfig {
+	return []config.ScrapeConfig{{
+		JobName:     i.cfg.Name(),
+		MetricsPath: "/metrics",
+	}}
+}
+
+// Run implements integrations.Integration.
+func (i *Integration) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
@@ -0,0 +1,269 @@
+package blackbox_exporter //nolint:golint
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/go-logfmt/logfmt"
+	"github.com/gorilla/mux"
+	v1 "github.com/grafana/agent/pkg/integrations"
+	integrations "github.com/grafana/agent/pkg/integrations/v2"
+	"github.com/grafana/agent/pkg/integrations/v2/autoscrape"
+	"github.com/grafana/agent/pkg/integrations/v2/common"
+	"github.com/grafana/agent/pkg/util"
+	"github.com/grafana/loki/clients/pkg/promtail/api"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/model"
+	prom_config "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// failuresStream is the log stream probe failures are sent to.
+const failuresStream = "probe_failures"
+
+// logSendTimeout is how long to wait for a probe failure to be accepted by
+// the logs instance.
+const logSendTimeout = 10 * time.Second
+
+// failureQueueSize is the number of probe failures which may wait to be sent
+// to the logs instance. Failures are dropped once the queue is full.
+const failureQueueSize = 100
+
+// upgrade upgrades a v1 Config to a native integrations-next config, where
+// every target is exposed as its own scrape target.
+func upgrade(before v1.Config, common common.MetricsConfig) integrations.UpgradedConfig {
+	return &upgradedConfig{orig: before.(*Config), common: common}
+}
+
+type upgradedConfig struct {
+	orig   *Config
+	common common.MetricsConfig
+}
+
+var (
+	_ integrations.UpgradedConfig   = (*upgradedConfig)(nil)
+	_ integrations.ComparableConfig = (*upgradedConfig)(nil)
+)
+
+func (c *upgradedConfig) LegacyConfig() (v1.Config, common.MetricsConfig) { return c.orig, c.common }
+
+func (c *upgradedConfig) Name() string { return "blackbox" }
+
+func (c *upgradedConfig) ApplyDefaults(g integrations.Globals) error {
+	c.common.ApplyDefaults(g.SubsystemOpts.Metrics.Autoscrape)
+	if id, err := c.Identifier(g); err == nil {
+		c.common.InstanceKey = &id
+	}
+	return nil
+}
+
+func (c *upgradedConfig) ConfigEquals(other integrations.Config) bool {
+	o, ok := other.(*upgradedConfig)
+	if !ok {
+		return false
+	}
+	return util.CompareYAML(c.orig, o.orig) && util.CompareYAML(c.common, o.common)
+}
+
+func (c *upgradedConfig) Identifier(g integrations.Globals) (string, error) {
+	if c.common.InstanceKey != nil {
+		return *c.common.InstanceKey, nil
+	}
+	return c.orig.InstanceKey(g.AgentIdentifier)
+}
+
+func (c *upgradedConfig) NewIntegration(l log.Logger, g integrations.Globals) (integrations.Integration, error) {
+	id, err := c.Identifier(g)
+	if err != nil {
+		return nil, err
+	}
+	return &integration{
+		log:     l,
+		name:    c.Name(),
+		id:      id,
+		cfg:     c.orig,
+		common:  c.common,
+		globals: g,
+
+		failures: make(chan api.Entry, failureQueueSize),
+	}, nil
+}
+
+// integration is the integrations-next version of the blackbox integration.
+type integration struct {
+	log      log.Logger
+	name, id string
+	cfg      *Config
+	common   common.MetricsConfig
+	globals  integrations.Globals
+	logsSink integrations.LogsSink
+
+	// failures holds probe failures waiting to be sent to logsSink.
+	failures chan api.Entry
+}
+
+var (
+	_ integrations.HTTPIntegration    = (*integration)(nil)
+	_ integrations.MetricsIntegration = (*integration)(nil)
+	_ integrations.LogsIntegration    = (*integration)(nil)
+)
+
+// RunIntegration implements integrations.Integration. Probe failures are
+// sent to the logs instance until ctx is canceled.
+func (i *integration) RunIntegration(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case entry := <-i.failures:
+			i.sendFailure(ctx, entry)
+		}
+	}
+}
+
+func (i *integration) sendFailure(ctx context.Context, entry api.Entry) {
+	ctx, cancel := context.WithTimeout(ctx, logSendTimeout)
+	defer cancel()
+	if err := i.logsSink.Send(ctx, failuresStream, entry); err != nil {
+		level.Warn(i.log).Log("msg", "failed to log probe failure", "target", entry.Labels["target"], "err", err)
+	}
+}
+
+// Handler implements integrations.HTTPIntegration. The metrics of every
+// target are exposed at <prefix>/targets/<target name>/metrics.
+func (i *integration) Handler(prefix string) (http.Handler, error) {
+	targets := make(map[string]Target, len(i.cfg.Targets))
+	for _, t := range i.cfg.Targets {
+		targets[t.Name] = t
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc(path.Join(prefix, "targets/{target}/metrics"), func(rw http.ResponseWriter, r *http.Request) {
+		t, ok := targets[mux.Vars(r)["target"]]
+		if !ok {
+			http.NotFound(rw, r)
+			return
+		}
+		m, _ := i.cfg.module(t.Module)
+		pl := newProbeLogger(i.log, t)
+		reg, success := probe(r.Context(), t, m, probeTimeout(r, m), pl)
+		if !success {
+			i.logFailure(t, pl.Reason())
+		}
+		promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(rw, r)
+	})
+	return r, nil
+}
+
+// logFailure queues the failure of a probe to be sent to the logs instance,
+// if enabled. reason holds the key/value pairs of the error logged by the
+// prober. Failures are sent by RunIntegration so scrapes aren't slowed down
+// by the logs instance, and are dropped when the queue is full.
+func (i *integration) logFailure(t Target, reason []interface{}) {
+	if !i.cfg.LogFailures || i.logsSink == nil {
+		return
+	}
+
+	keyvals := []interface{}{"msg", "probe failed", "target", t.Name, "module", t.Module, "address", t.Address}
+	for idx := 0; idx+1 < len(reason); idx += 2 {
+		key := reason[idx]
+		if key == "msg" {
+			key = "reason"
+		}
+		keyvals = append(keyvals, key, reason[idx+1])
+	}
+	line, err := logfmt.MarshalKeyvals(keyvals...)
+	if err != nil {
+		level.Warn(i.log).Log("msg", "failed to encode probe failure", "target", t.Name, "err", err)
+		return
+	}
+
+	entry := api.Entry{
+		Labels: model.LabelSet{
+			"target": model.LabelValue(t.Name),
+			"module": model.LabelValue(t.Module),
+		},
+		Entry: logproto.Entry{
+			Timestamp: time.Now(),
+			Line:      string(line),
+		},
+	}
+	select {
+	case i.failures <- entry:
+	default:
+		level.Debug(i.log).Log("msg", "dropping probe failure, queue is full", "target", t.Name)
+	}
+}
+
+// Targets implements integrations.MetricsIntegration. Every probe target is
+// its own scrape target.
+func (i *integration) Targets(ep integrations.Endpoint) []*targetgroup.Group {
+	group := &targetgroup.Group{
+		Labels: model.LabelSet{
+			model.InstanceLabel: model.LabelValue(i.id),
+			model.JobLabel:      model.LabelValue("integrations/" + i.name),
+			"agent_hostname":    model.LabelValue(i.globals.AgentIdentifier),
+
+			// Meta labels that can be used during SD.
+			"__meta_agent_integration_name":       model.LabelValue(i.name),
+			"__meta_agent_integration_instance":   model.LabelValue(i.id),
+			"__meta_agent_integration_autoscrape": model.LabelValue(fmt.Sprint(*i.common.Autoscrape.Enable)),
+		},
+		Source: fmt.Sprintf("%s/%s", i.name, i.id),
+	}
+	for _, lbl := range i.common.ExtraLabels {
+		group.Labels[model.LabelName(lbl.Name)] = model.LabelValue(lbl.Value)
+	}
+
+	for _, t := range i.cfg.Targets {
+		group.Targets = append(group.Targets, model.LabelSet{
+			model.AddressLabel:     model.LabelValue(ep.Host),
+			model.MetricsPathLabel: model.LabelValue(path.Join(ep.Prefix, "targets", t.Name, "metrics")),
+			"target":               model.LabelValue(t.Name),
+			"module":               model.LabelValue(t.Module),
+		}.Merge(t.Labels))
+	}
+	return []*targetgroup.Group{group}
+}
+
+// ScrapeConfigs implements integrations.MetricsIntegration.
+func (i *integration) ScrapeConfigs(sd discovery.Configs) []*autoscrape.ScrapeConfig {
+	if !*i.common.Autoscrape.Enable {
+		return nil
+	}
+
+	cfg := prom_config.DefaultScrapeConfig
+	cfg.JobName = fmt.Sprintf("%s/%s", i.name, i.id)
+	cfg.Scheme = i.globals.AgentBaseURL.Scheme
+	cfg.ServiceDiscoveryConfigs = sd
+	cfg.ScrapeInterval = i.common.Autoscrape.ScrapeInterval
+	cfg.ScrapeTimeout = i.common.Autoscrape.ScrapeTimeout
+	cfg.RelabelConfigs = i.common.Autoscrape.RelabelConfigs
+	cfg.MetricRelabelConfigs = i.common.Autoscrape.MetricRelabelConfigs
+
+	return []*autoscrape.ScrapeConfig{{
+		Instance: i.common.Autoscrape.MetricsInstance,
+		Config:   cfg,
+	}}
+}
+
+// LogsInstance implements integrations.LogsIntegration.
+func (i *integration) LogsInstance() string { return i.cfg.LogsInstance }
+
+// LogStreams implements integrations.LogsIntegration.
+func (i *integration) LogStreams() []integrations.LogStream {
+	return []integrations.LogStream{{
+		Name:   failuresStream,
+		Labels: model.LabelSet{"integration": model.LabelValue(i.name)},
+	}}
+}
+
+// SetLogsSink implements integrations.LogsIntegration.
+func (i *integration) SetLogsSink(s integrations.LogsSink) { i.logsSink = s }
@@ -0,0 +1,147 @@
+package blackbox_exporter //nolint:golint
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	integrations "github.com/grafana/agent/pkg/integrations/v2"
+	"github.com/grafana/agent/pkg/integrations/v2/common"
+	"github.com/grafana/loki/clients/pkg/promtail/api"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrationV2(t *testing.T) {
+	cfg := upgrade(&Config{
+		LogFailures: true,
+		Targets: []Target{
+			{Name: "a", Address: "127.0.0.1:1", Module: "tcp_connect", Labels: model.LabelSet{"team": "a"}},
+			{Name: "b", Address: "127.0.0.1:1", Module: "tcp_connect"},
+		},
+	}, common.MetricsConfig{})
+
+	globals := integrations.Globals{
+		AgentIdentifier: "agent",
+		AgentBaseURL:    &url.URL{Scheme: "http", Host: "localhost:12345"},
+		SubsystemOpts:   integrations.DefaultSubsystemOptions,
+	}
+	require.Equal(t, "blackbox", cfg.Name())
+	require.NoError(t, cfg.ApplyDefaults(globals))
+
+	i, err := cfg.NewIntegration(log.NewNopLogger(), globals)
+	require.NoError(t, err)
+
+	sink := &mockLogsSink{}
+	li := i.(integrations.LogsIntegration)
+	li.SetLogsSink(sink)
+	require.Equal(t, failuresStream, li.LogStreams()[0].Name)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = i.RunIntegration(ctx) }()
+
+	t.Run("targets", func(t *testing.T) {
+		groups := i.(integrations.MetricsIntegration).Targets(integrations.Endpoint{Host: "localhost:12345", Prefix: "/integrations/blackbox/agent/"})
+		require.Len(t, groups, 1)
+		require.Equal(t, model.LabelValue("integrations/blackbox"), groups[0].Labels[model.JobLabel])
+		require.Equal(t, []model.LabelSet{
+			{
+				model.AddressLabel:     "localhost:12345",
+				model.MetricsPathLabel: "/integrations/blackbox/agent/targets/a/metrics",
+				"target":               "a",
+				"module":               "tcp_connect",
+				"team":                 "a",
+			},
+			{
+				model.AddressLabel:     "localhost:12345",
+				model.MetricsPathLabel: "/integrations/blackbox/agent/targets/b/metrics",
+				"target":               "b",
+				"module":               "tcp_connect",
+			},
+		}, groups[0].Targets)
+	})
+
+	t.Run("handler", func(t *testing.T) {
+		h, err := i.(integrations.HTTPIntegration).Handler("/integrations/blackbox/agent/")
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/integrations/blackbox/agent/targets/a/metrics", nil))
+		require.Equal(t, 200, rr.Code)
+		require.Contains(t, rr.Body.String(), "probe_success 0")
+
+		// Failures are sent to the logs instance by RunIntegration.
+		require.Eventually(t, func() bool {
+			return len(sink.Entries()) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Equal(t, model.LabelValue("a"), sink.Entries()[0].Labels["target"])
+		require.Contains(t, sink.Entries()[0].Line, `msg="probe failed" target=a module=tcp_connect address=127.0.0.1:1 reason="Error dialing TCP"`)
+
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/integrations/blackbox/agent/targets/missing/metrics", nil))
+		require.Equal(t, 404, rr.Code)
+	})
+
+	t.Run("scrape configs", func(t *testing.T) {
+		scs := i.(integrations.MetricsIntegration).ScrapeConfigs(nil)
+		require.Len(t, scs, 1)
+		require.Equal(t, "blackbox/agent", scs[0].Config.JobName)
+		require.Equal(t, "default", scs[0].Instance)
+	})
+}
+
+func TestIntegrationV2_FailureQueueFull(t *testing.T) {
+	cfg := upgrade(&Config{
+		LogFailures: true,
+		Targets:     []Target{{Name: "a", Address: "127.0.0.1:1", Module: "tcp_connect"}},
+	}, common.MetricsConfig{})
+
+	globals := integrations.Globals{
+		AgentIdentifier: "agent",
+		SubsystemOpts:   integrations.DefaultSubsystemOptions,
+	}
+	require.NoError(t, cfg.ApplyDefaults(globals))
+	i, err := cfg.NewIntegration(log.NewNopLogger(), globals)
+	require.NoError(t, err)
+
+	sink := &mockLogsSink{}
+	i.(integrations.LogsIntegration).SetLogsSink(sink)
+
+	// Without RunIntegration, nothing drains the queue. Failures past the size
+	// of the queue must be dropped instead of piling up.
+	bi := i.(*integration)
+	for n := 0; n < failureQueueSize*2; n++ {
+		bi.logFailure(bi.cfg.Targets[0], nil)
+	}
+	require.Len(t, bi.failures, failureQueueSize)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = i.RunIntegration(ctx) }()
+	require.Eventually(t, func() bool {
+		return len(sink.Entries()) == failureQueueSize
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+type mockLogsSink struct {
+	mut     sync.Mutex
+	entries []api.Entry
+}
+
+func (s *mockLogsSink) Send(_ context.Context, stream string, entry api.Entry) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *mockLogsSink) Entries() []api.Entry {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]api.Entry(nil), s.entries...)
+}
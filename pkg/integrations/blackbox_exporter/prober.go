@@ -0,0 +1,162 @@
+package blackbox_exporter //nolint:golint
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	blackbox_config "github.com/prometheus/blackbox_exporter/config"
+	"github.com/prometheus/blackbox_exporter/prober"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// probers are the probers of blackbox_exporter, by name.
+var probers = map[string]prober.ProbeFn{
+	"http": prober.ProbeHTTP,
+	"tcp":  prober.ProbeTCP,
+	"icmp": prober.ProbeICMP,
+	"dns":  prober.ProbeDNS,
+	"grpc": prober.ProbeGRPC,
+}
+
+const (
+	// defaultProbeTimeout is the timeout of probes when neither the module
+	// nor the scrape sets one.
+	defaultProbeTimeout = 120 * time.Second
+
+	// probeTimeoutOffset is subtracted from the scrape timeout so probes
+	// finish before the scrape times out.
+	probeTimeoutOffset = 500 * time.Millisecond
+)
+
+// probeTimeout returns the timeout of probing with m for the scrape r. As in
+// blackbox_exporter, the timeout of the module is used when it's shorter
+// than the scrape timeout.
+func probeTimeout(r *http.Request, m blackbox_config.Module) time.Duration {
+	timeout := defaultProbeTimeout
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+			timeout = time.Duration(secs * float64(time.Second))
+		}
+	}
+	timeout -= probeTimeoutOffset
+
+	if m.Timeout > 0 && m.Timeout < timeout {
+		return m.Timeout
+	}
+	return timeout
+}
+
+// probe probes t with m, stopping after timeout, and returns the registry
+// holding the metrics of the probe. Log lines of the prober are sent to l.
+func probe(ctx context.Context, t Target, m blackbox_config.Module, timeout time.Duration, l log.Logger) (*prometheus.Registry, bool) {
+	// Probers require a deadline, which some of them use as the timeout of
+	// their clients.
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var (
+		successGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_success",
+			Help: "Displays whether or not the probe was a success",
+		})
+		durationGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_duration_seconds",
+			Help: "Returns how long the probe took to complete in seconds",
+		})
+	)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(successGauge, durationGauge)
+
+	start := time.Now()
+	success := probers[m.Prober](ctx, t.Address, m, reg, l)
+	durationGauge.Set(time.Since(start).Seconds())
+	if success {
+		successGauge.Set(1)
+	}
+	return reg, success
+}
+
+// probeLogger sends the log lines of a prober to a logger at the debug
+// level, since probers log every step of a probe. The last lines logged by
+// the prober are kept so the reason of a failed probe can be reported.
+type probeLogger struct {
+	next log.Logger
+
+	mut           sync.Mutex
+	last, lastErr []interface{}
+}
+
+func newProbeLogger(l log.Logger, t Target) *probeLogger {
+	return &probeLogger{next: log.With(l, "target", t.Name, "module", t.Module)}
+}
+
+// Log implements log.Logger.
+func (l *probeLogger) Log(keyvals ...interface{}) error {
+	var (
+		isErr bool
+		kvs   = make([]interface{}, 0, len(keyvals))
+	)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if keyvals[i] == level.Key() {
+			isErr = keyvals[i+1] == level.ErrorValue()
+			continue
+		}
+		kvs = append(kvs, keyvals[i], keyvals[i+1])
+	}
+
+	l.mut.Lock()
+	l.last = kvs
+	if isErr {
+		l.lastErr = kvs
+	}
+	l.mut.Unlock()
+	return level.Debug(l.next).Log(kvs...)
+}
+
+// Reason returns the key/value pairs of the last error logged by the
+// prober. The last line is returned instead if no error was logged, since
+// probers log some failures, such as unexpected status codes, as info.
+func (l *probeLogger) Reason() []interface{} {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if l.lastErr != nil {
+		return l.lastErr
+	}
+	return l.last
+}
+
+// labeledGatherer adds labels to every metric gathered from a Gatherer.
+type labeledGatherer struct {
+	prometheus.Gatherer
+	labels []*dto.LabelPair
+}
+
+func newLabeledGatherer(g prometheus.Gatherer, labels prometheus.Labels) prometheus.Gatherer {
+	pairs := make([]*dto.LabelPair, 0, len(labels))
+	for name, value := range labels {
+		name, value := name, value
+		pairs = append(pairs, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	return &labeledGatherer{Gatherer: g, labels: pairs}
+}
+
+// Gather implements prometheus.Gatherer.
+func (g *labeledGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	for _, f := range families {
+		for _, m := range f.Metric {
+			m.Label = append(m.Label, g.labels...)
+			sort.Slice(m.Label, func(i, j int) bool {
+				return m.Label[i].GetName() < m.Label[j].GetName()
+			})
+		}
+	}
+	return families, err
+}
@@ -0,0 +1,275 @@
+package haproxy_exporter //nolint:golint
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Values of the type column of the statistics page.
+const (
+	typeFrontend = "0"
+	typeBackend  = "1"
+	typeServer   = "2"
+)
+
+// field maps a column of the statistics page to a metric.
+type field struct {
+	column string
+	name   string
+	help   string
+	vt     prometheus.ValueType
+}
+
+// Columns shared by frontends, backends and servers.
+var (
+	fieldCurrentSessions = field{"scur", "current_sessions", "Current number of active sessions.", prometheus.GaugeValue}
+	fieldMaxSessions     = field{"smax", "max_sessions", "Maximum observed number of active sessions.", prometheus.GaugeValue}
+	fieldSessionsTotal   = field{"stot", "sessions_total", "Total number of sessions.", prometheus.CounterValue}
+	fieldBytesIn         = field{"bin", "bytes_in_total", "Current total of incoming bytes.", prometheus.CounterValue}
+	fieldBytesOut        = field{"bout", "bytes_out_total", "Current total of outgoing bytes.", prometheus.CounterValue}
+	fieldCurrentQueue    = field{"qcur", "current_queue", "Current number of queued requests.", prometheus.GaugeValue}
+	fieldConnErrors      = field{"econ", "connection_errors_total", "Total of connection errors.", prometheus.CounterValue}
+	fieldResponseErrors  = field{"eresp", "response_errors_total", "Total of response errors.", prometheus.CounterValue}
+	fieldWeight          = field{"weight", "weight", "Current weight.", prometheus.GaugeValue}
+)
+
+var (
+	frontendFields = []field{
+		fieldCurrentSessions,
+		fieldMaxSessions,
+		{"slim", "limit_sessions", "Configured session limit.", prometheus.GaugeValue},
+		fieldSessionsTotal,
+		fieldBytesIn,
+		fieldBytesOut,
+		{"dreq", "requests_denied_total", "Total of requests denied for security.", prometheus.CounterValue},
+		{"ereq", "request_errors_total", "Total of request errors.", prometheus.CounterValue},
+		{"req_tot", "http_requests_total", "Total HTTP requests.", prometheus.CounterValue},
+	}
+
+	backendFields = []field{
+		fieldCurrentQueue,
+		fieldCurrentSessions,
+		fieldMaxSessions,
+		fieldSessionsTotal,
+		fieldBytesIn,
+		fieldBytesOut,
+		fieldConnErrors,
+		fieldResponseErrors,
+		fieldWeight,
+		{"act", "current_server", "Current number of active servers.", prometheus.GaugeValue},
+	}
+
+	serverFields = []field{
+		fieldCurrentQueue,
+		fieldCurrentSessions,
+		fieldMaxSessions,
+		fieldSessionsTotal,
+		fieldBytesIn,
+		fieldBytesOut,
+		fieldConnErrors,
+		fieldResponseErrors,
+		fieldWeight,
+		{"chkfail", "check_failures_total", "Total number of failed health checks.", prometheus.CounterValue},
+		{"downtime", "downtime_seconds_total", "Total downtime in seconds.", prometheus.CounterValue},
+	}
+
+	// httpResponseCodes are the response code classes of the hrsp_ columns.
+	httpResponseCodes = []string{"1xx", "2xx", "3xx", "4xx", "5xx", "other"}
+)
+
+// proxyType holds the metrics for one type of row in the statistics page.
+type proxyType struct {
+	fields        []field
+	descs         []*prometheus.Desc
+	up            *prometheus.Desc
+	httpResponses *prometheus.Desc
+}
+
+func newProxyType(prefix string, fields []field, labels ...string) *proxyType {
+	pt := &proxyType{fields: fields}
+	for _, f := range fields {
+		pt.descs = append(pt.descs, prometheus.NewDesc(prefix+f.name, f.help, labels, nil))
+	}
+	pt.httpResponses = prometheus.NewDesc(
+		prefix+"http_responses_total",
+		"Total of HTTP responses.",
+		append(append([]string{}, labels...), "code"), nil,
+	)
+	return pt
+}
+
+// collector scrapes the statistics page of HAProxy on every collection.
+type collector struct {
+	log       log.Logger
+	client    *http.Client
+	scrapeURI string
+	timeout   time.Duration
+
+	up        *prometheus.Desc
+	frontends *proxyType
+	backends  *proxyType
+	servers   *proxyType
+}
+
+var _ prometheus.Collector = (*collector)(nil)
+
+func newCollector(l log.Logger, client *http.Client, scrapeURI string, timeout time.Duration) *collector {
+	c := &collector{
+		log:       l,
+		client:    client,
+		scrapeURI: scrapeURI,
+		timeout:   timeout,
+
+		up:        prometheus.NewDesc("haproxy_up", "Whether the statistics page of HAProxy could be scraped.", nil, nil),
+		frontends: newProxyType("haproxy_frontend_", frontendFields, "frontend"),
+		backends:  newProxyType("haproxy_backend_", backendFields, "backend"),
+		servers:   newProxyType("haproxy_server_", serverFields, "backend", "server"),
+	}
+	c.backends.up = prometheus.NewDesc("haproxy_backend_up", "Whether the backend is up.", []string{"backend"}, nil)
+	c.servers.up = prometheus.NewDesc("haproxy_server_up", "Whether the server is up.", []string{"backend", "server"}, nil)
+	return c
+}
+
+// Describe implements prometheus.Collector.
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.up
+	for _, pt := range []*proxyType{c.frontends, c.backends, c.servers} {
+		for _, d := range pt.descs {
+			ch <- d
+		}
+		if pt.up != nil {
+			ch <- pt.up
+		}
+		ch <- pt.httpResponses
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	rows, err := c.scrape()
+	if err != nil {
+		level.Error(c.log).Log("msg", "failed to scrape HAProxy", "uri", c.scrapeURI, "err", err)
+		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 0)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 1)
+
+	for _, row := range rows {
+		switch row["type"] {
+		case typeFrontend:
+			c.collectRow(ch, c.frontends, row, row["pxname"])
+		case typeBackend:
+			c.collectRow(ch, c.backends, row, row["pxname"])
+		case typeServer:
+			c.collectRow(ch, c.servers, row, row["pxname"], row["svname"])
+		}
+	}
+}
+
+func (c *collector) collectRow(ch chan<- prometheus.Metric, pt *proxyType, row map[string]string, labels ...string) {
+	// Columns are empty when they don't apply to a row, such as the queue of a
+	// backend without a maxconn, so they're skipped.
+	emit := func(desc *prometheus.Desc, vt prometheus.ValueType, column string, labels ...string) {
+		raw := row[column]
+		if raw == "" {
+			return
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			level.Debug(c.log).Log("msg", "ignoring invalid HAProxy column", "column", column, "value", raw)
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(desc, vt, v, labels...)
+	}
+
+	for i, f := range pt.fields {
+		emit(pt.descs[i], f.vt, f.column, labels...)
+	}
+	for _, code := range httpResponseCodes {
+		emit(pt.httpResponses, prometheus.CounterValue, "hrsp_"+code, append(append([]string{}, labels...), code)...)
+	}
+
+	if pt.up != nil {
+		ch <- prometheus.MustNewConstMetric(pt.up, prometheus.GaugeValue, parseStatus(row["status"]), labels...)
+	}
+}
+
+// parseStatus converts the status column into 1 if the backend or server is
+// up and 0 otherwise.
+func parseStatus(status string) float64 {
+	switch {
+	case status == "UP", status == "no check", status == "NOLB", status == "DRAIN":
+		return 1
+	case strings.HasPrefix(status, "UP "):
+		// Servers which are going down report UP 1/3, UP 2/3, etc.
+		return 1
+	default:
+		return 0
+	}
+}
+
+// parseCSV parses the CSV statistics page into one map of column names to
+// values per row.
+func parseCSV(r io.Reader) ([]map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if len(header) == 0 || !strings.HasPrefix(header[0], "# ") {
+		return nil, fmt.Errorf("invalid statistics page: missing header")
+	}
+	header[0] = strings.TrimPrefix(header[0], "# ")
+
+	var rows []map[string]string
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]string, len(header))
+		for i, v := range record {
+			if i < len(header) {
+				row[header[i]] = v
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func (c *collector) scrape() ([]map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.scrapeURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return parseCSV(resp.Body)
+}
@@ -0,0 +1,87 @@
+// Package haproxy_exporter implements an integration which scrapes the CSV
+// statistics page of HAProxy. Metrics follow the names used by
+// https://github.com/prometheus/haproxy_exporter.
+package haproxy_exporter //nolint:golint
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/agent/pkg/integrations"
+	integrations_v2 "github.com/grafana/agent/pkg/integrations/v2"
+	"github.com/grafana/agent/pkg/integrations/v2/metricsutils"
+	config_util "github.com/prometheus/common/config"
+)
+
+// DefaultConfig holds the default settings for the haproxy_exporter
+// integration.
+var DefaultConfig = Config{
+	ScrapeURI:        "http://localhost/;csv",
+	Timeout:          5 * time.Second,
+	HTTPClientConfig: config_util.DefaultHTTPClientConfig,
+}
+
+// Config controls the haproxy_exporter integration.
+type Config struct {
+	// ScrapeURI is the URL of the CSV statistics page, which ends in ;csv.
+	ScrapeURI string `yaml:"scrape_uri,omitempty"`
+	// Timeout for requests to ScrapeURI.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	HTTPClientConfig config_util.HTTPClientConfig `yaml:",inline"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler for Config.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConfig
+
+	type plain Config
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+
+	if _, err := url.Parse(c.ScrapeURI); err != nil {
+		return fmt.Errorf("invalid scrape_uri: %w", err)
+	}
+	return c.HTTPClientConfig.Validate()
+}
+
+// Name returns the name of the integration that this config represents.
+func (c *Config) Name() string {
+	return "haproxy_exporter"
+}
+
+// InstanceKey returns the host:port of the HAProxy server.
+func (c *Config) InstanceKey(agentKey string) (string, error) {
+	u, err := url.Parse(c.ScrapeURI)
+	if err != nil || u.Host == "" {
+		return agentKey, nil
+	}
+	return u.Host, nil
+}
+
+// NewIntegration converts this config into an instance of an integration.
+func (c *Config) NewIntegration(l log.Logger) (integrations.Integration, error) {
+	return New(l, c)
+}
+
+func init() {
+	integrations.RegisterIntegration(&Config{})
+	integrations_v2.RegisterLegacy(&Config{}, integrations_v2.TypeMultiplex, metricsutils.NewNamedShim("haproxy"))
+}
+
+// New creates a new haproxy_exporter integration. The integration scrapes the
+// statistics page whenever its metrics are collected.
+func New(l log.Logger, c *Config) (integrations.Integration, error) {
+	client, err := config_util.NewClientFromConfig(c.HTTPClientConfig, c.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP client: %w", err)
+	}
+
+	return integrations.NewCollectorIntegration(
+		c.Name(),
+		integrations.WithCollectors(newCollector(l, client, c.ScrapeURI, c.Timeout)),
+	), nil
+}
@@ -0,0 +1,141 @@
+package haproxy_exporter //nolint:golint
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+const testCSV = `# pxname,svname,qcur,qmax,scur,smax,slim,stot,bin,bout,dreq,dresp,ereq,econ,eresp,wretr,wredis,status,weight,act,bck,chkfail,chkdown,lastchg,downtime,qlimit,pid,iid,sid,throttle,lbtot,tracked,type,rate,rate_lim,rate_max,check_status,check_code,check_duration,hrsp_1xx,hrsp_2xx,hrsp_3xx,hrsp_4xx,hrsp_5xx,hrsp_other,hanafail,req_rate,req_rate_max,req_tot,
+http-in,FRONTEND,,,5,20,2000,1000,123456,654321,1,0,3,,,,,OPEN,,,,,,,,,1,2,0,,,,0,2,0,10,,,,0,900,50,40,10,0,,2,10,1000,
+app,web1,0,0,2,8,,600,60000,300000,,0,,0,1,0,0,UP,1,1,0,0,0,100,0,,1,3,1,,600,,2,1,,5,L7OK,200,1,0,550,30,15,5,0,,,,,
+app,web2,0,0,0,4,,400,40000,200000,,0,,2,0,1,0,DOWN,1,1,0,3,1,20,20,,1,3,2,,400,,2,0,,4,L4CON,,0,0,350,20,25,5,0,,,,,
+app,BACKEND,0,0,2,10,,1000,100000,500000,0,0,,2,1,1,0,UP,1,1,0,,0,100,0,,1,3,0,,1000,,1,1,,8,,,,0,900,50,40,10,0,,,,1000,
+`
+
+func TestConfig_Unmarshal(t *testing.T) {
+	var c Config
+	err := yaml.UnmarshalStrict([]byte(`
+scrape_uri: http://lb-1:8404/stats;csv
+timeout: 2s`), &c)
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Second, c.Timeout)
+
+	key, err := c.InstanceKey("agent:12345")
+	require.NoError(t, err)
+	require.Equal(t, "lb-1:8404", key)
+}
+
+func TestCollector(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testCSV))
+	}))
+	defer srv.Close()
+
+	c := newCollector(log.NewNopLogger(), srv.Client(), srv.URL, time.Second)
+
+	expect := `
+# HELP haproxy_backend_current_queue Current number of queued requests.
+# TYPE haproxy_backend_current_queue gauge
+haproxy_backend_current_queue{backend="app"} 0
+# HELP haproxy_backend_up Whether the backend is up.
+# TYPE haproxy_backend_up gauge
+haproxy_backend_up{backend="app"} 1
+# HELP haproxy_frontend_bytes_in_total Current total of incoming bytes.
+# TYPE haproxy_frontend_bytes_in_total counter
+haproxy_frontend_bytes_in_total{frontend="http-in"} 123456
+# HELP haproxy_frontend_http_requests_total Total HTTP requests.
+# TYPE haproxy_frontend_http_requests_total counter
+haproxy_frontend_http_requests_total{frontend="http-in"} 1000
+# HELP haproxy_frontend_http_responses_total Total of HTTP responses.
+# TYPE haproxy_frontend_http_responses_total counter
+haproxy_frontend_http_responses_total{code="1xx",frontend="http-in"} 0
+haproxy_frontend_http_responses_total{code="2xx",frontend="http-in"} 900
+haproxy_frontend_http_responses_total{code="3xx",frontend="http-in"} 50
+haproxy_frontend_http_responses_total{code="4xx",frontend="http-in"} 40
+haproxy_frontend_http_responses_total{code="5xx",frontend="http-in"} 10
+haproxy_frontend_http_responses_total{code="other",frontend="http-in"} 0
+# HELP haproxy_server_check_failures_total Total number of failed health checks.
+# TYPE haproxy_server_check_failures_total counter
+haproxy_server_check_failures_total{backend="app",server="web1"} 0
+haproxy_server_check_failures_total{backend="app",server="web2"} 3
+# HELP haproxy_server_up Whether the server is up.
+# TYPE haproxy_server_up gauge
+haproxy_server_up{backend="app",server="web1"} 1
+haproxy_server_up{backend="app",server="web2"} 0
+# HELP haproxy_up Whether the statistics page of HAProxy could be scraped.
+# TYPE haproxy_up gauge
+haproxy_up 1
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expect),
+		"haproxy_up",
+		"haproxy_frontend_bytes_in_total",
+		"haproxy_frontend_http_requests_total",
+		"haproxy_frontend_http_responses_total",
+		"haproxy_backend_current_queue",
+		"haproxy_backend_up",
+		"haproxy_server_check_failures_total",
+		"haproxy_server_up",
+	))
+
+	// Empty columns, like the queue of the frontend, must be skipped.
+	require.Equal(t, 69, testutil.CollectAndCount(c))
+}
+
+func TestParseStatus(t *testing.T) {
+	for status, expect := range map[string]float64{
+		"UP":       1,
+		"UP 1/3":   1,
+		"no check": 1,
+		"NOLB":     1,
+		"DRAIN":    1,
+		"DOWN":     0,
+		"DOWN 1/2": 0,
+		"MAINT":    0,
+	} {
+		require.Equal(t, expect, parseStatus(status), status)
+	}
+}
+
+func TestCollector_Down(t *testing.T) {
+	tt := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{
+			name: "error status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+			},
+		},
+		{
+			name: "HTML page",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("<html><body>Statistics Report for HAProxy</body></html>"))
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(tc.handler)
+			defer srv.Close()
+
+			c := newCollector(log.NewNopLogger(), srv.Client(), srv.URL, time.Second)
+
+			expect := `
+# HELP haproxy_up Whether the statistics page of HAProxy could be scraped.
+# TYPE haproxy_up gauge
+haproxy_up 0
+`
+			require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expect)))
+		})
+	}
+}
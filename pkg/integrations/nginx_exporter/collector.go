@@ -0,0 +1,170 @@
+package nginx_exporter //nolint:golint
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// stubStatus holds the values of the stub_status page.
+type stubStatus struct {
+	Active   int64
+	Accepts  int64
+	Handled  int64
+	Requests int64
+	Reading  int64
+	Writing  int64
+	Waiting  int64
+}
+
+// parseStubStatus parses the stub_status page:
+//
+//	Active connections: 291
+//	server accepts handled requests
+//	 16630948 16630948 31070465
+//	Reading: 6 Writing: 179 Waiting: 106
+func parseStubStatus(r io.Reader) (stubStatus, error) {
+	var s stubStatus
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return s, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 4 {
+		return s, fmt.Errorf("invalid stub_status page: expected 4 lines, got %d", len(lines))
+	}
+
+	parseInt := func(v string) int64 {
+		if err != nil {
+			return 0
+		}
+		var n int64
+		n, err = strconv.ParseInt(v, 10, 64)
+		return n
+	}
+
+	active := strings.TrimPrefix(strings.TrimSpace(lines[0]), "Active connections:")
+	s.Active = parseInt(strings.TrimSpace(active))
+
+	counters := strings.Fields(lines[2])
+	if len(counters) != 3 {
+		return s, fmt.Errorf("invalid stub_status page: expected 3 counters, got %d", len(counters))
+	}
+	s.Accepts = parseInt(counters[0])
+	s.Handled = parseInt(counters[1])
+	s.Requests = parseInt(counters[2])
+
+	states := strings.Fields(lines[3])
+	if len(states) != 6 || states[0] != "Reading:" || states[2] != "Writing:" || states[4] != "Waiting:" {
+		return s, fmt.Errorf("invalid stub_status page: unexpected connection states %q", lines[3])
+	}
+	s.Reading = parseInt(states[1])
+	s.Writing = parseInt(states[3])
+	s.Waiting = parseInt(states[5])
+
+	if err != nil {
+		return s, fmt.Errorf("invalid stub_status page: %w", err)
+	}
+	return s, nil
+}
+
+// collector scrapes the stub_status page of nginx on every collection.
+type collector struct {
+	log       log.Logger
+	client    *http.Client
+	scrapeURI string
+	timeout   time.Duration
+
+	up                *prometheus.Desc
+	connsActive       *prometheus.Desc
+	connsAccepted     *prometheus.Desc
+	connsHandled      *prometheus.Desc
+	connsReading      *prometheus.Desc
+	connsWriting      *prometheus.Desc
+	connsWaiting      *prometheus.Desc
+	httpRequestsTotal *prometheus.Desc
+}
+
+var _ prometheus.Collector = (*collector)(nil)
+
+func newCollector(l log.Logger, client *http.Client, scrapeURI string, timeout time.Duration) *collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc("nginx_"+name, help, nil, nil)
+	}
+
+	return &collector{
+		log:       l,
+		client:    client,
+		scrapeURI: scrapeURI,
+		timeout:   timeout,
+
+		up:                desc("up", "Whether the stub_status page of nginx could be scraped."),
+		connsActive:       desc("connections_active", "Active client connections."),
+		connsAccepted:     desc("connections_accepted", "Accepted client connections."),
+		connsHandled:      desc("connections_handled", "Handled client connections."),
+		connsReading:      desc("connections_reading", "Connections where nginx is reading the request header."),
+		connsWriting:      desc("connections_writing", "Connections where nginx is writing the response back to the client."),
+		connsWaiting:      desc("connections_waiting", "Idle client connections."),
+		httpRequestsTotal: desc("http_requests_total", "Total HTTP requests."),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.up
+	ch <- c.connsActive
+	ch <- c.connsAccepted
+	ch <- c.connsHandled
+	ch <- c.connsReading
+	ch <- c.connsWriting
+	ch <- c.connsWaiting
+	ch <- c.httpRequestsTotal
+}
+
+// Collect implements prometheus.Collector.
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	s, err := c.scrape()
+	if err != nil {
+		level.Error(c.log).Log("msg", "failed to scrape nginx", "uri", c.scrapeURI, "err", err)
+		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 0)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 1)
+	ch <- prometheus.MustNewConstMetric(c.connsActive, prometheus.GaugeValue, float64(s.Active))
+	ch <- prometheus.MustNewConstMetric(c.connsAccepted, prometheus.CounterValue, float64(s.Accepts))
+	ch <- prometheus.MustNewConstMetric(c.connsHandled, prometheus.CounterValue, float64(s.Handled))
+	ch <- prometheus.MustNewConstMetric(c.connsReading, prometheus.GaugeValue, float64(s.Reading))
+	ch <- prometheus.MustNewConstMetric(c.connsWriting, prometheus.GaugeValue, float64(s.Writing))
+	ch <- prometheus.MustNewConstMetric(c.connsWaiting, prometheus.GaugeValue, float64(s.Waiting))
+	ch <- prometheus.MustNewConstMetric(c.httpRequestsTotal, prometheus.CounterValue, float64(s.Requests))
+}
+
+func (c *collector) scrape() (stubStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.scrapeURI, nil)
+	if err != nil {
+		return stubStatus{}, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return stubStatus{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return stubStatus{}, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return parseStubStatus(resp.Body)
+}
@@ -0,0 +1,110 @@
+package nginx_exporter //nolint:golint
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+const testStubStatus = `Active connections: 291
+server accepts handled requests
+ 16630948 16630948 31070465
+Reading: 6 Writing: 179 Waiting: 106
+`
+
+func TestConfig_Unmarshal(t *testing.T) {
+	var c Config
+	err := yaml.UnmarshalStrict([]byte(`
+scrape_uri: http://nginx:8080/basic_status
+basic_auth:
+  username: agent
+  password: secret`), &c)
+	require.NoError(t, err)
+	require.Equal(t, "http://nginx:8080/basic_status", c.ScrapeURI)
+	require.Equal(t, DefaultConfig.Timeout, c.Timeout)
+	require.Equal(t, "agent", c.HTTPClientConfig.BasicAuth.Username)
+
+	key, err := c.InstanceKey("agent:12345")
+	require.NoError(t, err)
+	require.Equal(t, "nginx:8080", key)
+}
+
+func TestCollector(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testStubStatus))
+	}))
+	defer srv.Close()
+
+	c := newCollector(log.NewNopLogger(), srv.Client(), srv.URL, time.Second)
+
+	expect := `
+# HELP nginx_connections_accepted Accepted client connections.
+# TYPE nginx_connections_accepted counter
+nginx_connections_accepted 1.6630948e+07
+# HELP nginx_connections_active Active client connections.
+# TYPE nginx_connections_active gauge
+nginx_connections_active 291
+# HELP nginx_connections_handled Handled client connections.
+# TYPE nginx_connections_handled counter
+nginx_connections_handled 1.6630948e+07
+# HELP nginx_connections_reading Connections where nginx is reading the request header.
+# TYPE nginx_connections_reading gauge
+nginx_connections_reading 6
+# HELP nginx_connections_waiting Idle client connections.
+# TYPE nginx_connections_waiting gauge
+nginx_connections_waiting 106
+# HELP nginx_connections_writing Connections where nginx is writing the response back to the client.
+# TYPE nginx_connections_writing gauge
+nginx_connections_writing 179
+# HELP nginx_http_requests_total Total HTTP requests.
+# TYPE nginx_http_requests_total counter
+nginx_http_requests_total 3.1070465e+07
+# HELP nginx_up Whether the stub_status page of nginx could be scraped.
+# TYPE nginx_up gauge
+nginx_up 1
+`
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expect)))
+}
+
+func TestCollector_Down(t *testing.T) {
+	tt := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{
+			name: "error status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "not found", http.StatusNotFound)
+			},
+		},
+		{
+			name: "invalid page",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("<html>Welcome to nginx!</html>"))
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(tc.handler)
+			defer srv.Close()
+
+			c := newCollector(log.NewNopLogger(), srv.Client(), srv.URL, time.Second)
+
+			expect := `
+# HELP nginx_up Whether the stub_status page of nginx could be scraped.
+# TYPE nginx_up gauge
+nginx_up 0
+`
+			require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expect)))
+		})
+	}
+}
@@ -0,0 +1,402 @@
+package sql_exporter //nolint:golint
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collector runs queries against a database and exposes their results as
+// metrics.
+type collector struct {
+	log     log.Logger
+	db      *sql.DB
+	timeout time.Duration
+	queries []*query
+
+	up          *prometheus.Desc
+	errors      *prometheus.CounterVec
+	duration    *prometheus.GaugeVec
+	lastSuccess *prometheus.GaugeVec
+}
+
+var _ prometheus.Collector = (*collector)(nil)
+
+func newCollector(l log.Logger, db *sql.DB, c *Config) *collector {
+	col := &collector{
+		log:     l,
+		db:      db,
+		timeout: c.QueryTimeout,
+
+		up: prometheus.NewDesc(
+			"sql_exporter_up",
+			"Whether the database could be reached.",
+			nil, nil,
+		),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sql_exporter_query_errors_total",
+			Help: "Total number of failed query runs, including runs which returned rows with duplicate label values.",
+		}, []string{"query"}),
+		duration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sql_exporter_query_duration_seconds",
+			Help: "Duration of the most recent run of a query.",
+		}, []string{"query"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sql_exporter_query_last_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful run of a query.",
+		}, []string{"query"}),
+	}
+
+	for _, qc := range c.Queries {
+		q := &query{cfg: qc, timeout: qc.Timeout}
+		if q.timeout == 0 {
+			q.timeout = c.QueryTimeout
+		}
+		for _, m := range qc.Metrics {
+			q.metrics = append(q.metrics, newQueryMetric(m))
+		}
+
+		// Initialize the series so failures are visible from the first scrape.
+		col.errors.WithLabelValues(qc.Name)
+		col.queries = append(col.queries, q)
+	}
+
+	return col
+}
+
+// Run runs scheduled queries until ctx is canceled. The database is closed
+// once Run exits.
+func (c *collector) Run(ctx context.Context) error {
+	defer c.db.Close()
+
+	var wg sync.WaitGroup
+	for _, q := range c.queries {
+		if q.cfg.Interval == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(q *query) {
+			defer wg.Done()
+
+			t := time.NewTicker(q.cfg.Interval)
+			defer t.Stop()
+
+			for {
+				c.runQuery(ctx, q)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+				}
+			}
+		}(q)
+	}
+
+	<-ctx.Done()
+	wg.Wait()
+	return nil
+}
+
+// Describe implements prometheus.Collector.
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.up
+	for _, q := range c.queries {
+		for _, m := range q.metrics {
+			ch <- m.desc
+		}
+	}
+	c.errors.Describe(ch)
+	c.duration.Describe(ch)
+	c.lastSuccess.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+
+	up := 1.0
+	pingCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	if err := c.db.PingContext(pingCtx); err != nil {
+		level.Error(c.log).Log("msg", "failed to reach database", "err", err)
+		up = 0
+	}
+	ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, up)
+
+	var wg sync.WaitGroup
+	for _, q := range c.queries {
+		wg.Add(1)
+		go func(q *query) {
+			defer wg.Done()
+
+			if q.cfg.Interval == 0 && q.expired(time.Now()) {
+				c.runQuery(ctx, q)
+			}
+			for _, m := range q.cachedMetrics() {
+				ch <- m
+			}
+		}(q)
+	}
+	wg.Wait()
+
+	c.errors.Collect(ch)
+	c.duration.Collect(ch)
+	c.lastSuccess.Collect(ch)
+}
+
+// runQuery runs q and caches its results. Failed runs keep serving the
+// results of the previous successful run.
+func (c *collector) runQuery(ctx context.Context, q *query) {
+	// Serialize runs of the same query so concurrent scrapes of a cached query
+	// don't all hit the database.
+	q.runMut.Lock()
+	defer q.runMut.Unlock()
+
+	start := time.Now()
+	if !q.expired(start) {
+		return
+	}
+
+	metrics, duplicates, err := q.run(ctx, c.db)
+	c.duration.WithLabelValues(q.cfg.Name).Set(time.Since(start).Seconds())
+	if err != nil {
+		level.Error(c.log).Log("msg", "failed to run query", "query", q.cfg.Name, "err", err)
+		c.errors.WithLabelValues(q.cfg.Name).Inc()
+		return
+	}
+	if duplicates > 0 {
+		level.Warn(c.log).Log("msg", "dropped rows with duplicate label values", "query", q.cfg.Name, "rows", duplicates)
+		c.errors.WithLabelValues(q.cfg.Name).Inc()
+	}
+
+	c.lastSuccess.WithLabelValues(q.cfg.Name).Set(float64(time.Now().Unix()))
+	q.setCache(start, metrics)
+}
+
+// query is a configured query along with its most recent results.
+type query struct {
+	cfg     Query
+	timeout time.Duration
+	metrics []*queryMetric
+
+	runMut sync.Mutex
+
+	mut     sync.Mutex
+	lastRun time.Time
+	cache   []prometheus.Metric
+}
+
+// expired returns true if the query should be run again at time now.
+// Scheduled queries are always expired, as they only run on their schedule.
+func (q *query) expired(now time.Time) bool {
+	if q.cfg.Interval > 0 || q.cfg.CacheTTL == 0 {
+		return true
+	}
+
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	return q.lastRun.IsZero() || now.Sub(q.lastRun) >= q.cfg.CacheTTL
+}
+
+func (q *query) setCache(ts time.Time, metrics []prometheus.Metric) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	q.lastRun = ts
+	q.cache = metrics
+}
+
+func (q *query) cachedMetrics() []prometheus.Metric {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	return q.cache
+}
+
+// labelValuesSep separates label values when comparing the label values of
+// rows. It can't appear in valid UTF-8 label values.
+const labelValuesSep = "\xff"
+
+// run runs the query and converts the resulting rows into metrics. Rows with
+// the same label values as an earlier row of the same metric would fail the
+// whole scrape, so they are dropped and their number is returned.
+func (q *query) run(ctx context.Context, db *sql.DB) ([]prometheus.Metric, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, q.cfg.Query)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, 0, err
+	}
+	// Drivers don't agree on the case of column names, so columns are
+	// matched case-insensitively.
+	columnIndex := make(map[string]int, len(columns))
+	for i, c := range columns {
+		columnIndex[strings.ToLower(c)] = i
+	}
+	for _, m := range q.metrics {
+		if err := m.resolveColumns(columnIndex); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var (
+		res        []prometheus.Metric
+		duplicates int
+
+		// Label values seen for every metric, joined by labelValuesSep.
+		seen = make([]map[string]struct{}, len(q.metrics))
+	)
+	for i := range seen {
+		seen[i] = make(map[string]struct{})
+	}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, 0, err
+		}
+
+		for i, m := range q.metrics {
+			metric, err := m.metricFromRow(values)
+			if err != nil {
+				return nil, 0, err
+			} else if metric == nil {
+				continue
+			}
+
+			key := strings.Join(m.labelValues(values), labelValuesSep)
+			if _, dup := seen[i][key]; dup {
+				duplicates++
+				continue
+			}
+			seen[i][key] = struct{}{}
+			res = append(res, metric)
+		}
+	}
+	return res, duplicates, rows.Err()
+}
+
+// queryMetric converts rows of a query into a metric.
+type queryMetric struct {
+	cfg       Metric
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+
+	// Indices of the value and label columns in the most recent result.
+	valueIndex   int
+	labelIndices []int
+}
+
+func newQueryMetric(m Metric) *queryMetric {
+	valueType := prometheus.GaugeValue
+	if m.Type == MetricTypeCounter {
+		valueType = prometheus.CounterValue
+	}
+
+	help := m.Help
+	if help == "" {
+		help = fmt.Sprintf("Metric %s from column %s.", m.Name, m.ValueColumn)
+	}
+
+	return &queryMetric{
+		cfg:       m,
+		desc:      prometheus.NewDesc(m.Name, help, m.LabelColumns, m.StaticLabels),
+		valueType: valueType,
+	}
+}
+
+func (m *queryMetric) resolveColumns(columnIndex map[string]int) error {
+	idx, ok := columnIndex[strings.ToLower(m.cfg.ValueColumn)]
+	if !ok {
+		return fmt.Errorf("metric %q: value column %q not found in result", m.cfg.Name, m.cfg.ValueColumn)
+	}
+	m.valueIndex = idx
+
+	m.labelIndices = m.labelIndices[:0]
+	for _, l := range m.cfg.LabelColumns {
+		idx, ok := columnIndex[strings.ToLower(l)]
+		if !ok {
+			return fmt.Errorf("metric %q: label column %q not found in result", m.cfg.Name, l)
+		}
+		m.labelIndices = append(m.labelIndices, idx)
+	}
+	return nil
+}
+
+// metricFromRow converts a row into a metric. Returns nil if the value column
+// of the row is NULL.
+func (m *queryMetric) metricFromRow(row []interface{}) (prometheus.Metric, error) {
+	if row[m.valueIndex] == nil {
+		return nil, nil
+	}
+	value, err := toFloat(row[m.valueIndex])
+	if err != nil {
+		return nil, fmt.Errorf("metric %q: column %q: %w", m.cfg.Name, m.cfg.ValueColumn, err)
+	}
+
+	return prometheus.NewConstMetric(m.desc, m.valueType, value, m.labelValues(row)...)
+}
+
+// labelValues returns the values of the label columns of row.
+func (m *queryMetric) labelValues(row []interface{}) []string {
+	labelValues := make([]string, len(m.labelIndices))
+	for i, idx := range m.labelIndices {
+		labelValues[i] = toString(row[idx])
+	}
+	return labelValues
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case time.Time:
+		return float64(v.UnixNano()) / 1e9, nil
+	case []byte:
+		return strconv.ParseFloat(string(v), 64)
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return 0, fmt.Errorf("cannot convert %T to a number", v)
+	}
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
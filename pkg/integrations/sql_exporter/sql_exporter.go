@@ -0,0 +1,269 @@
+// Package sql_exporter implements an integration which exposes the results of
+// user-defined SQL queries as Prometheus metrics.
+package sql_exporter //nolint:golint
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-sql-driver/mysql"
+	"github.com/grafana/agent/pkg/integrations"
+	integrations_v2 "github.com/grafana/agent/pkg/integrations/v2"
+	"github.com/grafana/agent/pkg/integrations/v2/metricsutils"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+
+	// Register the supported database drivers.
+	_ "github.com/denisenkom/go-mssqldb"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// drivers maps the driver names accepted in the config to the names the
+// drivers are registered with in database/sql.
+var drivers = map[string]string{
+	"postgres":   "postgres",
+	"postgresql": "postgres",
+	"mysql":      "mysql",
+	"sqlite":     "sqlite3",
+	"sqlite3":    "sqlite3",
+	"mssql":      "sqlserver",
+	"sqlserver":  "sqlserver",
+}
+
+// Supported metric types.
+const (
+	MetricTypeGauge   = "gauge"
+	MetricTypeCounter = "counter"
+)
+
+// DefaultConfig holds the default settings for the sql_exporter integration.
+var DefaultConfig = Config{
+	MaxOpenConnections: 3,
+	MaxIdleConnections: 3,
+	QueryTimeout:       10 * time.Second,
+}
+
+// Config controls the sql_exporter integration.
+type Config struct {
+	// Driver used to connect to the database. One of postgres, mysql, sqlite
+	// or sqlserver.
+	Driver string `yaml:"driver,omitempty"`
+
+	// DataSourceName to use to connect to the database.
+	DataSourceName config_util.Secret `yaml:"data_source_name,omitempty"`
+
+	MaxOpenConnections int `yaml:"max_open_connections,omitempty"`
+	MaxIdleConnections int `yaml:"max_idle_connections,omitempty"`
+
+	// QueryTimeout is the default timeout for queries which don't set one.
+	QueryTimeout time.Duration `yaml:"query_timeout,omitempty"`
+
+	Queries []Query `yaml:"queries,omitempty"`
+}
+
+// Query is a single SQL query whose result rows are converted into metrics.
+type Query struct {
+	Name  string `yaml:"name,omitempty"`
+	Query string `yaml:"query,omitempty"`
+
+	// Timeout for the query. Defaults to Config.QueryTimeout.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// Interval runs the query in the background on a schedule rather than at
+	// scrape time. Scrapes return the results of the most recent run.
+	Interval time.Duration `yaml:"interval,omitempty"`
+
+	// CacheTTL caches the results of a scrape-time query for the given
+	// duration. Ignored when Interval is set.
+	CacheTTL time.Duration `yaml:"cache_ttl,omitempty"`
+
+	Metrics []Metric `yaml:"metrics,omitempty"`
+}
+
+// Metric maps columns from the result of a query to a metric.
+type Metric struct {
+	Name string `yaml:"name,omitempty"`
+	Help string `yaml:"help,omitempty"`
+	// Type of the metric. Defaults to gauge.
+	Type string `yaml:"type,omitempty"`
+
+	// ValueColumn is the column holding the metric's value.
+	ValueColumn string `yaml:"value_column,omitempty"`
+	// LabelColumns are the columns to use as labels. The column names are
+	// used as label names.
+	LabelColumns []string `yaml:"label_columns,omitempty"`
+	// StaticLabels are added to every series of the metric.
+	StaticLabels map[string]string `yaml:"static_labels,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler for Config.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConfig
+
+	type plain Config
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.validate()
+}
+
+func (c *Config) validate() error {
+	if _, ok := drivers[c.Driver]; !ok {
+		return fmt.Errorf("unsupported driver %q", c.Driver)
+	}
+	if c.DataSourceName == "" {
+		return fmt.Errorf("data_source_name must be set")
+	}
+	if len(c.Queries) == 0 {
+		return fmt.Errorf("at least one query must be configured")
+	}
+
+	var (
+		queryNames  = make(map[string]struct{}, len(c.Queries))
+		metricNames = make(map[string]struct{})
+	)
+	for _, q := range c.Queries {
+		if q.Name == "" {
+			return fmt.Errorf("query name must not be empty")
+		}
+		if _, exist := queryNames[q.Name]; exist {
+			return fmt.Errorf("found multiple queries named %q", q.Name)
+		}
+		queryNames[q.Name] = struct{}{}
+
+		if strings.TrimSpace(q.Query) == "" {
+			return fmt.Errorf("query %q: query must not be empty", q.Name)
+		}
+		if q.Timeout < 0 || q.Interval < 0 || q.CacheTTL < 0 {
+			return fmt.Errorf("query %q: durations must not be negative", q.Name)
+		}
+		if len(q.Metrics) == 0 {
+			return fmt.Errorf("query %q: at least one metric must be configured", q.Name)
+		}
+
+		for _, m := range q.Metrics {
+			if err := m.validate(); err != nil {
+				return fmt.Errorf("query %q: %w", q.Name, err)
+			}
+			if _, exist := metricNames[m.Name]; exist {
+				return fmt.Errorf("query %q: metric %q is defined more than once", q.Name, m.Name)
+			}
+			metricNames[m.Name] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+func (m Metric) validate() error {
+	if !model.IsValidMetricName(model.LabelValue(m.Name)) {
+		return fmt.Errorf("invalid metric name %q", m.Name)
+	}
+	switch m.Type {
+	case "", MetricTypeGauge, MetricTypeCounter:
+	default:
+		return fmt.Errorf("metric %q: unsupported type %q", m.Name, m.Type)
+	}
+	if m.ValueColumn == "" {
+		return fmt.Errorf("metric %q: value_column must be set", m.Name)
+	}
+
+	labels := make(map[string]struct{}, len(m.LabelColumns)+len(m.StaticLabels))
+	checkLabel := func(name string) error {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("metric %q: invalid label name %q", m.Name, name)
+		}
+		if _, exist := labels[name]; exist {
+			return fmt.Errorf("metric %q: label %q is defined more than once", m.Name, name)
+		}
+		labels[name] = struct{}{}
+		return nil
+	}
+	for _, l := range m.LabelColumns {
+		if err := checkLabel(l); err != nil {
+			return err
+		}
+	}
+	for l := range m.StaticLabels {
+		if err := checkLabel(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the integration that this config represents.
+func (c *Config) Name() string {
+	return "sql_exporter"
+}
+
+// InstanceKey returns the address of the database server, or agentKey when
+// the address can't be determined from the DSN.
+func (c *Config) InstanceKey(agentKey string) (string, error) {
+	dsn := string(c.DataSourceName)
+
+	switch drivers[c.Driver] {
+	case "mysql":
+		m, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse DSN: %w", err)
+		}
+		if m.Addr != "" {
+			return m.Addr, nil
+		}
+	case "sqlite3":
+		path := strings.TrimPrefix(dsn, "file:")
+		if idx := strings.Index(path, "?"); idx >= 0 {
+			path = path[:idx]
+		}
+		if path != "" {
+			return path, nil
+		}
+	default:
+		u, err := url.Parse(dsn)
+		if err == nil && u.Host != "" {
+			return u.Host, nil
+		}
+	}
+
+	return agentKey, nil
+}
+
+// NewIntegration converts this config into an instance of an integration.
+func (c *Config) NewIntegration(l log.Logger) (integrations.Integration, error) {
+	return New(l, c)
+}
+
+func init() {
+	integrations.RegisterIntegration(&Config{})
+	integrations_v2.RegisterLegacy(&Config{}, integrations_v2.TypeMultiplex, metricsutils.NewNamedShim("sql"))
+}
+
+// New creates a new sql_exporter integration. The integration runs the
+// configured queries against the database and exposes their results as
+// metrics.
+func New(l log.Logger, c *Config) (integrations.Integration, error) {
+	driver, ok := drivers[c.Driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported driver %q", c.Driver)
+	}
+
+	db, err := sql.Open(driver, string(c.DataSourceName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetMaxOpenConns(c.MaxOpenConnections)
+	db.SetMaxIdleConns(c.MaxIdleConnections)
+
+	col := newCollector(l, db, c)
+	return integrations.NewCollectorIntegration(
+		c.Name(),
+		integrations.WithCollectors(col),
+		integrations.WithRunner(col.Run),
+	), nil
+}
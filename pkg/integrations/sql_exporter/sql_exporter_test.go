@@ -0,0 +1,399 @@
+package sql_exporter //nolint:golint
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	config_util "github.com/prometheus/common/config"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConfig_Unmarshal(t *testing.T) {
+	tt := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{
+			name: "valid",
+			config: `
+driver: postgres
+data_source_name: postgresql://localhost:5432/shop
+queries:
+- name: orders
+  query: SELECT region, count(*) AS total FROM orders GROUP BY region
+  metrics:
+  - name: shop_orders
+    value_column: total
+    label_columns: [region]`,
+		},
+		{
+			name:   "unsupported driver",
+			config: `{driver: oracle, data_source_name: foo}`,
+			err:    `unsupported driver "oracle"`,
+		},
+		{
+			name: "duplicate metric",
+			config: `
+driver: mysql
+data_source_name: root@(localhost:3306)/
+queries:
+- name: a
+  query: SELECT 1 AS v
+  metrics: [{name: m, value_column: v}]
+- name: b
+  query: SELECT 1 AS v
+  metrics: [{name: m, value_column: v}]`,
+			err: `query "b": metric "m" is defined more than once`,
+		},
+		{
+			name: "invalid type",
+			config: `
+driver: sqlite
+data_source_name: /tmp/db.sqlite
+queries:
+- name: a
+  query: SELECT 1 AS v
+  metrics: [{name: m, value_column: v, type: histogram}]`,
+			err: `query "a": metric "m": unsupported type "histogram"`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var c Config
+			err := yaml.UnmarshalStrict([]byte(tc.config), &c)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, DefaultConfig.QueryTimeout, c.QueryTimeout)
+		})
+	}
+}
+
+func TestConfig_InstanceKey(t *testing.T) {
+	tt := []struct {
+		driver, dsn, expect string
+	}{
+		{"postgres", "postgresql://user:pass@db:5432/shop?sslmode=disable", "db:5432"},
+		{"postgres", "host=db dbname=shop", "agent"},
+		{"mysql", "root:secret@tcp(db:3306)/shop", "db:3306"},
+		{"sqlserver", "sqlserver://sa:secret@db:1433?database=shop", "db:1433"},
+		{"sqlite", "file:/var/lib/shop.db?mode=ro", "/var/lib/shop.db"},
+	}
+
+	for _, tc := range tt {
+		c := Config{Driver: tc.driver, DataSourceName: config_util.Secret(tc.dsn)}
+		key, err := c.InstanceKey("agent")
+		require.NoError(t, err)
+		require.Equal(t, tc.expect, key, tc.dsn)
+	}
+}
+
+func TestCollector(t *testing.T) {
+	db := newFakeDB(t)
+	db.set("SELECT region, total FROM orders", []string{"REGION", "TOTAL"}, [][]driver.Value{
+		{"eu", int64(10)},
+		{"us", []byte("2.5")},
+		{"apac", nil},
+	})
+
+	c := testConfig(t, `
+queries:
+- name: orders
+  query: SELECT region, total FROM orders
+  metrics:
+  - name: shop_orders_total
+    type: counter
+    help: Total orders.
+    value_column: total
+    label_columns: [region]
+    static_labels:
+      shop: main
+- name: broken
+  query: SELECT missing
+  metrics:
+  - name: shop_broken
+    value_column: v`)
+
+	col := newCollector(log.NewNopLogger(), db.db, c)
+	body := scrape(t, col)
+
+	require.Contains(t, body, "# TYPE shop_orders_total counter")
+	require.Contains(t, body, `shop_orders_total{region="eu",shop="main"} 10`)
+	require.Contains(t, body, `shop_orders_total{region="us",shop="main"} 2.5`)
+	require.NotContains(t, body, `region="apac"`)
+	require.Contains(t, body, "sql_exporter_up 1")
+	require.Contains(t, body, `sql_exporter_query_errors_total{query="broken"} 1`)
+	require.Contains(t, body, `sql_exporter_query_errors_total{query="orders"} 0`)
+	require.NotContains(t, body, "shop_broken")
+}
+
+func TestCollector_DuplicateLabels(t *testing.T) {
+	db := newFakeDB(t)
+	db.set("SELECT region, total FROM orders", []string{"region", "total"}, [][]driver.Value{
+		{"eu", int64(10)},
+		{"eu", int64(20)},
+		{"us", int64(5)},
+	})
+
+	c := testConfig(t, `
+queries:
+- name: orders
+  query: SELECT region, total FROM orders
+  metrics:
+  - name: shop_orders
+    value_column: total
+    label_columns: [region]`)
+
+	col := newCollector(log.NewNopLogger(), db.db, c)
+	body := scrape(t, col)
+
+	// The scrape must succeed, keeping the first row of the duplicated labels.
+	require.Contains(t, body, `shop_orders{region="eu"} 10`)
+	require.NotContains(t, body, `shop_orders{region="eu"} 20`)
+	require.Contains(t, body, `shop_orders{region="us"} 5`)
+	require.Contains(t, body, `sql_exporter_query_errors_total{query="orders"} 1`)
+}
+
+func TestCollector_CacheTTL(t *testing.T) {
+	db := newFakeDB(t)
+	db.set("SELECT v", []string{"v"}, [][]driver.Value{{int64(1)}})
+
+	c := testConfig(t, `
+queries:
+- name: cached
+  query: SELECT v
+  cache_ttl: 1h
+  metrics: [{name: cached_value, value_column: v}]`)
+
+	col := newCollector(log.NewNopLogger(), db.db, c)
+	require.Contains(t, scrape(t, col), "cached_value 1")
+
+	// The cached result should be served even though the data changed.
+	db.set("SELECT v", []string{"v"}, [][]driver.Value{{int64(2)}})
+	require.Contains(t, scrape(t, col), "cached_value 1")
+	require.Equal(t, 1, db.calls("SELECT v"))
+}
+
+func TestCollector_Timeout(t *testing.T) {
+	db := newFakeDB(t)
+	db.set("SELECT v", []string{"v"}, [][]driver.Value{{int64(1)}})
+	db.setDelay("SELECT v", time.Minute)
+
+	c := testConfig(t, `
+queries:
+- name: slow
+  query: SELECT v
+  timeout: 50ms
+  metrics: [{name: slow_value, value_column: v}]`)
+
+	col := newCollector(log.NewNopLogger(), db.db, c)
+	body := scrape(t, col)
+	require.NotContains(t, body, "slow_value")
+	require.Contains(t, body, `sql_exporter_query_errors_total{query="slow"} 1`)
+}
+
+func TestCollector_Scheduled(t *testing.T) {
+	db := newFakeDB(t)
+	db.set("SELECT v", []string{"v"}, [][]driver.Value{{int64(1)}})
+
+	c := testConfig(t, `
+queries:
+- name: scheduled
+  query: SELECT v
+  interval: 50ms
+  metrics: [{name: scheduled_value, value_column: v}]`)
+
+	col := newCollector(log.NewNopLogger(), db.db, c)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = col.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	require.Eventually(t, func() bool {
+		return db.calls("SELECT v") >= 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Scrapes shouldn't cause scheduled queries to run.
+	calls := db.calls("SELECT v")
+	require.Contains(t, scrape(t, col), "scheduled_value 1")
+	require.LessOrEqual(t, db.calls("SELECT v"), calls+1)
+}
+
+func testConfig(t *testing.T, queries string) *Config {
+	t.Helper()
+
+	cfg := "driver: sqlite\ndata_source_name: test.db\n" + strings.TrimSpace(queries)
+	var c Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(cfg), &c))
+	return &c
+}
+
+func scrape(t *testing.T, c prometheus.Collector) string {
+	t.Helper()
+
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(c))
+
+	srv := httptest.NewServer(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+//
+// Fake database driver
+//
+
+var (
+	fakeDriverOnce sync.Once
+	fakeDBs        sync.Map // DSN -> *fakeDB
+)
+
+type fakeDB struct {
+	db *sql.DB
+
+	mut     sync.Mutex
+	results map[string]fakeResult
+	counts  map[string]int
+}
+
+type fakeResult struct {
+	columns []string
+	rows    [][]driver.Value
+	delay   time.Duration
+}
+
+func newFakeDB(t *testing.T) *fakeDB {
+	fakeDriverOnce.Do(func() {
+		sql.Register("sql_exporter_fake", fakeDriver{})
+	})
+
+	fdb := &fakeDB{
+		results: make(map[string]fakeResult),
+		counts:  make(map[string]int),
+	}
+	fakeDBs.Store(t.Name(), fdb)
+	t.Cleanup(func() { fakeDBs.Delete(t.Name()) })
+
+	db, err := sql.Open("sql_exporter_fake", t.Name())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	fdb.db = db
+	return fdb
+}
+
+func (db *fakeDB) set(query string, columns []string, rows [][]driver.Value) {
+	db.mut.Lock()
+	defer db.mut.Unlock()
+	res := db.results[query]
+	res.columns, res.rows = columns, rows
+	db.results[query] = res
+}
+
+func (db *fakeDB) setDelay(query string, delay time.Duration) {
+	db.mut.Lock()
+	defer db.mut.Unlock()
+	res := db.results[query]
+	res.delay = delay
+	db.results[query] = res
+}
+
+func (db *fakeDB) calls(query string) int {
+	db.mut.Lock()
+	defer db.mut.Unlock()
+	return db.counts[query]
+}
+
+func (db *fakeDB) query(ctx context.Context, query string) (driver.Rows, error) {
+	db.mut.Lock()
+	res, ok := db.results[query]
+	db.counts[query]++
+	db.mut.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown query %q", query)
+	}
+	if res.delay > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(res.delay):
+		}
+	}
+	return &fakeRows{columns: res.columns, rows: res.rows}, nil
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	db, ok := fakeDBs.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown database %q", name)
+	}
+	return &fakeConn{db: db.(*fakeDB)}, nil
+}
+
+type fakeConn struct{ db *fakeDB }
+
+var (
+	_ driver.QueryerContext = (*fakeConn)(nil)
+	_ driver.Pinger         = (*fakeConn)(nil)
+)
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepared statements are not supported")
+}
+func (c *fakeConn) Close() error { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions are not supported")
+}
+func (c *fakeConn) Ping(context.Context) error { return nil }
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	return c.db.query(ctx, query)
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
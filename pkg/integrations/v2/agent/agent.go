@@ -1,19 +1,60 @@
 // Package agent is an "example" integration that has very little functionality,
 // but is still useful in practice. The Agent integration re-exposes the Agent's
-// own metrics endpoint and allows the Agent to scrape itself.
+// own metrics endpoint and allows the Agent to scrape itself. It can also ship
+// the Agent's own logs to a logs instance.
 package agent
 
 import (
+	"fmt"
+
 	"github.com/go-kit/log"
 	"github.com/grafana/agent/pkg/integrations/v2"
 	"github.com/grafana/agent/pkg/integrations/v2/common"
 	"github.com/grafana/agent/pkg/integrations/v2/metricsutils"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/model"
 )
 
+// DefaultSelfLogsConfig holds the default settings for SelfLogsConfig.
+var DefaultSelfLogsConfig = SelfLogsConfig{
+	BufferSize: 1000,
+}
+
 // Config controls the Agent integration.
 type Config struct {
 	Common common.MetricsConfig `yaml:",inline"`
+
+	// SelfLogs configures shipping the Agent's own logs to a logs instance.
+	SelfLogs SelfLogsConfig `yaml:"self_logs,omitempty"`
+}
+
+// SelfLogsConfig configures shipping the Agent's own logs to a logs instance.
+// The log lines which pass the log level of the server are shipped.
+type SelfLogsConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Name of the logs instance to ship logs to. Defaults to the logs instance
+	// of the integrations subsystem.
+	LogsInstance string `yaml:"logs_instance,omitempty"`
+	// Labels added to every log line, in addition to the job, instance and
+	// agent_hostname labels.
+	Labels model.LabelSet `yaml:"labels,omitempty"`
+	// Maximum number of log lines waiting to be shipped. Log lines are dropped
+	// when the buffer is full, such as when the logs instance is down.
+	BufferSize int `yaml:"buffer_size,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler for SelfLogsConfig.
+func (c *SelfLogsConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultSelfLogsConfig
+
+	type plain SelfLogsConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.BufferSize <= 0 {
+		return fmt.Errorf("self_logs.buffer_size must be greater than zero")
+	}
+	return c.Labels.Validate()
 }
 
 // Name returns the name of the integration that this config represents.
@@ -38,7 +79,23 @@ func (c *Config) Identifier(globals integrations.Globals) (string, error) {
 
 // NewIntegration converts this config into an instance of an integration.
 func (c *Config) NewIntegration(l log.Logger, globals integrations.Globals) (integrations.Integration, error) {
-	return metricsutils.NewMetricsHandlerIntegration(l, c, c.Common, globals, promhttp.Handler())
+	mi, err := metricsutils.NewMetricsHandlerIntegration(l, c, c.Common, globals, promhttp.Handler())
+	if err != nil || !c.SelfLogs.Enabled {
+		return mi, err
+	}
+
+	if globals.AgentLogger == nil {
+		return nil, fmt.Errorf("self_logs is not supported: the logger of the agent is unavailable")
+	}
+	id, err := c.Identifier(globals)
+	if err != nil {
+		return nil, err
+	}
+	return newSelfLogs(l, mi.(metricsIntegration), globals.AgentLogger, c.SelfLogs, model.LabelSet{
+		model.JobLabel:      model.LabelValue("integrations/" + c.Name()),
+		model.InstanceLabel: model.LabelValue(id),
+		"agent_hostname":    model.LabelValue(globals.AgentIdentifier),
+	}), nil
 }
 
 func init() {
@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/agent/pkg/integrations/v2"
+	"github.com/grafana/agent/pkg/server"
+	"github.com/grafana/loki/clients/pkg/promtail/api"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+)
+
+// selfLogsStream is the log stream the Agent's own logs are sent to.
+const selfLogsStream = "self_logs"
+
+// selfLogsSendTimeout is how long to wait for the logs instance to accept a
+// log line before dropping it.
+const selfLogsSendTimeout = 5 * time.Second
+
+// selfLogsComponent is the value of the component key of log lines written by
+// the self_logs integration itself.
+const selfLogsComponent = "self_logs"
+
+// ignoredComponents are the values of the component key of log lines which
+// are never shipped. Lines from the logs subsystem, such as errors sending
+// batches, and from the integration itself would otherwise feed back into the
+// logs instance which caused them.
+var ignoredComponents = map[string]struct{}{
+	"logs":            {},
+	selfLogsComponent: {},
+}
+
+var (
+	selfLogsSent = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "agent_self_logs_sent_total",
+		Help: "Total number of log lines of the agent sent to a logs instance.",
+	})
+	selfLogsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_self_logs_dropped_total",
+		Help: "Total number of log lines of the agent which couldn't be sent to a logs instance.",
+	}, []string{"reason"})
+)
+
+// metricsIntegration is the integration used to expose the metrics of the
+// Agent.
+type metricsIntegration interface {
+	integrations.HTTPIntegration
+	integrations.MetricsIntegration
+}
+
+// selfLogs is the Agent integration with self_logs enabled. It tees the
+// logger of the Agent and ships the log lines to a logs instance.
+type selfLogs struct {
+	metricsIntegration
+
+	log         log.Logger
+	agentLogger *server.Logger
+	instance    string
+	labels      model.LabelSet
+
+	entries chan api.Entry
+	sink    integrations.LogsSink
+}
+
+// Static typecheck tests
+var (
+	_ integrations.HTTPIntegration    = (*selfLogs)(nil)
+	_ integrations.MetricsIntegration = (*selfLogs)(nil)
+	_ integrations.LogsIntegration    = (*selfLogs)(nil)
+)
+
+func newSelfLogs(l log.Logger, mi metricsIntegration, agentLogger *server.Logger, c SelfLogsConfig, identity model.LabelSet) *selfLogs {
+	return &selfLogs{
+		metricsIntegration: mi,
+
+		log:         log.With(l, "component", selfLogsComponent),
+		agentLogger: agentLogger,
+		instance:    c.LogsInstance,
+		labels:      identity.Merge(c.Labels),
+
+		entries: make(chan api.Entry, c.BufferSize),
+	}
+}
+
+// LogsInstance implements integrations.LogsIntegration.
+func (i *selfLogs) LogsInstance() string { return i.instance }
+
+// LogStreams implements integrations.LogsIntegration.
+func (i *selfLogs) LogStreams() []integrations.LogStream {
+	return []integrations.LogStream{{Name: selfLogsStream, Labels: i.labels}}
+}
+
+// SetLogsSink implements integrations.LogsIntegration.
+func (i *selfLogs) SetLogsSink(s integrations.LogsSink) { i.sink = s }
+
+// RunIntegration implements integrations.Integration. Log lines are only
+// shipped while the integration runs.
+func (i *selfLogs) RunIntegration(ctx context.Context) error {
+	unregister := i.agentLogger.Tee(log.LoggerFunc(i.enqueue))
+	defer unregister()
+
+	// failing is set while entries can't be sent, so that only the first
+	// failure is logged rather than one line per entry.
+	var failing bool
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case entry := <-i.entries:
+			sendCtx, cancel := context.WithTimeout(ctx, selfLogsSendTimeout)
+			err := i.sink.Send(sendCtx, selfLogsStream, entry)
+			cancel()
+
+			switch {
+			case err != nil && ctx.Err() != nil:
+				return nil
+			case err != nil:
+				selfLogsDropped.WithLabelValues("send_failed").Inc()
+				if !failing {
+					level.Warn(i.log).Log("msg", "failed to ship agent logs, dropping log lines until the logs instance recovers", "err", err)
+					failing = true
+				}
+			default:
+				selfLogsSent.Inc()
+				if failing {
+					level.Info(i.log).Log("msg", "shipping agent logs again")
+					failing = false
+				}
+			}
+		}
+	}
+}
+
+// enqueue queues a log line of the Agent to be shipped. It's called by the
+// logger of the Agent and never blocks: lines are dropped when the buffer is
+// full.
+func (i *selfLogs) enqueue(kvps ...interface{}) error {
+	if isIgnored(kvps) {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := log.NewLogfmtLogger(&buf).Log(kvps...); err != nil {
+		return err
+	}
+
+	labels := model.LabelSet{}
+	if lvl := logValue(kvps, "level"); lvl != "" {
+		labels["level"] = model.LabelValue(lvl)
+	}
+
+	entry := api.Entry{
+		Labels: labels,
+		Entry: logproto.Entry{
+			Timestamp: time.Now(),
+			Line:      string(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))),
+		},
+	}
+
+	select {
+	case i.entries <- entry:
+	default:
+		selfLogsDropped.WithLabelValues("buffer_full").Inc()
+	}
+	return nil
+}
+
+// isIgnored returns true if the log line comes from one of the
+// ignoredComponents. Loggers can be nested, so every component key is
+// checked.
+func isIgnored(kvps []interface{}) bool {
+	for i := 0; i+1 < len(kvps); i += 2 {
+		if fmt.Sprint(kvps[i]) != "component" {
+			continue
+		}
+		if _, ignored := ignoredComponents[fmt.Sprint(kvps[i+1])]; ignored {
+			return true
+		}
+	}
+	return false
+}
+
+// logValue returns the value of key in the key/value pairs of a log line, or
+// an empty string if key isn't found.
+func logValue(kvps []interface{}, key string) string {
+	for i := 0; i+1 < len(kvps); i += 2 {
+		if fmt.Sprint(kvps[i]) == key {
+			return fmt.Sprint(kvps[i+1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/agent/pkg/integrations/v2"
+	"github.com/grafana/agent/pkg/server"
+	"github.com/grafana/loki/clients/pkg/promtail/api"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/logging"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSelfLogsConfig_Unmarshal(t *testing.T) {
+	var c Config
+	err := yaml.UnmarshalStrict([]byte(`
+self_logs:
+  enabled: true
+  labels:
+    cluster: prod`), &c)
+	require.NoError(t, err)
+	require.Equal(t, SelfLogsConfig{
+		Enabled:    true,
+		Labels:     model.LabelSet{"cluster": "prod"},
+		BufferSize: DefaultSelfLogsConfig.BufferSize,
+	}, c.SelfLogs)
+
+	err = yaml.UnmarshalStrict([]byte(`
+self_logs:
+  enabled: true
+  buffer_size: 0`), &c)
+	require.EqualError(t, err, "self_logs.buffer_size must be greater than zero")
+}
+
+func TestSelfLogs(t *testing.T) {
+	agentLogger := newTestLogger(t)
+	sink := &mockLogsSink{entries: make(chan api.Entry, 10)}
+
+	i := newSelfLogs(log.NewNopLogger(), nil, agentLogger, DefaultSelfLogsConfig, model.LabelSet{"job": "integrations/agent"})
+	i.SetLogsSink(sink)
+	require.Equal(t, []integrations.LogStream{{Name: selfLogsStream, Labels: model.LabelSet{"job": "integrations/agent"}}}, i.LogStreams())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = i.RunIntegration(ctx) }()
+
+	// Log until the integration registered its tee.
+	var entry api.Entry
+	require.Eventually(t, func() bool {
+		level.Info(agentLogger).Log("msg", "hello")
+		select {
+		case entry = <-sink.entries:
+			return true
+		default:
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, model.LabelSet{"level": "info"}, entry.Labels)
+	require.Equal(t, "level=info msg=hello", entry.Line)
+}
+
+func TestSelfLogs_Loop(t *testing.T) {
+	i := newSelfLogs(log.NewNopLogger(), nil, newTestLogger(t), DefaultSelfLogsConfig, nil)
+
+	// Lines from the logs subsystem and from the integration itself must not be
+	// shipped.
+	require.NoError(t, log.With(log.LoggerFunc(i.enqueue), "component", "logs").Log("msg", "error sending batch"))
+	require.NoError(t, log.With(log.LoggerFunc(i.enqueue), "integration", "agent", "component", selfLogsComponent).Log("msg", "failed to ship agent logs"))
+	require.NoError(t, log.With(log.LoggerFunc(i.enqueue), "component", "integrations", "component", "self_logs").Log("msg", "nested"))
+	require.Len(t, i.entries, 0)
+
+	require.NoError(t, log.With(log.LoggerFunc(i.enqueue), "component", "integrations").Log("msg", "shipped"))
+	require.Len(t, i.entries, 1)
+}
+
+func TestSelfLogs_BufferFull(t *testing.T) {
+	c := DefaultSelfLogsConfig
+	c.BufferSize = 1
+	i := newSelfLogs(log.NewNopLogger(), nil, newTestLogger(t), c, nil)
+
+	dropped := testutil.ToFloat64(selfLogsDropped.WithLabelValues("buffer_full"))
+	require.NoError(t, i.enqueue("msg", "first"))
+	require.NoError(t, i.enqueue("msg", "second"))
+
+	require.Len(t, i.entries, 1)
+	require.Equal(t, dropped+1, testutil.ToFloat64(selfLogsDropped.WithLabelValues("buffer_full")))
+}
+
+func newTestLogger(t *testing.T) *server.Logger {
+	t.Helper()
+
+	var (
+		lvl    logging.Level
+		format logging.Format
+	)
+	require.NoError(t, lvl.Set("info"))
+	require.NoError(t, format.Set("logfmt"))
+	return server.NewLoggerFromLevel(lvl, format)
+}
+
+type mockLogsSink struct {
+	entries chan api.Entry
+}
+
+func (s *mockLogsSink) Send(ctx context.Context, stream string, entry api.Entry) error {
+	if stream != selfLogsStream {
+		return fmt.Errorf("unknown log stream %q", stream)
+	}
+	select {
+	case s.entries <- entry:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
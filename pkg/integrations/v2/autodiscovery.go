@@ -0,0 +1,163 @@
+package integrations
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/grafana/agent/pkg/integrations/v2/autodiscovery"
+	"github.com/prometheus/common/model"
+)
+
+// DiscoveryState is the state of an integration found by autodiscovery.
+type DiscoveryState string
+
+const (
+	// DiscoveryActive is the state of discovered integrations which are
+	// running.
+	DiscoveryActive DiscoveryState = "active"
+	// DiscoverySkipped is the state of discovered integrations which were not
+	// created because an explicit config or another discovered service
+	// already defines them.
+	DiscoverySkipped DiscoveryState = "skipped"
+	// DiscoveryInvalid is the state of discovered integrations whose rendered
+	// config is invalid, or whose integration couldn't be constructed.
+	DiscoveryInvalid DiscoveryState = "invalid"
+)
+
+// DiscoveredIntegration is an integration found by autodiscovery, returned by
+// the autodiscovery API.
+type DiscoveredIntegration struct {
+	Name     string         `json:"name"`
+	Instance string         `json:"instance,omitempty"`
+	Rule     string         `json:"rule"`
+	Address  string         `json:"address"`
+	Labels   model.LabelSet `json:"labels"`
+	State    DiscoveryState `json:"state"`
+	Reason   string         `json:"reason,omitempty"`
+}
+
+// mergeDiscovered returns the configs of the integrations to run for
+// autodiscovery results, along with the status of every result. Explicit
+// configs always take precedence: a discovered integration is skipped if an
+// explicit config has the same name and identifier, or if it's a singleton
+// integration which is already configured.
+//
+// Discovered configs are validated before being returned so a single bad
+// template can't prevent other integrations from being applied. Integrations
+// which fail to be constructed are skipped by the controller and marked with
+// markFailed. The n-th active entry of status belongs to the n-th config.
+func mergeDiscovered(explicit Configs, results []autodiscovery.Result, globals Globals) (Configs, []DiscoveredIntegration) {
+	var (
+		merged Configs
+		status = make([]DiscoveredIntegration, 0, len(results))
+
+		explicitNames = make(map[string]struct{}, len(explicit))
+		ids           = make(map[integrationID]string, len(explicit)) // Used ID -> owner
+	)
+	for _, c := range explicit {
+		explicitNames[c.Name()] = struct{}{}
+		if identifier, err := c.Identifier(globals); err == nil {
+			ids[integrationID{Name: c.Name(), Identifier: identifier}] = "explicit config"
+		}
+	}
+
+	for _, r := range results {
+		di := DiscoveredIntegration{
+			Name:    r.Integration,
+			Rule:    r.Rule,
+			Address: r.Address(),
+			Labels:  r.Labels,
+			State:   DiscoveryInvalid,
+		}
+
+		c, err := newDiscoveredConfig(r, globals)
+		if err != nil {
+			di.Reason = err.Error()
+			status = append(status, di)
+			continue
+		}
+
+		identifier, _ := c.Identifier(globals)
+		id := integrationID{Name: c.Name(), Identifier: identifier}
+		di.Name, di.Instance = id.Name, id.Identifier
+
+		if t, _ := RegisteredType(c); t == TypeSingleton {
+			if _, ok := explicitNames[id.Name]; ok {
+				di.State = DiscoverySkipped
+				di.Reason = fmt.Sprintf("integration %q is explicitly configured", id.Name)
+				status = append(status, di)
+				continue
+			}
+			// Only the first discovered service can create a singleton.
+			id.Identifier = ""
+		}
+		if owner, ok := ids[id]; ok {
+			di.State = DiscoverySkipped
+			di.Reason = fmt.Sprintf("integration %s is already defined by %s", id, owner)
+			status = append(status, di)
+			continue
+		}
+		ids[id] = fmt.Sprintf("rule %q for %s", r.Rule, r.Address())
+
+		di.State = DiscoveryActive
+		status = append(status, di)
+		merged = append(merged, c)
+	}
+	return merged, status
+}
+
+// markFailed marks the active entries of status whose integration failed to
+// be constructed as invalid. failed holds the errors of the controller by
+// index of the configs returned by mergeDiscovered.
+func markFailed(status []DiscoveredIntegration, failed map[int]error) {
+	var idx int
+	for i := range status {
+		if status[i].State != DiscoveryActive {
+			continue
+		}
+		if err, ok := failed[idx]; ok {
+			status[i].State = DiscoveryInvalid
+			status[i].Reason = err.Error()
+		}
+		idx++
+	}
+}
+
+// sortDiscoveryStatus sorts status by integration name and instance.
+func sortDiscoveryStatus(status []DiscoveredIntegration) {
+	sort.SliceStable(status, func(i, j int) bool {
+		if status[i].Name != status[j].Name {
+			return status[i].Name < status[j].Name
+		}
+		return status[i].Instance < status[j].Instance
+	})
+}
+
+// newDiscoveredConfig creates and validates the integration config for an
+// autodiscovery result. The integration isn't constructed here, since
+// constructors may have side effects like opening connections; the
+// controller skips discovered integrations which fail to be constructed.
+func newDiscoveredConfig(r autodiscovery.Result, globals Globals) (Config, error) {
+	if r.Err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", r.Err)
+	}
+
+	c, err := unmarshalConfig(r.Integration, []byte(r.Config))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.Identifier(globals); err != nil {
+		return nil, fmt.Errorf("could not build identifier: %w", err)
+	}
+
+	// Validate against a copy so the returned config is still unmodified when
+	// it's handed to the controller.
+	check, err := unmarshalConfig(r.Integration, []byte(r.Config))
+	if err != nil {
+		return nil, err
+	}
+	if err := check.ApplyDefaults(globals); err != nil {
+		return nil, fmt.Errorf("failed to apply defaults: %w", err)
+	}
+	return c, nil
+}
@@ -0,0 +1,350 @@
+// Package autodiscovery finds services running next to the agent and renders
+// integration configs for them.
+//
+// Services are found through Prometheus service discovery mechanisms: a
+// built-in mechanism for local listening sockets and their processes, and
+// optionally Docker and Kubernetes. Every discovered service is matched
+// against a list of rules. Each rule that matches renders a template into the
+// YAML config of an integration.
+package autodiscovery
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"text/template"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/discovery/kubernetes"
+	"github.com/prometheus/prometheus/discovery/moby"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/prometheus/prometheus/model/relabel"
+)
+
+// DefaultConfig holds default settings for Config.
+var DefaultConfig = Config{
+	Local: DefaultLocalConfig,
+}
+
+// Config controls autodiscovery of integrations.
+type Config struct {
+	// Enabled turns on autodiscovery. Autodiscovery is disabled by default.
+	Enabled bool `yaml:"enabled"`
+
+	Local               LocalConfig            `yaml:"local,omitempty"`
+	DockerSDConfigs     []*moby.DockerSDConfig `yaml:"docker_sd_configs,omitempty"`
+	KubernetesSDConfigs []*kubernetes.SDConfig `yaml:"kubernetes_sd_configs,omitempty"`
+	Rules               []*Rule                `yaml:"rules,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConfig
+	type plain Config
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+
+	names := make(map[string]struct{}, len(c.Rules))
+	for i, r := range c.Rules {
+		if r == nil {
+			return fmt.Errorf("empty rule at index %d", i)
+		}
+		if _, exist := names[r.Name]; exist {
+			return fmt.Errorf("found multiple rules with name %q", r.Name)
+		}
+		names[r.Name] = struct{}{}
+	}
+	return nil
+}
+
+// sdConfigs returns the service discovery configs to use for finding
+// services.
+func (c *Config) sdConfigs() discovery.Configs {
+	if !c.Enabled {
+		return nil
+	}
+
+	var cfgs discovery.Configs
+	if c.Local.Enabled {
+		local := localSDConfig(c.Local)
+		cfgs = append(cfgs, &local)
+	}
+	for _, sd := range c.DockerSDConfigs {
+		cfgs = append(cfgs, sd)
+	}
+	for _, sd := range c.KubernetesSDConfigs {
+		cfgs = append(cfgs, sd)
+	}
+	return cfgs
+}
+
+// Rule renders an integration config for services which match it.
+type Rule struct {
+	// Name of the rule. Defaults to the name of the integration.
+	Name string `yaml:"name,omitempty"`
+	// Integration is the name of the integration to create, e.g.,
+	// redis_exporter.
+	Integration string `yaml:"integration"`
+	// Match holds regular expressions which must match the labels of a
+	// discovered service for the rule to apply. Labels which don't exist are
+	// matched as an empty string.
+	Match map[model.LabelName]relabel.Regexp `yaml:"match"`
+	// Template is a Go template of the integration's YAML config. It's
+	// rendered with TemplateData.
+	Template string `yaml:"template"`
+
+	tmpl *template.Template
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (r *Rule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Rule
+	if err := unmarshal((*plain)(r)); err != nil {
+		return err
+	}
+
+	if r.Integration == "" {
+		return fmt.Errorf("rule is missing integration")
+	}
+	if r.Name == "" {
+		r.Name = r.Integration
+	}
+	if len(r.Match) == 0 {
+		return fmt.Errorf("rule %q must match at least one label", r.Name)
+	}
+
+	tmpl, err := template.New(r.Name).Option("missingkey=error").Parse(r.Template)
+	if err != nil {
+		return fmt.Errorf("invalid template for rule %q: %w", r.Name, err)
+	}
+	r.tmpl = tmpl
+	return nil
+}
+
+// Matches returns true if the labels of a service match r.
+func (r *Rule) Matches(lset model.LabelSet) bool {
+	for name, re := range r.Match {
+		if !re.MatchString(string(lset[name])) {
+			return false
+		}
+	}
+	return true
+}
+
+// TemplateData is passed to rule templates.
+type TemplateData struct {
+	// Address of the service, in host:port form.
+	Address string
+	// Host and Port of Address.
+	Host, Port string
+	// Labels of the service from service discovery.
+	Labels map[string]string
+}
+
+func newTemplateData(lset model.LabelSet) TemplateData {
+	data := TemplateData{
+		Address: string(lset[model.AddressLabel]),
+		Labels:  make(map[string]string, len(lset)),
+	}
+	if host, port, err := net.SplitHostPort(data.Address); err == nil {
+		data.Host, data.Port = host, port
+	} else {
+		data.Host = data.Address
+	}
+	for k, v := range lset {
+		data.Labels[string(k)] = string(v)
+	}
+	return data
+}
+
+// Render renders the integration config for a service.
+func (r *Rule) Render(lset model.LabelSet) (string, error) {
+	var buf bytes.Buffer
+	if err := r.tmpl.Execute(&buf, newTemplateData(lset)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Result is a service which matched a rule.
+type Result struct {
+	// Rule is the name of the rule which matched.
+	Rule string
+	// Integration is the name of the integration to create.
+	Integration string
+	// Labels of the discovered service.
+	Labels model.LabelSet
+	// Config is the rendered integration config.
+	Config string
+	// Err is set if the template couldn't be rendered.
+	Err error
+}
+
+// Address returns the address of the discovered service.
+func (r Result) Address() string {
+	return string(r.Labels[model.AddressLabel])
+}
+
+// Evaluate matches the targets of groups against rules. Every rule which
+// matches a target generates a Result.
+func Evaluate(rules []*Rule, groups map[string][]*targetgroup.Group) []Result {
+	var res []Result
+
+	for _, tgs := range groups {
+		for _, group := range tgs {
+			if group == nil {
+				continue
+			}
+			for _, target := range group.Targets {
+				lset := group.Labels.Merge(target)
+
+				for _, r := range rules {
+					if !r.Matches(lset) {
+						continue
+					}
+					config, err := r.Render(lset)
+					res = append(res, Result{
+						Rule:        r.Name,
+						Integration: r.Integration,
+						Labels:      lset,
+						Config:      config,
+						Err:         err,
+					})
+				}
+			}
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Rule != res[j].Rule {
+			return res[i].Rule < res[j].Rule
+		}
+		return res[i].Address() < res[j].Address()
+	})
+	return res
+}
+
+// Discoverer runs service discovery and evaluates rules against discovered
+// services. Results are passed to a callback whenever they change.
+type Discoverer struct {
+	log      log.Logger
+	onUpdate func([]Result)
+
+	cancel context.CancelFunc
+	exited chan struct{}
+	sd     *discovery.Manager
+
+	reload chan struct{}
+
+	mut    sync.Mutex
+	cfg    Config
+	groups map[string][]*targetgroup.Group
+}
+
+// NewDiscoverer creates a new Discoverer. onUpdate is called from a
+// background goroutine when the results change. Discoverer will run until
+// Stop is called.
+func NewDiscoverer(l log.Logger, onUpdate func([]Result)) *Discoverer {
+	l = log.With(l, "component", "autodiscovery")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d := &Discoverer{
+		log:      l,
+		onUpdate: onUpdate,
+
+		cancel: cancel,
+		exited: make(chan struct{}),
+		sd:     discovery.NewManager(ctx, l, discovery.Name("autodiscovery")),
+
+		reload: make(chan struct{}, 1),
+	}
+
+	go func() {
+		err := d.sd.Run()
+		if err != nil {
+			level.Error(l).Log("msg", "autodiscovery service discovery exited with error", "err", err)
+		}
+	}()
+	go d.run(ctx)
+	return d
+}
+
+// ApplyConfig updates the config of the Discoverer. Results are recalculated
+// in the background.
+func (d *Discoverer) ApplyConfig(c Config) error {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	err := d.sd.ApplyConfig(map[string]discovery.Configs{
+		"autodiscovery": c.sdConfigs(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply autodiscovery service discovery config: %w", err)
+	}
+	d.cfg = c
+
+	// Disabling autodiscovery should remove all results immediately rather than
+	// waiting for service discovery to report that it's empty.
+	if !c.Enabled {
+		d.groups = nil
+	}
+
+	select {
+	case d.reload <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (d *Discoverer) run(ctx context.Context) {
+	defer close(d.exited)
+
+	var last []Result
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case groups := <-d.sd.SyncCh():
+			d.mut.Lock()
+			if d.cfg.Enabled {
+				d.groups = groups
+			}
+			d.mut.Unlock()
+		case <-d.reload:
+		}
+
+		d.mut.Lock()
+		res := Evaluate(d.cfg.Rules, d.groups)
+		d.mut.Unlock()
+
+		// Local discovery reports all sockets on every refresh, so only notify
+		// about actual changes.
+		if reflect.DeepEqual(res, last) {
+			continue
+		}
+		last = res
+
+		for _, r := range res {
+			if r.Err != nil {
+				level.Warn(d.log).Log("msg", "failed to render integration config", "rule", r.Rule, "address", r.Address(), "err", r.Err)
+			}
+		}
+		d.onUpdate(res)
+	}
+}
+
+// Stop stops the Discoverer. onUpdate will not be called after Stop returns.
+func (d *Discoverer) Stop() {
+	d.cancel()
+	<-d.exited
+}
@@ -0,0 +1,154 @@
+package autodiscovery
+
+import (
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConfig_Unmarshal(t *testing.T) {
+	in := `
+enabled: true
+rules:
+  - integration: redis
+    match:
+      __meta_local_port: "6379"
+    template: |
+      redis_addr: {{ .Address }}
+  - name: mysql-local
+    integration: mysql
+    match:
+      __meta_local_process_name: mysqld
+    template: |
+      data_source_name: root@tcp({{ .Address }})/
+`
+
+	var c Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(in), &c))
+	require.True(t, c.Enabled)
+	require.Equal(t, DefaultLocalConfig, c.Local)
+	require.Len(t, c.Rules, 2)
+	require.Equal(t, "redis", c.Rules[0].Name)
+	require.Equal(t, "mysql-local", c.Rules[1].Name)
+	require.Len(t, c.sdConfigs(), 1)
+
+	t.Run("disabled", func(t *testing.T) {
+		var c Config
+		require.NoError(t, yaml.UnmarshalStrict([]byte("enabled: false"), &c))
+		require.Empty(t, c.sdConfigs())
+	})
+}
+
+func TestConfig_Unmarshal_Invalid(t *testing.T) {
+	tt := []struct {
+		name   string
+		in     string
+		expect string
+	}{
+		{
+			name: "missing integration",
+			in: `
+rules:
+  - match: {__meta_local_port: "6379"}`,
+			expect: "rule is missing integration",
+		},
+		{
+			name: "missing match",
+			in: `
+rules:
+  - integration: redis`,
+			expect: `rule "redis" must match at least one label`,
+		},
+		{
+			name: "duplicate names",
+			in: `
+rules:
+  - integration: redis
+    match: {__meta_local_port: "6379"}
+  - integration: redis
+    match: {__meta_local_port: "6380"}`,
+			expect: `found multiple rules with name "redis"`,
+		},
+		{
+			name: "bad template",
+			in: `
+rules:
+  - integration: redis
+    match: {__meta_local_port: "6379"}
+    template: "{{ .Address"`,
+			expect: `invalid template for rule "redis"`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var c Config
+			err := yaml.UnmarshalStrict([]byte(tc.in), &c)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.expect)
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	in := `
+rules:
+  - integration: redis
+    match:
+      __meta_local_port: "6379"
+    template: |
+      redis_addr: {{ .Address }}
+  - integration: memcached
+    match:
+      __meta_kubernetes_pod_annotation_agent_grafana_com_integration: memcached
+    template: |
+      memcached_address: {{ .Host }}:{{ .Port }}
+      instance: {{ index .Labels "__meta_kubernetes_pod_name" }}
+  - integration: broken
+    match:
+      __meta_local_port: "9999"
+    template: |
+      {{ .Missing }}
+`
+	var c Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(in), &c))
+
+	groups := map[string][]*targetgroup.Group{
+		"local": {{
+			Source: "local",
+			Targets: []model.LabelSet{
+				{"__address__": "localhost:6379", "__meta_local_port": "6379"},
+				{"__address__": "localhost:22", "__meta_local_port": "22"},
+				{"__address__": "localhost:9999", "__meta_local_port": "9999"},
+			},
+		}},
+		"kubernetes": {{
+			Source: "pod/default/cache",
+			Labels: model.LabelSet{
+				"__meta_kubernetes_pod_name":                                     "cache",
+				"__meta_kubernetes_pod_annotation_agent_grafana_com_integration": "memcached",
+			},
+			Targets: []model.LabelSet{
+				{"__address__": "10.0.0.5:11211"},
+			},
+		}},
+	}
+
+	res := Evaluate(c.Rules, groups)
+	require.Len(t, res, 3)
+
+	require.Equal(t, "broken", res[0].Rule)
+	require.Error(t, res[0].Err)
+
+	require.Equal(t, "memcached", res[1].Integration)
+	require.Equal(t, "10.0.0.5:11211", res[1].Address())
+	require.NoError(t, res[1].Err)
+	require.Equal(t, "memcached_address: 10.0.0.5:11211\ninstance: cache\n", res[1].Config)
+
+	require.Equal(t, "redis", res[2].Integration)
+	require.NoError(t, res[2].Err)
+	require.Equal(t, "redis_addr: localhost:6379\n", res[2].Config)
+}
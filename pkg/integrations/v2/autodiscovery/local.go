@@ -0,0 +1,193 @@
+package autodiscovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/procfs"
+	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/discovery/refresh"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+const (
+	localLabel               = model.MetaLabelPrefix + "local_"
+	localLabelListenAddress  = localLabel + "listen_address"
+	localLabelPort           = localLabel + "port"
+	localLabelProcessPID     = localLabel + "process_pid"
+	localLabelProcessName    = localLabel + "process_name"
+	localLabelProcessCmdline = localLabel + "process_cmdline"
+
+	// tcpListen is the value of the st column of /proc/net/tcp for sockets in
+	// the LISTEN state.
+	tcpListen = 0x0A
+)
+
+// DefaultLocalConfig holds default settings for LocalConfig.
+var DefaultLocalConfig = LocalConfig{
+	Enabled:         true,
+	ProcFSPath:      procfs.DefaultMountPoint,
+	RefreshInterval: model.Duration(30 * time.Second),
+}
+
+// LocalConfig configures discovery of services listening on the local host.
+// Local discovery finds listening TCP sockets and the processes which own
+// them by reading procfs, so it is only supported on Linux.
+type LocalConfig struct {
+	Enabled         bool           `yaml:"enabled"`
+	ProcFSPath      string         `yaml:"procfs_path,omitempty"`
+	RefreshInterval model.Duration `yaml:"refresh_interval,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *LocalConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultLocalConfig
+	type plain LocalConfig
+	return unmarshal((*plain)(c))
+}
+
+// localSDConfig adapts LocalConfig to a Prometheus service discovery config.
+type localSDConfig LocalConfig
+
+// Name implements discovery.Config.
+func (*localSDConfig) Name() string { return "local" }
+
+// NewDiscoverer implements discovery.Config.
+func (c *localSDConfig) NewDiscoverer(opts discovery.DiscovererOptions) (discovery.Discoverer, error) {
+	fs, err := procfs.NewFS(c.ProcFSPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open procfs: %w", err)
+	}
+	d := &localDiscovery{fs: fs}
+	return refresh.NewDiscovery(
+		opts.Logger,
+		"local",
+		time.Duration(c.RefreshInterval),
+		d.refresh,
+	), nil
+}
+
+// localDiscovery discovers listening sockets on the local host.
+type localDiscovery struct {
+	fs procfs.FS
+}
+
+// localProcess is a process that owns a listening socket.
+type localProcess struct {
+	pid     int
+	name    string
+	cmdline string
+}
+
+func (d *localDiscovery) refresh(_ context.Context) ([]*targetgroup.Group, error) {
+	var sockets procfs.NetTCP
+	for _, read := range []func() (procfs.NetTCP, error){d.fs.NetTCP, d.fs.NetTCP6} {
+		lines, err := read()
+		if err != nil {
+			// IPv6 may be disabled on the host, so only fail if no sockets could be
+			// read at all.
+			continue
+		}
+		sockets = append(sockets, lines...)
+	}
+	if sockets == nil {
+		return nil, fmt.Errorf("failed to read TCP sockets from procfs")
+	}
+
+	owners := d.socketOwners()
+
+	tg := &targetgroup.Group{Source: "local"}
+	seen := make(map[model.LabelValue]struct{})
+
+	for _, s := range sockets {
+		if s.St != tcpListen {
+			continue
+		}
+
+		listenAddr := s.LocalAddr.String()
+		addr := model.LabelValue(net.JoinHostPort(targetHost(s.LocalAddr), strconv.FormatUint(s.LocalPort, 10)))
+
+		// Services listening on both IPv4 and IPv6 should only be discovered
+		// once.
+		if _, ok := seen[addr]; ok {
+			continue
+		}
+		seen[addr] = struct{}{}
+
+		target := model.LabelSet{
+			model.AddressLabel:      addr,
+			localLabelListenAddress: model.LabelValue(listenAddr),
+			localLabelPort:          model.LabelValue(strconv.FormatUint(s.LocalPort, 10)),
+		}
+		if p, ok := owners[s.Inode]; ok {
+			target[localLabelProcessPID] = model.LabelValue(strconv.Itoa(p.pid))
+			target[localLabelProcessName] = model.LabelValue(p.name)
+			target[localLabelProcessCmdline] = model.LabelValue(p.cmdline)
+		}
+		tg.Targets = append(tg.Targets, target)
+	}
+
+	return []*targetgroup.Group{tg}, nil
+}
+
+// socketOwners maps socket inodes to the processes which own them. Processes
+// whose file descriptors can't be read, which is common when the agent isn't
+// running as root, are ignored.
+func (d *localDiscovery) socketOwners() map[uint64]localProcess {
+	owners := make(map[uint64]localProcess)
+
+	procs, err := d.fs.AllProcs()
+	if err != nil {
+		return owners
+	}
+
+	for _, p := range procs {
+		targets, err := p.FileDescriptorTargets()
+		if err != nil {
+			continue
+		}
+
+		var proc *localProcess
+		for _, t := range targets {
+			inode, ok := parseSocketInode(t)
+			if !ok {
+				continue
+			}
+			if proc == nil {
+				name, _ := p.Comm()
+				cmdline, _ := p.CmdLine()
+				proc = &localProcess{
+					pid:     p.PID,
+					name:    name,
+					cmdline: strings.Join(cmdline, " "),
+				}
+			}
+			owners[inode] = *proc
+		}
+	}
+	return owners
+}
+
+// parseSocketInode parses the inode from a file descriptor target in the
+// form socket:[inode].
+func parseSocketInode(target string) (uint64, bool) {
+	if !strings.HasPrefix(target, "socket:[") || !strings.HasSuffix(target, "]") {
+		return 0, false
+	}
+	inode, err := strconv.ParseUint(target[len("socket:["):len(target)-1], 10, 64)
+	return inode, err == nil
+}
+
+// targetHost returns the host to use for connecting to a service listening on
+// ip. Services listening on all interfaces are reached through localhost.
+func targetHost(ip net.IP) string {
+	if ip.IsUnspecified() {
+		return "localhost"
+	}
+	return ip.String()
+}
@@ -0,0 +1,77 @@
+package autodiscovery
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/procfs"
+	"github.com/stretchr/testify/require"
+)
+
+const testNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:18EB 00000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 1002 1 0000000000000000 100 0 0 10 0
+   2: 0100007F:18EB 0100007F:D2F0 01 00000000:00000000 00:00000000 00000000   999        0 1003 1 0000000000000000 100 0 0 10 0
+`
+
+const testNetTCP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:18EB 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 1004 1 0000000000000000 100 0 0 10 0
+`
+
+func TestLocalDiscovery(t *testing.T) {
+	root := t.TempDir()
+
+	writeFile := func(name, content string) {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	writeFile("net/tcp", testNetTCP)
+	writeFile("net/tcp6", testNetTCP6)
+
+	// Process 100 is Redis and owns the socket listening on 6379.
+	writeFile("100/comm", "redis-server\n")
+	writeFile("100/cmdline", "redis-server\x00*:6379\x00")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "100/fd"), 0755))
+	require.NoError(t, os.Symlink("socket:[1001]", filepath.Join(root, "100/fd/3")))
+	require.NoError(t, os.Symlink("/dev/null", filepath.Join(root, "100/fd/0")))
+
+	fs, err := procfs.NewFS(root)
+	require.NoError(t, err)
+
+	d := &localDiscovery{fs: fs}
+	tgs, err := d.refresh(context.Background())
+	require.NoError(t, err)
+	require.Len(t, tgs, 1)
+
+	expect := []model.LabelSet{
+		{
+			"__address__":                  "localhost:6379",
+			"__meta_local_listen_address":  "0.0.0.0",
+			"__meta_local_port":            "6379",
+			"__meta_local_process_pid":     "100",
+			"__meta_local_process_name":    "redis-server",
+			"__meta_local_process_cmdline": "redis-server *:6379",
+		},
+		{
+			"__address__":                 "127.0.0.1:3306",
+			"__meta_local_listen_address": "127.0.0.1",
+			"__meta_local_port":           "3306",
+		},
+	}
+	require.Equal(t, expect, tgs[0].Targets)
+}
+
+func TestParseSocketInode(t *testing.T) {
+	inode, ok := parseSocketInode("socket:[12345]")
+	require.True(t, ok)
+	require.Equal(t, uint64(12345), inode)
+
+	_, ok = parseSocketInode("pipe:[12345]")
+	require.False(t, ok)
+	_, ok = parseSocketInode("socket:[abc]")
+	require.False(t, ok)
+}
@@ -0,0 +1,112 @@
+package integrations
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/agent/pkg/integrations/v2/autodiscovery"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_mergeDiscovered(t *testing.T) {
+	setRegistered(t, map[Config]Type{
+		&testDiscoveredConfig{}: TypeMultiplex,
+		&testIntegrationA{}:     TypeSingleton,
+	})
+
+	result := func(integration, addr, config string) autodiscovery.Result {
+		return autodiscovery.Result{
+			Rule:        integration,
+			Integration: integration,
+			Labels:      model.LabelSet{model.AddressLabel: model.LabelValue(addr)},
+			Config:      config,
+		}
+	}
+
+	explicit := Configs{
+		&testDiscoveredConfig{Address: "localhost:6379"},
+		&testIntegrationA{},
+	}
+	results := []autodiscovery.Result{
+		result("discovered", "localhost:6379", "address: localhost:6379"),
+		result("discovered", "localhost:6380", "address: localhost:6380"),
+		result("discovered", "localhost:6381", "address: bad"),
+		result("discovered", "localhost:6382", "unknown_field: true"),
+		result("test", "localhost:8080", "text: hello"),
+		result("notregistered", "localhost:9090", ""),
+	}
+
+	merged, status := mergeDiscovered(explicit, results, Globals{})
+	require.Equal(t, Configs{
+		&testDiscoveredConfig{Address: "localhost:6380"},
+	}, merged)
+
+	states := make(map[string]DiscoveryState, len(status))
+	for _, s := range status {
+		states[s.Address] = s.State
+	}
+	require.Equal(t, map[string]DiscoveryState{
+		"localhost:6379": DiscoverySkipped,
+		"localhost:6380": DiscoveryActive,
+		"localhost:6381": DiscoveryInvalid,
+		"localhost:6382": DiscoveryInvalid,
+		"localhost:8080": DiscoverySkipped,
+		"localhost:9090": DiscoveryInvalid,
+	}, states)
+}
+
+func Test_mergeDiscovered_Duplicates(t *testing.T) {
+	setRegistered(t, map[Config]Type{
+		&testDiscoveredConfig{}: TypeMultiplex,
+	})
+
+	// Two rules rendering the same integration for the same address must only
+	// create it once.
+	results := []autodiscovery.Result{
+		{Rule: "a", Integration: "discovered", Labels: model.LabelSet{model.AddressLabel: "localhost:6379"}, Config: "address: localhost:6379"},
+		{Rule: "b", Integration: "discovered", Labels: model.LabelSet{model.AddressLabel: "localhost:6379"}, Config: "address: localhost:6379"},
+	}
+
+	merged, status := mergeDiscovered(nil, results, Globals{})
+	require.Len(t, merged, 1)
+	require.Len(t, status, 2)
+	require.Equal(t, DiscoveryActive, status[0].State)
+	require.Equal(t, DiscoverySkipped, status[1].State)
+	require.Equal(t, `integration discovered/localhost:6379 is already defined by rule "a" for localhost:6379`, status[1].Reason)
+}
+
+func Test_markFailed(t *testing.T) {
+	status := []DiscoveredIntegration{
+		{Address: "a", State: DiscoveryActive},
+		{Address: "b", State: DiscoverySkipped},
+		{Address: "c", State: DiscoveryActive},
+	}
+	markFailed(status, map[int]error{1: fmt.Errorf("connection refused")})
+
+	require.Equal(t, []DiscoveredIntegration{
+		{Address: "a", State: DiscoveryActive},
+		{Address: "b", State: DiscoverySkipped},
+		{Address: "c", State: DiscoveryInvalid, Reason: "connection refused"},
+	}, status)
+}
+
+type testDiscoveredConfig struct {
+	Address string `yaml:"address"`
+}
+
+func (c *testDiscoveredConfig) Name() string                       { return "discovered" }
+func (c *testDiscoveredConfig) Identifier(Globals) (string, error) { return c.Address, nil }
+func (c *testDiscoveredConfig) ApplyDefaults(Globals) error {
+	if c.Address == "bad" {
+		return fmt.Errorf("invalid address")
+	}
+	return nil
+}
+
+// NewIntegration fails the test, since discovered configs must be validated
+// without constructing integrations.
+func (c *testDiscoveredConfig) NewIntegration(log.Logger, Globals) (Integration, error) {
+	panic("NewIntegration must not be called while merging discovered configs")
+}
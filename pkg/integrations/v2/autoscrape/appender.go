@@ -1,6 +1,7 @@
 package autoscrape
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/prometheus/prometheus/model/exemplar"
@@ -8,25 +9,124 @@ import (
 	"github.com/prometheus/prometheus/storage"
 )
 
+// routingAppendable is a storage.Appendable which sends samples to the
+// instance named by their instanceLabel.
+type routingAppendable struct {
+	is InstanceStore
+}
+
+var _ storage.Appendable = (*routingAppendable)(nil)
+
+func (ra *routingAppendable) Appender(ctx context.Context) storage.Appender {
+	return &routingAppender{
+		ctx:  ctx,
+		is:   ra.is,
+		apps: make(map[string]storage.Appender),
+	}
+}
+
+// routingAppender lazily creates an appender for every instance it receives
+// samples for. All appenders are committed or rolled back together.
+//
+// Series always go to the same instance since instanceLabel is part of their
+// labels, so refs returned by an instance are only ever passed back to it.
+type routingAppender struct {
+	ctx  context.Context
+	is   InstanceStore
+	apps map[string]storage.Appender
+}
+
+var _ storage.Appender = (*routingAppender)(nil)
+
+// appender returns the appender for the instance of l and l without
+// instanceLabel.
+func (ra *routingAppender) appender(l labels.Labels) (storage.Appender, labels.Labels) {
+	name := l.Get(instanceLabel)
+
+	app, ok := ra.apps[name]
+	if !ok {
+		app = ra.newAppender(name)
+		ra.apps[name] = app
+	}
+	return app, withoutLabel(l, instanceLabel)
+}
+
+func (ra *routingAppender) newAppender(name string) storage.Appender {
+	if name == "" {
+		return &failedAppender{err: fmt.Errorf("sample is missing the %s label", instanceLabel)}
+	}
+	mi, err := ra.is.GetInstance(name)
+	if err != nil {
+		return &failedAppender{err: fmt.Errorf("no such instance %s", name)}
+	}
+	return mi.Appender(ra.ctx)
+}
+
+func (ra *routingAppender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	app, l := ra.appender(l)
+	return app.Append(ref, l, t, v)
+}
+
+func (ra *routingAppender) AppendExemplar(ref storage.SeriesRef, l labels.Labels, e exemplar.Exemplar) (storage.SeriesRef, error) {
+	app, l := ra.appender(l)
+	return app.AppendExemplar(ref, l, e)
+}
+
+// Commit commits all appenders, returning the first error.
+func (ra *routingAppender) Commit() error {
+	var firstErr error
+	for _, app := range ra.apps {
+		if err := app.Commit(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Rollback rolls back all appenders, returning the first error.
+func (ra *routingAppender) Rollback() error {
+	var firstErr error
+	for _, app := range ra.apps {
+		if err := app.Rollback(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// withoutLabel returns a copy of l without the label called name. l is
+// returned if it doesn't have the label.
+func withoutLabel(l labels.Labels, name string) labels.Labels {
+	for i := range l {
+		if l[i].Name != name {
+			continue
+		}
+		res := make(labels.Labels, 0, len(l)-1)
+		res = append(res, l[:i]...)
+		return append(res, l[i+1:]...)
+	}
+	return l
+}
+
 // failedAppender is used as the appender when an instance couldn't be found.
 type failedAppender struct {
-	instanceName string
+	err error
 }
 
 var _ storage.Appender = (*failedAppender)(nil)
 
 func (fa *failedAppender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
-	return 0, fmt.Errorf("no such instance %s", fa.instanceName)
+	return 0, fa.err
 }
 
 func (fa *failedAppender) Commit() error {
-	return fmt.Errorf("no such instance %s", fa.instanceName)
+	return fa.err
 }
 
 func (fa *failedAppender) Rollback() error {
-	return fmt.Errorf("no such instance %s", fa.instanceName)
+	return fa.err
 }
 
 func (fa *failedAppender) AppendExemplar(ref storage.SeriesRef, l labels.Labels, e exemplar.Exemplar) (storage.SeriesRef, error) {
-	return 0, fmt.Errorf("no such instance %s", fa.instanceName)
+	return 0, fa.err
 }
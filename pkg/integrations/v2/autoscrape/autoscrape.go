@@ -3,6 +3,7 @@ package autoscrape
 
 import (
 	"context"
+	"fmt"
 	"sync"
 
 	"github.com/go-kit/log"
@@ -17,7 +18,6 @@ import (
 	"github.com/prometheus/prometheus/discovery"
 	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/prometheus/prometheus/scrape"
-	"github.com/prometheus/prometheus/storage"
 )
 
 // DefaultGlobal holds default values for Global.
@@ -67,23 +67,29 @@ type ScrapeConfig struct {
 	Config   prom_config.ScrapeConfig
 }
 
-// Scraper is a metrics autoscraper.
+// instanceLabel is added to the targets of every autoscrape job to record
+// the instance scraped metrics should be sent to. It's removed from samples
+// before they're passed to the instance.
+//
+// Prometheus doesn't pass contextual information at scrape time that could be
+// used to change the behavior of generating an appender, so the label is the
+// only way for the shared scrape manager to route samples per job.
+const instanceLabel = "agent_autoscrape_instance"
+
+// Scraper is a metrics autoscraper. A single pair of SD and scrape managers
+// is used for all jobs, with samples being routed to the instance of their
+// job.
 type Scraper struct {
-	ctx    context.Context
+	log    log.Logger
+	is     InstanceStore
 	cancel context.CancelFunc
+	exited chan struct{}
 
-	log log.Logger
-	is  InstanceStore
-
-	// Prometheus doesn't pass contextual information at scrape time that could
-	// be used to change the behavior of generating an appender. This means that
-	// it's not yet possible for us to just run a single SD + scrape manager for
-	// all of our integrations, and we instead need to launch a pair of each for
-	// every instance we're writing to.
+	sd *discovery.Manager
+	sm *scrape.Manager
 
-	iscrapersMut sync.RWMutex
-	iscrapers    map[string]*instanceScraper
-	dialerFunc   server.DialContextFunc
+	jobsMut sync.RWMutex
+	jobs    map[string]string // Job name -> instance name
 }
 
 // NewScraper creates a new autoscraper. Scraper will run until Stop is called.
@@ -94,218 +100,157 @@ func NewScraper(l log.Logger, is InstanceStore, dialerFunc server.DialContextFun
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	s := &Scraper{
-		ctx:    ctx,
-		cancel: cancel,
-
-		log:        l,
-		is:         is,
-		iscrapers:  map[string]*instanceScraper{},
-		dialerFunc: dialerFunc,
-	}
-	return s
-}
-
-// ApplyConfig will apply the given jobs. An error will be returned for any
-// jobs that failed to be applied.
-func (s *Scraper) ApplyConfig(jobs []*ScrapeConfig) error {
-	s.iscrapersMut.Lock()
-	defer s.iscrapersMut.Unlock()
-
-	var firstError error
-	saveError := func(e error) {
-		if firstError == nil {
-			firstError = e
-		}
-	}
-
-	// Shard our jobs by target instance.
-	shardedJobs := map[string][]*prom_config.ScrapeConfig{}
-	for _, j := range jobs {
-		_, err := s.is.GetInstance(j.Instance)
-		if err != nil {
-			level.Error(s.log).Log("msg", "cannot autoscrape integration", "name", j.Config.JobName, "err", err)
-			saveError(err)
-			continue
-		}
-
-		shardedJobs[j.Instance] = append(shardedJobs[j.Instance], &j.Config)
-	}
-
-	// Then pass the jobs to instanceScraper, creating them if we need to.
-	for instance, jobs := range shardedJobs {
-		is, ok := s.iscrapers[instance]
-		if !ok {
-			is = newInstanceScraper(s.ctx, s.log, s.is, instance, config_util.DialContextFunc(s.dialerFunc))
-			s.iscrapers[instance] = is
-		}
-		if err := is.ApplyConfig(jobs); err != nil {
-			// Not logging here; is.ApplyConfig already logged the errors.
-			saveError(err)
-		}
-	}
-
-	// Garbage collect: if if there's a key in s.scrapers that wasn't in
-	// shardedJobs, stop that unused scraper.
-	for instance, is := range s.iscrapers {
-		_, current := shardedJobs[instance]
-		if !current {
-			is.Stop()
-			delete(s.iscrapers, instance)
-		}
-	}
-
-	return firstError
-}
-
-// TargetsActive returns the set of active scrape targets for all target
-// instances.
-func (s *Scraper) TargetsActive() map[string]metrics.TargetSet {
-	s.iscrapersMut.RLock()
-	defer s.iscrapersMut.RUnlock()
-
-	allTargets := make(map[string]metrics.TargetSet, len(s.iscrapers))
-	for instance, is := range s.iscrapers {
-		allTargets[instance] = is.sm.TargetsActive()
-	}
-	return allTargets
-}
-
-// Stop stops the Scraper.
-func (s *Scraper) Stop() {
-	s.iscrapersMut.Lock()
-	defer s.iscrapersMut.Unlock()
-
-	for instance, is := range s.iscrapers {
-		is.Stop()
-		delete(s.iscrapers, instance)
-	}
-
-	s.cancel()
-}
-
-// instanceScraper is a Scraper which always sends to the same instance.
-type instanceScraper struct {
-	log log.Logger
-
-	sd     *discovery.Manager
-	sm     *scrape.Manager
-	cancel context.CancelFunc
-	exited chan struct{}
-}
-
-// newInstanceScraper runs a new instanceScraper. Must be stopped by calling
-// Stop.
-func newInstanceScraper(
-	ctx context.Context,
-	l log.Logger,
-	s InstanceStore,
-	instanceName string,
-	dialerFunc config_util.DialContextFunc,
-) *instanceScraper {
-
-	ctx, cancel := context.WithCancel(ctx)
-	l = log.With(l, "target_instance", instanceName)
-
 	sdOpts := []func(*discovery.Manager){
-		discovery.Name("autoscraper/" + instanceName),
-		discovery.DialContextFunc(dialerFunc),
+		discovery.Name("autoscraper"),
+		discovery.DialContextFunc(config_util.DialContextFunc(dialerFunc)),
 	}
 	sd := discovery.NewManager(ctx, l, sdOpts...)
 	sm := scrape.NewManager(&scrape.Options{
 		HTTPClientOptions: []config_util.HTTPClientOption{
 			// If dialerFunc is nil, scrape.NewManager will use Go's default dialer.
-			config_util.WithDialContextFunc(dialerFunc),
+			config_util.WithDialContextFunc(config_util.DialContextFunc(dialerFunc)),
 		},
-	}, l, &agentAppender{
-		inst: instanceName,
-		is:   s,
-	})
+	}, l, &routingAppendable{is: is})
 
-	is := &instanceScraper{
-		log: l,
-
-		sd:     sd,
-		sm:     sm,
+	s := &Scraper{
+		log:    l,
+		is:     is,
 		cancel: cancel,
 		exited: make(chan struct{}),
-	}
 
-	go is.run()
-	return is
-}
-
-type agentAppender struct {
-	inst string
-	is   InstanceStore
-}
+		sd: sd,
+		sm: sm,
 
-func (aa *agentAppender) Appender(ctx context.Context) storage.Appender {
-	mi, err := aa.is.GetInstance(aa.inst)
-	if err != nil {
-		return &failedAppender{instanceName: aa.inst}
+		jobs: map[string]string{},
 	}
-	return mi.Appender(ctx)
+
+	go s.run()
+	return s
 }
 
-func (is *instanceScraper) run() {
-	defer close(is.exited)
+func (s *Scraper) run() {
+	defer close(s.exited)
 	var rg run.Group
 
 	rg.Add(func() error {
-		// Service discovery will stop whenever our parent context is canceled or
-		// if is.cancel is called.
-		err := is.sd.Run()
+		// Service discovery will stop whenever s.cancel is called.
+		err := s.sd.Run()
 		if err != nil {
-			level.Error(is.log).Log("msg", "autoscrape service discovery exited with error", "err", err)
+			level.Error(s.log).Log("msg", "autoscrape service discovery exited with error", "err", err)
 		}
 		return err
 	}, func(_ error) {
-		is.cancel()
+		s.cancel()
 	})
 
 	rg.Add(func() error {
-		err := is.sm.Run(is.sd.SyncCh())
+		err := s.sm.Run(s.sd.SyncCh())
 		if err != nil {
-			level.Error(is.log).Log("msg", "autoscrape scrape manager exited with error", "err", err)
+			level.Error(s.log).Log("msg", "autoscrape scrape manager exited with error", "err", err)
 		}
 		return err
 	}, func(_ error) {
-		is.sm.Stop()
+		s.sm.Stop()
 	})
 
 	_ = rg.Run()
 }
 
-func (is *instanceScraper) ApplyConfig(jobs []*prom_config.ScrapeConfig) error {
+// ApplyConfig will apply the given jobs. An error will be returned for any
+// jobs that failed to be applied.
+func (s *Scraper) ApplyConfig(jobs []*ScrapeConfig) error {
+	s.jobsMut.Lock()
+	defer s.jobsMut.Unlock()
+
 	var firstError error
 	saveError := func(e error) {
-		if firstError == nil && e != nil {
+		if firstError == nil {
 			firstError = e
 		}
 	}
 
 	var (
+		newJobs       = make(map[string]string, len(jobs))
 		scrapeConfigs = make([]*prom_config.ScrapeConfig, 0, len(jobs))
 		sdConfigs     = make(map[string]discovery.Configs, len(jobs))
 	)
-	for _, job := range jobs {
-		sdConfigs[job.JobName] = job.ServiceDiscoveryConfigs
-		scrapeConfigs = append(scrapeConfigs, job)
+	for _, j := range jobs {
+		_, err := s.is.GetInstance(j.Instance)
+		if err != nil {
+			level.Error(s.log).Log("msg", "cannot autoscrape integration", "name", j.Config.JobName, "err", err)
+			saveError(err)
+			continue
+		}
+		if _, exist := newJobs[j.Config.JobName]; exist {
+			err := fmt.Errorf("found multiple autoscrape jobs named %q", j.Config.JobName)
+			level.Error(s.log).Log("msg", "cannot autoscrape integration", "name", j.Config.JobName, "err", err)
+			saveError(err)
+			continue
+		}
+		newJobs[j.Config.JobName] = j.Instance
+
+		cfg := routedScrapeConfig(j)
+		sdConfigs[cfg.JobName] = cfg.ServiceDiscoveryConfigs
+		scrapeConfigs = append(scrapeConfigs, cfg)
 	}
-	if err := is.sd.ApplyConfig(sdConfigs); err != nil {
-		level.Error(is.log).Log("msg", "error when applying SD to autoscraper", "err", err)
+
+	if err := s.sd.ApplyConfig(sdConfigs); err != nil {
+		level.Error(s.log).Log("msg", "error when applying SD to autoscraper", "err", err)
 		saveError(err)
 	}
-	if err := is.sm.ApplyConfig(&prom_config.Config{ScrapeConfigs: scrapeConfigs}); err != nil {
-		level.Error(is.log).Log("msg", "error when applying jobs to scraper", "err", err)
+	if err := s.sm.ApplyConfig(&prom_config.Config{ScrapeConfigs: scrapeConfigs}); err != nil {
+		level.Error(s.log).Log("msg", "error when applying jobs to scraper", "err", err)
 		saveError(err)
 	}
 
+	s.jobs = newJobs
 	return firstError
 }
 
-func (is *instanceScraper) Stop() {
-	is.cancel()
-	<-is.exited
+// routedScrapeConfig returns a copy of the config of j which adds
+// instanceLabel to all targets. The rule is added after the rules of the job
+// so it can't be overridden. It's also added after the metric relabel rules
+// of the job, which are applied after target labels and could otherwise drop
+// instanceLabel with a labelkeep or labeldrop rule.
+func routedScrapeConfig(j *ScrapeConfig) *prom_config.ScrapeConfig {
+	cfg := j.Config
+
+	routeRule := relabel.DefaultRelabelConfig
+	routeRule.TargetLabel = instanceLabel
+	routeRule.Replacement = j.Instance
+
+	cfg.RelabelConfigs = make([]*relabel.Config, 0, len(j.Config.RelabelConfigs)+1)
+	cfg.RelabelConfigs = append(cfg.RelabelConfigs, j.Config.RelabelConfigs...)
+	cfg.RelabelConfigs = append(cfg.RelabelConfigs, &routeRule)
+
+	cfg.MetricRelabelConfigs = make([]*relabel.Config, 0, len(j.Config.MetricRelabelConfigs)+1)
+	cfg.MetricRelabelConfigs = append(cfg.MetricRelabelConfigs, j.Config.MetricRelabelConfigs...)
+	cfg.MetricRelabelConfigs = append(cfg.MetricRelabelConfigs, &routeRule)
+	return &cfg
+}
+
+// TargetsActive returns the set of active scrape targets for all target
+// instances.
+func (s *Scraper) TargetsActive() map[string]metrics.TargetSet {
+	s.jobsMut.RLock()
+	defer s.jobsMut.RUnlock()
+
+	allTargets := make(map[string]metrics.TargetSet)
+	for job, targets := range s.sm.TargetsActive() {
+		instance, ok := s.jobs[job]
+		if !ok {
+			continue
+		}
+		if allTargets[instance] == nil {
+			allTargets[instance] = make(metrics.TargetSet)
+		}
+		allTargets[instance][job] = targets
+	}
+	return allTargets
+}
+
+// Stop stops the Scraper.
+func (s *Scraper) Stop() {
+	s.cancel()
+	<-s.exited
 }
@@ -2,7 +2,9 @@ package autoscrape
 
 import (
 	"context"
+	"fmt"
 	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,6 +16,8 @@ import (
 	"github.com/prometheus/prometheus/discovery"
 	"github.com/prometheus/prometheus/model/exemplar"
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/model/value"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -69,6 +73,186 @@ func TestAutoscrape(t *testing.T) {
 	require.NoError(t, wt.Wait(5*time.Second), "timed out waiting for scrape")
 }
 
+// TestAutoscrape_Routing ensures that the samples of jobs are sent to their
+// own instance, including staleness markers when targets go away.
+func TestAutoscrape_Routing(t *testing.T) {
+	srv := httptest.NewServer(promhttp.Handler())
+	defer srv.Close()
+
+	var (
+		mut     sync.Mutex
+		samples = map[string][]labels.Labels{}
+		stale   = map[string]int{}
+	)
+	instanceAppender := func(name string) *mockAppender {
+		app := noOpAppender
+		app.AppendFunc = func(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+			mut.Lock()
+			defer mut.Unlock()
+			samples[name] = append(samples[name], l)
+			if value.IsStaleNaN(v) {
+				stale[name]++
+			}
+			return noOpAppender.AppendFunc(ref, l, t, v)
+		}
+		return &app
+	}
+	instances := map[string]instance.ManagedInstance{
+		"a": &mockInstance{app: instanceAppender("a")},
+		"b": &mockInstance{app: instanceAppender("b")},
+	}
+
+	im := instance.MockManager{
+		GetInstanceFunc: func(name string) (instance.ManagedInstance, error) {
+			if inst, ok := instances[name]; ok {
+				return inst, nil
+			}
+			return nil, fmt.Errorf("instance %s not found", name)
+		},
+	}
+	as := NewScraper(util.TestLogger(t), im, nil)
+	defer as.Stop()
+
+	jobA := testScrapeConfig("job-a", "a", srv.Listener.Addr().String())
+	jobB := testScrapeConfig("job-b", "b", srv.Listener.Addr().String())
+	require.NoError(t, as.ApplyConfig([]*ScrapeConfig{jobA, jobB}))
+	require.Empty(t, jobA.Config.RelabelConfigs, "job config should not be modified")
+
+	// NOTE(rfratto): SD won't start sending targets until after 5 seconds. We'll
+	// need to at least wait that long.
+	time.Sleep(5 * time.Second)
+
+	hasJob := func(name, job string) bool {
+		mut.Lock()
+		defer mut.Unlock()
+		for _, l := range samples[name] {
+			if l.Get(model.JobLabel) == job {
+				return true
+			}
+		}
+		return false
+	}
+	require.Eventually(t, func() bool {
+		return hasJob("a", "job-a") && hasJob("b", "job-b")
+	}, 5*time.Second, 100*time.Millisecond)
+
+	mut.Lock()
+	for name, ll := range samples {
+		for _, l := range ll {
+			require.Equal(t, "job-"+name, l.Get(model.JobLabel), "sample sent to the wrong instance")
+			require.False(t, l.Has(instanceLabel), "routing label not removed")
+		}
+	}
+	mut.Unlock()
+
+	targets := as.TargetsActive()
+	require.Len(t, targets, 2)
+	require.Len(t, targets["a"]["job-a"], 1)
+	require.Len(t, targets["b"]["job-b"], 1)
+
+	// Targets going away from job-b should send staleness markers to instance
+	// b only.
+	jobB = testScrapeConfig("job-b", "b", "")
+	require.NoError(t, as.ApplyConfig([]*ScrapeConfig{jobA, jobB}))
+	require.Eventually(t, func() bool {
+		mut.Lock()
+		defer mut.Unlock()
+		return stale["b"] > 0
+	}, 15*time.Second, 100*time.Millisecond)
+
+	mut.Lock()
+	require.Zero(t, stale["a"])
+	mut.Unlock()
+}
+
+func TestAutoscrape_DuplicateJob(t *testing.T) {
+	im := instance.MockManager{
+		GetInstanceFunc: func(name string) (instance.ManagedInstance, error) {
+			return &mockInstance{app: &noOpAppender}, nil
+		},
+	}
+	as := NewScraper(util.TestLogger(t), im, nil)
+	defer as.Stop()
+
+	err := as.ApplyConfig([]*ScrapeConfig{
+		testScrapeConfig("job", "a", "localhost:12345"),
+		testScrapeConfig("job", "b", "localhost:12345"),
+	})
+	require.EqualError(t, err, `found multiple autoscrape jobs named "job"`)
+}
+
+// TestRoutedScrapeConfig_MetricRelabel ensures that metric relabel rules of a
+// job can't remove the label used to route its samples.
+func TestRoutedScrapeConfig_MetricRelabel(t *testing.T) {
+	job := testScrapeConfig("job-a", "a", "localhost:12345")
+	job.Config.MetricRelabelConfigs = []*relabel.Config{{
+		Action: relabel.LabelKeep,
+		Regex:  relabel.MustNewRegexp("__name__|job"),
+	}}
+
+	cfg := routedScrapeConfig(job)
+	require.Len(t, job.Config.MetricRelabelConfigs, 1, "job config should not be modified")
+
+	// Target labels, including the routing label, are added to samples before
+	// metric relabel rules are applied.
+	lset := labels.FromStrings(
+		model.MetricNameLabel, "up",
+		model.JobLabel, "job-a",
+		model.InstanceLabel, "localhost:12345",
+		instanceLabel, "a",
+	)
+	res := relabel.Process(lset, cfg.MetricRelabelConfigs...)
+	require.Equal(t, labels.FromStrings(
+		model.MetricNameLabel, "up",
+		model.JobLabel, "job-a",
+		instanceLabel, "a",
+	), res)
+}
+
+func TestRoutingAppender(t *testing.T) {
+	var got []labels.Labels
+	app := noOpAppender
+	app.AppendFunc = func(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+		got = append(got, l)
+		return noOpAppender.AppendFunc(ref, l, t, v)
+	}
+
+	im := instance.MockManager{
+		GetInstanceFunc: func(name string) (instance.ManagedInstance, error) {
+			if name != "a" {
+				return nil, fmt.Errorf("instance %s not found", name)
+			}
+			return &mockInstance{app: &app}, nil
+		},
+	}
+	ra := (&routingAppendable{is: im}).Appender(context.Background())
+
+	_, err := ra.Append(0, labels.FromStrings("__name__", "up", instanceLabel, "a"), 0, 1)
+	require.NoError(t, err)
+	require.Equal(t, []labels.Labels{labels.FromStrings("__name__", "up")}, got)
+
+	_, err = ra.Append(0, labels.FromStrings("__name__", "up", instanceLabel, "b"), 0, 1)
+	require.EqualError(t, err, "no such instance b")
+
+	_, err = ra.Append(0, labels.FromStrings("__name__", "up"), 0, 1)
+	require.EqualError(t, err, "sample is missing the agent_autoscrape_instance label")
+}
+
+func testScrapeConfig(job, instance, addr string) *ScrapeConfig {
+	cfg := prom_config.DefaultScrapeConfig
+	cfg.JobName = job
+	cfg.ScrapeInterval = model.Duration(time.Second)
+	cfg.ScrapeTimeout = model.Duration(time.Second / 2)
+	var targets []model.LabelSet
+	if addr != "" {
+		targets = append(targets, model.LabelSet{model.AddressLabel: model.LabelValue(addr)})
+	}
+	cfg.ServiceDiscoveryConfigs = discovery.Configs{
+		discovery.StaticConfig{{Targets: targets, Source: job}},
+	}
+	return &ScrapeConfig{Instance: instance, Config: cfg}
+}
+
 var globalRef atomic.Uint64
 var noOpAppender = mockAppender{
 	AppendFunc: func(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
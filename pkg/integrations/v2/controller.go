@@ -10,16 +10,25 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/gorilla/mux"
 	"github.com/grafana/agent/pkg/integrations/v2/autoscrape"
+	"github.com/grafana/dskit/backoff"
 	"github.com/prometheus/prometheus/discovery"
 	http_sd "github.com/prometheus/prometheus/discovery/http"
 	"go.uber.org/atomic"
 )
 
+// DefaultRestartBackoff is the backoff used when restarting integrations which
+// exited with an error.
+var DefaultRestartBackoff = backoff.Config{
+	MinBackoff: time.Second,
+	MaxBackoff: time.Minute,
+}
+
 // controllerConfig holds a set of integration configs.
 type controllerConfig []Config
 
@@ -33,6 +42,10 @@ type controller struct {
 	integrations []*controlledIntegration // Running integrations
 
 	runIntegrations chan []*controlledIntegration // Schedule integrations to run
+
+	// restartBackoff is used when restarting integrations which exited with
+	// an error.
+	restartBackoff backoff.Config
 }
 
 // newController creates a new Controller. Controller is intended to be
@@ -42,6 +55,7 @@ func newController(l log.Logger, cfg controllerConfig, globals Globals) (*contro
 	c := &controller{
 		logger:          l,
 		runIntegrations: make(chan []*controlledIntegration, 1),
+		restartBackoff:  DefaultRestartBackoff,
 	}
 	if err := c.UpdateController(cfg, globals); err != nil {
 		return nil, err
@@ -51,7 +65,7 @@ func newController(l log.Logger, cfg controllerConfig, globals Globals) (*contro
 
 // run starts the controller and blocks until ctx is canceled.
 func (c *controller) run(ctx context.Context) {
-	pool := newWorkerPool(ctx, c.logger)
+	pool := newWorkerPool(ctx, c.logger, c.restartBackoff)
 	defer pool.Close()
 
 	for {
@@ -72,6 +86,12 @@ type controlledIntegration struct {
 	i       Integration
 	c       Config // Config that generated i. Used for changing to see if a config changed.
 	running atomic.Bool
+	status  integrationStatus
+
+	// Sinks given to integrations implementing LogsIntegration or
+	// TracesIntegration.
+	logsSink   *logsSink
+	tracesSink *tracesSink
 }
 
 func (ci *controlledIntegration) Running() bool {
@@ -92,9 +112,24 @@ func (id integrationID) String() string {
 // UpdateController updates running integrations. Extensions can be
 // recalculated by calling relevant methods like Handler or Targets.
 func (c *controller) UpdateController(cfg controllerConfig, globals Globals) error {
+	_, err := c.updateController(cfg, nil, globals)
+	return err
+}
+
+// updateController updates the controller like UpdateController, running the
+// integrations of both explicit and discovered configs. Discovered configs
+// whose integration can't be constructed or updated are skipped instead of
+// failing the update, so one discovered service can't prevent the other
+// integrations from running. The errors of skipped configs are returned by
+// their index in discovered.
+func (c *controller) updateController(explicit, discovered controllerConfig, globals Globals) (failed map[int]error, err error) {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
+	cfg := make(controllerConfig, 0, len(explicit)+len(discovered))
+	cfg = append(cfg, explicit...)
+	cfg = append(cfg, discovered...)
+
 	// Ensure that no singleton integration is defined twice
 	var (
 		duplicatedSingletons []string
@@ -113,32 +148,46 @@ func (c *controller) UpdateController(cfg controllerConfig, globals Globals) err
 		singletonSet[cfg.Name()] = struct{}{}
 	}
 	if len(duplicatedSingletons) == 1 {
-		return fmt.Errorf("integration %q may only be defined once", duplicatedSingletons[0])
+		return nil, fmt.Errorf("integration %q may only be defined once", duplicatedSingletons[0])
 	} else if len(duplicatedSingletons) > 1 {
 		list := strings.Join(duplicatedSingletons, ", ")
-		return fmt.Errorf("the following integrations may only be defined once each: %s", list)
+		return nil, fmt.Errorf("the following integrations may only be defined once each: %s", list)
 	}
 
 	integrationIDMap := map[integrationID]struct{}{}
 
 	integrations := make([]*controlledIntegration, 0, len(cfg))
 
+	// skip skips the discovered config at index idx of cfg, returning false
+	// if it's an explicit config which must fail the update instead.
+	skip := func(idx int, err error) bool {
+		if idx < len(explicit) {
+			return false
+		}
+		level.Warn(c.logger).Log("msg", "skipping discovered integration", "err", err)
+		if failed == nil {
+			failed = make(map[int]error)
+		}
+		failed[idx-len(explicit)] = err
+		return true
+	}
+
 NextConfig:
-	for _, ic := range cfg {
+	for idx, ic := range cfg {
 		name := ic.Name()
 
 		identifier, err := ic.Identifier(globals)
 		if err != nil {
-			return fmt.Errorf("could not build identifier for integration %q: %w", name, err)
+			return nil, fmt.Errorf("could not build identifier for integration %q: %w", name, err)
 		}
 
 		if err := ic.ApplyDefaults(globals); err != nil {
-			return fmt.Errorf("failed to apply defaults for %s/%s: %w", name, identifier, err)
+			return nil, fmt.Errorf("failed to apply defaults for %s/%s: %w", name, identifier, err)
 		}
 
 		id := integrationID{Name: name, Identifier: identifier}
 		if _, exist := integrationIDMap[id]; exist {
-			return fmt.Errorf("multiple instance names %q in integration %q", identifier, name)
+			return nil, fmt.Errorf("multiple instance names %q in integration %q", identifier, name)
 		}
 		integrationIDMap[id] = struct{}{}
 
@@ -160,7 +209,11 @@ NextConfig:
 					level.Warn(c.logger).Log("msg", "failed to dynamically update integration; will recreate", "integration", name, "instance", identifier, "err', err")
 					break
 				} else if err != nil {
-					return fmt.Errorf("failed to update %s integration %q: %w", name, identifier, err)
+					err = fmt.Errorf("failed to update %s integration %q: %w", name, identifier, err)
+					if skip(idx, err) {
+						continue NextConfig
+					}
+					return nil, err
 				} else {
 					// Update succeeded; re-use the running one and go to the next
 					// integration to process.
@@ -176,7 +229,11 @@ NextConfig:
 		logger := log.With(c.logger, "integration", name, "instance", identifier)
 		integration, err := ic.NewIntegration(logger, globals)
 		if err != nil {
-			return fmt.Errorf("failed to construct %s integration %q: %w", name, identifier, err)
+			err = fmt.Errorf("failed to construct %s integration %q: %w", name, identifier, err)
+			if skip(idx, err) {
+				continue
+			}
+			return nil, err
 		}
 
 		// Create a new controlled integration.
@@ -187,13 +244,19 @@ NextConfig:
 		})
 	}
 
+	// Hand off sinks before the integrations run. Running integrations keep
+	// their sinks, which are updated with the new config.
+	for _, ci := range integrations {
+		ci.updateSinks(globals)
+	}
+
 	// Schedule integrations to run
 	c.runIntegrations <- integrations
 
 	c.cfg = cfg
 	c.globals = globals
 	c.integrations = integrations
-	return nil
+	return failed, nil
 }
 
 // Handler returns an HTTP handler for the controller and its integrations.
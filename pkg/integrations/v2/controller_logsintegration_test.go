@@ -0,0 +1,144 @@
+//go:build !race
+// +build !race
+
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/agent/pkg/logs"
+	"github.com/grafana/agent/pkg/util"
+	"github.com/grafana/loki/clients/pkg/promtail/api"
+	"github.com/grafana/loki/pkg/loghttp/push"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+//
+// Tests for controller's utilization of the LogsIntegration interface.
+//
+
+func Test_controller_LogsIntegration(t *testing.T) {
+	pushes := make(chan *logproto.PushRequest)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = lis.Close() })
+	go func() {
+		_ = http.Serve(lis, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			req, err := push.ParseRequest(log.NewNopLogger(), "user_id", r, nil)
+			require.NoError(t, err)
+
+			pushes <- req
+			_, _ = rw.Write(nil)
+		}))
+	}()
+
+	cfgText := util.Untab(fmt.Sprintf(`
+positions_directory: %s
+configs:
+- name: default
+  clients:
+  - url: http://%s/loki/api/v1/push
+		batchwait: 50ms
+		batchsize: 1
+	`, t.TempDir(), lis.Addr().String()))
+
+	var logsCfg logs.Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(cfgText), &logsCfg))
+
+	l, err := logs.New(prometheus.NewRegistry(), &logsCfg, nil, log.NewNopLogger())
+	require.NoError(t, err)
+	t.Cleanup(l.Stop)
+
+	li := &mockLogsIntegration{
+		Integration: NoOpIntegration,
+		StreamsFunc: func() []LogStream {
+			return []LogStream{{Name: "test", Labels: model.LabelSet{"stream": "test", "job": "stream"}}}
+		},
+	}
+
+	globals := Globals{
+		Logs:          l,
+		SubsystemOpts: DefaultSubsystemOptions,
+	}
+	// The config never changes, so the running integration is kept when
+	// reloading.
+	cfg := mockConfigForIntegration(t, li)
+	cfg.ConfigEqualsFunc = func(Config) bool { return true }
+	cfgs := controllerConfig{cfg}
+	ctrl, err := newController(util.TestLogger(t), cfgs, globals)
+	require.NoError(t, err)
+	sc := newSyncController(t, ctrl)
+	t.Cleanup(sc.Stop)
+
+	require.NotNil(t, li.sink, "controller didn't set the logs sink")
+	sink := li.sink
+
+	// The default logs instance is used and labels of the entry take
+	// precedence over labels of the stream.
+	entry := api.Entry{
+		Labels: model.LabelSet{"job": "entry"},
+		Entry:  logproto.Entry{Timestamp: time.Now(), Line: "Hello, world!"},
+	}
+	require.NoError(t, sink.Send(context.Background(), "test", entry))
+
+	select {
+	case <-time.After(time.Second * 30):
+		require.FailNow(t, "timed out waiting for data to be pushed")
+	case req := <-pushes:
+		require.Equal(t, `{job="entry", stream="test"}`, req.Streams[0].Labels)
+		require.Equal(t, "Hello, world!", req.Streams[0].Entries[0].Line)
+	}
+
+	require.EqualError(t, sink.Send(context.Background(), "missing", entry), `unknown log stream "missing"`)
+
+	// Reloading the config keeps the sink but updates it.
+	li.InstanceFunc = func() string { return "missing" }
+	require.NoError(t, sc.UpdateController(cfgs, globals))
+	require.Same(t, sink, li.sink)
+	require.EqualError(t, sink.Send(context.Background(), "test", entry), `logs instance "missing" not found`)
+}
+
+func Test_controller_LogsIntegration_NoSubsystem(t *testing.T) {
+	li := &mockLogsIntegration{
+		Integration: NoOpIntegration,
+		StreamsFunc: func() []LogStream { return []LogStream{{Name: "test"}} },
+	}
+
+	ctrl, err := newController(util.TestLogger(t), controllerConfig{mockConfigForIntegration(t, li)}, Globals{})
+	require.NoError(t, err)
+	sc := newSyncController(t, ctrl)
+	t.Cleanup(sc.Stop)
+
+	err = li.sink.Send(context.Background(), "test", api.Entry{})
+	require.EqualError(t, err, "logs subsystem is not enabled")
+}
+
+type mockLogsIntegration struct {
+	Integration
+	InstanceFunc func() string
+	StreamsFunc  func() []LogStream
+
+	sink LogsSink
+}
+
+func (m *mockLogsIntegration) LogsInstance() string {
+	if m.InstanceFunc == nil {
+		return ""
+	}
+	return m.InstanceFunc()
+}
+
+func (m *mockLogsIntegration) LogStreams() []LogStream { return m.StreamsFunc() }
+
+func (m *mockLogsIntegration) SetLogsSink(s LogsSink) { m.sink = s }
@@ -2,12 +2,15 @@ package integrations
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/grafana/agent/pkg/util"
+	"github.com/grafana/dskit/backoff"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/atomic"
 )
@@ -48,6 +51,41 @@ func Test_controller_UniqueIdentifier(t *testing.T) {
 	})
 }
 
+// Test_controller_SkipsFailedDiscovered ensures that discovered integrations
+// which fail to be constructed are skipped without failing the update, while
+// explicit ones still fail it.
+func Test_controller_SkipsFailedDiscovered(t *testing.T) {
+	failing := mockConfigNameTuple(t, "discovered", "bad").WithNewIntegrationFunc(func(log.Logger, Globals) (Integration, error) {
+		return nil, fmt.Errorf("connection refused")
+	})
+
+	ctrl, err := newController(util.TestLogger(t), nil, Globals{})
+	require.NoError(t, err)
+	sc := newSyncController(t, ctrl)
+	t.Cleanup(sc.Stop)
+
+	explicit := controllerConfig{mockConfigNameTuple(t, "explicit", "a")}
+	discovered := controllerConfig{failing, mockConfigNameTuple(t, "discovered", "good")}
+
+	failed, err := sc.inner.updateController(explicit, discovered, Globals{})
+	require.NoError(t, err)
+	sc.refresh()
+	require.Len(t, failed, 1)
+	require.EqualError(t, failed[0], `failed to construct discovered integration "bad": connection refused`)
+
+	var ids []integrationID
+	for _, ci := range sc.inner.integrations {
+		ids = append(ids, ci.id)
+	}
+	require.Equal(t, []integrationID{
+		{Name: "explicit", Identifier: "a"},
+		{Name: "discovered", Identifier: "good"},
+	}, ids)
+
+	_, err = sc.inner.updateController(append(explicit, failing), nil, Globals{})
+	require.EqualError(t, err, `failed to construct discovered integration "bad": connection refused`)
+}
+
 // Test_controller_RunsIntegration ensures that integrations
 // run.
 func Test_controller_RunsIntegration(t *testing.T) {
@@ -78,6 +116,73 @@ func Test_controller_RunsIntegration(t *testing.T) {
 	wg.Wait()
 }
 
+// Test_controller_RestartsIntegration ensures that integrations which exit
+// with an error are restarted and that their status is reported.
+func Test_controller_RestartsIntegration(t *testing.T) {
+	var runs atomic.Int64
+
+	ctrl, err := newController(
+		util.TestLogger(t),
+		controllerConfig{
+			mockConfigForIntegration(t, FuncIntegration(func(ctx context.Context) error {
+				if runs.Inc() <= 2 {
+					return fmt.Errorf("failure %d", runs.Load())
+				}
+				<-ctx.Done()
+				return nil
+			})),
+		},
+		Globals{},
+	)
+	require.NoError(t, err, "failed to create controller")
+	ctrl.restartBackoff = backoff.Config{MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ctrl.run(ctx)
+
+	require.Eventually(t, func() bool {
+		status := ctrl.Status()
+		return len(status) == 1 && status[0].State == StateRunning && status[0].Restarts == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	status := ctrl.Status()[0]
+	require.Equal(t, mockIntegrationName, status.Name)
+	require.Equal(t, "failure 2", status.LastError)
+	require.NotNil(t, status.LastErrorTime)
+}
+
+// Test_controller_ExitedIntegration ensures that integrations which exit
+// without an error aren't restarted.
+func Test_controller_ExitedIntegration(t *testing.T) {
+	var runs atomic.Int64
+
+	ctrl, err := newController(
+		util.TestLogger(t),
+		controllerConfig{
+			mockConfigForIntegration(t, FuncIntegration(func(ctx context.Context) error {
+				runs.Inc()
+				return nil
+			})),
+		},
+		Globals{},
+	)
+	require.NoError(t, err, "failed to create controller")
+	ctrl.restartBackoff = backoff.Config{MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ctrl.run(ctx)
+
+	require.Eventually(t, func() bool {
+		status := ctrl.Status()
+		return len(status) == 1 && status[0].State == StateExited
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, int64(1), runs.Load())
+	require.Equal(t, 0, ctrl.Status()[0].Restarts)
+	require.Empty(t, ctrl.Status()[0].LastError)
+}
+
 // Test_controller_ConfigChanges ensures that integrations only get restarted
 // when configs are no longer equal.
 func Test_controller_ConfigChanges(t *testing.T) {
@@ -182,7 +287,7 @@ func newSyncController(t *testing.T, inner *controller) *syncController {
 
 	sc := &syncController{
 		inner: inner,
-		pool:  newWorkerPool(context.Background(), inner.logger),
+		pool:  newWorkerPool(context.Background(), inner.logger, inner.restartBackoff),
 	}
 
 	// There's always immediately one queued integration set from any
@@ -0,0 +1,78 @@
+package integrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/agent/pkg/traces"
+	"github.com/grafana/agent/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/logging"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+//
+// Tests for controller's utilization of the TracesIntegration interface.
+//
+
+func Test_controller_TracesIntegration(t *testing.T) {
+	tr, err := traces.New(nil, nil, nil, prometheus.NewRegistry(), traces.Config{}, logrus.InfoLevel, logging.Format{})
+	require.NoError(t, err)
+	t.Cleanup(tr.Stop)
+
+	ti := &mockTracesIntegration{Integration: NoOpIntegration}
+
+	globals := Globals{
+		Tracing:       tr,
+		SubsystemOpts: DefaultSubsystemOptions,
+	}
+	// The config never changes, so the running integration is kept when
+	// reloading.
+	cfg := mockConfigForIntegration(t, ti)
+	cfg.ConfigEqualsFunc = func(Config) bool { return true }
+	cfgs := controllerConfig{cfg}
+	ctrl, err := newController(util.TestLogger(t), cfgs, globals)
+	require.NoError(t, err)
+	sc := newSyncController(t, ctrl)
+	t.Cleanup(sc.Stop)
+
+	require.NotNil(t, ti.sink, "controller didn't set the traces sink")
+	sink := ti.sink
+
+	// The default traces instance is used when the integration doesn't set
+	// one.
+	err = sink.Send(context.Background(), pdata.NewTraces())
+	require.EqualError(t, err, `traces instance "default" not found`)
+
+	// Reloading the config keeps the sink but updates it.
+	ti.instance = "other"
+	require.NoError(t, sc.UpdateController(cfgs, globals))
+	require.Same(t, sink, ti.sink)
+	err = sink.Send(context.Background(), pdata.NewTraces())
+	require.EqualError(t, err, `traces instance "other" not found`)
+}
+
+func Test_controller_TracesIntegration_NoSubsystem(t *testing.T) {
+	ti := &mockTracesIntegration{Integration: NoOpIntegration}
+
+	ctrl, err := newController(util.TestLogger(t), controllerConfig{mockConfigForIntegration(t, ti)}, Globals{})
+	require.NoError(t, err)
+	sc := newSyncController(t, ctrl)
+	t.Cleanup(sc.Stop)
+
+	err = ti.sink.Send(context.Background(), pdata.NewTraces())
+	require.EqualError(t, err, "traces subsystem is not enabled")
+}
+
+type mockTracesIntegration struct {
+	Integration
+	instance string
+
+	sink TracesSink
+}
+
+func (m *mockTracesIntegration) TracesInstance() string { return m.instance }
+
+func (m *mockTracesIntegration) SetTracesSink(s TracesSink) { m.sink = s }
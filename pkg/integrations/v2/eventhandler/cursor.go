@@ -0,0 +1,90 @@
+package eventhandler
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordinationv1 "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// cursorAnnotation is the annotation on the leader election Lease which holds
+// the last shipped events.
+const cursorAnnotation = "eventhandler.grafana.com/last-event"
+
+// cursorStore persists the encoded ShippedEvents of the last shipped event so
+// shipping can resume where it left off.
+type cursorStore interface {
+	// Load returns the stored cursor. An empty cursor is returned if nothing
+	// has been stored yet.
+	Load(ctx context.Context) ([]byte, error)
+	// Save stores the cursor.
+	Save(ctx context.Context, cursor []byte) error
+}
+
+// fileStore stores the cursor in a local cache file.
+type fileStore struct {
+	path string
+}
+
+func (s fileStore) Load(_ context.Context) ([]byte, error) {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache dir: %w", err)
+	}
+
+	// cache file to store events shipped (prevents double shipping on restart)
+	f, err := os.OpenFile(s.path, os.O_RDWR|os.O_CREATE, cacheFileMode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open or create cache file: %w", err)
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+func (s fileStore) Save(_ context.Context, cursor []byte) error {
+	temp := s.path + "-new"
+	if err := ioutil.WriteFile(temp, cursor, os.FileMode(cacheFileMode)); err != nil {
+		return err
+	}
+	return os.Rename(temp, s.path)
+}
+
+// leaseStore stores the cursor as an annotation on the leader election Lease
+// so a new leader can resume where the previous one left off.
+type leaseStore struct {
+	client    coordinationv1.LeasesGetter
+	namespace string
+	name      string
+}
+
+func (s leaseStore) Load(ctx context.Context) ([]byte, error) {
+	lease, err := s.client.Leases(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lease: %w", err)
+	}
+	return []byte(lease.Annotations[cursorAnnotation]), nil
+}
+
+func (s leaseStore) Save(ctx context.Context, cursor []byte) error {
+	// The leader elector updates the same Lease, so retry on conflicts.
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		lease, err := s.client.Leases(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+		if k8s_errors.IsNotFound(err) {
+			return fmt.Errorf("lease %s/%s does not exist", s.namespace, s.name)
+		} else if err != nil {
+			return err
+		}
+
+		if lease.Annotations == nil {
+			lease.Annotations = make(map[string]string)
+		}
+		lease.Annotations[cursorAnnotation] = string(cursor)
+		_, err = s.client.Leases(s.namespace).Update(ctx, lease, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -3,6 +3,7 @@
 package eventhandler
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -24,31 +25,56 @@ import (
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/grafana/agent/pkg/integrations/v2"
-	"github.com/grafana/agent/pkg/logs"
+	"github.com/grafana/agent/pkg/integrations/v2/metricsutils"
 	"github.com/grafana/loki/clients/pkg/promtail/api"
 	"github.com/grafana/loki/pkg/logproto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/model"
 )
 
 const (
 	cacheFileMode = 0600
+
+	// flushTimeout is the maximum amount of time to spend storing the last
+	// shipped event.
+	flushTimeout = 10 * time.Second
 )
 
+// metricsIntegration is the integration used to expose the metrics of the
+// EventHandler.
+type metricsIntegration interface {
+	integrations.HTTPIntegration
+	integrations.MetricsIntegration
+}
+
 // EventHandler watches for Kubernetes Event objects and hands them off to
 // Agent's logs subsystem (embedded promtail).
 type EventHandler struct {
-	LogsClient    *logs.Logs
-	LogsInstance  string
-	Log           log.Logger
-	CachePath     string
-	LastEvent     *ShippedEvents
-	InitEvent     *ShippedEvents
-	EventInformer cache.SharedIndexInformer
-	SendTimeout   time.Duration
-	ticker        *time.Ticker
+	metricsIntegration
+
+	Log            log.Logger
+	LastEvent      *ShippedEvents
+	InitEvent      *ShippedEvents
+	SendTimeout    time.Duration
+	clientset      kubernetes.Interface
+	namespaces     []string
+	informerResync time.Duration
+	flushInterval  time.Duration
+	reasons        map[string]struct{}
+	types          map[string]struct{}
+	store          cursorStore
+	leaderElection *leaderElection
+	logsInstance   string
+	logsSink       integrations.LogsSink
+	eventsTotal    *prometheus.CounterVec
+	leader         prometheus.Gauge
 	sync.Mutex
 }
 
+// eventsStream is the log stream events are sent to.
+const eventsStream = "events"
+
 // ShippedEvents stores a timestamp and map of event ResourceVersions shipped for that timestamp.
 // Used to avoid double-shipping events upon restart.
 type ShippedEvents struct {
@@ -62,9 +88,8 @@ type ShippedEvents struct {
 
 func newEventHandler(l log.Logger, globals integrations.Globals, c *Config) (integrations.Integration, error) {
 	var (
-		config  *rest.Config
-		err     error
-		factory informers.SharedInformerFactory
+		config *rest.Config
+		err    error
 	)
 
 	// Try using KubeconfigPath or inClusterConfig
@@ -90,36 +115,114 @@ func newEventHandler(l log.Logger, globals integrations.Globals, c *Config) (int
 		return nil, err
 	}
 
-	// get an informer
-	if c.Namespace == "" {
-		factory = informers.NewSharedInformerFactory(clientset, time.Duration(c.InformerResync)*time.Second)
-	} else {
-		factory = informers.NewSharedInformerFactoryWithOptions(clientset, time.Duration(c.InformerResync)*time.Second, informers.WithNamespace(c.Namespace))
+	return newEventHandlerWithClient(l, globals, c, clientset)
+}
+
+func newEventHandlerWithClient(l log.Logger, globals integrations.Globals, c *Config, clientset kubernetes.Interface) (*EventHandler, error) {
+	eh := &EventHandler{
+		Log:            l,
+		SendTimeout:    time.Duration(c.SendTimeout) * time.Second,
+		clientset:      clientset,
+		namespaces:     watchedNamespaces(c),
+		informerResync: time.Duration(c.InformerResync) * time.Second,
+		flushInterval:  time.Duration(c.FlushInterval) * time.Second,
+		reasons:        stringSet(c.Reasons),
+		types:          stringSet(c.Types),
+		store:          fileStore{path: c.CachePath},
+		logsInstance:   c.LogsInstance,
+
+		eventsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "eventhandler_events_total",
+			Help: "Total number of Kubernetes events shipped.",
+		}, []string{"namespace", "involved_object_kind", "reason", "type"}),
+		leader: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "eventhandler_leader",
+			Help: "Set to 1 while this agent is shipping events.",
+		}),
+	}
+
+	if c.LeaderElection.Enabled {
+		le, err := newLeaderElection(l, clientset, c.LeaderElection)
+		if err != nil {
+			return nil, err
+		}
+		eh.leaderElection = le
+		eh.store = le.store
 	}
 
-	eventInformer := factory.Core().V1().Events().Informer()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(eh.eventsTotal, eh.leader)
+	mi, err := metricsutils.NewMetricsHandlerIntegration(l, c, c.Common, globals, promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	if err != nil {
+		return nil, err
+	}
+	eh.metricsIntegration = mi.(metricsIntegration)
 
-	eh := &EventHandler{
-		LogsClient:    globals.Logs,
-		LogsInstance:  c.LogsInstance,
-		Log:           l,
-		CachePath:     c.CachePath,
-		EventInformer: eventInformer,
-		SendTimeout:   time.Duration(c.SendTimeout) * time.Second,
-	}
-	// set the resource handler fns
-	eh.initInformer(eventInformer)
-	eh.ticker = time.NewTicker(time.Duration(c.FlushInterval) * time.Second)
 	return eh, nil
 }
 
-// Initialize informer by setting event handler fns
-func (eh *EventHandler) initInformer(eventsInformer cache.SharedIndexInformer) {
-	eventsInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc:    eh.addEvent,
-		UpdateFunc: eh.updateEvent,
-		DeleteFunc: eh.deleteEvent,
-	})
+// watchedNamespaces returns the namespaces to watch events in. A single
+// empty namespace is returned to watch all namespaces.
+func watchedNamespaces(c *Config) []string {
+	var (
+		res  []string
+		seen = make(map[string]struct{})
+	)
+	for _, ns := range append([]string{c.Namespace}, c.Namespaces...) {
+		if _, ok := seen[ns]; ok || ns == "" {
+			continue
+		}
+		seen[ns] = struct{}{}
+		res = append(res, ns)
+	}
+	if len(res) == 0 {
+		return []string{""}
+	}
+	return res
+}
+
+func stringSet(ss []string) map[string]struct{} {
+	if len(ss) == 0 {
+		return nil
+	}
+	res := make(map[string]struct{}, len(ss))
+	for _, s := range ss {
+		res[s] = struct{}{}
+	}
+	return res
+}
+
+// newInformers creates an event informer for each watched namespace and sets
+// the event handler fns.
+func (eh *EventHandler) newInformers() []cache.SharedIndexInformer {
+	res := make([]cache.SharedIndexInformer, 0, len(eh.namespaces))
+	for _, ns := range eh.namespaces {
+		factory := informers.NewSharedInformerFactoryWithOptions(eh.clientset, eh.informerResync, informers.WithNamespace(ns))
+
+		informer := factory.Core().V1().Events().Informer()
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    eh.addEvent,
+			UpdateFunc: eh.updateEvent,
+			DeleteFunc: eh.deleteEvent,
+		})
+		res = append(res, informer)
+	}
+	return res
+}
+
+// shouldShip returns true if event passes the configured filters.
+func (eh *EventHandler) shouldShip(event *v1.Event) bool {
+	if eh.reasons != nil {
+		if _, ok := eh.reasons[event.Reason]; !ok {
+			return false
+		}
+	}
+	if eh.types != nil {
+		if _, ok := eh.types[event.Type]; !ok {
+			return false
+		}
+	}
+	return true
 }
 
 // Handles new event objects
@@ -151,6 +254,10 @@ func (eh *EventHandler) updateEvent(objOld interface{}, objNew interface{}) {
 }
 
 func (eh *EventHandler) handleEvent(event *v1.Event) error {
+	if !eh.shouldShip(event) {
+		return nil
+	}
+
 	eventTs := getTimestamp(event)
 
 	// if event is older than the one stored in cache on startup, we've shipped it
@@ -170,12 +277,13 @@ func (eh *EventHandler) handleEvent(event *v1.Event) error {
 	}
 
 	entry := newEntry(msg, eventTs, labels)
-	ok := eh.LogsClient.Instance(eh.LogsInstance).SendEntry(entry, eh.SendTimeout)
-	if !ok {
-		err = fmt.Errorf("msg=%s entry=%s", "error handing entry off to promtail", entry)
-		return err
+	ctx, cancel := context.WithTimeout(context.Background(), eh.SendTimeout)
+	defer cancel()
+	if err := eh.logsSink.Send(ctx, eventsStream, entry); err != nil {
+		return fmt.Errorf("msg=%s entry=%s err=%w", "error handing entry off to promtail", entry, err)
 	}
 	level.Info(eh.Log).Log("msg", "Shipped entry", "eventRV", event.ResourceVersion, "eventMsg", event.Message)
+	eh.eventsTotal.WithLabelValues(event.InvolvedObject.Namespace, event.InvolvedObject.Kind, event.Reason, event.Type).Inc()
 
 	// update cache with new "last" event
 	err = eh.updateLastEvent(event, eventTs)
@@ -288,76 +396,81 @@ func (eh *EventHandler) updateLastEvent(e *v1.Event, eventTs time.Time) error {
 }
 
 func (eh *EventHandler) writeOutLastEvent() error {
-	level.Info(eh.Log).Log("msg", "Flushing last event to disk")
+	level.Info(eh.Log).Log("msg", "Flushing last event")
 
 	eh.Lock()
-	defer eh.Unlock()
-
 	if eh.LastEvent == nil {
+		eh.Unlock()
 		level.Info(eh.Log).Log("msg", "No last event to flush, returning")
 		return nil
 	}
-
-	temp := eh.CachePath + "-new"
 	buf, err := json.Marshal(&eh.LastEvent)
+	eh.Unlock()
 	if err != nil {
 		return err
 	}
 
-	err = ioutil.WriteFile(temp, buf, os.FileMode(cacheFileMode))
-	if err != nil {
-		return err
-	}
-
-	if err = os.Rename(temp, eh.CachePath); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	if err := eh.store.Save(ctx, buf); err != nil {
 		return err
 	}
-	level.Info(eh.Log).Log("msg", "Flushed last event to disk")
+	level.Info(eh.Log).Log("msg", "Flushed last event")
 	return nil
 }
 
+// LogsInstance implements integrations.LogsIntegration.
+func (eh *EventHandler) LogsInstance() string { return eh.logsInstance }
+
+// LogStreams implements integrations.LogsIntegration.
+func (eh *EventHandler) LogStreams() []integrations.LogStream {
+	return []integrations.LogStream{{Name: eventsStream}}
+}
+
+// SetLogsSink implements integrations.LogsIntegration.
+func (eh *EventHandler) SetLogsSink(s integrations.LogsSink) { eh.logsSink = s }
+
 // RunIntegration runs the eventhandler integration
 func (eh *EventHandler) RunIntegration(ctx context.Context) error {
-	var wg sync.WaitGroup
+	if eh.leaderElection != nil {
+		return eh.leaderElection.run(ctx, eh.runShipper)
+	}
+	return eh.runShipper(ctx)
+}
 
+// runShipper ships events until ctx is canceled, resuming after the last
+// event shipped according to the cursor store.
+func (eh *EventHandler) runShipper(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	// Quick check to make sure logs instance exists
-	if i := eh.LogsClient.Instance(eh.LogsInstance); i == nil {
-		level.Error(eh.Log).Log("msg", "Logs instance not configured", "instance", eh.LogsInstance)
-		cancel()
-	}
-
-	cacheDir := filepath.Dir(eh.CachePath)
-	if err := os.MkdirAll(cacheDir, 0755); err != nil {
-		level.Error(eh.Log).Log("msg", "Failed to create cache dir", "err", err)
-		cancel()
-	}
+	eh.leader.Set(1)
+	defer eh.leader.Set(0)
 
-	// cache file to store events shipped (prevents double shipping on restart)
-	cacheFile, err := os.OpenFile(eh.CachePath, os.O_RDWR|os.O_CREATE, cacheFileMode)
+	// attempt to read last shipped event into a ShippedEvents struct
+	buf, err := eh.store.Load(ctx)
 	if err != nil {
-		level.Error(eh.Log).Log("msg", "Failed to open or create cache file", "err", err)
-		cancel()
+		return fmt.Errorf("failed to load last event: %w", err)
 	}
-
-	// attempt to read last timestamp from cache file into a ShippedEvents struct
-	initEvent, err := readInitEvent(cacheFile, eh.Log)
+	initEvent, err := parseInitEvent(buf, eh.Log)
 	if err != nil {
-		level.Error(eh.Log).Log("msg", "Failed to read last event from cache file", "err", err)
-		cancel()
+		return err
 	}
+
+	eh.Lock()
 	eh.InitEvent = initEvent
+	eh.LastEvent = nil
+	eh.Unlock()
 
-	if err = cacheFile.Close(); err != nil {
-		level.Error(eh.Log).Log("msg", "Failed to close cache file", "err", err)
-		cancel()
-	}
+	eventInformers := eh.newInformers()
 
 	go func() {
 		level.Info(eh.Log).Log("msg", "Waiting for cache to sync (initial List of events)")
-		isSynced := cache.WaitForCacheSync(ctx.Done(), eh.EventInformer.HasSynced)
+		hasSynced := make([]cache.InformerSynced, 0, len(eventInformers))
+		for _, informer := range eventInformers {
+			hasSynced = append(hasSynced, informer.HasSynced)
+		}
+		isSynced := cache.WaitForCacheSync(ctx.Done(), hasSynced...)
 		if !isSynced {
 			level.Error(eh.Log).Log("msg", "Failed to sync informer cache")
 			// maybe want to bail here
@@ -366,24 +479,21 @@ func (eh *EventHandler) RunIntegration(ctx context.Context) error {
 		level.Info(eh.Log).Log("msg", "Informer cache synced")
 	}()
 
-	// start the informer
-	// technically we should prob use the factory here, but since we
-	// only have one informer atm, this likely doesn't matter
-	go eh.EventInformer.Run(ctx.Done())
+	// start the informers
+	for _, informer := range eventInformers {
+		go informer.Run(ctx.Done())
+	}
 
 	// wait for last event to flush before returning
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		eh.runTicker(ctx.Done())
-	}()
-	wg.Wait()
-
+	eh.runTicker(ctx.Done())
 	return nil
 }
 
 // write out last event every FlushInterval
 func (eh *EventHandler) runTicker(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(eh.flushInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-stopCh:
@@ -391,7 +501,7 @@ func (eh *EventHandler) runTicker(stopCh <-chan struct{}) {
 				level.Error(eh.Log).Log("msg", "Failed to flush last event", "err", err)
 			}
 			return
-		case <-eh.ticker.C:
+		case <-ticker.C:
 			if err := eh.writeOutLastEvent(); err != nil {
 				level.Error(eh.Log).Log("msg", "Failed to flush last event", "err", err)
 			}
@@ -400,25 +510,28 @@ func (eh *EventHandler) runTicker(stopCh <-chan struct{}) {
 }
 
 func readInitEvent(file *os.File, logger log.Logger) (*ShippedEvents, error) {
+	buf, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseInitEvent(buf, logger)
+}
+
+func parseInitEvent(buf []byte, logger log.Logger) (*ShippedEvents, error) {
 	var (
 		initEvent = new(ShippedEvents)
 	)
 
-	stat, err := file.Stat()
-	if err != nil {
-		return nil, err
-	}
-	if stat.Size() == 0 {
-		level.Info(logger).Log("msg", "Cache file empty, setting zero-valued initEvent")
+	if len(bytes.TrimSpace(buf)) == 0 {
+		level.Info(logger).Log("msg", "No last event stored, setting zero-valued initEvent")
 		return initEvent, nil
 	}
 
-	dec := json.NewDecoder(file)
-	err = dec.Decode(&initEvent)
+	err := json.Unmarshal(buf, &initEvent)
 	if err != nil {
-		err = fmt.Errorf("could not read init event from cache: %s. Please delete the cache file", err)
+		err = fmt.Errorf("could not read init event: %s. Please delete the cache file or the last event annotation of the leader election Lease", err)
 		return nil, err
 	}
-	level.Info(logger).Log("msg", "Loaded init event from cache file", "initEventTime", initEvent.Timestamp)
+	level.Info(logger).Log("msg", "Loaded init event", "initEventTime", initEvent.Timestamp)
 	return initEvent, nil
 }
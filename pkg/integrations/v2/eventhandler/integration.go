@@ -1,17 +1,30 @@
 package eventhandler
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/go-kit/log"
 	"github.com/grafana/agent/pkg/integrations/v2"
+	"github.com/grafana/agent/pkg/integrations/v2/common"
+	"k8s.io/client-go/tools/leaderelection"
 )
 
 // DefaultConfig sets defaults for Config
 var DefaultConfig = Config{
 	SendTimeout:    60,
 	CachePath:      "./.eventcache/eventhandler.cache",
-	LogsInstance:   "default",
 	InformerResync: 120,
 	FlushInterval:  10,
+	LeaderElection: DefaultLeaderElectionConfig,
+}
+
+// DefaultLeaderElectionConfig sets defaults for LeaderElectionConfig
+var DefaultLeaderElectionConfig = LeaderElectionConfig{
+	LeaseName:     "grafana-agent-eventhandler",
+	LeaseDuration: 15 * time.Second,
+	RenewDeadline: 10 * time.Second,
+	RetryPeriod:   2 * time.Second,
 }
 
 // Config configures the eventhandler integration
@@ -26,8 +39,10 @@ type Config struct {
 	KubeconfigPath string `yaml:"kubeconfig_path,omitempty"`
 	// Path to a cache file that will store the last timestamp for a shipped event and events
 	// shipped for that timestamp. Used to prevent double-shipping on integration restart.
+	// Not used when leader election is enabled.
 	CachePath string `yaml:"cache_path,omitempty"`
-	// Name of logs subsystem instance to hand log entries off to.
+	// Name of logs subsystem instance to hand log entries off to. Defaults to
+	// the logs instance of the integrations subsystem.
 	LogsInstance string `yaml:"logs_instance,omitempty"`
 	// K8s informer resync interval (seconds). You should use defaults here unless you are
 	// familiar with K8s informers.
@@ -36,6 +51,36 @@ type Config struct {
 	FlushInterval int `yaml:"flush_interval,omitempty"`
 	// If you would like to limit events to a given namespace, use this parameter.
 	Namespace string `yaml:"namespace,omitempty"`
+	// If you would like to limit events to a set of namespaces, use this
+	// parameter. Combined with Namespace.
+	Namespaces []string `yaml:"namespaces,omitempty"`
+	// If set, only events with one of these reasons are shipped.
+	Reasons []string `yaml:"reasons,omitempty"`
+	// If set, only events with one of these types are shipped.
+	Types []string `yaml:"types,omitempty"`
+	// Configures leader election between multiple replicas of the integration.
+	LeaderElection LeaderElectionConfig `yaml:"leader_election,omitempty"`
+
+	// Common options for the metrics exposed by the integration.
+	Common common.MetricsConfig `yaml:",inline"`
+}
+
+// LeaderElectionConfig configures leader election using a Kubernetes Lease.
+// Only the leader ships events, and the last shipped event is stored in the
+// Lease rather than in the cache file.
+type LeaderElectionConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Name of the Lease object.
+	LeaseName string `yaml:"lease_name,omitempty"`
+	// Namespace of the Lease object. Defaults to the namespace the agent runs
+	// in, or "default" when running outside of Kubernetes.
+	LeaseNamespace string `yaml:"lease_namespace,omitempty"`
+	// Identity of this replica. Defaults to the hostname.
+	Identity string `yaml:"identity,omitempty"`
+
+	LeaseDuration time.Duration `yaml:"lease_duration,omitempty"`
+	RenewDeadline time.Duration `yaml:"renew_deadline,omitempty"`
+	RetryPeriod   time.Duration `yaml:"retry_period,omitempty"`
 }
 
 // UnmarshalYAML implements yaml.Unmarshaler for Config
@@ -43,7 +88,29 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	*c = DefaultConfig
 
 	type plain Config
-	return unmarshal((*plain)(c))
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.LeaderElection.validate()
+}
+
+func (c *LeaderElectionConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.LeaseName == "" {
+		return fmt.Errorf("leader_election.lease_name must not be empty")
+	}
+	if c.RetryPeriod <= 0 {
+		return fmt.Errorf("leader_election.retry_period must be greater than zero")
+	}
+	if c.RenewDeadline <= time.Duration(leaderelection.JitterFactor*float64(c.RetryPeriod)) {
+		return fmt.Errorf("leader_election.renew_deadline must be greater than retry_period*%v", leaderelection.JitterFactor)
+	}
+	if c.LeaseDuration <= c.RenewDeadline {
+		return fmt.Errorf("leader_election.lease_duration must be greater than renew_deadline")
+	}
+	return nil
 }
 
 // Name returns the name of the integration that this config represents
@@ -51,11 +118,18 @@ func (c *Config) Name() string { return "eventhandler" }
 
 // ApplyDefaults applies runtime-specific defaults to c
 func (c *Config) ApplyDefaults(globals integrations.Globals) error {
+	c.Common.ApplyDefaults(globals.SubsystemOpts.Metrics.Autoscrape)
+	if id, err := c.Identifier(globals); err == nil {
+		c.Common.InstanceKey = &id
+	}
 	return nil
 }
 
 // Identifier uniquely identifies this instance of Config
 func (c *Config) Identifier(globals integrations.Globals) (string, error) {
+	if c.Common.InstanceKey != nil {
+		return *c.Common.InstanceKey, nil
+	}
 	return globals.AgentIdentifier, nil
 }
 
@@ -0,0 +1,122 @@
+package eventhandler
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// serviceAccountNamespace is the file holding the namespace of the pod when
+// running in Kubernetes.
+const serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// leaderElection elects a single replica of the integration to ship events
+// using a Kubernetes Lease.
+type leaderElection struct {
+	log   log.Logger
+	cfg   LeaderElectionConfig
+	lock  *resourcelock.LeaseLock
+	store leaseStore
+}
+
+func newLeaderElection(l log.Logger, clientset kubernetes.Interface, cfg LeaderElectionConfig) (*leaderElection, error) {
+	if cfg.LeaseNamespace == "" {
+		cfg.LeaseNamespace = podNamespace()
+	}
+	if cfg.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Identity = hostname
+	}
+
+	return &leaderElection{
+		log: l,
+		cfg: cfg,
+		lock: &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Name:      cfg.LeaseName,
+				Namespace: cfg.LeaseNamespace,
+			},
+			Client:     clientset.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: cfg.Identity},
+		},
+		store: leaseStore{
+			client:    clientset.CoordinationV1(),
+			namespace: cfg.LeaseNamespace,
+			name:      cfg.LeaseName,
+		},
+	}, nil
+}
+
+// podNamespace returns the namespace the agent is running in, falling back to
+// "default" when not running in Kubernetes.
+func podNamespace() string {
+	buf, err := ioutil.ReadFile(serviceAccountNamespace)
+	if err != nil {
+		return metav1.NamespaceDefault
+	}
+	if ns := strings.TrimSpace(string(buf)); ns != "" {
+		return ns
+	}
+	return metav1.NamespaceDefault
+}
+
+// run campaigns for leadership until ctx is canceled, invoking lead for every
+// term this replica is the leader. The ctx passed to lead is canceled when
+// leadership is lost. An error from lead stops the election.
+//
+// The Lease is not released when a term ends so the leader can store the last
+// shipped event before another replica takes over.
+func (le *leaderElection) run(ctx context.Context, lead func(context.Context) error) error {
+	for ctx.Err() == nil {
+		ctx, cancel := context.WithCancel(ctx)
+
+		leading := make(chan context.Context, 1)
+		elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock:          le.lock,
+			LeaseDuration: le.cfg.LeaseDuration,
+			RenewDeadline: le.cfg.RenewDeadline,
+			RetryPeriod:   le.cfg.RetryPeriod,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(termCtx context.Context) { leading <- termCtx },
+				OnStoppedLeading: func() {},
+			},
+			Name: le.cfg.LeaseName,
+		})
+		if err != nil {
+			cancel()
+			return err
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			elector.Run(ctx)
+		}()
+
+		select {
+		case termCtx := <-leading:
+			level.Info(le.log).Log("msg", "became leader, shipping events", "identity", le.cfg.Identity)
+			err = lead(termCtx)
+			level.Info(le.log).Log("msg", "stopped leading", "identity", le.cfg.Identity)
+		case <-done:
+		}
+
+		cancel()
+		<-done
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package eventhandler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/agent/pkg/integrations/v2"
+	"github.com/grafana/agent/pkg/util"
+	"github.com/grafana/loki/clients/pkg/promtail/api"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfig_LeaderElectionValidation(t *testing.T) {
+	var c Config
+	err := yaml.UnmarshalStrict([]byte(util.Untab(`
+leader_election:
+  enabled: true
+  lease_duration: 5s
+  renew_deadline: 5s
+	`)), &c)
+	require.EqualError(t, err, "leader_election.lease_duration must be greater than renew_deadline")
+
+	err = yaml.UnmarshalStrict([]byte(`leader_election: {enabled: true}`), &c)
+	require.NoError(t, err)
+	require.Equal(t, DefaultLeaderElectionConfig.LeaseDuration, c.LeaderElection.LeaseDuration)
+}
+
+func TestWatchedNamespaces(t *testing.T) {
+	require.Equal(t, []string{""}, watchedNamespaces(&Config{}))
+	require.Equal(t, []string{"a", "b"}, watchedNamespaces(&Config{
+		Namespace:  "a",
+		Namespaces: []string{"b", "a"},
+	}))
+}
+
+func TestEventHandler_Filters(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	eh := newTestEventHandler(t, client, &Config{
+		Reasons: []string{"BackOff", "Failed"},
+		Types:   []string{"Warning"},
+	})
+	sink := &mockLogsSink{}
+	eh.SetLogsSink(sink)
+	eh.InitEvent = &ShippedEvents{}
+
+	events := []*v1.Event{
+		testEvent("a", "1", time.Now(), "BackOff", "Warning"),
+		testEvent("b", "2", time.Now(), "BackOff", "Normal"),
+		testEvent("c", "3", time.Now(), "Pulled", "Warning"),
+		testEvent("d", "4", time.Now(), "Failed", "Warning"),
+	}
+	for _, e := range events {
+		require.NoError(t, eh.handleEvent(e))
+	}
+	require.Equal(t, []string{"a", "d"}, sink.objectNames())
+
+	metrics := scrapeMetrics(t, eh)
+	require.Contains(t, metrics, `eventhandler_events_total{involved_object_kind="Pod",namespace="default",reason="BackOff",type="Warning"} 1`)
+	require.Contains(t, metrics, `eventhandler_events_total{involved_object_kind="Pod",namespace="default",reason="Failed",type="Warning"} 1`)
+	require.NotContains(t, metrics, `reason="Pulled"`)
+}
+
+func TestLeaseStore(t *testing.T) {
+	client := fake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: "lease", Namespace: "agent"},
+	})
+	store := leaseStore{client: client.CoordinationV1(), namespace: "agent", name: "lease"}
+
+	cursor, err := store.Load(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, cursor)
+
+	require.NoError(t, store.Save(context.Background(), []byte(`{"ts":"2022-01-26T13:39:40-05:00"}`)))
+	cursor, err = store.Load(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, `{"ts":"2022-01-26T13:39:40-05:00"}`, string(cursor))
+
+	missing := leaseStore{client: client.CoordinationV1(), namespace: "agent", name: "missing"}
+	require.Error(t, missing.Save(context.Background(), []byte("{}")))
+}
+
+func TestEventHandler_LeaderElection(t *testing.T) {
+	start := time.Now().Truncate(time.Second)
+	client := fake.NewSimpleClientset(
+		testEvent("first", "1", start, "Started", "Normal"),
+		testEvent("second", "2", start.Add(time.Second), "Started", "Normal"),
+	)
+
+	newReplica := func(identity string) (*EventHandler, *mockLogsSink) {
+		eh := newTestEventHandler(t, client, &Config{
+			LeaderElection: LeaderElectionConfig{
+				Enabled:        true,
+				LeaseName:      "eventhandler",
+				LeaseNamespace: "agent",
+				Identity:       identity,
+				LeaseDuration:  time.Second,
+				RenewDeadline:  500 * time.Millisecond,
+				RetryPeriod:    100 * time.Millisecond,
+			},
+		})
+		sink := &mockLogsSink{}
+		eh.SetLogsSink(sink)
+		return eh, sink
+	}
+
+	var (
+		a, sinkA = newReplica("a")
+		b, sinkB = newReplica("b")
+	)
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	doneA := runInBackground(t, ctxA, a)
+	require.Eventually(t, func() bool {
+		return len(sinkA.objectNames()) == 2
+	}, 10*time.Second, 50*time.Millisecond)
+
+	// b must not ship anything while a is the leader.
+	ctxB, cancelB := context.WithCancel(context.Background())
+	doneB := runInBackground(t, ctxB, b)
+	defer func() {
+		cancelB()
+		<-doneB
+	}()
+	time.Sleep(500 * time.Millisecond)
+	require.Empty(t, sinkB.objectNames())
+	require.Contains(t, scrapeMetrics(t, a), "eventhandler_leader 1")
+	require.Contains(t, scrapeMetrics(t, b), "eventhandler_leader 0")
+
+	// Stop a. b should take over and only ship new events.
+	cancelA()
+	<-doneA
+
+	_, err := client.CoreV1().Events("default").Create(context.Background(),
+		testEvent("third", "3", start.Add(2*time.Second), "Started", "Normal"),
+		metav1.CreateOptions{},
+	)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(sinkB.objectNames()) > 0
+	}, 10*time.Second, 50*time.Millisecond)
+	require.Equal(t, []string{"third"}, sinkB.objectNames())
+	require.ElementsMatch(t, []string{"first", "second"}, sinkA.objectNames())
+}
+
+func newTestEventHandler(t *testing.T, client kubernetes.Interface, c *Config) *EventHandler {
+	t.Helper()
+
+	cfg := DefaultConfig
+	cfg.CachePath = t.TempDir() + "/eventhandler.cache"
+	cfg.FlushInterval = 1
+	cfg.SendTimeout = 1
+	cfg.Namespace = c.Namespace
+	cfg.Namespaces = c.Namespaces
+	cfg.Reasons = c.Reasons
+	cfg.Types = c.Types
+	if c.LeaderElection.Enabled {
+		cfg.LeaderElection = c.LeaderElection
+	}
+
+	globals := integrations.Globals{
+		AgentIdentifier: "agent",
+		SubsystemOpts:   integrations.DefaultSubsystemOptions,
+	}
+	require.NoError(t, cfg.ApplyDefaults(globals))
+
+	eh, err := newEventHandlerWithClient(log.NewNopLogger(), globals, &cfg, client)
+	require.NoError(t, err)
+	return eh
+}
+
+func runInBackground(t *testing.T, ctx context.Context, eh *EventHandler) <-chan struct{} {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		require.NoError(t, eh.RunIntegration(ctx))
+	}()
+	return done
+}
+
+func testEvent(object, rv string, ts time.Time, reason, typ string) *v1.Event {
+	return &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            fmt.Sprintf("%s.%s", object, rv),
+			Namespace:       "default",
+			ResourceVersion: rv,
+		},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: object, Namespace: "default"},
+		LastTimestamp:  metav1.NewTime(ts),
+		Reason:         reason,
+		Type:           typ,
+		Message:        "test",
+	}
+}
+
+func scrapeMetrics(t *testing.T, eh *EventHandler) string {
+	t.Helper()
+
+	h, err := eh.Handler("/integrations/eventhandler/")
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/integrations/eventhandler/metrics", nil))
+	body, err := io.ReadAll(rec.Result().Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+type mockLogsSink struct {
+	mut     sync.Mutex
+	entries []api.Entry
+}
+
+func (s *mockLogsSink) Send(_ context.Context, _ string, e api.Entry) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.entries = append(s.entries, e)
+	return nil
+}
+
+// objectNames returns the names of the involved objects of the shipped
+// events, in order.
+func (s *mockLogsSink) objectNames() []string {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	var res []string
+	for _, e := range s.entries {
+		var name string
+		_, _ = fmt.Sscanf(e.Line, "name=%s ", &name)
+		res = append(res, name)
+	}
+	return res
+}
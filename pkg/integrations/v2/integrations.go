@@ -30,8 +30,11 @@ import (
 	"github.com/grafana/agent/pkg/metrics"
 	"github.com/grafana/agent/pkg/server"
 	"github.com/grafana/agent/pkg/traces"
+	"github.com/grafana/loki/clients/pkg/promtail/api"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/discovery"
 	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"go.opentelemetry.io/collector/model/pdata"
 )
 
 var (
@@ -98,6 +101,9 @@ type Globals struct {
 	AgentBaseURL *url.URL
 	// Dialer to use for making connections. May be nil.
 	DialContextFunc server.DialContextFunc
+	// Logger of the agent. Integrations can tee it to receive the agent's own
+	// log lines. May be nil.
+	AgentLogger *server.Logger
 }
 
 // CloneAgentBaseURL returns a copy of AgentBaseURL that can be modified.
@@ -186,6 +192,70 @@ type MetricsIntegration interface {
 	ScrapeConfigs(discovery.Configs) []*autoscrape.ScrapeConfig
 }
 
+// LogsIntegration is an integration that generates logs.
+//
+// Logs are sent through a LogsSink given to the integration by the
+// integrations subsystem, which hands them off to a logs instance.
+type LogsIntegration interface {
+	Integration
+
+	// LogsInstance returns the name of the logs instance to send logs to. If
+	// empty, the default logs instance of the integrations subsystem is used.
+	// The instance must exist.
+	LogsInstance() string
+
+	// LogStreams returns the set of streams logs are sent to. Each stream has
+	// a name used when sending entries and a set of labels added to every
+	// entry of the stream.
+	LogStreams() []LogStream
+
+	// SetLogsSink is called with the LogsSink to send logs to before the
+	// integration runs. The sink remains valid across config reloads.
+	SetLogsSink(LogsSink)
+}
+
+// LogStream is a stream of logs generated by a LogsIntegration.
+type LogStream struct {
+	// Name of the stream, unique within the integration.
+	Name string
+	// Labels to add to every entry of the stream. Labels from the entries
+	// take precedence over labels of the stream.
+	Labels model.LabelSet
+}
+
+// LogsSink receives logs from a LogsIntegration.
+type LogsSink interface {
+	// Send sends entry to the stream with the given name. Send blocks until
+	// the logs instance accepts the entry or ctx is canceled, applying
+	// backpressure to the integration.
+	Send(ctx context.Context, stream string, entry api.Entry) error
+}
+
+// TracesIntegration is an integration that generates traces.
+//
+// Traces are sent through a TracesSink given to the integration by the
+// integrations subsystem, which hands them off to the pipeline of a traces
+// instance.
+type TracesIntegration interface {
+	Integration
+
+	// TracesInstance returns the name of the traces instance to send traces
+	// to. If empty, the default traces instance of the integrations subsystem
+	// is used. The instance must exist.
+	TracesInstance() string
+
+	// SetTracesSink is called with the TracesSink to send traces to before
+	// the integration runs. The sink remains valid across config reloads.
+	SetTracesSink(TracesSink)
+}
+
+// TracesSink receives traces from a TracesIntegration.
+type TracesSink interface {
+	// Send passes td to the pipeline of the traces instance, as if it was
+	// received by one of its receivers.
+	Send(ctx context.Context, td pdata.Traces) error
+}
+
 // Endpoint is a location where something is exposed.
 type Endpoint struct {
 	// Hostname (and optional port) where endpoint is exposed.
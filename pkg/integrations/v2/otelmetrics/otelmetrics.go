@@ -0,0 +1,145 @@
+// Package otelmetrics implements an integration which runs OpenTelemetry
+// Collector metrics receivers, like hostmetrics, and writes the metrics they
+// collect to a metrics instance.
+package otelmetrics
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/agent/pkg/integrations/v2"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/grafana/agent/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"go.opentelemetry.io/collector/config"
+)
+
+// DefaultConfig holds the default settings for the otel_metrics integration.
+var DefaultConfig = Config{
+	ResourceAttributes: []string{
+		"service.namespace",
+		"service.name",
+		"service.instance.id",
+		"host.name",
+	},
+}
+
+// Config configures the otel_metrics integration.
+type Config struct {
+	// Instance uniquely identifies the integration. Defaults to the agent
+	// identifier.
+	Instance *string `yaml:"instance,omitempty"`
+	// Name of the metrics instance to write metrics to. Defaults to the
+	// metrics instance used for autoscraping integrations.
+	MetricsInstance string `yaml:"metrics_instance,omitempty"`
+	// Prefix added to the name of every metric.
+	Namespace string `yaml:"namespace,omitempty"`
+	// Labels added to every metric. The job, instance and agent_hostname
+	// labels are added by default.
+	ConstLabels prometheus.Labels `yaml:"const_labels,omitempty"`
+	// Resource attributes added as labels to every metric, with dots
+	// replaced by underscores.
+	ResourceAttributes []string `yaml:"resource_attributes,omitempty"`
+
+	// Receivers configures the OTel receivers of the pipeline, using the same
+	// format as the OpenTelemetry Collector.
+	Receivers map[string]interface{} `yaml:"receivers,omitempty"`
+	// Batch configures the OTel batch processor. Metrics aren't batched if
+	// unset.
+	Batch map[string]interface{} `yaml:"batch,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler for Config.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConfig
+
+	type plain Config
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if len(c.Receivers) == 0 {
+		return fmt.Errorf("must have at least one configured receiver")
+	}
+	return nil
+}
+
+// Name returns the name of the integration that this config represents.
+func (c *Config) Name() string { return "otel_metrics" }
+
+// ApplyDefaults applies runtime-specific defaults to c.
+func (c *Config) ApplyDefaults(globals integrations.Globals) error {
+	id, err := c.Identifier(globals)
+	if err != nil {
+		return err
+	}
+	c.Instance = &id
+
+	if c.MetricsInstance == "" {
+		c.MetricsInstance = globals.SubsystemOpts.Metrics.Autoscrape.MetricsInstance
+	}
+
+	// Copy the labels so defaults aren't added to the map of the original
+	// config.
+	constLabels := prometheus.Labels{
+		model.JobLabel:      "integrations/" + c.Name(),
+		model.InstanceLabel: id,
+		"agent_hostname":    globals.AgentIdentifier,
+	}
+	for name, value := range c.ConstLabels {
+		constLabels[name] = value
+	}
+	c.ConstLabels = constLabels
+	return nil
+}
+
+// Identifier uniquely identifies this instance of Config.
+func (c *Config) Identifier(globals integrations.Globals) (string, error) {
+	if c.Instance != nil {
+		return *c.Instance, nil
+	}
+	return globals.AgentIdentifier, nil
+}
+
+// NewIntegration converts this config into an instance of an integration.
+func (c *Config) NewIntegration(l log.Logger, globals integrations.Globals) (integrations.Integration, error) {
+	if globals.Metrics == nil {
+		return nil, fmt.Errorf("otel_metrics requires the metrics subsystem to be enabled")
+	}
+
+	otelCfg, err := c.otelConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return &otelMetrics{
+		log:     l,
+		otelCfg: otelCfg,
+		manager: globals.Metrics.InstanceManager(),
+	}, nil
+}
+
+func init() {
+	integrations.Register(&Config{}, integrations.TypeMultiplex)
+}
+
+// otelMetrics runs an OTel metrics pipeline for the lifetime of the
+// integration.
+type otelMetrics struct {
+	log     log.Logger
+	otelCfg *config.Config
+	manager instance.Manager
+}
+
+// RunIntegration implements integrations.Integration.
+func (i *otelMetrics) RunIntegration(ctx context.Context) error {
+	p, err := startPipeline(ctx, util.NewZapLogger(i.log), i.otelCfg, i.manager)
+	if err != nil {
+		return err
+	}
+	defer p.Shutdown()
+
+	<-ctx.Done()
+	return nil
+}
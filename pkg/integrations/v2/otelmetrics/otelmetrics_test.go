@@ -0,0 +1,144 @@
+package otelmetrics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/agent/pkg/integrations/v2"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConfig_Unmarshal(t *testing.T) {
+	var c Config
+	err := yaml.UnmarshalStrict([]byte(`metrics_instance: default`), &c)
+	require.EqualError(t, err, "must have at least one configured receiver")
+}
+
+func TestConfig_ApplyDefaults(t *testing.T) {
+	var c Config
+	err := yaml.UnmarshalStrict([]byte(`
+receivers:
+  hostmetrics:
+    scrapers:
+      load: {}
+const_labels:
+  job: hosts`), &c)
+	require.NoError(t, err)
+
+	globals := integrations.Globals{AgentIdentifier: "agent-1:12345"}
+	globals.SubsystemOpts.Metrics.Autoscrape.MetricsInstance = "default"
+	require.NoError(t, c.ApplyDefaults(globals))
+
+	require.Equal(t, "agent-1:12345", *c.Instance)
+	require.Equal(t, "default", c.MetricsInstance)
+	require.Equal(t, prometheus.Labels{
+		"job":            "hosts",
+		"instance":       "agent-1:12345",
+		"agent_hostname": "agent-1:12345",
+	}, c.ConstLabels)
+	require.Equal(t, DefaultConfig.ResourceAttributes, c.ResourceAttributes)
+
+	_, err = c.otelConfig()
+	require.NoError(t, err)
+}
+
+func TestConfig_UnknownReceiver(t *testing.T) {
+	c := Config{
+		MetricsInstance: "default",
+		Receivers:       map[string]interface{}{"jaeger": nil},
+	}
+	_, err := c.otelConfig()
+	require.Error(t, err)
+}
+
+func TestPipeline(t *testing.T) {
+	var c Config
+	err := yaml.UnmarshalStrict([]byte(`
+metrics_instance: default
+namespace: otel
+const_labels:
+  job: hosts
+receivers:
+  hostmetrics:
+    collection_interval: 100ms
+    scrapers:
+      load: {}`), &c)
+	require.NoError(t, err)
+
+	otelCfg, err := c.otelConfig()
+	require.NoError(t, err)
+
+	app := &mockAppender{}
+	manager := instance.MockManager{
+		GetInstanceFunc: func(name string) (instance.ManagedInstance, error) {
+			if name != "default" {
+				return nil, fmt.Errorf("instance %s not found", name)
+			}
+			return &mockInstance{app: app}, nil
+		},
+	}
+
+	p, err := startPipeline(context.Background(), zap.NewNop(), otelCfg, manager)
+	require.NoError(t, err)
+	defer p.Shutdown()
+
+	require.Eventually(t, func() bool {
+		return len(app.Series("otel_system_cpu_load_average_1m")) > 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	series := app.Series("otel_system_cpu_load_average_1m")
+	require.Equal(t, "hosts", series[0].Get("job"))
+}
+
+type mockInstance struct {
+	instance.NoOpInstance
+	app *mockAppender
+}
+
+func (i *mockInstance) Appender(_ context.Context) storage.Appender { return i.app }
+
+// mockAppender records the series of appended samples. It's safe for
+// concurrent use.
+type mockAppender struct {
+	mut    sync.Mutex
+	series []labels.Labels
+}
+
+// Series returns the appended series with the given metric name.
+func (a *mockAppender) Series(name string) []labels.Labels {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	var res []labels.Labels
+	for _, ls := range a.series {
+		if ls.Get(labels.MetricName) == name {
+			res = append(res, ls)
+		}
+	}
+	return res
+}
+
+func (a *mockAppender) Append(_ storage.SeriesRef, l labels.Labels, _ int64, _ float64) (storage.SeriesRef, error) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.series = append(a.series, l)
+	return 0, nil
+}
+
+func (a *mockAppender) AppendExemplar(_ storage.SeriesRef, _ labels.Labels, _ exemplar.Exemplar) (storage.SeriesRef, error) {
+	return 0, nil
+}
+
+func (a *mockAppender) Commit() error { return nil }
+
+func (a *mockAppender) Rollback() error { return nil }
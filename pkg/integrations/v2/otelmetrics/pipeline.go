@@ -0,0 +1,249 @@
+package otelmetrics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/grafana/agent/pkg/build"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/grafana/agent/pkg/traces/contextkeys"
+	"github.com/grafana/agent/pkg/traces/remotewriteexporter"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/receiver/hostmetricsreceiver"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/receiver/opencensusreceiver"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/config/configunmarshaler"
+	"go.opentelemetry.io/collector/processor/batchprocessor"
+	"go.opentelemetry.io/collector/receiver/otlpreceiver"
+	"go.opentelemetry.io/collector/service/external/builder"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// pipelineName is the name of the only pipeline built by the integration.
+const pipelineName = "metrics"
+
+// metricsFactories returns the OTel components which can be used in the
+// pipeline. If we decide to add support for a new receiver, we need to add
+// it here.
+func metricsFactories() (component.Factories, error) {
+	receivers, err := component.MakeReceiverFactoryMap(
+		hostmetricsreceiver.NewFactory(),
+		otlpreceiver.NewFactory(),
+		opencensusreceiver.NewFactory(),
+	)
+	if err != nil {
+		return component.Factories{}, err
+	}
+
+	processors, err := component.MakeProcessorFactoryMap(
+		batchprocessor.NewFactory(),
+	)
+	if err != nil {
+		return component.Factories{}, err
+	}
+
+	exporters, err := component.MakeExporterFactoryMap(
+		remotewriteexporter.NewFactory(),
+	)
+	if err != nil {
+		return component.Factories{}, err
+	}
+
+	return component.Factories{
+		Receivers:  receivers,
+		Processors: processors,
+		Exporters:  exporters,
+	}, nil
+}
+
+// otelConfig builds the OTel config of the pipeline described by c. c must
+// have had defaults applied.
+func (c *Config) otelConfig() (*config.Config, error) {
+	if len(c.Receivers) == 0 {
+		return nil, fmt.Errorf("must have at least one configured receiver")
+	}
+
+	receiverNames := make([]string, 0, len(c.Receivers))
+	for name := range c.Receivers {
+		receiverNames = append(receiverNames, name)
+	}
+
+	processors := map[string]interface{}{}
+	processorNames := []string{}
+	if c.Batch != nil {
+		processors["batch"] = c.Batch
+		processorNames = append(processorNames, "batch")
+	}
+
+	otelMapStructure := map[string]interface{}{
+		"receivers":  c.Receivers,
+		"processors": processors,
+		"exporters": map[string]interface{}{
+			remotewriteexporter.TypeStr: map[string]interface{}{
+				"namespace":           c.Namespace,
+				"const_labels":        map[string]string(c.ConstLabels),
+				"metrics_instance":    c.MetricsInstance,
+				"resource_attributes": c.ResourceAttributes,
+			},
+		},
+		"service": map[string]interface{}{
+			"pipelines": map[string]interface{}{
+				pipelineName: map[string]interface{}{
+					"receivers":  receiverNames,
+					"processors": processorNames,
+					"exporters":  []string{remotewriteexporter.TypeStr},
+				},
+			},
+		},
+	}
+
+	factories, err := metricsFactories()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create factories: %w", err)
+	}
+
+	otelCfg, err := configunmarshaler.NewDefault().Unmarshal(config.NewMapFromStringMap(otelMapStructure), factories)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OTel config: %w", err)
+	}
+	if err := otelCfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid OTel config: %w", err)
+	}
+	return otelCfg, nil
+}
+
+// pipeline is a running OTel metrics pipeline. It implements component.Host
+// for the components of the pipeline.
+type pipeline struct {
+	logger *zap.Logger
+
+	exporters builder.Exporters
+	pipelines builder.BuiltPipelines
+	receivers builder.Receivers
+}
+
+// startPipeline builds and starts the pipeline of otelCfg. Metrics are
+// written to the metrics instances of manager.
+func startPipeline(ctx context.Context, logger *zap.Logger, otelCfg *config.Config, manager instance.Manager) (*pipeline, error) {
+	factories, err := metricsFactories()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create factories: %w", err)
+	}
+
+	ctx = context.WithValue(ctx, contextkeys.Metrics, manager)
+
+	appinfo := component.BuildInfo{
+		Command:     "agent",
+		Description: "agent",
+		Version:     build.Version,
+	}
+	settings := component.TelemetrySettings{
+		Logger:         logger,
+		TracerProvider: trace.NewNoopTracerProvider(),
+		MeterProvider:  metric.NewNoopMeterProvider(),
+	}
+
+	p := &pipeline{logger: logger}
+
+	// Start components in reverse order of the data flow, so that receivers
+	// only start when the rest of the pipeline is ready.
+	p.exporters, err = builder.BuildExporters(settings, appinfo, otelCfg, factories.Exporters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exporters builder: %w", err)
+	}
+	if err := p.exporters.StartAll(ctx, p); err != nil {
+		p.Shutdown()
+		return nil, fmt.Errorf("failed to start exporters: %w", err)
+	}
+
+	p.pipelines, err = builder.BuildPipelines(settings, appinfo, otelCfg, p.exporters, factories.Processors)
+	if err != nil {
+		p.Shutdown()
+		return nil, fmt.Errorf("failed to create pipelines builder: %w", err)
+	}
+	if err := p.pipelines.StartProcessors(ctx, p); err != nil {
+		p.Shutdown()
+		return nil, fmt.Errorf("failed to start processors: %w", err)
+	}
+
+	p.receivers, err = builder.BuildReceivers(settings, appinfo, otelCfg, p.pipelines, factories.Receivers)
+	if err != nil {
+		p.Shutdown()
+		return nil, fmt.Errorf("failed to create receivers builder: %w", err)
+	}
+	if err := p.receivers.StartAll(ctx, p); err != nil {
+		p.Shutdown()
+		return nil, fmt.Errorf("failed to start receivers: %w", err)
+	}
+
+	return p, nil
+}
+
+// Shutdown stops the components of the pipeline in the order of the data
+// flow.
+func (p *pipeline) Shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dependencies := []struct {
+		name     string
+		shutdown func() error
+	}{
+		{
+			name: "receivers",
+			shutdown: func() error {
+				if p.receivers == nil {
+					return nil
+				}
+				return p.receivers.ShutdownAll(ctx)
+			},
+		},
+		{
+			name: "processors",
+			shutdown: func() error {
+				if p.pipelines == nil {
+					return nil
+				}
+				return p.pipelines.ShutdownProcessors(ctx)
+			},
+		},
+		{
+			name: "exporters",
+			shutdown: func() error {
+				if p.exporters == nil {
+					return nil
+				}
+				return p.exporters.ShutdownAll(ctx)
+			},
+		},
+	}
+
+	for _, dep := range dependencies {
+		if err := dep.shutdown(); err != nil {
+			p.logger.Error(fmt.Sprintf("failed to shutdown %s", dep.name), zap.Error(err))
+		}
+	}
+}
+
+// ReportFatalError implements component.Host.
+func (p *pipeline) ReportFatalError(err error) {
+	p.logger.Error("fatal error reported", zap.Error(err))
+}
+
+// GetFactory implements component.Host.
+func (p *pipeline) GetFactory(component.Kind, config.Type) component.Factory {
+	return nil
+}
+
+// GetExtensions implements component.Host.
+func (p *pipeline) GetExtensions() map[config.ComponentID]component.Extension {
+	return nil
+}
+
+// GetExporters implements component.Host.
+func (p *pipeline) GetExporters() map[config.DataType]map[config.ComponentID]component.Exporter {
+	return p.exporters.ToMapByDataType()
+}
@@ -373,6 +373,16 @@ func UnmarshalYAML(out interface{}, unmarshal func(interface{}) error) error {
 	return nil
 }
 
+// unmarshalConfig unmarshals the YAML of a single config for the registered
+// integration called name.
+func unmarshalConfig(name string, raw []byte) (Config, error) {
+	ref, ok := integrationByName[name]
+	if !ok {
+		return nil, fmt.Errorf("integration %q not registered", name)
+	}
+	return deferredConfigUnmarshal(raw, ref)
+}
+
 // deferredConfigUnmarshal performs a deferred unmarshal of raw into a Config.
 // ref must be either Config or v1.Config.
 func deferredConfigUnmarshal(raw util.RawYAML, ref interface{}) (Config, error) {
@@ -0,0 +1,145 @@
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/agent/pkg/logs"
+	"github.com/grafana/agent/pkg/traces"
+	"github.com/grafana/loki/clients/pkg/promtail/api"
+	"github.com/prometheus/common/model"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+// logsSendTimeout is how long a logsSink waits for the logs instance to
+// accept an entry before checking if the context is done and trying again.
+// The same time is waited between tries, since stopped instances reject
+// entries immediately.
+const logsSendTimeout = 100 * time.Millisecond
+
+// logsSink implements LogsSink for a LogsIntegration. It's updated by the
+// controller whenever the config is reloaded.
+type logsSink struct {
+	mut      sync.RWMutex
+	logs     *logs.Logs
+	instance string
+	streams  map[string]model.LabelSet
+}
+
+// update updates the sink with the settings of li.
+func (s *logsSink) update(g Globals, li LogsIntegration) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.logs = g.Logs
+	s.instance = li.LogsInstance()
+	if s.instance == "" {
+		s.instance = g.SubsystemOpts.Logs.LogsInstance
+	}
+
+	s.streams = make(map[string]model.LabelSet)
+	for _, stream := range li.LogStreams() {
+		s.streams[stream.Name] = stream.Labels
+	}
+}
+
+// Send implements LogsSink.
+func (s *logsSink) Send(ctx context.Context, stream string, entry api.Entry) error {
+	s.mut.RLock()
+	var (
+		l        = s.logs
+		instance = s.instance
+	)
+	labels, ok := s.streams[stream]
+	s.mut.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("unknown log stream %q", stream)
+	}
+	if l == nil {
+		return fmt.Errorf("logs subsystem is not enabled")
+	}
+	entry.Labels = labels.Merge(entry.Labels)
+
+	for {
+		// The instance is looked up every time, since it may be recreated when
+		// the config is reloaded.
+		inst := l.Instance(instance)
+		if inst == nil {
+			return fmt.Errorf("logs instance %q not found", instance)
+		}
+		if inst.SendEntry(entry, logsSendTimeout) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to send entry to logs instance %q: %w", instance, ctx.Err())
+		case <-time.After(logsSendTimeout):
+		}
+	}
+}
+
+// tracesSink implements TracesSink for a TracesIntegration. It's updated by
+// the controller whenever the config is reloaded.
+type tracesSink struct {
+	mut      sync.RWMutex
+	traces   *traces.Traces
+	instance string
+}
+
+// update updates the sink with the settings of ti.
+func (s *tracesSink) update(g Globals, ti TracesIntegration) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.traces = g.Tracing
+	s.instance = ti.TracesInstance()
+	if s.instance == "" {
+		s.instance = g.SubsystemOpts.Traces.TracesInstance
+	}
+}
+
+// Send implements TracesSink.
+func (s *tracesSink) Send(ctx context.Context, td pdata.Traces) error {
+	s.mut.RLock()
+	var (
+		t        = s.traces
+		instance = s.instance
+	)
+	s.mut.RUnlock()
+
+	if t == nil {
+		return fmt.Errorf("traces subsystem is not enabled")
+	}
+	inst := t.Instance(instance)
+	if inst == nil {
+		return fmt.Errorf("traces instance %q not found", instance)
+	}
+	return inst.ConsumeTraces(ctx, td)
+}
+
+// updateSinks creates or updates the sinks of ci for the extensions it
+// implements.
+func (ci *controlledIntegration) updateSinks(g Globals) {
+	if li, ok := ci.i.(LogsIntegration); ok {
+		if ci.logsSink == nil {
+			ci.logsSink = &logsSink{}
+			ci.logsSink.update(g, li)
+			li.SetLogsSink(ci.logsSink)
+		} else {
+			ci.logsSink.update(g, li)
+		}
+	}
+	if ti, ok := ci.i.(TracesIntegration); ok {
+		if ci.tracesSink == nil {
+			ci.tracesSink = &tracesSink{}
+			ci.tracesSink.update(g, ti)
+			ti.SetTracesSink(ci.tracesSink)
+		} else {
+			ci.tracesSink.update(g, ti)
+		}
+	}
+}
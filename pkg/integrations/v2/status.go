@@ -0,0 +1,168 @@
+package integrations
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	integrationRunning = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "agent_integration_running",
+		Help: "Set to 1 if the integration is running, 0 otherwise.",
+	}, []string{"integration_name", "integration_instance"})
+	integrationRestarts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_integration_restarts_total",
+		Help: "Total number of times the integration was restarted after exiting with an error.",
+	}, []string{"integration_name", "integration_instance"})
+	integrationStartTime = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "agent_integration_start_time_seconds",
+		Help: "Unix timestamp of the last time the integration started running.",
+	}, []string{"integration_name", "integration_instance"})
+	integrationLastErrorTime = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "agent_integration_last_error_time_seconds",
+		Help: "Unix timestamp of the last time the integration exited with an error.",
+	}, []string{"integration_name", "integration_instance"})
+)
+
+// IntegrationState is the state of an integration.
+type IntegrationState string
+
+const (
+	// StatePending is the state of integrations which haven't started yet.
+	StatePending IntegrationState = "pending"
+	// StateRunning is the state of running integrations.
+	StateRunning IntegrationState = "running"
+	// StateRestarting is the state of integrations which exited with an
+	// error and are waiting to be restarted.
+	StateRestarting IntegrationState = "restarting"
+	// StateExited is the state of integrations which exited without an
+	// error. They aren't restarted until the config is reloaded.
+	StateExited IntegrationState = "exited"
+	// StateStopped is the state of integrations stopped by the Agent.
+	StateStopped IntegrationState = "stopped"
+)
+
+// IntegrationStatus is the status of an integration returned by the status
+// API.
+type IntegrationStatus struct {
+	Name     string           `json:"name"`
+	Instance string           `json:"instance"`
+	State    IntegrationState `json:"state"`
+	// Restarts is the number of times the integration was restarted after
+	// exiting with an error.
+	Restarts      int        `json:"restarts"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+	// Uptime is how long the integration has been running, in seconds.
+	Uptime float64 `json:"uptime_seconds"`
+}
+
+// integrationStatus tracks the status of a controlledIntegration.
+type integrationStatus struct {
+	mut           sync.Mutex
+	state         IntegrationState
+	restarts      int
+	lastError     error
+	lastErrorTime time.Time
+	startTime     time.Time
+}
+
+func (ci *controlledIntegration) labelValues() []string {
+	return []string{ci.id.Name, ci.id.Identifier}
+}
+
+// setRunning marks the integration as running.
+func (ci *controlledIntegration) setRunning(now time.Time) {
+	ci.status.mut.Lock()
+	defer ci.status.mut.Unlock()
+
+	ci.status.state = StateRunning
+	ci.status.startTime = now
+	ci.running.Store(true)
+
+	integrationRunning.WithLabelValues(ci.labelValues()...).Set(1)
+	integrationStartTime.WithLabelValues(ci.labelValues()...).Set(float64(now.Unix()))
+}
+
+// setExited marks the integration as no longer running. err is the error it
+// exited with, if any.
+func (ci *controlledIntegration) setExited(state IntegrationState, err error, now time.Time) {
+	ci.status.mut.Lock()
+	defer ci.status.mut.Unlock()
+
+	ci.status.state = state
+	ci.running.Store(false)
+	integrationRunning.WithLabelValues(ci.labelValues()...).Set(0)
+
+	if err != nil {
+		ci.status.lastError = err
+		ci.status.lastErrorTime = now
+		integrationLastErrorTime.WithLabelValues(ci.labelValues()...).Set(float64(now.Unix()))
+	}
+}
+
+// setRestarted records a restart of the integration.
+func (ci *controlledIntegration) setRestarted() {
+	ci.status.mut.Lock()
+	defer ci.status.mut.Unlock()
+
+	ci.status.restarts++
+	integrationRestarts.WithLabelValues(ci.labelValues()...).Inc()
+}
+
+// deleteMetrics removes the metrics of the integration.
+func (ci *controlledIntegration) deleteMetrics() {
+	integrationRunning.DeleteLabelValues(ci.labelValues()...)
+	integrationRestarts.DeleteLabelValues(ci.labelValues()...)
+	integrationStartTime.DeleteLabelValues(ci.labelValues()...)
+	integrationLastErrorTime.DeleteLabelValues(ci.labelValues()...)
+}
+
+// Status returns the status of the integration.
+func (ci *controlledIntegration) Status(now time.Time) IntegrationStatus {
+	ci.status.mut.Lock()
+	defer ci.status.mut.Unlock()
+
+	s := IntegrationStatus{
+		Name:     ci.id.Name,
+		Instance: ci.id.Identifier,
+		State:    ci.status.state,
+		Restarts: ci.status.restarts,
+	}
+	if s.State == "" {
+		s.State = StatePending
+	}
+	if ci.status.lastError != nil {
+		lastErrorTime := ci.status.lastErrorTime
+		s.LastError = ci.status.lastError.Error()
+		s.LastErrorTime = &lastErrorTime
+	}
+	if s.State == StateRunning {
+		s.Uptime = now.Sub(ci.status.startTime).Seconds()
+	}
+	return s
+}
+
+// Status returns the status of all integrations, sorted by name and
+// instance.
+func (c *controller) Status() []IntegrationStatus {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	now := time.Now()
+	res := make([]IntegrationStatus, 0, len(c.integrations))
+	for _, ci := range c.integrations {
+		res = append(res, ci.Status(now))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Name != res[j].Name {
+			return res[i].Name < res[j].Name
+		}
+		return res[i].Instance < res[j].Instance
+	})
+	return res
+}
@@ -9,9 +9,12 @@ import (
 	"time"
 
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/gorilla/mux"
+	"github.com/grafana/agent/pkg/integrations/v2/autodiscovery"
 	"github.com/grafana/agent/pkg/integrations/v2/autoscrape"
 	"github.com/grafana/agent/pkg/metrics"
+	"github.com/grafana/agent/pkg/metrics/cluster/configapi"
 	"github.com/prometheus/common/model"
 	http_sd "github.com/prometheus/prometheus/discovery/http"
 )
@@ -25,22 +28,47 @@ const (
 	// IntegrationsAutoscrapeTargetsEndpoint is the API endpoint where autoscrape
 	// integrations targets are exposed.
 	IntegrationsAutoscrapeTargetsEndpoint = "/agent/api/v1/metrics/integrations/targets"
+
+	// IntegrationsStatusEndpoint is the API endpoint where the status of
+	// integrations is exposed.
+	IntegrationsStatusEndpoint = "/agent/api/v1/integrations/status"
+
+	// IntegrationsAutodiscoveryEndpoint is the API endpoint where integrations
+	// found by autodiscovery are exposed.
+	IntegrationsAutodiscoveryEndpoint = "/agent/api/v1/integrations/autodiscovery"
 )
 
 // DefaultSubsystemOptions holds the default settings for a Controller.
 var (
 	DefaultSubsystemOptions = SubsystemOptions{
-		Metrics: DefaultMetricsSubsystemOptions,
+		Metrics:       DefaultMetricsSubsystemOptions,
+		Logs:          DefaultLogsSubsystemOptions,
+		Traces:        DefaultTracesSubsystemOptions,
+		Autodiscovery: autodiscovery.DefaultConfig,
 	}
 
 	DefaultMetricsSubsystemOptions = MetricsSubsystemOptions{
 		Autoscrape: autoscrape.DefaultGlobal,
 	}
+
+	DefaultLogsSubsystemOptions = LogsSubsystemOptions{
+		LogsInstance: "default",
+	}
+
+	DefaultTracesSubsystemOptions = TracesSubsystemOptions{
+		TracesInstance: "default",
+	}
 )
 
 // SubsystemOptions controls how the integrations subsystem behaves.
 type SubsystemOptions struct {
 	Metrics MetricsSubsystemOptions `yaml:"metrics,omitempty"`
+	Logs    LogsSubsystemOptions    `yaml:"logs,omitempty"`
+	Traces  TracesSubsystemOptions  `yaml:"traces,omitempty"`
+
+	// Autodiscovery creates integrations for services found next to the
+	// agent. Explicitly configured integrations take precedence.
+	Autodiscovery autodiscovery.Config `yaml:"autodiscovery,omitempty"`
 
 	// Configs are configurations of integration to create. Unmarshaled through
 	// the custom UnmarshalYAML method of Controller.
@@ -52,6 +80,20 @@ type MetricsSubsystemOptions struct {
 	Autoscrape autoscrape.Global `yaml:"autoscrape,omitempty"`
 }
 
+// LogsSubsystemOptions controls how logs integrations behave.
+type LogsSubsystemOptions struct {
+	// LogsInstance is the default logs instance logs integrations send logs
+	// to.
+	LogsInstance string `yaml:"logs_instance,omitempty"`
+}
+
+// TracesSubsystemOptions controls how traces integrations behave.
+type TracesSubsystemOptions struct {
+	// TracesInstance is the default traces instance traces integrations send
+	// traces to.
+	TracesInstance string `yaml:"traces_instance,omitempty"`
+}
+
 // ApplyDefaults will apply defaults to o.
 func (o *SubsystemOptions) ApplyDefaults(mcfg *metrics.Config) error {
 	if o.Metrics.Autoscrape.ScrapeInterval == 0 {
@@ -86,6 +128,11 @@ type Subsystem struct {
 	apiHandler  http.Handler // generated from controller
 	autoscraper *autoscrape.Scraper
 
+	// discoverer is created the first time autodiscovery is enabled.
+	discoverer      *autodiscovery.Discoverer
+	discovered      []autodiscovery.Result
+	discoveryStatus []DiscoveredIntegration
+
 	ctrl             *controller
 	stopController   context.CancelFunc
 	controllerExited chan struct{}
@@ -132,14 +179,55 @@ func NewSubsystem(l log.Logger, globals Globals) (*Subsystem, error) {
 
 // ApplyConfig updates the configuration of the integrations subsystem.
 func (s *Subsystem) ApplyConfig(globals Globals) error {
-	const prefix = "/integrations/"
+	s.mut.Lock()
+	defer s.mut.Unlock()
 
+	autodiscoveryConfig := globals.SubsystemOpts.Autodiscovery
+	if s.discoverer == nil && autodiscoveryConfig.Enabled {
+		s.discoverer = autodiscovery.NewDiscoverer(s.logger, s.onDiscovered)
+	}
+	if s.discoverer != nil {
+		if err := s.discoverer.ApplyConfig(autodiscoveryConfig); err != nil {
+			return err
+		}
+	}
+	if !autodiscoveryConfig.Enabled {
+		s.discovered = nil
+	}
+
+	return s.applyConfig(globals)
+}
+
+// onDiscovered is invoked by the discoverer when autodiscovery results
+// change.
+func (s *Subsystem) onDiscovered(results []autodiscovery.Result) {
 	s.mut.Lock()
 	defer s.mut.Unlock()
 
-	if err := s.ctrl.UpdateController(controllerConfig(globals.SubsystemOpts.Configs), globals); err != nil {
+	if !s.globals.SubsystemOpts.Autodiscovery.Enabled {
+		return
+	}
+
+	s.discovered = results
+	if err := s.applyConfig(s.globals); err != nil {
+		level.Error(s.logger).Log("msg", "failed to apply discovered integrations", "err", err)
+	}
+}
+
+// applyConfig applies globals along with any discovered integrations. s.mut
+// must be held when calling applyConfig.
+func (s *Subsystem) applyConfig(globals Globals) error {
+	const prefix = "/integrations/"
+
+	explicit := globals.SubsystemOpts.Configs
+	discovered, status := mergeDiscovered(explicit, s.discovered, globals)
+	failed, err := s.ctrl.updateController(controllerConfig(explicit), controllerConfig(discovered), globals)
+	if err != nil {
 		return fmt.Errorf("error applying integrations: %w", err)
 	}
+	markFailed(status, failed)
+	sortDiscoveryStatus(status)
+	s.discoveryStatus = status
 
 	var firstErr error
 	saveFirstErr := func(err error) {
@@ -236,11 +324,32 @@ func (s *Subsystem) WireAPI(r *mux.Router) {
 		allTargets := s.autoscraper.TargetsActive()
 		metrics.ListTargetsHandler(allTargets).ServeHTTP(rw, r)
 	})
+
+	r.HandleFunc(IntegrationsStatusEndpoint, func(rw http.ResponseWriter, r *http.Request) {
+		_ = configapi.WriteResponse(rw, http.StatusOK, s.ctrl.Status())
+	}).Methods("GET")
+
+	r.HandleFunc(IntegrationsAutodiscoveryEndpoint, func(rw http.ResponseWriter, r *http.Request) {
+		s.mut.RLock()
+		status := s.discoveryStatus
+		s.mut.RUnlock()
+
+		_ = configapi.WriteResponse(rw, http.StatusOK, status)
+	}).Methods("GET")
 }
 
 // Stop stops the manager and all running integrations. Blocks until all
 // running integrations exit.
 func (s *Subsystem) Stop() {
+	// The discoverer must be stopped first so it doesn't apply discovered
+	// integrations after the controller exits.
+	s.mut.RLock()
+	discoverer := s.discoverer
+	s.mut.RUnlock()
+	if discoverer != nil {
+		discoverer.Stop()
+	}
+
 	s.autoscraper.Stop()
 	s.stopController()
 	<-s.controllerExited
@@ -3,14 +3,17 @@ package integrations
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/backoff"
 )
 
 type workerPool struct {
-	log       log.Logger
-	parentCtx context.Context
+	log            log.Logger
+	parentCtx      context.Context
+	restartBackoff backoff.Config
 
 	mut     sync.Mutex
 	workers map[*controlledIntegration]worker
@@ -24,10 +27,11 @@ type worker struct {
 	exited chan struct{}
 }
 
-func newWorkerPool(ctx context.Context, l log.Logger) *workerPool {
+func newWorkerPool(ctx context.Context, l log.Logger, restartBackoff backoff.Config) *workerPool {
 	return &workerPool{
-		log:       l,
-		parentCtx: ctx,
+		log:            l,
+		parentCtx:      ctx,
+		restartBackoff: restartBackoff,
 
 		workers: make(map[*controlledIntegration]worker),
 	}
@@ -96,8 +100,6 @@ func (p *workerPool) scheduleWorker(ci *controlledIntegration) {
 	p.workers[ci] = w
 
 	go func() {
-		ci.running.Store(true)
-
 		// When the integration stops running, we want to free any of our
 		// resources that will notify watchers waiting for the worker to stop.
 		//
@@ -105,7 +107,6 @@ func (p *workerPool) scheduleWorker(ci *controlledIntegration) {
 		// an worker remove itself on shutdown allows exited integrations to
 		// re-start when the config is reloaded.
 		defer func() {
-			ci.running.Store(false)
 			close(w.exited)
 			p.runningWorkers.Done()
 
@@ -114,9 +115,53 @@ func (p *workerPool) scheduleWorker(ci *controlledIntegration) {
 			delete(p.workers, ci)
 		}()
 
+		p.runIntegration(ctx, ci)
+	}()
+}
+
+// runIntegration runs ci until ctx is canceled, restarting it with backoff
+// whenever it exits with an error.
+func (p *workerPool) runIntegration(ctx context.Context, ci *controlledIntegration) {
+	bo := backoff.New(ctx, p.restartBackoff)
+
+	for {
+		started := time.Now()
+		ci.setRunning(started)
 		err := ci.i.RunIntegration(ctx)
-		if err != nil {
-			level.Error(p.log).Log("msg", "integration exited with error", "id", ci.id, "err", err)
+
+		switch {
+		case ctx.Err() != nil:
+			// The integration was stopped by the pool: it's either being
+			// removed or recreated, so its metrics are removed too.
+			ci.setExited(StateStopped, nil, time.Now())
+			ci.deleteMetrics()
+			return
+		case err == nil:
+			level.Info(p.log).Log("msg", "integration exited", "id", ci.id)
+			ci.setExited(StateExited, nil, time.Now())
+			return
 		}
-	}()
+
+		ci.setExited(StateRestarting, err, time.Now())
+
+		// Integrations which ran for a while before failing start over with
+		// the minimum backoff.
+		if time.Since(started) > p.restartBackoff.MaxBackoff {
+			bo.Reset()
+		}
+		if !bo.Ongoing() {
+			level.Error(p.log).Log("msg", "integration exited with error, not restarting", "id", ci.id, "err", err, "retries", bo.NumRetries())
+			ci.setExited(StateExited, nil, time.Now())
+			return
+		}
+
+		level.Error(p.log).Log("msg", "integration exited with error, restarting after backoff", "id", ci.id, "err", err, "retries", bo.NumRetries())
+		bo.Wait()
+		if ctx.Err() != nil {
+			ci.setExited(StateStopped, nil, time.Now())
+			ci.deleteMetrics()
+			return
+		}
+		ci.setRestarted()
+	}
 }
@@ -80,6 +80,23 @@ type InstanceConfig struct {
 	PositionsConfig positions.Config      `yaml:"positions,omitempty"`
 	ScrapeConfig    []scrapeconfig.Config `yaml:"scrape_configs,omitempty"`
 	TargetConfig    file.Config           `yaml:"target_config,omitempty"`
+
+	// PositionsStore optionally stores positions outside of the local
+	// positions file.
+	PositionsStore *PositionsStoreConfig `yaml:"positions_store,omitempty"`
+
+	// Limits are rate limits applied to all entries of the instance.
+	Limits []LimitConfig `yaml:"limits,omitempty"`
+
+	// OTLP optionally receives logs over OTLP/gRPC and OTLP/HTTP.
+	OTLP *OTLPConfig `yaml:"otlp,omitempty"`
+
+	// Dedup optionally drops entries which are forwarded by another agent.
+	Dedup *DedupConfig `yaml:"dedup,omitempty"`
+
+	// TraceCorrelation optionally adds the IDs of traces seen by the traces
+	// subsystem to entries.
+	TraceCorrelation *TraceCorrelationConfig `yaml:"trace_correlation,omitempty"`
 }
 
 // UnmarshalYAML implements yaml.Unmarshaler.
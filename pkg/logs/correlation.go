@@ -0,0 +1,381 @@
+package logs
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/loki/clients/pkg/promtail/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+// DefaultTraceCorrelationConfig holds default settings for a
+// TraceCorrelationConfig.
+var DefaultTraceCorrelationConfig = TraceCorrelationConfig{
+	Field:   "traceID",
+	MaxAge:  5 * time.Minute,
+	MaxKeys: 100000,
+}
+
+// TraceCorrelationConfig configures adding the IDs of traces seen by the
+// traces subsystem to log entries. Traces configs send their spans to a logs
+// instance through the logs_correlation option.
+type TraceCorrelationConfig struct {
+	// Rules match keys extracted from entries against span attributes. The
+	// first rule which finds a trace is used.
+	Rules []TraceCorrelationRule `yaml:"rules,omitempty"`
+
+	// Field is the name of the structured field holding the trace ID which is
+	// added to entries.
+	Field string `yaml:"field,omitempty"`
+
+	// MaxAge is how long span attributes are remembered.
+	MaxAge time.Duration `yaml:"max_age,omitempty"`
+
+	// MaxKeys is the maximum number of distinct span attribute values
+	// remembered. The least recently observed values are forgotten first.
+	MaxKeys int `yaml:"max_keys,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *TraceCorrelationConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultTraceCorrelationConfig
+
+	type plain TraceCorrelationConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate ensures that the TraceCorrelationConfig is valid.
+func (c *TraceCorrelationConfig) Validate() error {
+	if len(c.Rules) == 0 {
+		return fmt.Errorf("trace_correlation must have at least one rule")
+	}
+	for i, r := range c.Rules {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("trace correlation rule %d: %w", i, err)
+		}
+	}
+	if c.Field == "" {
+		return fmt.Errorf("field must not be empty")
+	}
+	if c.MaxAge <= 0 {
+		return fmt.Errorf("max_age must be greater than 0")
+	}
+	if c.MaxKeys <= 0 {
+		return fmt.Errorf("max_keys must be greater than 0")
+	}
+	return nil
+}
+
+// TraceCorrelationRule extracts a key from entries and looks up the trace of
+// the most recent span whose SpanAttribute had the same value.
+type TraceCorrelationRule struct {
+	// SpanAttribute is the span or resource attribute to match against.
+	SpanAttribute string `yaml:"span_attribute,omitempty"`
+
+	// Label extracts the key from the value of a label.
+	Label string `yaml:"label,omitempty"`
+
+	// Expression extracts the key from the line using the first capture group
+	// of a regular expression.
+	Expression string `yaml:"expression,omitempty"`
+}
+
+func (r *TraceCorrelationRule) validate() error {
+	if r.SpanAttribute == "" {
+		return fmt.Errorf("span_attribute must be set")
+	}
+	if (r.Label == "") == (r.Expression == "") {
+		return fmt.Errorf("exactly one of label or expression must be set")
+	}
+	if r.Expression != "" {
+		re, err := regexp.Compile(r.Expression)
+		if err != nil {
+			return fmt.Errorf("invalid expression: %w", err)
+		}
+		if re.NumSubexp() == 0 {
+			return fmt.Errorf("expression must have a capture group")
+		}
+	}
+	return nil
+}
+
+// correlator is an api.EntryHandler which adds trace IDs to entries before
+// forwarding them to the next handler.
+type correlator struct {
+	log   log.Logger
+	cfg   TraceCorrelationConfig
+	next  api.EntryHandler
+	rules []correlationRule
+	index *traceIndex
+
+	in       chan api.Entry
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	stopOnce sync.Once
+
+	entries   *prometheus.CounterVec
+	indexKeys prometheus.Gauge
+}
+
+type correlationRule struct {
+	attr  string
+	label model.LabelName
+	re    *regexp.Regexp
+}
+
+// newCorrelator creates a new correlator which forwards entries to next.
+func newCorrelator(l log.Logger, reg prometheus.Registerer, cfg TraceCorrelationConfig, next api.EntryHandler) (*correlator, error) {
+	rules := make([]correlationRule, 0, len(cfg.Rules))
+	for _, r := range cfg.Rules {
+		rule := correlationRule{attr: r.SpanAttribute, label: model.LabelName(r.Label)}
+		if r.Expression != "" {
+			re, err := regexp.Compile(r.Expression)
+			if err != nil {
+				return nil, err
+			}
+			rule.re = re
+		}
+		rules = append(rules, rule)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &correlator{
+		log:   l,
+		cfg:   cfg,
+		next:  next,
+		rules: rules,
+		index: newTraceIndex(cfg.MaxAge, cfg.MaxKeys),
+
+		in:     make(chan api.Entry),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+
+		entries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_logs_trace_correlation_entries_total",
+			Help: "Total number of log entries with a correlation key, by whether a trace was found.",
+		}, []string{"result"}),
+		indexKeys: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "agent_logs_trace_correlation_index_keys",
+			Help: "Current number of span attribute values remembered for trace correlation.",
+		}),
+	}
+
+	for _, coll := range []prometheus.Collector{c.entries, c.indexKeys} {
+		if err := reg.Register(coll); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	go c.run()
+	return c, nil
+}
+
+func (c *correlator) run() {
+	defer close(c.done)
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case e := <-c.in:
+			c.correlate(&e)
+			select {
+			case c.next.Chan() <- e:
+			case <-c.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// correlate adds the trace ID field to e if one of the rules finds a trace.
+func (c *correlator) correlate(e *api.Entry) {
+	if hasField(e.Line, c.cfg.Field) {
+		return
+	}
+
+	var extracted bool
+	for _, r := range c.rules {
+		key, ok := r.extract(e)
+		if !ok {
+			continue
+		}
+		extracted = true
+
+		if traceID, ok := c.index.Lookup(r.attr, key); ok {
+			e.Line = addField(e.Line, c.cfg.Field, traceID)
+			c.entries.WithLabelValues("matched").Inc()
+			return
+		}
+	}
+	if extracted {
+		c.entries.WithLabelValues("unmatched").Inc()
+	}
+}
+
+func (r *correlationRule) extract(e *api.Entry) (string, bool) {
+	if r.re != nil {
+		m := r.re.FindStringSubmatch(e.Line)
+		if len(m) < 2 || m[1] == "" {
+			return "", false
+		}
+		return m[1], true
+	}
+
+	v, ok := e.Labels[r.label]
+	return string(v), ok && v != ""
+}
+
+// ObserveSpan remembers the attributes of a span which are used by rules.
+// Attributes are looked up in order of the provided maps, so span attributes
+// should be passed before resource attributes.
+func (c *correlator) ObserveSpan(traceID string, attrs ...pdata.AttributeMap) {
+	for _, r := range c.rules {
+		for _, m := range attrs {
+			v, ok := m.Get(r.attr)
+			if !ok {
+				continue
+			}
+			if s := v.AsString(); s != "" {
+				c.index.Observe(r.attr, s, traceID)
+			}
+			break
+		}
+	}
+	c.indexKeys.Set(float64(c.index.Len()))
+}
+
+// Chan implements api.EntryHandler.
+func (c *correlator) Chan() chan<- api.Entry { return c.in }
+
+// Stop implements api.EntryHandler. It does not stop the next handler.
+func (c *correlator) Stop() {
+	c.stopOnce.Do(func() {
+		c.cancel()
+		<-c.done
+	})
+}
+
+// hasField returns true if line appears to already have a logfmt or JSON
+// field called name.
+func hasField(line, name string) bool {
+	return strings.HasPrefix(line, name+"=") ||
+		strings.Contains(line, " "+name+"=") ||
+		strings.Contains(line, `"`+name+`":`)
+}
+
+// addField adds a field to a line. Lines holding a JSON object get a new key,
+// and all other lines get a logfmt pair appended.
+func addField(line, name, value string) string {
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "{") && strings.HasSuffix(trimmed, "}") && json.Valid([]byte(trimmed)) {
+		k, _ := json.Marshal(name)
+		v, _ := json.Marshal(value)
+		pair := string(k) + ":" + string(v)
+
+		idx := strings.Index(line, "{")
+		if strings.TrimSpace(trimmed[1:len(trimmed)-1]) == "" {
+			return line[:idx+1] + pair + line[idx+1:]
+		}
+		return line[:idx+1] + pair + "," + line[idx+1:]
+	}
+	return line + " " + name + "=" + value
+}
+
+// traceIndex remembers the most recent trace ID seen for span attribute
+// values. Values are forgotten after maxAge or once more than maxKeys
+// distinct values are remembered, least recently observed first.
+type traceIndex struct {
+	maxAge  time.Duration
+	maxKeys int
+
+	mut     sync.Mutex
+	entries map[string]*list.Element // Elements of order, by key
+	order   *list.List               // *indexEntry, least recently observed first
+}
+
+type indexEntry struct {
+	key     string
+	traceID string
+	at      time.Time
+}
+
+func newTraceIndex(maxAge time.Duration, maxKeys int) *traceIndex {
+	return &traceIndex{
+		maxAge:  maxAge,
+		maxKeys: maxKeys,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func indexKey(attr, value string) string { return attr + "\x00" + value }
+
+// Observe records that value was seen for attr in the trace traceID.
+func (t *traceIndex) Observe(attr, value, traceID string) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	now := time.Now()
+	key := indexKey(attr, value)
+	if el, ok := t.entries[key]; ok {
+		e := el.Value.(*indexEntry)
+		e.traceID, e.at = traceID, now
+		t.order.MoveToBack(el)
+	} else {
+		t.entries[key] = t.order.PushBack(&indexEntry{key: key, traceID: traceID, at: now})
+	}
+	t.expire(now)
+}
+
+// Lookup returns the most recent trace ID seen for a value of attr.
+func (t *traceIndex) Lookup(attr, value string) (string, bool) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	el, ok := t.entries[indexKey(attr, value)]
+	if !ok {
+		return "", false
+	}
+	e := el.Value.(*indexEntry)
+	if time.Since(e.at) > t.maxAge {
+		return "", false
+	}
+	return e.traceID, true
+}
+
+// Len returns the number of remembered values.
+func (t *traceIndex) Len() int {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return len(t.entries)
+}
+
+// expire forgets values observed longer than maxAge ago and the least
+// recently observed values above maxKeys. t.mut must be held when calling
+// expire.
+func (t *traceIndex) expire(now time.Time) {
+	for el := t.order.Front(); el != nil; el = t.order.Front() {
+		e := el.Value.(*indexEntry)
+		if len(t.entries) <= t.maxKeys && now.Sub(e.at) <= t.maxAge {
+			break
+		}
+		delete(t.entries, e.key)
+		t.order.Remove(el)
+	}
+}
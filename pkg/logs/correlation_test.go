@@ -0,0 +1,173 @@
+package logs
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/grafana/agent/pkg/util"
+	"github.com/grafana/loki/clients/pkg/promtail/api"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/model/pdata"
+	"gopkg.in/yaml.v2"
+)
+
+func TestTraceCorrelationConfig_Unmarshal(t *testing.T) {
+	tt := []struct {
+		name   string
+		cfg    string
+		expect string
+	}{
+		{
+			name: "defaults",
+			cfg: `
+rules:
+- span_attribute: http.request_id
+  expression: 'request_id=(\S+)'`,
+		},
+		{
+			name:   "no rules",
+			cfg:    `field: tid`,
+			expect: "trace_correlation must have at least one rule",
+		},
+		{
+			name: "label and expression",
+			cfg: `
+rules:
+- span_attribute: enduser.id
+  label: user
+  expression: 'user=(\S+)'`,
+			expect: "trace correlation rule 0: exactly one of label or expression must be set",
+		},
+		{
+			name: "no capture group",
+			cfg: `
+rules:
+- span_attribute: enduser.id
+  expression: 'user=\S+'`,
+			expect: "trace correlation rule 0: expression must have a capture group",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var c TraceCorrelationConfig
+			err := yaml.UnmarshalStrict([]byte(tc.cfg), &c)
+			if tc.expect == "" {
+				require.NoError(t, err)
+				require.Equal(t, DefaultTraceCorrelationConfig.Field, c.Field)
+				return
+			}
+			require.EqualError(t, err, tc.expect)
+		})
+	}
+}
+
+func TestCorrelator(t *testing.T) {
+	out := make(chan api.Entry, 10)
+
+	cfg := DefaultTraceCorrelationConfig
+	cfg.Rules = []TraceCorrelationRule{
+		{SpanAttribute: "http.request_id", Expression: `request_id"?\s*[=:]\s*"?([\w-]+)`},
+		{SpanAttribute: "enduser.id", Label: "user"},
+	}
+
+	c, err := newCorrelator(util.TestLogger(t), prometheus.NewRegistry(), cfg, api.NewEntryHandler(out, func() {}))
+	require.NoError(t, err)
+	defer c.Stop()
+
+	spanAttrs := pdata.NewAttributeMap()
+	spanAttrs.InsertString("http.request_id", "req-1")
+	resAttrs := pdata.NewAttributeMap()
+	resAttrs.InsertString("enduser.id", "alice")
+	c.ObserveSpan("0102030405060708090a0b0c0d0e0f10", spanAttrs, resAttrs)
+
+	tt := []struct {
+		line   string
+		labels model.LabelSet
+		expect string
+	}{
+		{
+			line:   "msg=done request_id=req-1",
+			expect: "msg=done request_id=req-1 traceID=0102030405060708090a0b0c0d0e0f10",
+		},
+		{
+			line:   `{"msg": "done", "request_id": "req-1"}`,
+			expect: `{"traceID":"0102030405060708090a0b0c0d0e0f10","msg": "done", "request_id": "req-1"}`,
+		},
+		{
+			line:   "msg=login",
+			labels: model.LabelSet{"user": "alice"},
+			expect: "msg=login traceID=0102030405060708090a0b0c0d0e0f10",
+		},
+		{
+			line:   "msg=done request_id=req-2",
+			expect: "msg=done request_id=req-2",
+		},
+		{
+			line:   "msg=done request_id=req-1 traceID=abc",
+			expect: "msg=done request_id=req-1 traceID=abc",
+		},
+	}
+
+	for _, tc := range tt {
+		c.Chan() <- api.Entry{
+			Labels: tc.labels,
+			Entry:  logproto.Entry{Timestamp: time.Now(), Line: tc.line},
+		}
+		select {
+		case e := <-out:
+			require.Equal(t, tc.expect, e.Line)
+		case <-time.After(time.Second):
+			require.FailNow(t, "timed out waiting for entry")
+		}
+	}
+
+	require.Equal(t, 3.0, testutil.ToFloat64(c.entries.WithLabelValues("matched")))
+	require.Equal(t, 1.0, testutil.ToFloat64(c.entries.WithLabelValues("unmatched")))
+}
+
+func TestTraceIndex(t *testing.T) {
+	idx := newTraceIndex(time.Minute, 2)
+
+	idx.Observe("attr", "a", "trace-a")
+	idx.Observe("attr", "b", "trace-b")
+	idx.Observe("attr", "a", "trace-a2")
+
+	// The first observation of a was replaced, so b and a are kept.
+	id, ok := idx.Lookup("attr", "a")
+	require.True(t, ok)
+	require.Equal(t, "trace-a2", id)
+	_, ok = idx.Lookup("attr", "b")
+	require.True(t, ok)
+
+	// c evicts b, the oldest value.
+	idx.Observe("attr", "c", "trace-c")
+	_, ok = idx.Lookup("attr", "b")
+	require.False(t, ok)
+	require.Equal(t, 2, idx.Len())
+}
+
+func TestTraceIndex_DistinctKeys(t *testing.T) {
+	idx := newTraceIndex(time.Minute, 2)
+
+	// Observing a value again doesn't count towards max_keys.
+	idx.Observe("attr", "a", "trace-a")
+	idx.Observe("attr", "b", "trace-b")
+	for i := 0; i < 10; i++ {
+		idx.Observe("attr", "b", fmt.Sprintf("trace-b%d", i))
+	}
+
+	id, ok := idx.Lookup("attr", "a")
+	require.True(t, ok)
+	require.Equal(t, "trace-a", id)
+	id, ok = idx.Lookup("attr", "b")
+	require.True(t, ok)
+	require.Equal(t, "trace-b9", id)
+	require.Equal(t, 2, idx.Len())
+	require.Equal(t, 2, idx.order.Len())
+}
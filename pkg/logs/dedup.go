@@ -0,0 +1,548 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/loki/clients/pkg/promtail/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// Modes which can be used for deduplicating entries between agents.
+const (
+	DedupModeRing = "ring"
+	DedupModeHash = "hash"
+)
+
+// dedupFlushInterval is how often hashes of forwarded entries are sent to
+// peers in hash mode.
+const dedupFlushInterval = 250 * time.Millisecond
+
+// dedupPeerResolveInterval is the minimum time between resolving the
+// addresses of peers in hash mode.
+const dedupPeerResolveInterval = 10 * time.Second
+
+// errNotDedupPeer is returned when hashes are received from an address which
+// isn't one of the configured peers.
+var errNotDedupPeer = errors.New("address is not a dedup peer")
+
+// DefaultDedupConfig holds default settings for a DedupConfig.
+var DefaultDedupConfig = DedupConfig{
+	Window:      time.Minute,
+	MaxHashes:   100000,
+	PeerTimeout: 5 * time.Second,
+}
+
+// Ring determines which agent in a cluster owns a key. It is implemented by
+// the metrics subsystem when the scraping service is enabled.
+type Ring interface {
+	Owns(key string) (bool, error)
+}
+
+// DedupConfig configures deduplication of entries between agents which
+// collect the same logs, such as a pair of agents tailing a shared volume.
+type DedupConfig struct {
+	// Mode is either ring or hash.
+	//
+	// In ring mode, each stream is forwarded only by the agent which owns it
+	// in the scraping service ring.
+	//
+	// In hash mode, agents tell their peers about the entries they forwarded,
+	// and entries already forwarded by a peer are dropped.
+	Mode string `yaml:"mode,omitempty"`
+
+	// By is the list of label names which identify a stream in ring mode.
+	// When empty, all labels are used.
+	By []string `yaml:"by,omitempty"`
+
+	// Peers is the list of base URLs of the other agents in hash mode. Only
+	// hashes sent from the addresses of peers are accepted.
+	Peers []string `yaml:"peers,omitempty"`
+
+	// Wait is how long entries are held before being forwarded in hash mode,
+	// giving peers a chance to report them first. It should be zero on the
+	// primary agent and greater than zero on standbys.
+	Wait time.Duration `yaml:"wait,omitempty"`
+
+	// Window is how long entries reported by peers are remembered in hash
+	// mode.
+	Window time.Duration `yaml:"window,omitempty"`
+
+	// MaxHashes is the maximum number of hashes reported by peers which are
+	// remembered in hash mode. The oldest hashes are forgotten first.
+	MaxHashes int `yaml:"max_hashes,omitempty"`
+
+	// PeerTimeout is the timeout for sending hashes to a peer.
+	PeerTimeout time.Duration `yaml:"peer_timeout,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *DedupConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultDedupConfig
+
+	type plain DedupConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate ensures that the DedupConfig is valid.
+func (c *DedupConfig) Validate() error {
+	switch c.Mode {
+	case DedupModeRing:
+		if len(c.Peers) > 0 || c.Wait > 0 {
+			return fmt.Errorf("peers and wait may only be set in %q dedup mode", DedupModeHash)
+		}
+	case DedupModeHash:
+		if len(c.Peers) == 0 {
+			return fmt.Errorf("%q dedup mode requires at least one peer", DedupModeHash)
+		}
+		if c.Window <= 0 {
+			return fmt.Errorf("window must be greater than 0")
+		}
+		if c.Wait < 0 || c.Wait >= c.Window {
+			return fmt.Errorf("wait must be between 0 and window")
+		}
+		if c.MaxHashes <= 0 {
+			return fmt.Errorf("max_hashes must be greater than 0")
+		}
+		for _, p := range c.Peers {
+			if u, err := url.Parse(p); err != nil || u.Hostname() == "" {
+				return fmt.Errorf("invalid peer URL %q", p)
+			}
+		}
+		if c.PeerTimeout <= 0 {
+			return fmt.Errorf("peer_timeout must be greater than 0")
+		}
+	default:
+		return fmt.Errorf("unsupported dedup mode %q, expected %q or %q", c.Mode, DedupModeRing, DedupModeHash)
+	}
+	return nil
+}
+
+// deduper is an api.EntryHandler which drops entries forwarded by other
+// agents before passing entries to the next handler.
+type deduper struct {
+	log      log.Logger
+	cfg      DedupConfig
+	instance string
+	ring     Ring
+	next     api.EntryHandler
+	by       []model.LabelName
+	client   *http.Client
+
+	in       chan api.Entry
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+
+	mut      sync.Mutex
+	seen     map[uint64]int // Hashes reported by peers, with multiplicity
+	expiry   []seenHash     // Hashes reported by peers, oldest first
+	outgoing []uint64       // Hashes to report to peers
+
+	// lookupHost resolves the addresses of peers.
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+
+	peersMut      sync.Mutex
+	peerAddrs     []net.IP  // Resolved addresses of peers
+	peersResolved time.Time // When peerAddrs were last resolved
+
+	droppedEntries *prometheus.CounterVec
+	ringErrors     prometheus.Counter
+	peerErrors     *prometheus.CounterVec
+	evictedHashes  prometheus.Counter
+}
+
+type seenHash struct {
+	hash uint64
+	at   time.Time
+}
+
+type pendingEntry struct {
+	entry   api.Entry
+	hash    uint64
+	release time.Time
+}
+
+// newDeduper creates a new deduper for the instance with the given name
+// which forwards entries to next. ring must be non-nil in ring mode.
+func newDeduper(l log.Logger, reg prometheus.Registerer, instance string, cfg DedupConfig, ring Ring, next api.EntryHandler) (*deduper, error) {
+	if cfg.Mode == DedupModeRing && ring == nil {
+		return nil, fmt.Errorf("%q dedup mode requires the metrics scraping service to be enabled", DedupModeRing)
+	}
+
+	by := make([]model.LabelName, 0, len(cfg.By))
+	for _, n := range cfg.By {
+		by = append(by, model.LabelName(n))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &deduper{
+		log:      l,
+		cfg:      cfg,
+		instance: instance,
+		ring:     ring,
+		next:     next,
+		by:       by,
+		client:   &http.Client{Timeout: cfg.PeerTimeout},
+
+		in:     make(chan api.Entry),
+		ctx:    ctx,
+		cancel: cancel,
+
+		seen: make(map[uint64]int),
+
+		lookupHost: net.DefaultResolver.LookupHost,
+
+		droppedEntries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_logs_dedup_dropped_entries_total",
+			Help: "Total number of log entries dropped because another agent forwards them.",
+		}, []string{"reason"}),
+		ringErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "agent_logs_dedup_ring_errors_total",
+			Help: "Total number of failed ring lookups. Entries are forwarded when the lookup fails.",
+		}),
+		peerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_logs_dedup_peer_errors_total",
+			Help: "Total number of failures sending hashes of forwarded entries to a peer.",
+		}, []string{"peer"}),
+		evictedHashes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "agent_logs_dedup_evicted_hashes_total",
+			Help: "Total number of hashes reported by peers which were forgotten before the end of the window because max_hashes was reached.",
+		}),
+	}
+
+	for _, c := range []prometheus.Collector{d.droppedEntries, d.ringErrors, d.peerErrors, d.evictedHashes} {
+		if err := reg.Register(c); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	d.wg.Add(1)
+	go d.run()
+	if cfg.Mode == DedupModeHash {
+		d.wg.Add(1)
+		go d.runNotify()
+	}
+	return d, nil
+}
+
+func (d *deduper) run() {
+	defer d.wg.Done()
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	var queue []pendingEntry
+
+	for {
+		var release <-chan time.Time
+		if len(queue) > 0 {
+			timer.Reset(time.Until(queue[0].release))
+			release = timer.C
+		}
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case e := <-d.in:
+			if release != nil && !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+
+			switch {
+			case d.cfg.Mode == DedupModeRing:
+				if d.owns(e) && !d.forward(e) {
+					return
+				}
+			case d.cfg.Wait == 0:
+				if d.claim(entryHash(e)) && !d.forward(e) {
+					return
+				}
+			default:
+				queue = append(queue, pendingEntry{
+					entry:   e,
+					hash:    entryHash(e),
+					release: time.Now().Add(d.cfg.Wait),
+				})
+			}
+		case now := <-release:
+			for len(queue) > 0 && !queue[0].release.After(now) {
+				p := queue[0]
+				queue[0] = pendingEntry{}
+				queue = queue[1:]
+
+				if d.claim(p.hash) && !d.forward(p.entry) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// forward sends e to the next handler. Returns false if the deduper stopped
+// before e could be sent.
+func (d *deduper) forward(e api.Entry) bool {
+	select {
+	case d.next.Chan() <- e:
+		return true
+	case <-d.ctx.Done():
+		return false
+	}
+}
+
+// owns returns true if this agent owns the stream of e in the ring. Entries
+// are forwarded if the ring can't be checked, preferring duplicates over
+// losing entries.
+func (d *deduper) owns(e api.Entry) bool {
+	owned, err := d.ring.Owns(d.streamKey(e.Labels))
+	if err != nil {
+		level.Debug(d.log).Log("msg", "failed to check stream ownership, forwarding entry", "err", err)
+		d.ringErrors.Inc()
+		return true
+	}
+	if !owned {
+		d.droppedEntries.WithLabelValues("not_owner").Inc()
+	}
+	return owned
+}
+
+// streamKey returns the ring key for a stream.
+func (d *deduper) streamKey(ls model.LabelSet) string {
+	if len(d.by) > 0 {
+		subset := make(model.LabelSet, len(d.by))
+		for _, n := range d.by {
+			if v, ok := ls[n]; ok {
+				subset[n] = v
+			}
+		}
+		ls = subset
+	}
+	return "logs/" + d.instance + "/" + ls.String()
+}
+
+// claim returns true if an entry with hash h should be forwarded by this
+// agent. Claimed hashes are reported to peers.
+func (d *deduper) claim(h uint64) bool {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	d.expire(time.Now())
+	if d.seen[h] > 0 {
+		d.seen[h]--
+		d.droppedEntries.WithLabelValues("duplicate").Inc()
+		return false
+	}
+	d.outgoing = append(d.outgoing, h)
+	return true
+}
+
+// Observe records hashes of entries which were forwarded by the peer at
+// addr, the remote address of its request. errNotDedupPeer is returned if
+// addr isn't the address of one of the configured peers.
+func (d *deduper) Observe(ctx context.Context, addr string, hashes []uint64) error {
+	if !d.isPeer(ctx, addr) {
+		return errNotDedupPeer
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	now := time.Now()
+	d.expire(now)
+	for _, h := range hashes {
+		d.seen[h]++
+		d.expiry = append(d.expiry, seenHash{hash: h, at: now})
+	}
+	if n := len(d.expiry) - d.cfg.MaxHashes; n > 0 {
+		d.forget(n)
+		d.evictedHashes.Add(float64(n))
+	}
+	return nil
+}
+
+// isPeer returns true if addr, a host:port pair, is the address of one of
+// the configured peers. Peers are resolved again when addr isn't found, at
+// most once every dedupPeerResolveInterval.
+func (d *deduper) isPeer(ctx context.Context, addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	d.peersMut.Lock()
+	defer d.peersMut.Unlock()
+
+	if containsIP(d.peerAddrs, ip) {
+		return true
+	}
+	if time.Since(d.peersResolved) < dedupPeerResolveInterval {
+		return false
+	}
+	d.peerAddrs = d.resolvePeers(ctx)
+	d.peersResolved = time.Now()
+	return containsIP(d.peerAddrs, ip)
+}
+
+// resolvePeers returns the addresses of the configured peers. Peers which
+// can't be resolved are skipped.
+func (d *deduper) resolvePeers(ctx context.Context) []net.IP {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.PeerTimeout)
+	defer cancel()
+
+	var ips []net.IP
+	for _, p := range d.cfg.Peers {
+		u, err := url.Parse(p)
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(u.Hostname()); ip != nil {
+			ips = append(ips, ip)
+			continue
+		}
+		addrs, err := d.lookupHost(ctx, u.Hostname())
+		if err != nil {
+			level.Warn(d.log).Log("msg", "failed to resolve dedup peer", "peer", p, "err", err)
+			continue
+		}
+		for _, a := range addrs {
+			if ip := net.ParseIP(a); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// expire forgets hashes reported by peers longer than the window ago. d.mut
+// must be held when calling expire.
+func (d *deduper) expire(now time.Time) {
+	var n int
+	for n < len(d.expiry) && now.Sub(d.expiry[n].at) > d.cfg.Window {
+		n++
+	}
+	d.forget(n)
+}
+
+// forget forgets the n oldest hashes reported by peers. d.mut must be held
+// when calling forget.
+func (d *deduper) forget(n int) {
+	for _, s := range d.expiry[:n] {
+		if d.seen[s.hash] <= 1 {
+			delete(d.seen, s.hash)
+		} else {
+			d.seen[s.hash]--
+		}
+	}
+	d.expiry = d.expiry[n:]
+}
+
+// runNotify periodically sends the hashes of claimed entries to peers.
+func (d *deduper) runNotify() {
+	defer d.wg.Done()
+
+	t := time.NewTicker(dedupFlushInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-t.C:
+			d.mut.Lock()
+			hashes := d.outgoing
+			d.outgoing = nil
+			d.mut.Unlock()
+
+			if len(hashes) == 0 {
+				continue
+			}
+			for _, peer := range d.cfg.Peers {
+				if err := d.notify(peer, hashes); err != nil {
+					level.Warn(d.log).Log("msg", "failed to send dedup hashes to peer", "peer", peer, "err", err)
+					d.peerErrors.WithLabelValues(peer).Inc()
+				}
+			}
+		}
+	}
+}
+
+func (d *deduper) notify(peer string, hashes []uint64) error {
+	bb, err := json.Marshal(DedupRequest{Hashes: hashes})
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(peer, "/") + "/agent/api/v1/logs/dedup/" + d.instance
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, url, bytes.NewReader(bb))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// entryHash returns the hash identifying an entry between agents. Timestamps
+// aren't included since each agent assigns its own when tailing files.
+func entryHash(e api.Entry) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(e.Labels.String()))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(e.Line))
+	return h.Sum64()
+}
+
+// Chan implements api.EntryHandler.
+func (d *deduper) Chan() chan<- api.Entry { return d.in }
+
+// Stop implements api.EntryHandler. It does not stop the next handler.
+// Entries which are still being held are dropped.
+func (d *deduper) Stop() {
+	d.stopOnce.Do(func() {
+		d.cancel()
+		d.wg.Wait()
+	})
+}
@@ -0,0 +1,244 @@
+package logs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/agent/pkg/util"
+	"github.com/grafana/loki/clients/pkg/promtail/api"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestDedupConfig_Unmarshal(t *testing.T) {
+	tt := []struct {
+		name   string
+		cfg    string
+		expect string
+	}{
+		{
+			name: "ring",
+			cfg:  `mode: ring`,
+		},
+		{
+			name: "hash",
+			cfg: `
+mode: hash
+peers: [http://agent-b:12345]
+wait: 5s`,
+		},
+		{
+			name:   "no mode",
+			cfg:    `peers: [http://agent-b:12345]`,
+			expect: `unsupported dedup mode "", expected "ring" or "hash"`,
+		},
+		{
+			name:   "hash without peers",
+			cfg:    `mode: hash`,
+			expect: `"hash" dedup mode requires at least one peer`,
+		},
+		{
+			name: "wait longer than window",
+			cfg: `
+mode: hash
+peers: [http://agent-b:12345]
+wait: 2m`,
+			expect: "wait must be between 0 and window",
+		},
+		{
+			name: "ring with peers",
+			cfg: `
+mode: ring
+peers: [http://agent-b:12345]`,
+			expect: `peers and wait may only be set in "hash" dedup mode`,
+		},
+		{
+			name: "invalid max_hashes",
+			cfg: `
+mode: hash
+peers: [http://agent-b:12345]
+max_hashes: 0`,
+			expect: "max_hashes must be greater than 0",
+		},
+		{
+			name: "invalid peer",
+			cfg: `
+mode: hash
+peers: [agent-b]`,
+			expect: `invalid peer URL "agent-b"`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var c DedupConfig
+			err := yaml.UnmarshalStrict([]byte(tc.cfg), &c)
+			if tc.expect == "" {
+				require.NoError(t, err)
+				require.Equal(t, DefaultDedupConfig.Window, c.Window)
+				return
+			}
+			require.EqualError(t, err, tc.expect)
+		})
+	}
+}
+
+func TestDeduper_Ring(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	out := make(chan api.Entry, 100)
+
+	ring := ringFunc(func(key string) (bool, error) {
+		switch {
+		case strings.Contains(key, "broken"):
+			return false, fmt.Errorf("empty ring")
+		case strings.Contains(key, "mine"):
+			return true, nil
+		default:
+			return false, nil
+		}
+	})
+
+	d, err := newDeduper(util.TestLogger(t), reg, "default", DedupConfig{
+		Mode: DedupModeRing,
+		By:   []string{"filename"},
+	}, ring, api.NewEntryHandler(out, func() {}))
+	require.NoError(t, err)
+	defer d.Stop()
+
+	d.Chan() <- testDedupEntry("/var/log/mine.log", "hello")
+	d.Chan() <- testDedupEntry("/var/log/theirs.log", "hello")
+	d.Chan() <- testDedupEntry("/var/log/broken.log", "hello")
+
+	// Entries are forwarded when the ring can't be checked.
+	require.Eventually(t, func() bool { return len(out) == 2 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, "/var/log/mine.log", string((<-out).Labels["filename"]))
+	require.Equal(t, "/var/log/broken.log", string((<-out).Labels["filename"]))
+
+	require.Equal(t, 1.0, testutil.ToFloat64(d.droppedEntries.WithLabelValues("not_owner")))
+	require.Equal(t, 1.0, testutil.ToFloat64(d.ringErrors))
+}
+
+func TestDeduper_RingRequired(t *testing.T) {
+	_, err := newDeduper(util.TestLogger(t), prometheus.NewRegistry(), "default", DedupConfig{
+		Mode: DedupModeRing,
+	}, nil, api.NewEntryHandler(make(chan api.Entry), func() {}))
+	require.EqualError(t, err, `"ring" dedup mode requires the metrics scraping service to be enabled`)
+}
+
+func TestDeduper_Hash(t *testing.T) {
+	received := make(chan DedupRequest, 10)
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/agent/api/v1/logs/dedup/default", r.URL.Path)
+
+		var req DedupRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		received <- req
+	}))
+	defer peer.Close()
+
+	out := make(chan api.Entry, 100)
+	d, err := newDeduper(util.TestLogger(t), prometheus.NewRegistry(), "default", DedupConfig{
+		Mode:        DedupModeHash,
+		Peers:       []string{peer.URL},
+		Wait:        200 * time.Millisecond,
+		Window:      time.Minute,
+		MaxHashes:   100,
+		PeerTimeout: time.Second,
+	}, nil, api.NewEntryHandler(out, func() {}))
+	require.NoError(t, err)
+	defer d.Stop()
+
+	dup := testDedupEntry("/var/log/app.log", "sent by peer")
+	uniq := testDedupEntry("/var/log/app.log", "only here")
+
+	// The peer reports having sent dup while it's being held.
+	d.Chan() <- dup
+	d.Chan() <- uniq
+	require.NoError(t, d.Observe(context.Background(), "127.0.0.1:50000", []uint64{entryHash(dup)}))
+
+	require.Eventually(t, func() bool { return len(out) == 1 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, "only here", (<-out).Line)
+	require.Equal(t, 1.0, testutil.ToFloat64(d.droppedEntries.WithLabelValues("duplicate")))
+
+	// The forwarded entry is reported to the peer.
+	select {
+	case req := <-received:
+		require.Equal(t, []uint64{entryHash(uniq)}, req.Hashes)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for peer notification")
+	}
+
+	// The peer's report only covers one copy of the entry.
+	d.Chan() <- dup
+	require.Eventually(t, func() bool { return len(out) == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestDeduper_HashPeers(t *testing.T) {
+	d, err := newDeduper(util.TestLogger(t), prometheus.NewRegistry(), "default", DedupConfig{
+		Mode:        DedupModeHash,
+		Peers:       []string{"http://agent-b:12345", "http://10.0.0.3:12345"},
+		Window:      time.Minute,
+		MaxHashes:   100,
+		PeerTimeout: time.Second,
+	}, nil, api.NewEntryHandler(make(chan api.Entry), func() {}))
+	require.NoError(t, err)
+	defer d.Stop()
+
+	var lookups int
+	d.lookupHost = func(_ context.Context, host string) ([]string, error) {
+		lookups++
+		require.Equal(t, "agent-b", host)
+		return []string{"10.0.0.2"}, nil
+	}
+
+	ctx := context.Background()
+	require.NoError(t, d.Observe(ctx, "10.0.0.2:40000", []uint64{1}))
+	require.NoError(t, d.Observe(ctx, "10.0.0.3:40000", []uint64{2}))
+	require.Equal(t, errNotDedupPeer, d.Observe(ctx, "10.0.0.4:40000", []uint64{3}))
+	require.Equal(t, map[uint64]int{1: 1, 2: 1}, d.seen)
+
+	// Peers aren't resolved again for every rejected request.
+	require.Equal(t, 1, lookups)
+}
+
+func TestDeduper_HashMaxHashes(t *testing.T) {
+	d, err := newDeduper(util.TestLogger(t), prometheus.NewRegistry(), "default", DedupConfig{
+		Mode:        DedupModeHash,
+		Peers:       []string{"http://127.0.0.1:12345"},
+		Window:      time.Minute,
+		MaxHashes:   3,
+		PeerTimeout: time.Second,
+	}, nil, api.NewEntryHandler(make(chan api.Entry), func() {}))
+	require.NoError(t, err)
+	defer d.Stop()
+
+	ctx := context.Background()
+	require.NoError(t, d.Observe(ctx, "127.0.0.1:40000", []uint64{1, 2, 2}))
+	require.NoError(t, d.Observe(ctx, "127.0.0.1:40000", []uint64{3, 4}))
+
+	// The oldest hashes are forgotten first.
+	require.Equal(t, map[uint64]int{2: 1, 3: 1, 4: 1}, d.seen)
+	require.Len(t, d.expiry, 3)
+	require.Equal(t, 2.0, testutil.ToFloat64(d.evictedHashes))
+}
+
+type ringFunc func(key string) (bool, error)
+
+func (f ringFunc) Owns(key string) (bool, error) { return f(key) }
+
+func testDedupEntry(filename, line string) api.Entry {
+	return api.Entry{
+		Labels: model.LabelSet{"filename": model.LabelValue(filename), "job": "varlogs"},
+		Entry:  logproto.Entry{Timestamp: time.Now(), Line: line},
+	}
+}
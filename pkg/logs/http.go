@@ -1,6 +1,8 @@
 package logs
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"sort"
 
@@ -15,6 +17,7 @@ import (
 func (l *Logs) WireAPI(r *mux.Router) {
 	r.HandleFunc("/agent/api/v1/logs/instances", l.ListInstancesHandler).Methods("GET")
 	r.HandleFunc("/agent/api/v1/logs/targets", l.ListTargetsHandler).Methods("GET")
+	r.HandleFunc("/agent/api/v1/logs/dedup/{instance}", l.DedupHandler).Methods("POST")
 }
 
 // ListInstancesHandler writes the set of currently running instances to the http.ResponseWriter.
@@ -38,11 +41,45 @@ func (l *Logs) ListTargetsHandler(w http.ResponseWriter, r *http.Request) {
 	instances := l.instances
 	allTagets := make(map[string]TargetSet, len(instances))
 	for instName, inst := range instances {
-		allTagets[instName] = inst.promtail.ActiveTargets()
+		allTagets[instName] = inst.ActiveTargets()
 	}
 	listTargetsHandler(allTagets).ServeHTTP(w, r)
 }
 
+// DedupHandler receives the hashes of entries which were forwarded by a peer
+// agent in hash dedup mode. Requests from addresses other than the
+// configured peers are rejected.
+func (l *Logs) DedupHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["instance"]
+
+	var req DedupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		_ = configapi.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	inst := l.Instance(name)
+	if inst == nil {
+		_ = configapi.WriteError(w, http.StatusNotFound, fmt.Errorf("instance %q does not use hash deduplication", name))
+		return
+	}
+	ok, err := inst.ObserveDedup(r.Context(), r.RemoteAddr, req.Hashes)
+	if !ok {
+		_ = configapi.WriteError(w, http.StatusNotFound, fmt.Errorf("instance %q does not use hash deduplication", name))
+		return
+	} else if err != nil {
+		level.Warn(l.l).Log("msg", "rejected dedup hashes", "instance", name, "addr", r.RemoteAddr, "err", err)
+		_ = configapi.WriteError(w, http.StatusForbidden, err)
+		return
+	}
+	_ = configapi.WriteResponse(w, http.StatusOK, nil)
+}
+
+// DedupRequest is sent to peers by instances using hash deduplication.
+type DedupRequest struct {
+	Hashes []uint64 `json:"hashes"`
+}
+
 func listTargetsHandler(targets map[string]TargetSet) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
 		resp := ListTargetsResponse{}
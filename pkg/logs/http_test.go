@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/cortexproject/cortex/pkg/util/test"
+	"github.com/gorilla/mux"
 	"github.com/grafana/agent/pkg/util"
 	"github.com/grafana/loki/clients/pkg/promtail/targets/target"
 	"github.com/prometheus/client_golang/prometheus"
@@ -29,7 +30,7 @@ configs:
 	var cfg Config
 
 	logger := util.TestLogger(t)
-	l, err := New(prometheus.NewRegistry(), &cfg, logger)
+	l, err := New(prometheus.NewRegistry(), &cfg, nil, logger)
 	require.NoError(t, err)
 	defer l.Stop()
 
@@ -73,7 +74,7 @@ configs:
 	require.NoError(t, dec.Decode(&cfg))
 
 	logger := util.TestLogger(t)
-	l, err := New(prometheus.NewRegistry(), &cfg, logger)
+	l, err := New(prometheus.NewRegistry(), &cfg, nil, logger)
 	require.NoError(t, err)
 	defer l.Stop()
 
@@ -130,6 +131,54 @@ configs:
 	})
 }
 
+func TestAgent_DedupHandler(t *testing.T) {
+	cfgText := util.Untab(`
+configs:
+- name: instance-a
+  positions:
+    filename: /tmp/positions.yaml
+  clients:
+	- url: http://127.0.0.1:80/loki/api/v1/push
+  dedup:
+    mode: hash
+    peers: [http://10.0.0.2:12345]
+	`)
+
+	var cfg Config
+	dec := yaml.NewDecoder(strings.NewReader(cfgText))
+	dec.SetStrict(true)
+	require.NoError(t, dec.Decode(&cfg))
+
+	logger := util.TestLogger(t)
+	l, err := New(prometheus.NewRegistry(), &cfg, nil, logger)
+	require.NoError(t, err)
+	defer l.Stop()
+
+	router := mux.NewRouter()
+	l.WireAPI(router)
+
+	tt := []struct {
+		name     string
+		instance string
+		addr     string
+		expect   int
+	}{
+		{name: "peer", instance: "instance-a", addr: "10.0.0.2:40000", expect: http.StatusOK},
+		{name: "not a peer", instance: "instance-a", addr: "10.0.0.3:40000", expect: http.StatusForbidden},
+		{name: "unknown instance", instance: "instance-b", addr: "10.0.0.2:40000", expect: http.StatusNotFound},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/agent/api/v1/logs/dedup/"+tc.instance, strings.NewReader(`{"hashes":[1]}`))
+			r.RemoteAddr = tc.addr
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, r)
+			require.Equal(t, tc.expect, rr.Result().StatusCode, rr.Body.String())
+		})
+	}
+}
+
 func mockActiveTargets() map[string][]target.Target {
 	return map[string][]target.Target{
 		"varlogs": {&mockTarget{}},
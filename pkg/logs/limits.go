@@ -0,0 +1,514 @@
+package logs
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/agent/pkg/util"
+	"github.com/grafana/loki/clients/pkg/promtail/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"golang.org/x/time/rate"
+)
+
+// Actions which can be taken when an entry exceeds a limit.
+const (
+	LimitActionDrop   = "drop"
+	LimitActionSample = "sample"
+	LimitActionDelay  = "delay"
+)
+
+// limiterIdleTimeout is how long a limiting key can go without receiving
+// entries before its state is forgotten.
+const limiterIdleTimeout = 10 * time.Minute
+
+// DefaultLimitConfig holds default settings for a LimitConfig.
+var DefaultLimitConfig = LimitConfig{
+	Action:     LimitActionDrop,
+	SampleRate: 0.1,
+	MaxDelay:   5 * time.Second,
+}
+
+// LimitConfig configures a rate limit applied to entries of a logs instance.
+// Each unique set of values for the labels in By is limited separately.
+type LimitConfig struct {
+	// By is the list of label names whose values form the limiting key. When
+	// empty, a single limit is shared by all entries.
+	By []string `yaml:"by,omitempty"`
+
+	// LinesPerSecond and BytesPerSecond are the sustained rates allowed per
+	// limiting key. A value of zero disables that limit.
+	LinesPerSecond float64 `yaml:"lines_per_second,omitempty"`
+	LinesBurst     int     `yaml:"lines_burst,omitempty"`
+	BytesPerSecond float64 `yaml:"bytes_per_second,omitempty"`
+	BytesBurst     int     `yaml:"bytes_burst,omitempty"`
+
+	// Action is taken for entries exceeding the limit: drop, sample, or delay.
+	Action string `yaml:"action,omitempty"`
+
+	// SampleRate is the fraction of entries exceeding the limit to keep when
+	// Action is sample.
+	SampleRate float64 `yaml:"sample_rate,omitempty"`
+
+	// MaxDelay is the maximum time an entry is held back when Action is
+	// delay. Entries which would need to wait longer are dropped.
+	MaxDelay time.Duration `yaml:"max_delay,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *LimitConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultLimitConfig
+
+	type plain LimitConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate ensures that the LimitConfig is valid.
+func (c *LimitConfig) Validate() error {
+	if c.LinesPerSecond <= 0 && c.BytesPerSecond <= 0 {
+		return fmt.Errorf("limit must set at least one of lines_per_second or bytes_per_second")
+	}
+	if c.LinesPerSecond < 0 || c.BytesPerSecond < 0 || c.LinesBurst < 0 || c.BytesBurst < 0 {
+		return fmt.Errorf("limit rates and bursts must not be negative")
+	}
+	switch c.Action {
+	case LimitActionDrop:
+	case LimitActionSample:
+		if c.SampleRate < 0 || c.SampleRate > 1 {
+			return fmt.Errorf("sample_rate must be between 0 and 1")
+		}
+	case LimitActionDelay:
+		if c.MaxDelay <= 0 {
+			return fmt.Errorf("max_delay must be greater than 0")
+		}
+	default:
+		return fmt.Errorf("unsupported limit action %q, expected one of %q, %q or %q", c.Action, LimitActionDrop, LimitActionSample, LimitActionDelay)
+	}
+	return nil
+}
+
+func (c *LimitConfig) linesBurst() int {
+	if c.LinesBurst > 0 {
+		return c.LinesBurst
+	}
+	return int(c.LinesPerSecond) + 1
+}
+
+func (c *LimitConfig) bytesBurst() int {
+	if c.BytesBurst > 0 {
+		return c.BytesBurst
+	}
+	return int(c.BytesPerSecond) + 1
+}
+
+// limiter is an api.EntryHandler which applies a set of limits to entries
+// before forwarding them to the next handler.
+type limiter struct {
+	log  log.Logger
+	next api.EntryHandler
+
+	in       chan api.Entry
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	stopOnce sync.Once
+
+	mut    sync.Mutex
+	limits []*limit
+
+	// delayed holds entries held back by the delay action until their
+	// release time. Only the run goroutine uses it.
+	delayed    delayQueue
+	delayedSeq uint64
+
+	droppedEntries *prometheus.CounterVec
+	droppedBytes   *prometheus.CounterVec
+	sampledEntries *prometheus.CounterVec
+	delayedEntries *prometheus.CounterVec
+}
+
+// newLimiter creates a new limiter which forwards entries to next. reg is
+// used to register metrics.
+func newLimiter(l log.Logger, reg prometheus.Registerer, cfgs []LimitConfig, next api.EntryHandler) (*limiter, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	lim := &limiter{
+		log:    l,
+		next:   next,
+		in:     make(chan api.Entry),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+
+		droppedEntries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_logs_limiter_dropped_entries_total",
+			Help: "Total number of log entries dropped for exceeding a limit.",
+		}, []string{"limit", "key"}),
+		droppedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_logs_limiter_dropped_bytes_total",
+			Help: "Total number of bytes of log entries dropped for exceeding a limit.",
+		}, []string{"limit", "key"}),
+		sampledEntries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_logs_limiter_sampled_entries_total",
+			Help: "Total number of log entries kept by sampling after exceeding a limit.",
+		}, []string{"limit", "key"}),
+		delayedEntries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_logs_limiter_delayed_entries_total",
+			Help: "Total number of log entries delayed for exceeding a limit.",
+		}, []string{"limit", "key"}),
+	}
+
+	for _, c := range []prometheus.Collector{lim.droppedEntries, lim.droppedBytes, lim.sampledEntries, lim.delayedEntries} {
+		if err := reg.Register(c); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	lim.ApplyConfig(cfgs)
+	go lim.run()
+	return lim, nil
+}
+
+// ApplyConfig replaces the set of limits. The state of limits which did not
+// change is kept.
+func (l *limiter) ApplyConfig(cfgs []LimitConfig) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	newLimits := make([]*limit, 0, len(cfgs))
+	for idx, c := range cfgs {
+		if idx < len(l.limits) && util.CompareYAML(l.limits[idx].cfg, c) {
+			newLimits = append(newLimits, l.limits[idx])
+			continue
+		}
+		newLimits = append(newLimits, newLimit(strconv.Itoa(idx), c))
+	}
+	l.limits = newLimits
+}
+
+func (l *limiter) run() {
+	defer close(l.done)
+
+	gc := time.NewTicker(time.Minute)
+	defer gc.Stop()
+
+	// release fires when the first delayed entry is due. It's only armed while
+	// entries are delayed.
+	release := time.NewTimer(0)
+	if !release.Stop() {
+		<-release.C
+	}
+	defer release.Stop()
+
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-gc.C:
+			l.gc(time.Now())
+		case <-release.C:
+			now := time.Now()
+			for l.delayed.Len() > 0 && !l.delayed[0].at.After(now) {
+				if !l.send(heap.Pop(&l.delayed).(*delayedEntry).entry) {
+					return
+				}
+			}
+		case e := <-l.in:
+			at, ok := l.allow(e)
+			if !ok {
+				continue
+			} else if at.IsZero() {
+				if !l.send(e) {
+					return
+				}
+				continue
+			}
+
+			// Entries are held back without blocking the loop, so that
+			// entries for other keys keep flowing. Entries of the same stream
+			// are always released in order: their reservations come from the
+			// same buckets, so their release times never decrease.
+			l.delayedSeq++
+			heap.Push(&l.delayed, &delayedEntry{entry: e, at: at, seq: l.delayedSeq})
+		}
+
+		if l.delayed.Len() > 0 {
+			if !release.Stop() {
+				select {
+				case <-release.C:
+				default:
+				}
+			}
+			release.Reset(time.Until(l.delayed[0].at))
+		}
+	}
+}
+
+// send forwards e to the next handler. Returns false if the limiter stopped
+// while sending.
+func (l *limiter) send(e api.Entry) bool {
+	select {
+	case l.next.Chan() <- e:
+		return true
+	case <-l.ctx.Done():
+		return false
+	}
+}
+
+// gc forgets the state of limiting keys which haven't been used recently,
+// along with their metrics.
+func (l *limiter) gc(now time.Time) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	for _, lim := range l.limits {
+		for _, key := range lim.gc(now) {
+			for _, c := range []*prometheus.CounterVec{l.droppedEntries, l.droppedBytes, l.sampledEntries, l.delayedEntries} {
+				c.DeleteLabelValues(lim.name, key)
+			}
+		}
+	}
+}
+
+// allow returns true if e should be forwarded to the next handler, along with
+// the time it may be forwarded at when a limit uses the delay action. The
+// time is zero if e isn't delayed.
+func (l *limiter) allow(e api.Entry) (time.Time, bool) {
+	l.mut.Lock()
+	limits := l.limits
+	l.mut.Unlock()
+
+	var (
+		now   = time.Now()
+		delay time.Duration
+
+		// taken are the tokens reserved for e so far, which are returned if e
+		// is dropped by a later limit.
+		taken   []reservation
+		delayed []prometheus.Counter
+	)
+
+	for _, lim := range limits {
+		key := lim.key(e.Labels)
+		b := lim.bucket(key, now)
+
+		res := b.reserve(now, len(e.Line))
+		if res.ok && res.delay == 0 {
+			taken = append(taken, res)
+			continue
+		}
+
+		switch lim.cfg.Action {
+		case LimitActionSample:
+			res.cancel(now)
+			if rand.Float64() < lim.cfg.SampleRate {
+				l.sampledEntries.WithLabelValues(lim.name, key).Inc()
+				continue
+			}
+		case LimitActionDelay:
+			if res.ok && res.delay <= lim.cfg.MaxDelay {
+				taken = append(taken, res)
+				delayed = append(delayed, l.delayedEntries.WithLabelValues(lim.name, key))
+				if res.delay > delay {
+					delay = res.delay
+				}
+				continue
+			}
+			res.cancel(now)
+		default:
+			res.cancel(now)
+		}
+
+		for _, r := range taken {
+			r.cancel(now)
+		}
+
+		level.Debug(l.log).Log("msg", "dropping entry exceeding limit", "limit", lim.name, "key", key)
+		l.droppedEntries.WithLabelValues(lim.name, key).Inc()
+		l.droppedBytes.WithLabelValues(lim.name, key).Add(float64(len(e.Line)))
+		return time.Time{}, false
+	}
+
+	for _, c := range delayed {
+		c.Inc()
+	}
+	if delay == 0 {
+		return time.Time{}, true
+	}
+	return now.Add(delay), true
+}
+
+// Chan implements api.EntryHandler.
+func (l *limiter) Chan() chan<- api.Entry { return l.in }
+
+// Stop implements api.EntryHandler. It does not stop the next handler.
+func (l *limiter) Stop() {
+	l.stopOnce.Do(func() {
+		l.cancel()
+		<-l.done
+	})
+}
+
+// limit tracks the state of an individual LimitConfig.
+type limit struct {
+	name    string
+	cfg     LimitConfig
+	by      []model.LabelName // Sorted copy of cfg.By
+	buckets map[string]*bucket
+}
+
+func newLimit(name string, c LimitConfig) *limit {
+	by := make([]model.LabelName, 0, len(c.By))
+	for _, n := range c.By {
+		by = append(by, model.LabelName(n))
+	}
+	sort.Slice(by, func(i, j int) bool { return by[i] < by[j] })
+
+	return &limit{
+		name:    name,
+		cfg:     c,
+		by:      by,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// key returns the limiting key for a label set.
+func (l *limit) key(ls model.LabelSet) string {
+	if len(l.by) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(l.by))
+	for _, n := range l.by {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", n, ls[n]))
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// bucket returns the bucket for a key, creating it if it doesn't exist.
+// Only the limiter's run goroutine calls bucket, so no locking is needed.
+func (l *limit) bucket(key string, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{}
+		if l.cfg.LinesPerSecond > 0 {
+			b.lines = rate.NewLimiter(rate.Limit(l.cfg.LinesPerSecond), l.cfg.linesBurst())
+		}
+		if l.cfg.BytesPerSecond > 0 {
+			b.bytes = rate.NewLimiter(rate.Limit(l.cfg.BytesPerSecond), l.cfg.bytesBurst())
+		}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+	return b
+}
+
+// gc removes buckets which have not been used recently and returns their
+// keys.
+func (l *limit) gc(now time.Time) []string {
+	var removed []string
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > limiterIdleTimeout {
+			delete(l.buckets, key)
+			removed = append(removed, key)
+		}
+	}
+	return removed
+}
+
+// bucket holds the token buckets for a single limiting key.
+type bucket struct {
+	lines    *rate.Limiter
+	bytes    *rate.Limiter
+	lastSeen time.Time
+}
+
+// reserve reserves tokens for an entry of size n.
+func (b *bucket) reserve(now time.Time, n int) reservation {
+	res := reservation{ok: true}
+
+	for _, r := range []struct {
+		lim *rate.Limiter
+		n   int
+	}{{b.lines, 1}, {b.bytes, n}} {
+		if r.lim == nil {
+			continue
+		}
+
+		rr := r.lim.ReserveN(now, r.n)
+		if !rr.OK() {
+			// The entry can never fit within the limit.
+			res.ok = false
+			continue
+		}
+		res.reservations = append(res.reservations, rr)
+		if d := rr.DelayFrom(now); d > res.delay {
+			res.delay = d
+		}
+	}
+
+	return res
+}
+
+// reservation is a set of tokens reserved for an entry.
+type reservation struct {
+	// ok is false if the entry can never fit within the limit.
+	ok bool
+	// delay is how long to wait before the entry fits within the limit.
+	delay        time.Duration
+	reservations []*rate.Reservation
+}
+
+// cancel returns the reserved tokens.
+func (r reservation) cancel(now time.Time) {
+	for _, rr := range r.reservations {
+		rr.CancelAt(now)
+	}
+}
+
+// delayedEntry is an entry held back by the delay action.
+type delayedEntry struct {
+	entry api.Entry
+	at    time.Time
+	// seq breaks ties between entries with the same release time, keeping
+	// them in the order they were received.
+	seq uint64
+}
+
+// delayQueue is a heap of delayed entries ordered by release time.
+type delayQueue []*delayedEntry
+
+func (q delayQueue) Len() int { return len(q) }
+
+func (q delayQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+
+func (q delayQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *delayQueue) Push(x interface{}) { *q = append(*q, x.(*delayedEntry)) }
+
+func (q *delayQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return e
+}
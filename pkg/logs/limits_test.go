@@ -0,0 +1,263 @@
+package logs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/agent/pkg/util"
+	"github.com/grafana/loki/clients/pkg/promtail/api"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestLimitConfig_Unmarshal(t *testing.T) {
+	tt := []struct {
+		name   string
+		cfg    string
+		expect string
+	}{
+		{
+			name: "defaults",
+			cfg:  `lines_per_second: 10`,
+		},
+		{
+			name:   "no rate",
+			cfg:    `by: [namespace]`,
+			expect: "limit must set at least one of lines_per_second or bytes_per_second",
+		},
+		{
+			name: "bad action",
+			cfg: `
+lines_per_second: 10
+action: block`,
+			expect: `unsupported limit action "block", expected one of "drop", "sample" or "delay"`,
+		},
+		{
+			name: "bad sample rate",
+			cfg: `
+lines_per_second: 10
+action: sample
+sample_rate: 2`,
+			expect: "sample_rate must be between 0 and 1",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var c LimitConfig
+			err := yaml.UnmarshalStrict([]byte(tc.cfg), &c)
+			if tc.expect == "" {
+				require.NoError(t, err)
+				require.Equal(t, LimitActionDrop, c.Action)
+				return
+			}
+			require.EqualError(t, err, tc.expect)
+		})
+	}
+}
+
+func TestLimiter_Drop(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	out := make(chan api.Entry, 100)
+
+	l, err := newLimiter(util.TestLogger(t), reg, []LimitConfig{{
+		By:             []string{"namespace"},
+		LinesPerSecond: 0.001,
+		LinesBurst:     2,
+		Action:         LimitActionDrop,
+	}}, api.NewEntryHandler(out, func() {}))
+	require.NoError(t, err)
+	defer l.Stop()
+
+	for i := 0; i < 5; i++ {
+		l.Chan() <- testLimitEntry("noisy", "hello")
+	}
+	l.Chan() <- testLimitEntry("quiet", "hello")
+
+	// The noisy namespace gets its burst of 2 lines, and the quiet namespace
+	// is unaffected.
+	require.Eventually(t, func() bool { return len(out) == 3 }, time.Second, 10*time.Millisecond)
+
+	dropped := l.droppedEntries.WithLabelValues("0", `{namespace="noisy"}`)
+	require.Equal(t, 3.0, testutil.ToFloat64(dropped))
+}
+
+func TestLimiter_Bytes(t *testing.T) {
+	out := make(chan api.Entry, 100)
+
+	l, err := newLimiter(util.TestLogger(t), prometheus.NewRegistry(), []LimitConfig{{
+		BytesPerSecond: 0.001,
+		BytesBurst:     10,
+		Action:         LimitActionDrop,
+	}}, api.NewEntryHandler(out, func() {}))
+	require.NoError(t, err)
+	defer l.Stop()
+
+	l.Chan() <- testLimitEntry("a", "12345")
+	l.Chan() <- testLimitEntry("a", "this line is longer than the burst")
+	l.Chan() <- testLimitEntry("a", "12345")
+	l.Chan() <- testLimitEntry("a", "1")
+
+	require.Eventually(t, func() bool { return len(out) == 2 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, 35.0, testutil.ToFloat64(l.droppedBytes.WithLabelValues("0", "")))
+}
+
+func TestLimiter_Delay(t *testing.T) {
+	out := make(chan api.Entry, 100)
+
+	l, err := newLimiter(util.TestLogger(t), prometheus.NewRegistry(), []LimitConfig{{
+		LinesPerSecond: 20,
+		LinesBurst:     1,
+		Action:         LimitActionDelay,
+		MaxDelay:       time.Second,
+	}}, api.NewEntryHandler(out, func() {}))
+	require.NoError(t, err)
+	defer l.Stop()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		l.Chan() <- testLimitEntry("a", "hello")
+	}
+	require.Eventually(t, func() bool { return len(out) == 3 }, time.Second, 5*time.Millisecond)
+
+	// The second and third entries each had to wait for a token.
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	require.Equal(t, 0.0, testutil.ToFloat64(l.droppedEntries.WithLabelValues("0", "")))
+}
+
+func TestLimiter_DelayPerKey(t *testing.T) {
+	out := make(chan api.Entry, 100)
+
+	l, err := newLimiter(util.TestLogger(t), prometheus.NewRegistry(), []LimitConfig{{
+		By:             []string{"namespace"},
+		LinesPerSecond: 1,
+		LinesBurst:     1,
+		Action:         LimitActionDelay,
+		MaxDelay:       5 * time.Second,
+	}}, api.NewEntryHandler(out, func() {}))
+	require.NoError(t, err)
+	defer l.Stop()
+
+	l.Chan() <- testLimitEntry("noisy", "first")
+	l.Chan() <- testLimitEntry("noisy", "second")
+	l.Chan() <- testLimitEntry("quiet", "hello")
+
+	// The quiet namespace isn't held back by the delayed noisy entry.
+	for _, expect := range []string{"first", "hello", "second"} {
+		select {
+		case e := <-out:
+			require.Equal(t, expect, e.Line)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for entry", expect)
+		}
+	}
+}
+
+func TestLimiter_DropRefundsEarlierLimits(t *testing.T) {
+	out := make(chan api.Entry, 100)
+
+	l, err := newLimiter(util.TestLogger(t), prometheus.NewRegistry(), []LimitConfig{
+		{LinesPerSecond: 0.001, LinesBurst: 2, Action: LimitActionDrop},
+		{By: []string{"namespace"}, LinesPerSecond: 0.001, LinesBurst: 1, Action: LimitActionDrop},
+	}, api.NewEntryHandler(out, func() {}))
+	require.NoError(t, err)
+	defer l.Stop()
+
+	// The second noisy entry is dropped by the second limit, so the token it
+	// took from the first limit is returned for the quiet entry.
+	l.Chan() <- testLimitEntry("noisy", "hello")
+	l.Chan() <- testLimitEntry("noisy", "hello")
+	l.Chan() <- testLimitEntry("quiet", "hello")
+
+	require.Eventually(t, func() bool { return len(out) == 2 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, 0.0, testutil.ToFloat64(l.droppedEntries.WithLabelValues("0", "")))
+}
+
+func TestLimiter_GC(t *testing.T) {
+	l, err := newLimiter(util.TestLogger(t), prometheus.NewRegistry(), []LimitConfig{{
+		By:             []string{"namespace"},
+		LinesPerSecond: 0.001,
+		LinesBurst:     1,
+		Action:         LimitActionDrop,
+	}}, api.NewEntryHandler(make(chan api.Entry, 100), func() {}))
+	require.NoError(t, err)
+	defer l.Stop()
+
+	l.Chan() <- testLimitEntry("noisy", "hello")
+	l.Chan() <- testLimitEntry("noisy", "hello")
+	require.Eventually(t, func() bool {
+		return testutil.CollectAndCount(l.droppedEntries) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Evicting the bucket of the key deletes its series.
+	l.gc(time.Now().Add(2 * limiterIdleTimeout))
+	require.Equal(t, 0, testutil.CollectAndCount(l.droppedEntries))
+}
+
+func TestLimiter_ApplyConfig(t *testing.T) {
+	out := make(chan api.Entry, 100)
+
+	l, err := newLimiter(util.TestLogger(t), prometheus.NewRegistry(), []LimitConfig{{
+		LinesPerSecond: 0.001,
+		LinesBurst:     1,
+		Action:         LimitActionDrop,
+	}}, api.NewEntryHandler(out, func() {}))
+	require.NoError(t, err)
+	defer l.Stop()
+
+	l.Chan() <- testLimitEntry("a", "hello")
+	l.Chan() <- testLimitEntry("a", "hello")
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(l.droppedEntries.WithLabelValues("0", "")) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Raise the limit; entries should flow again.
+	l.ApplyConfig([]LimitConfig{{
+		LinesPerSecond: 1000,
+		LinesBurst:     1000,
+		Action:         LimitActionDrop,
+	}})
+	for i := 0; i < 10; i++ {
+		l.Chan() <- testLimitEntry("a", "hello")
+	}
+	require.Eventually(t, func() bool { return len(out) == 11 }, time.Second, 10*time.Millisecond)
+}
+
+func testLimitEntry(namespace, line string) api.Entry {
+	return api.Entry{
+		Labels: model.LabelSet{"namespace": model.LabelValue(namespace)},
+		Entry:  logproto.Entry{Timestamp: time.Now(), Line: line},
+	}
+}
+
+func TestInstance_ApplyConfig_OnlyLimits(t *testing.T) {
+	var cfg Config
+	err := yaml.UnmarshalStrict([]byte(util.Untab(`
+positions_directory: `+t.TempDir()+`
+configs:
+- name: default
+  clients:
+  - url: http://127.0.0.1:0/loki/api/v1/push
+  limits:
+  - lines_per_second: 10
+	`)), &cfg)
+	require.NoError(t, err)
+
+	inst, err := NewInstance(prometheus.NewRegistry(), cfg.Configs[0], nil, util.TestLogger(t))
+	require.NoError(t, err)
+	defer inst.Stop()
+
+	origLimiter, origClient := inst.limiter, inst.client
+
+	newCfg := *cfg.Configs[0]
+	newCfg.Limits = []LimitConfig{{LinesPerSecond: 100, Action: LimitActionDrop}}
+	require.NoError(t, inst.ApplyConfig(&newCfg))
+
+	require.Same(t, origLimiter, inst.limiter, "limiter should be updated in place")
+	require.Same(t, origClient, inst.client, "client should not be recreated")
+	require.Equal(t, 100.0, inst.limiter.limits[0].cfg.LinesPerSecond)
+}
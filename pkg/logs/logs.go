@@ -2,6 +2,7 @@
 package logs
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -12,13 +13,14 @@ import (
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/grafana/agent/pkg/util"
-	"github.com/grafana/loki/clients/pkg/promtail"
 	"github.com/grafana/loki/clients/pkg/promtail/api"
 	"github.com/grafana/loki/clients/pkg/promtail/client"
 	"github.com/grafana/loki/clients/pkg/promtail/config"
 	"github.com/grafana/loki/clients/pkg/promtail/server"
+	"github.com/grafana/loki/clients/pkg/promtail/targets"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/version"
+	"go.opentelemetry.io/collector/model/pdata"
 )
 
 func init() {
@@ -31,15 +33,18 @@ type Logs struct {
 	mut sync.Mutex
 
 	reg       prometheus.Registerer
+	ring      Ring
 	l         log.Logger
 	instances map[string]*Instance
 }
 
-// New creates and starts Loki log collection.
-func New(reg prometheus.Registerer, c *Config, l log.Logger) (*Logs, error) {
+// New creates and starts Loki log collection. ring is used by instances which
+// deduplicate entries in ring mode and may be nil.
+func New(reg prometheus.Registerer, c *Config, ring Ring, l log.Logger) (*Logs, error) {
 	logs := &Logs{
 		instances: make(map[string]*Instance),
 		reg:       reg,
+		ring:      ring,
 		l:         log.With(l, "component", "logs"),
 	}
 	if err := logs.ApplyConfig(c); err != nil {
@@ -71,7 +76,7 @@ func (l *Logs) ApplyConfig(c *Config) error {
 			continue
 		}
 
-		inst, err := NewInstance(l.reg, ic, l.l)
+		inst, err := NewInstance(l.reg, ic, l.ring, l.l)
 		if err != nil {
 			return fmt.Errorf("unable to apply config for %s: %w", ic.Name, err)
 		}
@@ -113,20 +118,29 @@ func (l *Logs) Instance(name string) *Instance {
 type Instance struct {
 	mut sync.Mutex
 
-	cfg *InstanceConfig
-	log log.Logger
-	reg *util.Unregisterer
-
-	promtail *promtail.Promtail
+	cfg  *InstanceConfig
+	log  log.Logger
+	reg  *util.Unregisterer
+	ring Ring
+
+	client     client.Client
+	limiter    *limiter
+	correlator *correlator
+	dedup      *deduper
+	positions  *positionsMirror
+	targets    *targets.TargetManagers
+	otlp       *otlpReceiver
 }
 
-// NewInstance creates and starts a Logs instance.
-func NewInstance(reg prometheus.Registerer, c *InstanceConfig, l log.Logger) (*Instance, error) {
+// NewInstance creates and starts a Logs instance. ring may be nil if the
+// instance doesn't deduplicate entries in ring mode.
+func NewInstance(reg prometheus.Registerer, c *InstanceConfig, ring Ring, l log.Logger) (*Instance, error) {
 	instReg := prometheus.WrapRegistererWith(prometheus.Labels{"logs_config": c.Name}, reg)
 
 	inst := Instance{
-		reg: util.WrapWithUnregisterer(instReg),
-		log: log.With(l, "logs_config", c.Name),
+		reg:  util.WrapWithUnregisterer(instReg),
+		log:  log.With(l, "logs_config", c.Name),
+		ring: ring,
 	}
 	if err := inst.ApplyConfig(c); err != nil {
 		return nil, err
@@ -135,8 +149,9 @@ func NewInstance(reg prometheus.Registerer, c *InstanceConfig, l log.Logger) (*I
 }
 
 // ApplyConfig will apply a new InstanceConfig. If the config hasn't changed,
-// then nothing will happen, otherwise the old Promtail will be stopped and
-// then replaced with a new one.
+// then nothing will happen. If only the limits have changed, they will be
+// updated in place. Otherwise the old Promtail will be stopped and then
+// replaced with a new one.
 func (i *Instance) ApplyConfig(c *InstanceConfig) error {
 	i.mut.Lock()
 	defer i.mut.Unlock()
@@ -146,6 +161,14 @@ func (i *Instance) ApplyConfig(c *InstanceConfig) error {
 		level.Debug(i.log).Log("msg", "instance config hasn't changed, not recreating Promtail")
 		return nil
 	}
+
+	// Update limits in place if nothing else has changed.
+	if i.limiter != nil && onlyLimitsChanged(i.cfg, c) {
+		level.Debug(i.log).Log("msg", "only limits changed, not recreating Promtail")
+		i.limiter.ApplyConfig(c.Limits)
+		i.cfg = c
+		return nil
+	}
 	i.cfg = c
 
 	positionsDir := filepath.Dir(c.PositionsConfig.PositionsFile)
@@ -154,10 +177,7 @@ func (i *Instance) ApplyConfig(c *InstanceConfig) error {
 		level.Warn(i.log).Log("msg", "failed to create the positions directory. logs may be unable to save their position", "path", positionsDir, "err", err)
 	}
 
-	if i.promtail != nil {
-		i.promtail.Shutdown()
-		i.promtail = nil
-	}
+	i.stop()
 
 	// Unregister all existing metrics before trying to create a new instance.
 	if !i.reg.UnregisterAll() {
@@ -171,33 +191,122 @@ func (i *Instance) ApplyConfig(c *InstanceConfig) error {
 		return nil
 	}
 
-	clientMetrics := client.NewMetrics(i.reg, nil)
-	p, err := promtail.New(config.Config{
+	// Promtail is built from its individual components rather than
+	// promtail.New so limits can be applied between targets and the clients.
+	cfg := config.Config{
 		ServerConfig:    server.Config{Disable: true},
 		ClientConfigs:   c.ClientConfigs,
 		PositionsConfig: c.PositionsConfig,
 		ScrapeConfig:    c.ScrapeConfig,
 		TargetConfig:    c.TargetConfig,
-	}, clientMetrics, false, promtail.WithLogger(i.log), promtail.WithRegisterer(i.reg))
+	}
+	cfg.Setup()
+
+	clientMetrics := client.NewMetrics(i.reg, nil)
+	i.client, err = client.NewMulti(clientMetrics, cfg.Options.StreamLagLabels, i.log, cfg.ClientConfigs...)
+	if err != nil {
+		return fmt.Errorf("unable to create logs instance: %w", err)
+	}
+
+	i.limiter, err = newLimiter(i.log, i.reg, c.Limits, i.client)
+	if err != nil {
+		i.stop()
+		return fmt.Errorf("unable to create logs limiter: %w", err)
+	}
+
+	if c.TraceCorrelation != nil {
+		i.correlator, err = newCorrelator(i.log, i.reg, *c.TraceCorrelation, i.limiter)
+		if err != nil {
+			i.stop()
+			return fmt.Errorf("unable to create logs trace correlation: %w", err)
+		}
+	}
+
+	// Deduplication happens before correlation since peers may not have
+	// seen the same traces.
+	if c.Dedup != nil {
+		i.dedup, err = newDeduper(i.log, i.reg, c.Name, *c.Dedup, i.ring, i.correlatorOrLimiter())
+		if err != nil {
+			i.stop()
+			return fmt.Errorf("unable to create logs deduplication: %w", err)
+		}
+	}
+
+	if c.PositionsStore != nil {
+		store, err := newPositionsStore(i.log, i.reg, c.Name, c.PositionsStore)
+		if err != nil {
+			i.stop()
+			return fmt.Errorf("unable to create positions store: %w", err)
+		}
+		i.positions, err = newPositionsMirror(i.log, i.reg, cfg.PositionsConfig, store)
+		if err != nil {
+			i.stop()
+			return fmt.Errorf("unable to create positions store: %w", err)
+		}
+
+		// A failed restore isn't fatal; files will be read from the positions
+		// in the local file, if any.
+		if err := i.positions.Restore(); err != nil {
+			level.Warn(i.log).Log("msg", "failed to restore positions from positions store", "err", err)
+		}
+		i.positions.Start()
+	}
+
+	i.targets, err = targets.NewTargetManagers(i, i.reg, i.log, cfg.PositionsConfig, i.handler(), cfg.ScrapeConfig, &cfg.TargetConfig)
 	if err != nil {
+		i.stop()
 		return fmt.Errorf("unable to create logs instance: %w", err)
 	}
 
-	i.promtail = p
+	if c.OTLP != nil {
+		r, err := newOTLPReceiver(i.log, c.OTLP, i.handler())
+		if err != nil {
+			i.stop()
+			return fmt.Errorf("unable to create otlp receiver: %w", err)
+		}
+		i.otlp = r
+	}
 	return nil
 }
 
+// handler returns the first handler entries are sent to. i.mut must be held
+// when calling handler.
+func (i *Instance) handler() api.EntryHandler {
+	if i.dedup != nil {
+		return i.dedup
+	}
+	return i.correlatorOrLimiter()
+}
+
+func (i *Instance) correlatorOrLimiter() api.EntryHandler {
+	if i.correlator != nil {
+		return i.correlator
+	}
+	return i.limiter
+}
+
+// onlyLimitsChanged returns true if the only difference between prev and next
+// is their limits.
+func onlyLimitsChanged(prev, next *InstanceConfig) bool {
+	if prev == nil || next == nil {
+		return false
+	}
+	a, b := *prev, *next
+	a.Limits, b.Limits = nil, nil
+	return util.CompareYAML(a, b)
+}
+
 // SendEntry passes an entry to the internal promtail client and returns true if successfully sent. It is
 // best effort and not guaranteed to succeed.
 func (i *Instance) SendEntry(entry api.Entry, dur time.Duration) bool {
 	i.mut.Lock()
 	defer i.mut.Unlock()
 
-	// promtail is nil it has been stopped
-	if i.promtail != nil {
+	// limiter is nil it has been stopped
+	if i.limiter != nil {
 		// send non blocking so we don't block the mutex. this is best effort
 		select {
-		case i.promtail.Client().Chan() <- entry:
+		case i.handler().Chan() <- entry:
 			return true
 		case <-time.After(dur):
 		}
@@ -206,13 +315,88 @@ func (i *Instance) SendEntry(entry api.Entry, dur time.Duration) bool {
 	return false
 }
 
+// ActiveTargets returns the active targets of the instance, grouped by job.
+func (i *Instance) ActiveTargets() TargetSet {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+
+	if i.targets == nil {
+		return nil
+	}
+	return i.targets.ActiveTargets()
+}
+
+// ObserveDedup records hashes of entries which were forwarded by the peer
+// at addr. It returns false if the instance doesn't deduplicate entries in
+// hash mode, and an error if addr isn't the address of a peer.
+func (i *Instance) ObserveDedup(ctx context.Context, addr string, hashes []uint64) (bool, error) {
+	i.mut.Lock()
+	d := i.dedup
+	i.mut.Unlock()
+
+	if d == nil || d.cfg.Mode != DedupModeHash {
+		return false, nil
+	}
+	return true, d.Observe(ctx, addr, hashes)
+}
+
+// ObserveSpan records the attributes of a span used for trace correlation.
+// It is a no-op if the instance doesn't have trace correlation configured.
+func (i *Instance) ObserveSpan(traceID string, attrs ...pdata.AttributeMap) {
+	i.mut.Lock()
+	c := i.correlator
+	i.mut.Unlock()
+
+	if c != nil {
+		c.ObserveSpan(traceID, attrs...)
+	}
+}
+
+// Shutdown implements stdin.Shutdownable. It is invoked by the stdin target
+// once it has read all of its input.
+func (i *Instance) Shutdown() {
+	go i.Stop()
+}
+
 // Stop stops the Promtail instance.
 func (i *Instance) Stop() {
 	i.mut.Lock()
 	defer i.mut.Unlock()
 
-	if i.promtail != nil {
-		i.promtail.Shutdown()
-		i.promtail = nil
+	i.stop()
+}
+
+func (i *Instance) stop() {
+	// Components are stopped in the order entries flow through them, so no
+	// entries are sent to a stopped component.
+	if i.otlp != nil {
+		if err := i.otlp.Stop(); err != nil {
+			level.Warn(i.log).Log("msg", "failed to stop otlp receiver", "err", err)
+		}
+		i.otlp = nil
+	}
+	if i.targets != nil {
+		i.targets.Stop()
+		i.targets = nil
+	}
+	if i.positions != nil {
+		i.positions.Stop()
+		i.positions = nil
+	}
+	if i.dedup != nil {
+		i.dedup.Stop()
+		i.dedup = nil
+	}
+	if i.correlator != nil {
+		i.correlator.Stop()
+		i.correlator = nil
+	}
+	if i.limiter != nil {
+		i.limiter.Stop()
+		i.limiter = nil
+	}
+	if i.client != nil {
+		i.client.Stop()
+		i.client = nil
 	}
 }
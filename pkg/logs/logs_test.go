@@ -25,7 +25,7 @@ import (
 )
 
 func TestLogs_NilConfig(t *testing.T) {
-	l, err := New(prometheus.NewRegistry(), nil, util.TestLogger(t))
+	l, err := New(prometheus.NewRegistry(), nil, nil, util.TestLogger(t))
 	require.NoError(t, err)
 	require.NoError(t, l.ApplyConfig(nil))
 
@@ -94,7 +94,7 @@ configs:
 	require.NoError(t, dec.Decode(&cfg))
 
 	logger := log.NewSyncLogger(log.NewNopLogger())
-	l, err := New(prometheus.NewRegistry(), &cfg, logger)
+	l, err := New(prometheus.NewRegistry(), &cfg, nil, logger)
 	require.NoError(t, err)
 	defer l.Stop()
 
@@ -184,7 +184,7 @@ configs:
 	require.NoError(t, dec.Decode(&cfg))
 
 	logger := util.TestLogger(t)
-	l, err := New(prometheus.NewRegistry(), &cfg, logger)
+	l, err := New(prometheus.NewRegistry(), &cfg, nil, logger)
 	require.NoError(t, err)
 	defer l.Stop()
 
@@ -0,0 +1,360 @@
+package logs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/go-logfmt/logfmt"
+	"github.com/grafana/agent/pkg/build"
+	"github.com/grafana/agent/pkg/util"
+	"github.com/grafana/loki/clients/pkg/promtail/api"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/util/strutil"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/consumer/consumerhelper"
+	"go.opentelemetry.io/collector/model/pdata"
+	"go.opentelemetry.io/collector/receiver/otlpreceiver"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Actions which can be taken on an OTLP attribute.
+const (
+	OTLPActionLabel = "label"
+	OTLPActionLine  = "line"
+	OTLPActionDrop  = "drop"
+)
+
+// Sources an OTLP attribute rule can match against.
+const (
+	OTLPSourceResource = "resource"
+	OTLPSourceRecord   = "record"
+)
+
+// Formats which can be used for writing OTLP log lines.
+const (
+	OTLPLineFormatLogfmt = "logfmt"
+	OTLPLineFormatJSON   = "json"
+)
+
+// Keys used for the well-known fields of an OTLP log record when writing
+// them into the log line.
+const (
+	otlpTraceIDKey  = "traceID"
+	otlpSpanIDKey   = "spanID"
+	otlpSeverityKey = "level"
+	otlpBodyKey     = "body"
+)
+
+// DefaultOTLPConfig holds default settings for an OTLPConfig.
+var DefaultOTLPConfig = OTLPConfig{
+	DefaultAction: OTLPActionLine,
+	LineFormat:    OTLPLineFormatLogfmt,
+	Timeout:       5 * time.Second,
+}
+
+// OTLPConfig configures an OTLP logs receiver for a logs instance.
+type OTLPConfig struct {
+	// Protocols configures the gRPC and HTTP servers to listen on. It uses the
+	// same format as the protocols block of the OpenTelemetry Collector's OTLP
+	// receiver.
+	Protocols map[string]interface{} `yaml:"protocols,omitempty"`
+
+	// Labels are static labels added to every received log entry.
+	Labels model.LabelSet `yaml:"labels,omitempty"`
+
+	// AttributeRules determine what to do with resource and log record
+	// attributes. The first matching rule is used. Attributes that do not
+	// match any rule are handled with DefaultAction.
+	AttributeRules []OTLPAttributeRule `yaml:"attribute_rules,omitempty"`
+	DefaultAction  string              `yaml:"default_action,omitempty"`
+
+	// LineFormat is the format used to write the log body and structured
+	// fields into the log line.
+	LineFormat string `yaml:"line_format,omitempty"`
+
+	// Timeout is the maximum amount of time to wait to hand off a received
+	// entry to the logs instance before failing the request.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *OTLPConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultOTLPConfig
+
+	type plain OTLPConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate ensures that the OTLPConfig is valid.
+func (c *OTLPConfig) Validate() error {
+	if len(c.Protocols) == 0 {
+		return fmt.Errorf("otlp must have at least one protocol configured")
+	}
+	if err := validateOTLPAction(c.DefaultAction); err != nil {
+		return fmt.Errorf("invalid default_action: %w", err)
+	}
+	switch c.LineFormat {
+	case OTLPLineFormatLogfmt, OTLPLineFormatJSON:
+	default:
+		return fmt.Errorf("unsupported line_format %q, expected %q or %q", c.LineFormat, OTLPLineFormatLogfmt, OTLPLineFormatJSON)
+	}
+	for i, r := range c.AttributeRules {
+		if r.Key == "" {
+			return fmt.Errorf("attribute rule %d must have a key", i)
+		}
+		switch r.Source {
+		case "", OTLPSourceResource, OTLPSourceRecord:
+		default:
+			return fmt.Errorf("attribute rule %d has unsupported source %q, expected %q or %q", i, r.Source, OTLPSourceResource, OTLPSourceRecord)
+		}
+		if err := validateOTLPAction(r.Action); err != nil {
+			return fmt.Errorf("attribute rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func validateOTLPAction(action string) error {
+	switch action {
+	case OTLPActionLabel, OTLPActionLine, OTLPActionDrop:
+		return nil
+	default:
+		return fmt.Errorf("unsupported action %q, expected one of %q, %q or %q", action, OTLPActionLabel, OTLPActionLine, OTLPActionDrop)
+	}
+}
+
+// OTLPAttributeRule determines how an OTLP attribute is mapped into a log
+// entry.
+type OTLPAttributeRule struct {
+	// Source is where the attribute is looked up: resource or record. When
+	// empty, the rule matches attributes from both.
+	Source string `yaml:"source,omitempty"`
+	// Key is the attribute key to match.
+	Key string `yaml:"key"`
+	// Action is one of label, line, or drop.
+	Action string `yaml:"action"`
+	// Name is the label or field name to write the attribute as. Defaults to
+	// Key. Label names are sanitized to be valid Loki labels.
+	Name string `yaml:"name,omitempty"`
+}
+
+// otlpReceiver receives logs over OTLP and writes them to an entry handler.
+type otlpReceiver struct {
+	cfg      *OTLPConfig
+	handler  api.EntryHandler
+	receiver component.LogsReceiver
+}
+
+// newOTLPReceiver creates and starts a new otlpReceiver which forwards logs to
+// h.
+func newOTLPReceiver(l log.Logger, c *OTLPConfig, h api.EntryHandler) (*otlpReceiver, error) {
+	factory := otlpreceiver.NewFactory()
+
+	otelCfg := factory.CreateDefaultConfig().(*otlpreceiver.Config)
+	err := otelCfg.Unmarshal(config.NewMapFromStringMap(map[string]interface{}{
+		"protocols": c.Protocols,
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("invalid otlp protocols: %w", err)
+	}
+	if err := otelCfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid otlp protocols: %w", err)
+	}
+
+	r := &otlpReceiver{cfg: c, handler: h}
+
+	next, err := consumerhelper.NewLogs(r.consumeLogs)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := component.ReceiverCreateSettings{
+		TelemetrySettings: component.TelemetrySettings{
+			Logger:         util.NewZapLogger(log.With(l, "component", "otlp_receiver")),
+			TracerProvider: trace.NewNoopTracerProvider(),
+			MeterProvider:  metric.NewNoopMeterProvider(),
+		},
+		BuildInfo: component.BuildInfo{
+			Command:     "agent",
+			Description: "agent",
+			Version:     build.Version,
+		},
+	}
+
+	r.receiver, err = factory.CreateLogsReceiver(context.Background(), settings, otelCfg, next)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create otlp receiver: %w", err)
+	}
+	if err := r.receiver.Start(context.Background(), &otlpHost{log: l}); err != nil {
+		return nil, fmt.Errorf("failed to start otlp receiver: %w", err)
+	}
+	return r, nil
+}
+
+func (r *otlpReceiver) consumeLogs(ctx context.Context, ld pdata.Logs) error {
+	entries := convertOTLPLogs(r.cfg, ld)
+
+	timeout := time.NewTimer(r.cfg.Timeout)
+	defer timeout.Stop()
+
+	for _, e := range entries {
+		select {
+		case r.handler.Chan() <- e:
+		case <-timeout.C:
+			return fmt.Errorf("timed out sending entries to the logs instance")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Stop stops the otlpReceiver. In-flight requests are completed before Stop
+// returns.
+func (r *otlpReceiver) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return r.receiver.Shutdown(ctx)
+}
+
+// convertOTLPLogs converts ld into a list of entries using the rules from c.
+func convertOTLPLogs(c *OTLPConfig, ld pdata.Logs) []api.Entry {
+	var entries []api.Entry
+
+	rls := ld.ResourceLogs()
+	for i := 0; i < rls.Len(); i++ {
+		rl := rls.At(i)
+
+		resLabels := model.LabelSet{}
+		var resFields []interface{}
+		applyOTLPRules(c, OTLPSourceResource, rl.Resource().Attributes(), resLabels, &resFields)
+
+		ills := rl.InstrumentationLibraryLogs()
+		for j := 0; j < ills.Len(); j++ {
+			records := ills.At(j).LogRecords()
+			for k := 0; k < records.Len(); k++ {
+				entries = append(entries, convertOTLPRecord(c, records.At(k), resLabels, resFields))
+			}
+		}
+	}
+
+	return entries
+}
+
+func convertOTLPRecord(c *OTLPConfig, lr pdata.LogRecord, resLabels model.LabelSet, resFields []interface{}) api.Entry {
+	labels := c.Labels.Merge(resLabels)
+
+	fields := append([]interface{}{}, resFields...)
+	applyOTLPRules(c, OTLPSourceRecord, lr.Attributes(), labels, &fields)
+
+	if sev := lr.SeverityText(); sev != "" {
+		fields = append(fields, otlpSeverityKey, sev)
+	}
+	if id := lr.TraceID(); !id.IsEmpty() {
+		fields = append(fields, otlpTraceIDKey, id.HexString())
+	}
+	if id := lr.SpanID(); !id.IsEmpty() {
+		fields = append(fields, otlpSpanIDKey, id.HexString())
+	}
+
+	ts := lr.Timestamp().AsTime()
+	if lr.Timestamp() == 0 {
+		ts = time.Now()
+	}
+
+	return api.Entry{
+		Labels: labels,
+		Entry: logproto.Entry{
+			Timestamp: ts,
+			Line:      formatOTLPLine(c.LineFormat, lr.Body().AsString(), fields),
+		},
+	}
+}
+
+// applyOTLPRules applies the rules from c to attrs. Attributes mapped to
+// labels are written to labels, and attributes mapped to the line are
+// appended to fields as key/value pairs.
+func applyOTLPRules(c *OTLPConfig, source string, attrs pdata.AttributeMap, labels model.LabelSet, fields *[]interface{}) {
+	attrs.Sort().Range(func(k string, v pdata.AttributeValue) bool {
+		action, name := c.DefaultAction, k
+		for _, r := range c.AttributeRules {
+			if r.Key != k || (r.Source != "" && r.Source != source) {
+				continue
+			}
+			action = r.Action
+			if r.Name != "" {
+				name = r.Name
+			}
+			break
+		}
+
+		switch action {
+		case OTLPActionLabel:
+			labels[model.LabelName(strutil.SanitizeLabelName(name))] = model.LabelValue(v.AsString())
+		case OTLPActionLine:
+			*fields = append(*fields, name, v.AsString())
+		}
+		return true
+	})
+}
+
+// formatOTLPLine writes body and fields into a log line. For logfmt, the body
+// is written unmodified and followed by the fields. For json, the body is
+// written as a "body" field.
+func formatOTLPLine(format string, body string, fields []interface{}) string {
+	switch format {
+	case OTLPLineFormatJSON:
+		obj := make(map[string]interface{}, 1+len(fields)/2)
+		for i := 0; i < len(fields); i += 2 {
+			obj[fields[i].(string)] = fields[i+1]
+		}
+		obj[otlpBodyKey] = body
+
+		bb, err := json.Marshal(obj)
+		if err != nil {
+			return body
+		}
+		return string(bb)
+
+	default:
+		if len(fields) == 0 {
+			return body
+		}
+		bb, err := logfmt.MarshalKeyvals(fields...)
+		if err != nil {
+			return body
+		}
+		if body == "" {
+			return string(bb)
+		}
+		return strings.Join([]string{body, string(bb)}, " ")
+	}
+}
+
+// otlpHost implements component.Host for the OTLP receiver.
+type otlpHost struct {
+	log log.Logger
+}
+
+func (h *otlpHost) ReportFatalError(err error) {
+	level.Error(h.log).Log("msg", "fatal error reported by otlp receiver", "err", err)
+}
+
+func (h *otlpHost) GetFactory(component.Kind, config.Type) component.Factory { return nil }
+
+func (h *otlpHost) GetExtensions() map[config.ComponentID]component.Extension { return nil }
+
+func (h *otlpHost) GetExporters() map[config.DataType]map[config.ComponentID]component.Exporter {
+	return nil
+}
@@ -0,0 +1,196 @@
+package logs
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/agent/pkg/util"
+	"github.com/grafana/loki/clients/pkg/promtail/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/model/otlp"
+	"go.opentelemetry.io/collector/model/pdata"
+	"gopkg.in/yaml.v2"
+)
+
+func TestOTLPConfig_Unmarshal(t *testing.T) {
+	tt := []struct {
+		name   string
+		cfg    string
+		expect string
+	}{
+		{
+			name: "defaults",
+			cfg: `
+protocols:
+  grpc:`,
+		},
+		{
+			name:   "no protocols",
+			cfg:    `line_format: json`,
+			expect: "otlp must have at least one protocol configured",
+		},
+		{
+			name: "bad line format",
+			cfg: `
+protocols: {grpc: }
+line_format: xml`,
+			expect: `unsupported line_format "xml", expected "logfmt" or "json"`,
+		},
+		{
+			name: "bad rule action",
+			cfg: `
+protocols: {grpc: }
+attribute_rules:
+- key: service.name
+  action: index`,
+			expect: `attribute rule 0: unsupported action "index", expected one of "label", "line" or "drop"`,
+		},
+		{
+			name: "bad rule source",
+			cfg: `
+protocols: {grpc: }
+attribute_rules:
+- key: service.name
+  source: span
+  action: label`,
+			expect: `attribute rule 0 has unsupported source "span", expected "resource" or "record"`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var c OTLPConfig
+			err := yaml.UnmarshalStrict([]byte(tc.cfg), &c)
+			if tc.expect == "" {
+				require.NoError(t, err)
+				require.Equal(t, DefaultOTLPConfig.LineFormat, c.LineFormat)
+				require.Equal(t, DefaultOTLPConfig.DefaultAction, c.DefaultAction)
+				return
+			}
+			require.EqualError(t, err, tc.expect)
+		})
+	}
+}
+
+func TestConvertOTLPLogs(t *testing.T) {
+	cfg := DefaultOTLPConfig
+	cfg.Labels = model.LabelSet{"job": "otlp"}
+	cfg.AttributeRules = []OTLPAttributeRule{
+		{Source: OTLPSourceResource, Key: "service.name", Action: OTLPActionLabel},
+		{Key: "password", Action: OTLPActionDrop},
+		{Source: OTLPSourceRecord, Key: "http.method", Action: OTLPActionLine, Name: "method"},
+	}
+
+	ts := time.Date(2022, 4, 1, 12, 0, 0, 0, time.UTC)
+	ld := testOTLPLogs(ts)
+
+	entries := convertOTLPLogs(&cfg, ld)
+	require.Len(t, entries, 1)
+	require.Equal(t, model.LabelSet{"job": "otlp", "service_name": "checkout"}, entries[0].Labels)
+	require.Equal(t, ts, entries[0].Timestamp)
+	require.Equal(t,
+		"payment failed host=node-a method=POST level=ERROR traceID=0102030405060708090a0b0c0d0e0f10 spanID=0102030405060708",
+		entries[0].Line,
+	)
+
+	cfg.LineFormat = OTLPLineFormatJSON
+	entries = convertOTLPLogs(&cfg, ld)
+	require.Len(t, entries, 1)
+	require.JSONEq(t, `{
+		"body": "payment failed",
+		"host": "node-a",
+		"method": "POST",
+		"level": "ERROR",
+		"traceID": "0102030405060708090a0b0c0d0e0f10",
+		"spanID": "0102030405060708"
+	}`, entries[0].Line)
+}
+
+func TestOTLPReceiver(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := lis.Addr().String()
+	require.NoError(t, lis.Close())
+
+	cfg := DefaultOTLPConfig
+	cfg.Labels = model.LabelSet{"job": "otlp"}
+	cfg.Protocols = map[string]interface{}{
+		"http": map[string]interface{}{"endpoint": addr},
+	}
+
+	entries := make(chan api.Entry, 1)
+	r, err := newOTLPReceiver(util.TestLogger(t), &cfg, api.NewEntryHandler(entries, func() {}))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Stop()) }()
+
+	bb, err := otlp.NewJSONLogsMarshaler().MarshalLogs(testOTLPLogs(time.Now()))
+	require.NoError(t, err)
+
+	resp, err := http.Post("http://"+addr+"/v1/logs", "application/json", bytes.NewReader(bb))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	select {
+	case e := <-entries:
+		require.True(t, strings.HasPrefix(e.Line, "payment failed"))
+		require.Contains(t, e.Line, "traceID=0102030405060708090a0b0c0d0e0f10")
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for entry")
+	}
+}
+
+func testOTLPLogs(ts time.Time) pdata.Logs {
+	ld := pdata.NewLogs()
+	rl := ld.ResourceLogs().AppendEmpty()
+	rl.Resource().Attributes().InsertString("service.name", "checkout")
+	rl.Resource().Attributes().InsertString("host", "node-a")
+
+	lr := rl.InstrumentationLibraryLogs().AppendEmpty().LogRecords().AppendEmpty()
+	lr.SetTimestamp(pdata.NewTimestampFromTime(ts))
+	lr.SetSeverityText("ERROR")
+	lr.Body().SetStringVal("payment failed")
+	lr.Attributes().InsertString("http.method", "POST")
+	lr.Attributes().InsertString("password", "hunter2")
+	lr.SetTraceID(pdata.NewTraceID([16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}))
+	lr.SetSpanID(pdata.NewSpanID([8]byte{1, 2, 3, 4, 5, 6, 7, 8}))
+	return ld
+}
+
+func TestInstance_ApplyConfig_OTLPFailure(t *testing.T) {
+	// Keep the port in use so the receiver fails to start.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+
+	var cfg Config
+	err = yaml.UnmarshalStrict([]byte(util.Untab(`
+positions_directory: `+t.TempDir()+`
+configs:
+- name: default
+  clients:
+  - url: http://127.0.0.1:0/loki/api/v1/push
+  otlp:
+    protocols:
+      http:
+        endpoint: `+lis.Addr().String()+`
+	`)), &cfg)
+	require.NoError(t, err)
+
+	inst := &Instance{
+		reg: util.WrapWithUnregisterer(prometheus.NewRegistry()),
+		log: util.TestLogger(t),
+	}
+	require.Error(t, inst.ApplyConfig(cfg.Configs[0]))
+
+	// Components started before the receiver failed must be stopped.
+	require.Nil(t, inst.client)
+	require.Nil(t, inst.limiter)
+	require.Nil(t, inst.targets)
+}
@@ -0,0 +1,453 @@
+package logs
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/kv"
+	"github.com/grafana/dskit/kv/codec"
+	"github.com/grafana/loki/clients/pkg/promtail/positions"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
+)
+
+// Backends which can be used for storing positions.
+const (
+	PositionsBackendKubernetes = "kubernetes"
+	PositionsBackendKV         = "kv"
+)
+
+// nodeNameEnv is the environment variable holding the name of the node the
+// agent runs on, usually set through the Kubernetes downward API. It is used
+// as the default key of positions stores.
+const nodeNameEnv = "NODE_NAME"
+
+// positionsStoreTimeout is the timeout for individual requests to a
+// positions store.
+const positionsStoreTimeout = 10 * time.Second
+
+// DefaultKubernetesPositionsConfig holds default settings for a
+// KubernetesPositionsConfig.
+var DefaultKubernetesPositionsConfig = KubernetesPositionsConfig{
+	NamePrefix: "grafana-agent-positions",
+}
+
+// PositionsStoreConfig configures storing the positions of a logs instance
+// outside of the local positions file. The positions file is restored from
+// the store when the instance starts, and the store is updated with the
+// contents of the positions file at every positions sync_period.
+type PositionsStoreConfig struct {
+	// Backend is either kubernetes or kv.
+	Backend string `yaml:"backend,omitempty"`
+
+	// Key identifies the positions of this agent in the store. Agents which
+	// collect logs from the same files, such as DaemonSet pods scheduled to
+	// the same node, should use the same key. Defaults to the NODE_NAME
+	// environment variable. The kv backend falls back to the hostname when
+	// NODE_NAME isn't set; the kubernetes backend requires one of them, since
+	// the hostname of a pod changes when it's rescheduled.
+	Key string `yaml:"key,omitempty"`
+
+	Kubernetes KubernetesPositionsConfig `yaml:"kubernetes,omitempty"`
+	KVStore    kv.Config                 `yaml:"kvstore,omitempty"`
+}
+
+// KubernetesPositionsConfig configures storing positions in a Kubernetes
+// ConfigMap. One ConfigMap is used per key, holding the positions of every
+// logs instance using that key.
+type KubernetesPositionsConfig struct {
+	// KubeconfigPath is the path to a kubeconfig file. The in-cluster config
+	// is used when empty.
+	KubeconfigPath string `yaml:"kubeconfig_path,omitempty"`
+	Namespace      string `yaml:"namespace,omitempty"`
+	NamePrefix     string `yaml:"name_prefix,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *PositionsStoreConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = PositionsStoreConfig{Kubernetes: DefaultKubernetesPositionsConfig}
+
+	// Defaults for the KV store are hidden behind flags. Register flags to a
+	// fake flagset just to set the defaults in the config.
+	fs := flag.NewFlagSet("temp", flag.PanicOnError)
+	c.KVStore.RegisterFlagsWithPrefix("logs.positions-store.", "positions/", fs)
+
+	type plain PositionsStoreConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate ensures that the PositionsStoreConfig is valid.
+func (c *PositionsStoreConfig) Validate() error {
+	switch c.Backend {
+	case PositionsBackendKubernetes:
+		if c.Kubernetes.Namespace == "" {
+			return fmt.Errorf("kubernetes positions store requires a namespace")
+		}
+		if c.Kubernetes.NamePrefix == "" {
+			return fmt.Errorf("kubernetes positions store requires a name_prefix")
+		}
+	case PositionsBackendKV:
+	default:
+		return fmt.Errorf("unsupported positions store backend %q, expected %q or %q", c.Backend, PositionsBackendKubernetes, PositionsBackendKV)
+	}
+	return nil
+}
+
+// key returns the configured key, defaulting to the node name and then,
+// outside of the kubernetes backend, to the hostname.
+func (c *PositionsStoreConfig) key() (string, error) {
+	if c.Key != "" {
+		return c.Key, nil
+	}
+	if nodeName := os.Getenv(nodeNameEnv); nodeName != "" {
+		return nodeName, nil
+	}
+	if c.Backend == PositionsBackendKubernetes {
+		return "", fmt.Errorf("kubernetes positions store requires a key or the %s environment variable to be set", nodeNameEnv)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("failed to get hostname for positions store key: %w", err)
+	}
+	return hostname, nil
+}
+
+// positionsStore stores the positions of a logs instance.
+type positionsStore interface {
+	// Get returns the stored positions. Returns nil if no positions are
+	// stored.
+	Get(ctx context.Context) (map[string]string, error)
+
+	// Put replaces the stored positions.
+	Put(ctx context.Context, positions map[string]string) error
+}
+
+// newPositionsStore creates a positionsStore for the logs instance with the
+// given name.
+func newPositionsStore(l log.Logger, reg prometheus.Registerer, instance string, c *PositionsStoreConfig) (positionsStore, error) {
+	key, err := c.key()
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.Backend {
+	case PositionsBackendKubernetes:
+		restConfig, err := clientcmd.BuildConfigFromFlags("", c.Kubernetes.KubeconfigPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+		}
+		client, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+		}
+		return newKubernetesPositionsStore(client, c.Kubernetes, key, instance), nil
+	case PositionsBackendKV:
+		client, err := kv.NewClient(c.KVStore, codec.String{}, kv.RegistererWithKVName(reg, "logs-positions"), l)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create kv client: %w", err)
+		}
+		return &kvPositionsStore{client: client, key: key + "/" + instance}, nil
+	default:
+		return nil, fmt.Errorf("unsupported positions store backend %q", c.Backend)
+	}
+}
+
+func encodePositions(p map[string]string) (string, error) {
+	bb, err := yaml.Marshal(positions.File{Positions: p})
+	return string(bb), err
+}
+
+func decodePositions(s string) (map[string]string, error) {
+	var f positions.File
+	if err := yaml.Unmarshal([]byte(s), &f); err != nil {
+		return nil, err
+	}
+	if f.Positions == nil {
+		f.Positions = map[string]string{}
+	}
+	return f.Positions, nil
+}
+
+// kvPositionsStore stores positions in a dskit KV store.
+type kvPositionsStore struct {
+	client kv.Client
+	key    string
+}
+
+func (s *kvPositionsStore) Get(ctx context.Context) (map[string]string, error) {
+	v, err := s.client.Get(ctx, s.key)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	return decodePositions(v.(string))
+}
+
+func (s *kvPositionsStore) Put(ctx context.Context, p map[string]string) error {
+	enc, err := encodePositions(p)
+	if err != nil {
+		return err
+	}
+	return s.client.CAS(ctx, s.key, func(in interface{}) (out interface{}, retry bool, err error) {
+		return enc, true, nil
+	})
+}
+
+// invalidConfigMapChars matches characters which are not allowed in
+// ConfigMap names and keys.
+var invalidConfigMapChars = regexp.MustCompile(`[^-.a-z0-9]`)
+
+// kubernetesPositionsStore stores positions in a ConfigMap.
+type kubernetesPositionsStore struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	dataKey   string
+}
+
+func newKubernetesPositionsStore(client kubernetes.Interface, c KubernetesPositionsConfig, key, instance string) *kubernetesPositionsStore {
+	return &kubernetesPositionsStore{
+		client:    client,
+		namespace: c.Namespace,
+		name:      c.NamePrefix + "-" + invalidConfigMapChars.ReplaceAllString(strings.ToLower(key), "-"),
+		dataKey:   invalidConfigMapChars.ReplaceAllString(strings.ToLower(instance), "-") + ".yaml",
+	}
+}
+
+func (s *kubernetesPositionsStore) Get(ctx context.Context) (map[string]string, error) {
+	cm, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	data, ok := cm.Data[s.dataKey]
+	if !ok {
+		return nil, nil
+	}
+	return decodePositions(data)
+}
+
+func (s *kubernetesPositionsStore) Put(ctx context.Context, p map[string]string) error {
+	enc, err := encodePositions(p)
+	if err != nil {
+		return err
+	}
+
+	// Other logs instances may update the same ConfigMap concurrently.
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMaps := s.client.CoreV1().ConfigMaps(s.namespace)
+
+		cm, err := configMaps.Get(ctx, s.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = configMaps.Create(ctx, &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: s.name, Namespace: s.namespace},
+				Data:       map[string]string{s.dataKey: enc},
+			}, metav1.CreateOptions{})
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[s.dataKey] = enc
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// positionsMirror keeps a positions store up to date with the local positions
+// file of a logs instance.
+type positionsMirror struct {
+	log   log.Logger
+	cfg   positions.Config
+	store positionsStore
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	stopOnce sync.Once
+
+	started bool
+
+	// last is the most recent set of positions written to the store. It is
+	// only accessed by run and Stop.
+	last map[string]string
+
+	syncs        prometheus.Counter
+	syncFailures prometheus.Counter
+}
+
+// newPositionsMirror creates a new positionsMirror. Restore should be called
+// before the positions file is used, and Start afterwards.
+func newPositionsMirror(l log.Logger, reg prometheus.Registerer, cfg positions.Config, store positionsStore) (*positionsMirror, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &positionsMirror{
+		log:   l,
+		cfg:   cfg,
+		store: store,
+
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+
+		syncs: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "agent_logs_positions_store_syncs_total",
+			Help: "Total number of times positions were written to the positions store.",
+		}),
+		syncFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "agent_logs_positions_store_sync_failures_total",
+			Help: "Total number of failures writing positions to the positions store.",
+		}),
+	}
+
+	for _, c := range []prometheus.Collector{m.syncs, m.syncFailures} {
+		if err := reg.Register(c); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Restore merges the stored positions into the local positions file.
+// Positions in the local file take precedence over stored positions.
+func (m *positionsMirror) Restore() error {
+	ctx, cancel := context.WithTimeout(m.ctx, positionsStoreTimeout)
+	defer cancel()
+
+	stored, err := m.store.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get stored positions: %w", err)
+	}
+	local, err := m.readLocal()
+	if err != nil {
+		return err
+	}
+	m.last = stored
+
+	merged := make(map[string]string, len(stored)+len(local))
+	for k, v := range stored {
+		merged[k] = v
+	}
+	for k, v := range local {
+		merged[k] = v
+	}
+	if len(merged) == len(local) {
+		return nil
+	}
+
+	level.Info(m.log).Log("msg", "restoring positions from positions store", "restored", len(merged)-len(local))
+	return writePositions(m.cfg.PositionsFile, merged)
+}
+
+// Start starts writing positions to the store at every sync period.
+func (m *positionsMirror) Start() {
+	m.started = true
+	go m.run()
+}
+
+func (m *positionsMirror) run() {
+	defer close(m.done)
+
+	t := time.NewTicker(m.cfg.SyncPeriod)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-t.C:
+			m.sync(m.ctx)
+		}
+	}
+}
+
+// sync writes the local positions to the store if they changed.
+func (m *positionsMirror) sync(ctx context.Context) {
+	local, err := m.readLocal()
+	if err != nil {
+		level.Warn(m.log).Log("msg", "failed to read positions file", "err", err)
+		return
+	}
+	if m.last != nil && reflect.DeepEqual(local, m.last) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, positionsStoreTimeout)
+	defer cancel()
+
+	m.syncs.Inc()
+	if err := m.store.Put(ctx, local); err != nil {
+		level.Warn(m.log).Log("msg", "failed to write positions to positions store", "err", err)
+		m.syncFailures.Inc()
+		return
+	}
+	m.last = local
+}
+
+func (m *positionsMirror) readLocal() (map[string]string, error) {
+	buf, err := ioutil.ReadFile(filepath.Clean(m.cfg.PositionsFile))
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read positions file: %w", err)
+	}
+
+	p, err := decodePositions(string(buf))
+	if err != nil {
+		if m.cfg.IgnoreInvalidYaml {
+			return map[string]string{}, nil
+		}
+		return nil, fmt.Errorf("invalid positions file: %w", err)
+	}
+	return p, nil
+}
+
+// Stop stops the mirror, writing the local positions to the store one last
+// time. Stop should be called after the positions file was last written.
+func (m *positionsMirror) Stop() {
+	m.stopOnce.Do(func() {
+		m.cancel()
+		if m.started {
+			<-m.done
+		}
+		m.sync(context.Background())
+	})
+}
+
+// writePositions atomically writes positions to path.
+func writePositions(path string, p map[string]string) error {
+	enc, err := encodePositions(p)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + "-tmp"
+	if err := ioutil.WriteFile(tmp, []byte(enc), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
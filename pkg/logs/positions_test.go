@@ -0,0 +1,200 @@
+package logs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/agent/pkg/util"
+	"github.com/grafana/loki/clients/pkg/promtail/positions"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPositionsStoreConfig_Unmarshal(t *testing.T) {
+	tt := []struct {
+		name   string
+		cfg    string
+		expect string
+	}{
+		{
+			name: "kv",
+			cfg: `
+backend: kv
+kvstore:
+  store: inmemory`,
+		},
+		{
+			name: "kubernetes",
+			cfg: `
+backend: kubernetes
+kubernetes:
+  namespace: monitoring`,
+		},
+		{
+			name:   "kubernetes without namespace",
+			cfg:    `backend: kubernetes`,
+			expect: "kubernetes positions store requires a namespace",
+		},
+		{
+			name:   "bad backend",
+			cfg:    `backend: s3`,
+			expect: `unsupported positions store backend "s3", expected "kubernetes" or "kv"`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var c PositionsStoreConfig
+			err := yaml.UnmarshalStrict([]byte(tc.cfg), &c)
+			if tc.expect == "" {
+				require.NoError(t, err)
+				require.Equal(t, "positions/", c.KVStore.Prefix)
+				require.Equal(t, DefaultKubernetesPositionsConfig.NamePrefix, c.Kubernetes.NamePrefix)
+				return
+			}
+			require.EqualError(t, err, tc.expect)
+		})
+	}
+}
+
+func TestPositionsStoreConfig_Key(t *testing.T) {
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	tt := []struct {
+		name     string
+		cfg      PositionsStoreConfig
+		nodeName string
+		expect   string
+		err      string
+	}{
+		{
+			name:     "explicit key",
+			cfg:      PositionsStoreConfig{Backend: PositionsBackendKubernetes, Key: "node-a"},
+			nodeName: "node-b",
+			expect:   "node-a",
+		},
+		{
+			name:     "node name",
+			cfg:      PositionsStoreConfig{Backend: PositionsBackendKubernetes},
+			nodeName: "node-b",
+			expect:   "node-b",
+		},
+		{
+			name: "kubernetes without node name",
+			cfg:  PositionsStoreConfig{Backend: PositionsBackendKubernetes},
+			err:  "kubernetes positions store requires a key or the NODE_NAME environment variable to be set",
+		},
+		{
+			name:   "kv without node name",
+			cfg:    PositionsStoreConfig{Backend: PositionsBackendKV},
+			expect: hostname,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(nodeNameEnv, tc.nodeName)
+
+			key, err := tc.cfg.key()
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, key)
+		})
+	}
+}
+
+func TestPositionsMirror(t *testing.T) {
+	var storeCfg PositionsStoreConfig
+	err := yaml.UnmarshalStrict([]byte(util.Untab(`
+backend: kv
+key: node-a
+kvstore:
+  store: inmemory
+	`)), &storeCfg)
+	require.NoError(t, err)
+
+	store, err := newPositionsStore(util.TestLogger(t), prometheus.NewRegistry(), "default", &storeCfg)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(context.Background(), map[string]string{
+		"/var/log/a.log": "100",
+		"/var/log/b.log": "200",
+	}))
+
+	// The local file was lost but has a newer position for one file.
+	cfg := positions.Config{
+		PositionsFile: filepath.Join(t.TempDir(), "positions.yml"),
+		SyncPeriod:    50 * time.Millisecond,
+	}
+	require.NoError(t, writePositions(cfg.PositionsFile, map[string]string{"/var/log/b.log": "250"}))
+
+	m, err := newPositionsMirror(util.TestLogger(t), prometheus.NewRegistry(), cfg, store)
+	require.NoError(t, err)
+	require.NoError(t, m.Restore())
+	require.Equal(t, map[string]string{
+		"/var/log/a.log": "100",
+		"/var/log/b.log": "250",
+	}, readTestPositions(t, cfg.PositionsFile))
+
+	// Changes to the local file are written to the store at every sync period.
+	m.Start()
+	require.NoError(t, writePositions(cfg.PositionsFile, map[string]string{"/var/log/a.log": "300"}))
+	require.Eventually(t, func() bool {
+		p, err := store.Get(context.Background())
+		return err == nil && p["/var/log/a.log"] == "300"
+	}, time.Second, 10*time.Millisecond)
+
+	// Stopping writes the final positions.
+	require.NoError(t, writePositions(cfg.PositionsFile, map[string]string{"/var/log/a.log": "400"}))
+	m.Stop()
+	p, err := store.Get(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"/var/log/a.log": "400"}, p)
+}
+
+func TestKubernetesPositionsStore(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	cfg := KubernetesPositionsConfig{Namespace: "monitoring", NamePrefix: "grafana-agent-positions"}
+
+	a := newKubernetesPositionsStore(client, cfg, "Node_A", "default")
+	b := newKubernetesPositionsStore(client, cfg, "Node_A", "system")
+
+	p, err := a.Get(context.Background())
+	require.NoError(t, err)
+	require.Nil(t, p)
+
+	require.NoError(t, a.Put(context.Background(), map[string]string{"/var/log/a.log": "100"}))
+	require.NoError(t, b.Put(context.Background(), map[string]string{"/var/log/syslog": "200"}))
+	require.NoError(t, a.Put(context.Background(), map[string]string{"/var/log/a.log": "150"}))
+
+	p, err = a.Get(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"/var/log/a.log": "150"}, p)
+
+	// Both instances share a ConfigMap for the key.
+	cm, err := client.CoreV1().ConfigMaps("monitoring").Get(context.Background(), "grafana-agent-positions-node-a", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, cm.Data, 2)
+	require.Contains(t, cm.Data, "default.yaml")
+	require.Contains(t, cm.Data, "system.yaml")
+}
+
+func readTestPositions(t *testing.T, path string) map[string]string {
+	t.Helper()
+
+	bb, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	p, err := decodePositions(string(bb))
+	require.NoError(t, err)
+	return p
+}
@@ -342,6 +342,19 @@ func (a *Agent) Config() Config { return a.cfg }
 // InstanceManager returns the instance manager used by this Agent.
 func (a *Agent) InstanceManager() instance.Manager { return a.mm }
 
+// Owns checks to see if a key is owned by this agent in the scraping service
+// ring. Owns will return an error if the scraping service isn't enabled.
+func (a *Agent) Owns(key string) (bool, error) {
+	return a.cluster.Owns(key)
+}
+
+// ClusterPeers returns the addresses of the healthy agents in the scraping
+// service ring. ClusterPeers will return an error if the scraping service
+// isn't enabled.
+func (a *Agent) ClusterPeers() ([]string, error) {
+	return a.cluster.Peers()
+}
+
 // Stop stops the agent and all its instances.
 func (a *Agent) Stop() {
 	a.mut.Lock()
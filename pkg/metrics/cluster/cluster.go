@@ -145,6 +145,19 @@ func (c *Cluster) ApplyConfig(cfg Config) error {
 	return nil
 }
 
+// Owns checks to see if a key is owned by this agent. Owns will return an
+// error if the cluster isn't enabled, the ring is empty, or there aren't
+// enough healthy nodes.
+func (c *Cluster) Owns(key string) (bool, error) {
+	return c.node.Owns(key)
+}
+
+// Peers returns the addresses of the healthy agents in the cluster. Peers
+// will return an error if the cluster isn't enabled or the ring is empty.
+func (c *Cluster) Peers() ([]string, error) {
+	return c.node.Peers()
+}
+
 // WireAPI injects routes into the provided mux router for the config
 // management API.
 func (c *Cluster) WireAPI(r *mux.Router) {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"hash/fnv"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -362,6 +363,10 @@ func (n *node) Owns(key string) (bool, error) {
 	n.mut.RLock()
 	defer n.mut.RUnlock()
 
+	if n.ring == nil {
+		return false, fmt.Errorf("ring is not running")
+	}
+
 	rs, err := n.ring.Get(keyHash(key), ring.Write, nil, nil, nil)
 	if err != nil {
 		return false, err
@@ -374,6 +379,28 @@ func (n *node) Owns(key string) (bool, error) {
 	return false, nil
 }
 
+// Peers returns the addresses of the healthy nodes in the ring, sorted. Peers
+// will return an error if the ring is empty.
+func (n *node) Peers() ([]string, error) {
+	n.mut.RLock()
+	defer n.mut.RUnlock()
+
+	if n.ring == nil {
+		return nil, fmt.Errorf("ring is not running")
+	}
+
+	rs, err := n.ring.GetAllHealthy(ring.Read)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(rs.Instances))
+	for _, inst := range rs.Instances {
+		addrs = append(addrs, inst.Addr)
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
 func keyHash(key string) uint32 {
 	h := fnv.New32()
 	_, _ = h.Write([]byte(key))
@@ -4,6 +4,7 @@ import (
 	"sync"
 
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/weaveworks/common/logging"
 
 	cortex_log "github.com/cortexproject/cortex/pkg/util/log"
@@ -18,6 +19,10 @@ type Logger struct {
 	mut sync.RWMutex
 	l   log.Logger
 
+	// lvl filters the log lines passed to tees.
+	lvl  level.Option
+	tees []*tee
+
 	// makeLogger will default to defaultLogger. It's a struct
 	// member to make testing work properly.
 	makeLogger func(*Config) (log.Logger, error)
@@ -35,7 +40,8 @@ func NewLoggerFromLevel(lvl logging.Level, fmt logging.Format) *Logger {
 		panic(err)
 	}
 	return &Logger{
-		l: logger,
+		l:   logger,
+		lvl: lvl.Gokit,
 	}
 }
 
@@ -58,9 +64,53 @@ func (l *Logger) ApplyConfig(cfg *Config) error {
 	}
 
 	l.l = newLogger
+	l.lvl = cfg.LogLevel.Gokit
+	for _, t := range l.tees {
+		t.filtered = filterLevel(t.inner, l.lvl)
+	}
 	return nil
 }
 
+// tee is a logger registered through Logger.Tee.
+type tee struct {
+	inner    log.Logger
+	filtered log.Logger
+}
+
+// filterLevel filters the log lines passed to t by lvl. lvl may be nil when
+// the log level isn't configured.
+func filterLevel(t log.Logger, lvl level.Option) log.Logger {
+	if lvl == nil {
+		return t
+	}
+	return level.NewFilter(t, lvl)
+}
+
+// Tee registers t to also receive the log lines written to l which pass the
+// configured log level. t is called synchronously by the goroutine writing
+// the log line, so it must not block or log to l. Errors from t are ignored.
+//
+// The returned function unregisters t.
+func (l *Logger) Tee(t log.Logger) (unregister func()) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	registered := &tee{inner: t, filtered: filterLevel(t, l.lvl)}
+	l.tees = append(l.tees, registered)
+
+	return func() {
+		l.mut.Lock()
+		defer l.mut.Unlock()
+
+		for i, t := range l.tees {
+			if t == registered {
+				l.tees = append(l.tees[:i:i], l.tees[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
 func defaultLogger(cfg *Config) (log.Logger, error) {
 	return makeDefaultLogger(cfg.LogLevel, cfg.LogFormat)
 }
@@ -81,7 +131,12 @@ func makeDefaultLogger(lvl logging.Level, fmt logging.Format) (log.Logger, error
 func (l *Logger) Log(kvps ...interface{}) error {
 	l.mut.RLock()
 	defer l.mut.RUnlock()
-	return l.l.Log(kvps...)
+
+	err := l.l.Log(kvps...)
+	for _, t := range l.tees {
+		_ = t.filtered.Log(kvps...)
+	}
+	return err
 }
 
 // GoKitLogger creates a logging.Interface from a log.Logger.
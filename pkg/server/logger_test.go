@@ -55,3 +55,30 @@ log_format: json`
 		"msg":"this should appear"
 	}`, buf.String())
 }
+
+func TestLogger_Tee(t *testing.T) {
+	var buf, teeBuf bytes.Buffer
+	makeLogger := func(cfg *Config) (log.Logger, error) {
+		return level.NewFilter(log.NewLogfmtLogger(log.NewSyncWriter(&buf)), cfg.LogLevel.Gokit), nil
+	}
+
+	var cfg Config
+	require.NoError(t, yaml.Unmarshal([]byte(`log_level: info`), &cfg))
+	l := newLogger(&cfg, makeLogger)
+
+	unregister := l.Tee(log.NewLogfmtLogger(&teeBuf))
+	level.Debug(l).Log("msg", "filtered by level")
+	level.Info(l).Log("msg", "teed")
+	require.Equal(t, "level=info msg=teed\n", teeBuf.String())
+	require.Equal(t, buf.String(), teeBuf.String())
+
+	// The level of tees follows the config.
+	require.NoError(t, yaml.Unmarshal([]byte(`log_level: debug`), &cfg))
+	require.NoError(t, l.ApplyConfig(&cfg))
+	level.Debug(l).Log("msg", "debug")
+	require.Equal(t, "level=info msg=teed\nlevel=debug msg=debug\n", teeBuf.String())
+
+	unregister()
+	level.Info(l).Log("msg", "not teed")
+	require.Equal(t, "level=info msg=teed\nlevel=debug msg=debug\n", teeBuf.String())
+}
@@ -0,0 +1,121 @@
+package adaptivesamplingprocessor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/consumer"
+)
+
+const (
+	// TypeStr is the unique identifier for the Adaptive Sampling processor.
+	TypeStr = "adaptive_sampling"
+
+	// DefaultDecisionWait is the default time to wait for the spans of a
+	// trace before sampling it.
+	DefaultDecisionWait = 5 * time.Second
+	// DefaultAdjustInterval is the default interval at which sampling
+	// probabilities are adjusted.
+	DefaultAdjustInterval = 5 * time.Second
+	// DefaultMaxTraces is the default number of traces waiting for a
+	// decision kept in memory.
+	DefaultMaxTraces = 50000
+)
+
+// Config holds the configuration for the Adaptive Sampling processor.
+type Config struct {
+	config.ProcessorSettings `mapstructure:",squash"`
+
+	AdaptiveSamplingConfig *AdaptiveSamplingConfig `mapstructure:"adaptive_sampling"`
+}
+
+// AdaptiveSamplingConfig holds config information for adaptive sampling.
+type AdaptiveSamplingConfig struct {
+	// TracesPerSecond is the throughput target of services without a
+	// target in Targets.
+	TracesPerSecond float64 `mapstructure:"traces_per_second" yaml:"traces_per_second"`
+	// PerOperation applies the throughput targets to each root operation of
+	// a service instead of the service as a whole.
+	PerOperation bool `mapstructure:"per_operation" yaml:"per_operation,omitempty"`
+	// Targets override the throughput target of services and operations.
+	Targets []TargetConfig `mapstructure:"targets" yaml:"targets,omitempty"`
+
+	// DecisionWait is the time to wait for the spans of a trace before
+	// sampling it.
+	DecisionWait time.Duration `mapstructure:"decision_wait" yaml:"decision_wait,omitempty"`
+	// AdjustInterval is the interval at which sampling probabilities are
+	// adjusted to the observed throughput.
+	AdjustInterval time.Duration `mapstructure:"adjust_interval" yaml:"adjust_interval,omitempty"`
+	// MaxTraces is the maximum number of traces waiting for a decision.
+	// Once reached, the oldest traces are sampled early.
+	MaxTraces int `mapstructure:"max_traces" yaml:"max_traces,omitempty"`
+}
+
+// TargetConfig is the throughput target of a service, or of one of its root
+// operations if Operation is set.
+type TargetConfig struct {
+	Service         string  `mapstructure:"service" yaml:"service"`
+	Operation       string  `mapstructure:"operation" yaml:"operation,omitempty"`
+	TracesPerSecond float64 `mapstructure:"traces_per_second" yaml:"traces_per_second"`
+}
+
+// Validate ensures that the AdaptiveSamplingConfig is valid.
+func (c *AdaptiveSamplingConfig) Validate() error {
+	if c.TracesPerSecond <= 0 {
+		return fmt.Errorf("traces_per_second must be greater than 0")
+	}
+	if c.DecisionWait < 0 {
+		return fmt.Errorf("decision_wait must not be negative")
+	}
+	if c.AdjustInterval < 0 {
+		return fmt.Errorf("adjust_interval must not be negative")
+	}
+	if c.MaxTraces < 0 {
+		return fmt.Errorf("max_traces must not be negative")
+	}
+
+	targets := make(map[budgetKey]struct{}, len(c.Targets))
+	for i, t := range c.Targets {
+		if t.Service == "" {
+			return fmt.Errorf("target at index %d is missing a service", i)
+		}
+		if t.TracesPerSecond <= 0 {
+			return fmt.Errorf("target for service %s must have traces_per_second greater than 0", t.Service)
+		}
+		key := budgetKey{service: t.Service, operation: t.Operation}
+		if _, exist := targets[key]; exist {
+			return fmt.Errorf("found multiple targets for service %s and operation %q", t.Service, t.Operation)
+		}
+		targets[key] = struct{}{}
+	}
+	return nil
+}
+
+// NewFactory returns a new factory for the Adaptive Sampling processor.
+func NewFactory() component.ProcessorFactory {
+	return component.NewProcessorFactory(
+		TypeStr,
+		createDefaultConfig,
+		component.WithTracesProcessor(createTraceProcessor),
+	)
+}
+
+func createDefaultConfig() config.Processor {
+	return &Config{
+		ProcessorSettings: config.NewProcessorSettings(config.NewComponentIDWithName(TypeStr, TypeStr)),
+	}
+}
+
+func createTraceProcessor(
+	_ context.Context,
+	_ component.ProcessorCreateSettings,
+	cfg config.Processor,
+	nextConsumer consumer.Traces,
+) (component.TracesProcessor, error) {
+
+	oCfg := cfg.(*Config)
+	return newTraceProcessor(nextConsumer, oCfg.AdaptiveSamplingConfig)
+}
@@ -0,0 +1,337 @@
+// Package adaptivesamplingprocessor samples traces to meet per-service
+// throughput targets, adjusting the sampling probability of each service to
+// its traffic while always keeping error traces.
+package adaptivesamplingprocessor
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	util "github.com/cortexproject/cortex/pkg/util/log"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/agent/pkg/traces/contextkeys"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/batchpersignal"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenterror"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/model/pdata"
+	semconv "go.opentelemetry.io/collector/model/semconv/v1.6.1"
+)
+
+// maxCheckInterval is the maximum interval at which traces waiting for a
+// decision are checked.
+const maxCheckInterval = time.Second
+
+// pendingTrace is a trace waiting for a decision.
+type pendingTrace struct {
+	id       pdata.TraceID
+	traces   pdata.Traces
+	received time.Time
+}
+
+// decidedTrace remembers the decision made for a trace, so that spans
+// received after the decision are handled the same way.
+type decidedTrace struct {
+	sampled    bool
+	expiration time.Time
+}
+
+var _ component.TracesProcessor = (*processor)(nil)
+
+type processor struct {
+	nextConsumer consumer.Traces
+	logger       log.Logger
+
+	sampler        *sampler
+	decisionWait   time.Duration
+	adjustInterval time.Duration
+	maxTraces      int
+
+	mut     sync.Mutex
+	pending map[pdata.TraceID]*list.Element
+	// order holds *pendingTrace, oldest first.
+	order   *list.List
+	decided map[pdata.TraceID]decidedTrace
+
+	reg         prometheus.Registerer
+	tracesTotal *prometheus.CounterVec
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newTraceProcessor(nextConsumer consumer.Traces, cfg *AdaptiveSamplingConfig) (component.TracesProcessor, error) {
+	if nextConsumer == nil {
+		return nil, componenterror.ErrNilNextConsumer
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("adaptive sampling processor requires a config")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	p := &processor{
+		nextConsumer: nextConsumer,
+		logger:       log.With(util.Logger, "component", "adaptive sampling"),
+
+		decisionWait:   cfg.DecisionWait,
+		adjustInterval: cfg.AdjustInterval,
+		maxTraces:      cfg.MaxTraces,
+
+		pending: make(map[pdata.TraceID]*list.Element),
+		order:   list.New(),
+		decided: make(map[pdata.TraceID]decidedTrace),
+
+		tracesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "traces",
+			Name:      "adaptive_sampling_traces_total",
+			Help:      "Total count of traces by sampling decision",
+		}, []string{"decision"}),
+
+		closeCh: make(chan struct{}),
+	}
+	if p.decisionWait == 0 {
+		p.decisionWait = DefaultDecisionWait
+	}
+	if p.adjustInterval == 0 {
+		p.adjustInterval = DefaultAdjustInterval
+	}
+	if p.maxTraces == 0 {
+		p.maxTraces = DefaultMaxTraces
+	}
+	p.sampler = newSampler(cfg, p.adjustInterval)
+
+	for _, d := range []decision{decisionDropped, decisionSampled, decisionError} {
+		p.tracesTotal.WithLabelValues(d.String())
+	}
+
+	return p, nil
+}
+
+func (p *processor) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	now := time.Now()
+	sampled := pdata.NewTraces()
+
+	p.mut.Lock()
+	for _, trace := range batchpersignal.SplitTraces(td) {
+		id, ok := traceID(trace)
+		if !ok {
+			continue
+		}
+
+		if d, ok := p.decided[id]; ok {
+			if d.sampled {
+				trace.ResourceSpans().MoveAndAppendTo(sampled.ResourceSpans())
+			}
+			continue
+		}
+
+		if e, ok := p.pending[id]; ok {
+			trace.ResourceSpans().MoveAndAppendTo(e.Value.(*pendingTrace).traces.ResourceSpans())
+			continue
+		}
+		p.pending[id] = p.order.PushBack(&pendingTrace{id: id, traces: trace, received: now})
+
+		// Sample the oldest traces early if there are too many waiting.
+		for p.order.Len() > p.maxTraces {
+			p.decide(p.order.Front(), now, sampled)
+		}
+	}
+	p.mut.Unlock()
+
+	return p.forward(ctx, sampled)
+}
+
+// traceID returns the trace ID of a trace returned by SplitTraces.
+func traceID(td pdata.Traces) (pdata.TraceID, bool) {
+	rss := td.ResourceSpans()
+	if rss.Len() == 0 || rss.At(0).InstrumentationLibrarySpans().Len() == 0 {
+		return pdata.TraceID{}, false
+	}
+	spans := rss.At(0).InstrumentationLibrarySpans().At(0).Spans()
+	if spans.Len() == 0 {
+		return pdata.TraceID{}, false
+	}
+	return spans.At(0).TraceID(), true
+}
+
+// decide samples the pending trace in e, appending it to sampled if it's
+// kept. Must be called with mut held.
+func (p *processor) decide(e *list.Element, now time.Time, sampled pdata.Traces) {
+	pt := p.order.Remove(e).(*pendingTrace)
+	delete(p.pending, pt.id)
+
+	service, operation, failed := describe(pt.traces)
+	d := p.sampler.sample(pt.id, service, operation, failed)
+	p.tracesTotal.WithLabelValues(d.String()).Inc()
+
+	p.decided[pt.id] = decidedTrace{
+		sampled:    d != decisionDropped,
+		expiration: now.Add(p.decisionWait),
+	}
+	if d != decisionDropped {
+		pt.traces.ResourceSpans().MoveAndAppendTo(sampled.ResourceSpans())
+	}
+}
+
+// describe returns the service and name of the root span of a trace, and
+// whether any of its spans failed. If the root span wasn't received, the
+// first span is used instead.
+func describe(td pdata.Traces) (service, operation string, failed bool) {
+	var foundRoot, foundSpan bool
+	for i := 0; i < td.ResourceSpans().Len(); i++ {
+		rs := td.ResourceSpans().At(i)
+
+		var svc string
+		if att, ok := rs.Resource().Attributes().Get(semconv.AttributeServiceName); ok {
+			svc = att.AsString()
+		}
+
+		for j := 0; j < rs.InstrumentationLibrarySpans().Len(); j++ {
+			spans := rs.InstrumentationLibrarySpans().At(j).Spans()
+
+			for k := 0; k < spans.Len(); k++ {
+				span := spans.At(k)
+				if span.Status().Code() == pdata.StatusCodeError {
+					failed = true
+				}
+
+				isRoot := span.ParentSpanID().IsEmpty()
+				if foundRoot || (foundSpan && !isRoot) {
+					continue
+				}
+				service, operation = svc, span.Name()
+				foundSpan, foundRoot = true, isRoot
+			}
+		}
+	}
+	return service, operation, failed
+}
+
+func (p *processor) forward(ctx context.Context, td pdata.Traces) error {
+	if td.SpanCount() == 0 {
+		return nil
+	}
+	return p.nextConsumer.ConsumeTraces(ctx, td)
+}
+
+// decideExpired samples the traces which waited for decisionWait and forgets
+// expired decisions.
+func (p *processor) decideExpired(now time.Time) {
+	sampled := pdata.NewTraces()
+
+	p.mut.Lock()
+	for e := p.order.Front(); e != nil; e = p.order.Front() {
+		if now.Sub(e.Value.(*pendingTrace).received) < p.decisionWait {
+			break
+		}
+		p.decide(e, now, sampled)
+	}
+	for id, d := range p.decided {
+		if !now.Before(d.expiration) {
+			delete(p.decided, id)
+		}
+	}
+	p.mut.Unlock()
+
+	if err := p.forward(context.Background(), sampled); err != nil {
+		level.Error(p.logger).Log("msg", "failed to forward sampled traces", "err", err)
+	}
+}
+
+// decideAll samples all pending traces.
+func (p *processor) decideAll() {
+	now := time.Now()
+	sampled := pdata.NewTraces()
+
+	p.mut.Lock()
+	for e := p.order.Front(); e != nil; e = p.order.Front() {
+		p.decide(e, now, sampled)
+	}
+	p.mut.Unlock()
+
+	if err := p.forward(context.Background(), sampled); err != nil {
+		level.Error(p.logger).Log("msg", "failed to forward sampled traces", "err", err)
+	}
+}
+
+func (p *processor) run() {
+	defer p.wg.Done()
+
+	checkInterval := p.decisionWait / 2
+	if checkInterval > maxCheckInterval {
+		checkInterval = maxCheckInterval
+	}
+	checkTicker := time.NewTicker(checkInterval)
+	defer checkTicker.Stop()
+
+	adjustTicker := time.NewTicker(p.adjustInterval)
+	defer adjustTicker.Stop()
+	lastAdjust := time.Now()
+
+	for {
+		select {
+		case now := <-checkTicker.C:
+			p.decideExpired(now)
+		case now := <-adjustTicker.C:
+			p.sampler.adjust(now.Sub(lastAdjust))
+			lastAdjust = now
+		case <-p.closeCh:
+			return
+		}
+	}
+}
+
+func (p *processor) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{MutatesData: true}
+}
+
+// Start is invoked during service startup.
+func (p *processor) Start(ctx context.Context, _ component.Host) error {
+	if reg, ok := ctx.Value(contextkeys.PrometheusRegisterer).(prometheus.Registerer); ok && reg != nil {
+		cs := []prometheus.Collector{
+			p.tracesTotal,
+			p.sampler.probability,
+			p.sampler.receivedRate,
+			p.sampler.sampledRate,
+		}
+		for _, c := range cs {
+			if err := reg.Register(c); err != nil {
+				p.unregisterMetrics()
+				return err
+			}
+			p.reg = reg
+		}
+	}
+
+	p.wg.Add(1)
+	go p.run()
+	return nil
+}
+
+func (p *processor) unregisterMetrics() {
+	if p.reg == nil {
+		return
+	}
+	p.reg.Unregister(p.tracesTotal)
+	p.reg.Unregister(p.sampler.probability)
+	p.reg.Unregister(p.sampler.receivedRate)
+	p.reg.Unregister(p.sampler.sampledRate)
+	p.reg = nil
+}
+
+// Shutdown is invoked during service shutdown. Traces waiting for a
+// decision are sampled right away.
+func (p *processor) Shutdown(context.Context) error {
+	close(p.closeCh)
+	p.wg.Wait()
+	p.decideAll()
+	p.unregisterMetrics()
+	return nil
+}
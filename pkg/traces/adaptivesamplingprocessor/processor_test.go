@@ -0,0 +1,156 @@
+package adaptivesamplingprocessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/agent/pkg/traces/contextkeys"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/model/pdata"
+	semconv "go.opentelemetry.io/collector/model/semconv/v1.6.1"
+)
+
+func TestValidate(t *testing.T) {
+	tt := []struct {
+		name   string
+		cfg    AdaptiveSamplingConfig
+		expect string
+	}{
+		{name: "valid", cfg: AdaptiveSamplingConfig{TracesPerSecond: 10}},
+		{
+			name: "valid targets",
+			cfg: AdaptiveSamplingConfig{TracesPerSecond: 10, Targets: []TargetConfig{
+				{Service: "api", TracesPerSecond: 5},
+				{Service: "api", Operation: "/health", TracesPerSecond: 1},
+			}},
+		},
+		{name: "missing traces per second", expect: "traces_per_second must be greater than 0"},
+		{
+			name:   "negative decision wait",
+			cfg:    AdaptiveSamplingConfig{TracesPerSecond: 10, DecisionWait: -time.Second},
+			expect: "decision_wait must not be negative",
+		},
+		{
+			name:   "missing service",
+			cfg:    AdaptiveSamplingConfig{TracesPerSecond: 10, Targets: []TargetConfig{{TracesPerSecond: 5}}},
+			expect: "target at index 0 is missing a service",
+		},
+		{
+			name:   "missing target traces per second",
+			cfg:    AdaptiveSamplingConfig{TracesPerSecond: 10, Targets: []TargetConfig{{Service: "api"}}},
+			expect: "target for service api must have traces_per_second greater than 0",
+		},
+		{
+			name: "duplicate target",
+			cfg: AdaptiveSamplingConfig{TracesPerSecond: 10, Targets: []TargetConfig{
+				{Service: "api", TracesPerSecond: 5},
+				{Service: "api", TracesPerSecond: 1},
+			}},
+			expect: `found multiple targets for service api and operation ""`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.cfg.Validate()
+			if tc.expect == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tc.expect)
+		})
+	}
+}
+
+func TestConsumeTraces(t *testing.T) {
+	next := new(consumertest.TracesSink)
+	p, err := newTraceProcessor(next, &AdaptiveSamplingConfig{
+		TracesPerSecond: 1,
+		DecisionWait:    100 * time.Millisecond,
+		AdjustInterval:  time.Second,
+	})
+	require.NoError(t, err)
+
+	reg := prometheus.NewRegistry()
+	ctx := context.WithValue(context.Background(), contextkeys.PrometheusRegisterer, reg)
+	require.NoError(t, p.Start(ctx, nil))
+	t.Cleanup(func() { require.NoError(t, p.Shutdown(context.Background())) })
+
+	// The spans of a trace are sampled together once the decision wait is
+	// over. Only one trace fits in the budget of the interval, but the second
+	// one is kept as it failed.
+	require.NoError(t, p.ConsumeTraces(context.Background(), testTraces(testTraceID(1), "svc", true, false)))
+	require.NoError(t, p.ConsumeTraces(context.Background(), testTraces(testTraceID(1), "svc", false, false)))
+	require.NoError(t, p.ConsumeTraces(context.Background(), testTraces(testTraceID(2), "svc", true, false)))
+	require.NoError(t, p.ConsumeTraces(context.Background(), testTraces(testTraceID(2), "svc", false, true)))
+	require.NoError(t, p.ConsumeTraces(context.Background(), testTraces(testTraceID(3), "svc", true, false)))
+	require.Equal(t, 0, next.SpanCount())
+
+	require.Eventually(t, func() bool {
+		return next.SpanCount() == 4
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 1.0, testutil.ToFloat64(p.(*processor).tracesTotal.WithLabelValues("dropped")))
+	require.Equal(t, 1.0, testutil.ToFloat64(p.(*processor).tracesTotal.WithLabelValues("sampled")))
+	require.Equal(t, 1.0, testutil.ToFloat64(p.(*processor).tracesTotal.WithLabelValues("error")))
+
+	// Late spans follow the decision made for their trace.
+	require.NoError(t, p.ConsumeTraces(context.Background(), testTraces(testTraceID(1), "svc", false, false)))
+	require.NoError(t, p.ConsumeTraces(context.Background(), testTraces(testTraceID(3), "svc", false, false)))
+	require.Equal(t, 5, next.SpanCount())
+}
+
+func TestConsumeTraces_MaxTraces(t *testing.T) {
+	next := new(consumertest.TracesSink)
+	p, err := newTraceProcessor(next, &AdaptiveSamplingConfig{
+		TracesPerSecond: 100,
+		DecisionWait:    time.Hour,
+		MaxTraces:       1,
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Start(context.Background(), nil))
+
+	require.NoError(t, p.ConsumeTraces(context.Background(), testTraces(testTraceID(1), "svc", true, false)))
+	require.Equal(t, 0, next.SpanCount())
+
+	// The oldest trace is sampled early to make room for the new one.
+	require.NoError(t, p.ConsumeTraces(context.Background(), testTraces(testTraceID(2), "svc", true, false)))
+	require.Equal(t, 1, next.SpanCount())
+
+	// Traces waiting for a decision are sampled on shutdown.
+	require.NoError(t, p.Shutdown(context.Background()))
+	require.Equal(t, 2, next.SpanCount())
+}
+
+func TestDescribe(t *testing.T) {
+	td := testTraces(testTraceID(1), "frontend", false, false)
+	rs := td.ResourceSpans().AppendEmpty()
+	rs.Resource().Attributes().InsertString(semconv.AttributeServiceName, "backend")
+	root := rs.InstrumentationLibrarySpans().AppendEmpty().Spans().AppendEmpty()
+	root.SetTraceID(testTraceID(1))
+	root.SetName("root")
+
+	service, operation, failed := describe(td)
+	require.Equal(t, "backend", service)
+	require.Equal(t, "root", operation)
+	require.False(t, failed)
+}
+
+func testTraces(id pdata.TraceID, service string, root, failed bool) pdata.Traces {
+	td := pdata.NewTraces()
+	rs := td.ResourceSpans().AppendEmpty()
+	rs.Resource().Attributes().InsertString(semconv.AttributeServiceName, service)
+	span := rs.InstrumentationLibrarySpans().AppendEmpty().Spans().AppendEmpty()
+	span.SetTraceID(id)
+	span.SetName("span")
+	if !root {
+		span.SetParentSpanID(pdata.NewSpanID([8]byte{1}))
+	}
+	if failed {
+		span.Status().SetCode(pdata.StatusCodeError)
+	}
+	return td
+}
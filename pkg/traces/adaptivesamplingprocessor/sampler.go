@@ -0,0 +1,219 @@
+package adaptivesamplingprocessor
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+const (
+	// smoothing is the weight given to the latest interval when updating the
+	// moving averages of the throughput.
+	smoothing = 0.5
+	// maxIdleAdjustments is the number of adjustments without traces after
+	// which a budget is forgotten.
+	maxIdleAdjustments = 10
+)
+
+type decision int
+
+const (
+	decisionDropped decision = iota
+	decisionSampled
+	decisionError
+)
+
+func (d decision) String() string {
+	switch d {
+	case decisionSampled:
+		return "sampled"
+	case decisionError:
+		return "error"
+	default:
+		return "dropped"
+	}
+}
+
+// budgetKey identifies the traces sharing a throughput target.
+type budgetKey struct {
+	service, operation string
+}
+
+// budget tracks the throughput of the traces of a budgetKey.
+type budget struct {
+	target float64
+
+	// received, sampled and errors count the traces since the last
+	// adjustment. received and sampled don't include error traces.
+	received, sampled, errors int
+
+	// receivedRate and sampledRate are moving averages of the throughput in
+	// traces per second. sampledRate includes error traces.
+	receivedRate, sampledRate float64
+	adjusted                  bool
+
+	// probability is the probability of sampling a trace which isn't an
+	// error trace.
+	probability float64
+	idle        int
+}
+
+// sampler decides which traces are sampled, adjusting the probability of
+// sampling the traces of each service to meet its throughput target.
+type sampler struct {
+	defaultTarget float64
+	perOperation  bool
+	targets       map[budgetKey]float64
+	interval      time.Duration
+
+	mut     sync.Mutex
+	budgets map[budgetKey]*budget
+
+	probability  *prometheus.GaugeVec
+	receivedRate *prometheus.GaugeVec
+	sampledRate  *prometheus.GaugeVec
+}
+
+func newSampler(cfg *AdaptiveSamplingConfig, interval time.Duration) *sampler {
+	s := &sampler{
+		defaultTarget: cfg.TracesPerSecond,
+		perOperation:  cfg.PerOperation,
+		targets:       make(map[budgetKey]float64, len(cfg.Targets)),
+		interval:      interval,
+		budgets:       make(map[budgetKey]*budget),
+
+		probability: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "traces",
+			Name:      "adaptive_sampling_probability",
+			Help:      "Current probability of sampling a trace which isn't an error trace",
+		}, []string{"service", "operation"}),
+		receivedRate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "traces",
+			Name:      "adaptive_sampling_received_traces_per_second",
+			Help:      "Moving average of the traces received per second, excluding error traces",
+		}, []string{"service", "operation"}),
+		sampledRate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "traces",
+			Name:      "adaptive_sampling_sampled_traces_per_second",
+			Help:      "Moving average of the traces sampled per second, including error traces",
+		}, []string{"service", "operation"}),
+	}
+	for _, t := range cfg.Targets {
+		s.targets[budgetKey{service: t.Service, operation: t.Operation}] = t.TracesPerSecond
+	}
+	return s
+}
+
+// key returns the budget of the traces with the given root service and
+// operation. Operations only get a budget of their own if PerOperation is
+// set or they have a target.
+func (s *sampler) key(service, operation string) budgetKey {
+	k := budgetKey{service: service, operation: operation}
+	if s.perOperation {
+		return k
+	}
+	if _, ok := s.targets[k]; ok {
+		return k
+	}
+	return budgetKey{service: service}
+}
+
+func (s *sampler) target(k budgetKey) float64 {
+	if t, ok := s.targets[k]; ok {
+		return t
+	}
+	if t, ok := s.targets[budgetKey{service: k.service}]; ok {
+		return t
+	}
+	return s.defaultTarget
+}
+
+// sample decides whether the trace is sampled. Error traces are always
+// sampled. Other traces are sampled by trace ID with the probability of
+// their budget, and dropped once the budget of the current interval is
+// spent.
+func (s *sampler) sample(id pdata.TraceID, service, operation string, failed bool) decision {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	k := s.key(service, operation)
+	b, ok := s.budgets[k]
+	if !ok {
+		b = &budget{target: s.target(k), probability: 1}
+		s.budgets[k] = b
+	}
+	b.idle = 0
+
+	if failed {
+		b.errors++
+		return decisionError
+	}
+
+	b.received++
+	if float64(b.sampled) >= b.target*s.interval.Seconds() {
+		return decisionDropped
+	}
+	if b.probability < 1 && traceIDHash(id) >= uint64(b.probability*float64(1<<32)) {
+		return decisionDropped
+	}
+	b.sampled++
+	return decisionSampled
+}
+
+// adjust updates the throughput of each budget with the traces received
+// during the last elapsed time, and adjusts their sampling probability to
+// meet their target.
+func (s *sampler) adjust(elapsed time.Duration) {
+	secs := elapsed.Seconds()
+	if secs <= 0 {
+		return
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	for k, b := range s.budgets {
+		if b.received == 0 && b.errors == 0 {
+			b.idle++
+			if b.idle >= maxIdleAdjustments {
+				delete(s.budgets, k)
+				s.probability.DeleteLabelValues(k.service, k.operation)
+				s.receivedRate.DeleteLabelValues(k.service, k.operation)
+				s.sampledRate.DeleteLabelValues(k.service, k.operation)
+				continue
+			}
+		}
+
+		received := float64(b.received) / secs
+		sampled := float64(b.sampled+b.errors) / secs
+		if b.adjusted {
+			b.receivedRate = smoothing*received + (1-smoothing)*b.receivedRate
+			b.sampledRate = smoothing*sampled + (1-smoothing)*b.sampledRate
+		} else {
+			b.receivedRate, b.sampledRate = received, sampled
+			b.adjusted = true
+		}
+		b.received, b.sampled, b.errors = 0, 0, 0
+
+		b.probability = 1
+		if b.receivedRate > b.target {
+			b.probability = b.target / b.receivedRate
+		}
+
+		s.probability.WithLabelValues(k.service, k.operation).Set(b.probability)
+		s.receivedRate.WithLabelValues(k.service, k.operation).Set(b.receivedRate)
+		s.sampledRate.WithLabelValues(k.service, k.operation).Set(b.sampledRate)
+	}
+}
+
+// traceIDHash hashes a trace ID to a value in [0, 2^32), so that agents
+// with the same probability make the same decision for a trace.
+func traceIDHash(id pdata.TraceID) uint64 {
+	b := id.Bytes()
+	h := fnv.New32a()
+	_, _ = h.Write(b[:])
+	return uint64(h.Sum32())
+}
@@ -0,0 +1,102 @@
+package adaptivesamplingprocessor
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+func testTraceID(i int) pdata.TraceID {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[8:], uint64(i))
+	return pdata.NewTraceID(b)
+}
+
+func TestSampler_Adjust(t *testing.T) {
+	s := newSampler(&AdaptiveSamplingConfig{TracesPerSecond: 10}, time.Second)
+
+	// A high-traffic service receiving 100 traces per second and a
+	// low-traffic one receiving 5.
+	var id int
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 100; i++ {
+			s.sample(testTraceID(id), "high", "op", false)
+			id++
+		}
+		for i := 0; i < 5; i++ {
+			s.sample(testTraceID(id), "low", "op", false)
+			id++
+		}
+		s.adjust(time.Second)
+	}
+
+	require.InDelta(t, 0.1, s.budgets[budgetKey{service: "high"}].probability, 0.001)
+	require.Equal(t, 1.0, s.budgets[budgetKey{service: "low"}].probability)
+	require.InDelta(t, 0.1, testutil.ToFloat64(s.probability.WithLabelValues("high", "")), 0.001)
+	require.InDelta(t, 100, testutil.ToFloat64(s.receivedRate.WithLabelValues("high", "")), 0.001)
+	require.InDelta(t, 5, testutil.ToFloat64(s.sampledRate.WithLabelValues("low", "")), 0.001)
+
+	// Once adjusted, the high-traffic service is sampled close to its target.
+	var sampled int
+	for i := 0; i < 100; i++ {
+		if s.sample(testTraceID(id), "high", "op", false) == decisionSampled {
+			sampled++
+		}
+		id++
+	}
+	require.InDelta(t, 10, sampled, 5)
+}
+
+func TestSampler_Budget(t *testing.T) {
+	s := newSampler(&AdaptiveSamplingConfig{TracesPerSecond: 2}, time.Second)
+
+	// Before the first adjustment all traces are sampled until the budget of
+	// the interval is spent.
+	require.Equal(t, decisionSampled, s.sample(testTraceID(0), "svc", "op", false))
+	require.Equal(t, decisionSampled, s.sample(testTraceID(1), "svc", "op", false))
+	require.Equal(t, decisionDropped, s.sample(testTraceID(2), "svc", "op", false))
+
+	// Error traces are always sampled.
+	require.Equal(t, decisionError, s.sample(testTraceID(3), "svc", "op", true))
+}
+
+func TestSampler_Targets(t *testing.T) {
+	s := newSampler(&AdaptiveSamplingConfig{
+		TracesPerSecond: 1,
+		Targets: []TargetConfig{
+			{Service: "api", TracesPerSecond: 5},
+			{Service: "api", Operation: "/health", TracesPerSecond: 0.5},
+		},
+	}, time.Second)
+
+	require.Equal(t, budgetKey{service: "api"}, s.key("api", "/users"))
+	require.Equal(t, budgetKey{service: "api", operation: "/health"}, s.key("api", "/health"))
+	require.Equal(t, budgetKey{service: "db"}, s.key("db", "query"))
+
+	require.Equal(t, 5.0, s.target(s.key("api", "/users")))
+	require.Equal(t, 0.5, s.target(s.key("api", "/health")))
+	require.Equal(t, 1.0, s.target(s.key("db", "query")))
+
+	s.perOperation = true
+	require.Equal(t, budgetKey{service: "api", operation: "/users"}, s.key("api", "/users"))
+	require.Equal(t, 5.0, s.target(s.key("api", "/users")))
+	require.Equal(t, 1.0, s.target(s.key("db", "query")))
+}
+
+func TestSampler_ForgetIdle(t *testing.T) {
+	s := newSampler(&AdaptiveSamplingConfig{TracesPerSecond: 1}, time.Second)
+
+	s.sample(testTraceID(0), "svc", "op", false)
+	s.adjust(time.Second)
+	require.Equal(t, 1, testutil.CollectAndCount(s.probability))
+
+	for i := 0; i < maxIdleAdjustments; i++ {
+		s.adjust(time.Second)
+	}
+	require.Empty(t, s.budgets)
+	require.Equal(t, 0, testutil.CollectAndCount(s.probability))
+}
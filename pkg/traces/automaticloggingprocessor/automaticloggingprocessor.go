@@ -50,6 +50,9 @@ type automaticLoggingProcessor struct {
 
 	labels map[string]struct{}
 
+	rules   []rule
+	limiter *serviceLimiter
+
 	logger log.Logger
 }
 
@@ -93,6 +96,19 @@ func newTraceProcessor(nextConsumer consumer.Traces, cfg *AutomaticLoggingConfig
 		labels[l] = struct{}{}
 	}
 
+	rules, err := newRules(cfg.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("automaticLoggingProcessor has invalid rules: %w", err)
+	}
+
+	var limiter *serviceLimiter
+	if cfg.RateLimit != nil {
+		if cfg.RateLimit.LinesPerSecond <= 0 {
+			return nil, errors.New("automaticLoggingProcessor requires rate_limit.lines_per_second to be greater than 0")
+		}
+		limiter = newServiceLimiter(*cfg.RateLimit)
+	}
+
 	return &automaticLoggingProcessor{
 		nextConsumer: nextConsumer,
 		cfg:          cfg,
@@ -100,6 +116,8 @@ func newTraceProcessor(nextConsumer consumer.Traces, cfg *AutomaticLoggingConfig
 		logger:       logger,
 		done:         atomic.Bool{},
 		labels:       labels,
+		rules:        rules,
+		limiter:      limiter,
 	}, nil
 }
 
@@ -124,20 +142,27 @@ func (p *automaticLoggingProcessor) ConsumeTraces(ctx context.Context, td pdata.
 				span := ils.Spans().At(k)
 				traceID := span.TraceID().HexString()
 
+				// Process lines are logged for the first selected span
+				// of each trace.
+				ruleLabels, ok := p.selectSpan(span, rs.Resource(), svc)
+				if !ok {
+					continue
+				}
+
 				if p.cfg.Spans {
 					keyValues := append(p.spanKeyVals(span), p.processKeyVals(rs.Resource(), svc)...)
-					p.exportToLogsInstance(typeSpan, traceID, p.spanLabels(keyValues), keyValues...)
+					p.export(typeSpan, traceID, svc, p.spanLabels(keyValues).Merge(ruleLabels), keyValues...)
 				}
 
 				if p.cfg.Roots && span.ParentSpanID().IsEmpty() {
 					keyValues := append(p.spanKeyVals(span), p.processKeyVals(rs.Resource(), svc)...)
-					p.exportToLogsInstance(typeRoot, traceID, p.spanLabels(keyValues), keyValues...)
+					p.export(typeRoot, traceID, svc, p.spanLabels(keyValues).Merge(ruleLabels), keyValues...)
 				}
 
 				if p.cfg.Processes && lastTraceID != traceID {
 					lastTraceID = traceID
 					keyValues := p.processKeyVals(rs.Resource(), svc)
-					p.exportToLogsInstance(typeProcess, traceID, p.spanLabels(keyValues), keyValues...)
+					p.export(typeProcess, traceID, svc, p.spanLabels(keyValues).Merge(ruleLabels), keyValues...)
 				}
 			}
 		}
@@ -146,6 +171,29 @@ func (p *automaticLoggingProcessor) ConsumeTraces(ctx context.Context, td pdata.
 	return p.nextConsumer.ConsumeTraces(ctx, td)
 }
 
+// selectSpan returns true if the span matches the configured rules, along
+// with the labels of the first matching rule.
+func (p *automaticLoggingProcessor) selectSpan(span pdata.Span, resource pdata.Resource, svc string) (model.LabelSet, bool) {
+	if len(p.rules) == 0 {
+		return nil, true
+	}
+	for i := range p.rules {
+		if p.rules[i].matches(span, resource, svc) {
+			return p.rules[i].labels, true
+		}
+	}
+	return nil, false
+}
+
+// export exports a line unless the rate limit of svc is exceeded.
+func (p *automaticLoggingProcessor) export(kind string, traceID string, svc string, labels model.LabelSet, keyvals ...interface{}) {
+	if p.limiter != nil && !p.limiter.Allow(svc) {
+		level.Debug(p.logger).Log("msg", "dropping line over rate limit", "kind", kind, "service", svc, "traceid", traceID)
+		return
+	}
+	p.exportToLogsInstance(kind, traceID, labels, keyvals...)
+}
+
 func (p *automaticLoggingProcessor) spanLabels(keyValues []interface{}) model.LabelSet {
 	if len(keyValues) == 0 {
 		return model.LabelSet{}
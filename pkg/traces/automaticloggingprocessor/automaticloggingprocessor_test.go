@@ -1,16 +1,21 @@
 package automaticloggingprocessor
 
 import (
+	"bytes"
 	"context"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-kit/log"
+	"github.com/go-logfmt/logfmt"
 	"github.com/grafana/agent/pkg/logs"
 	"github.com/grafana/agent/pkg/util"
 	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/model/pdata"
 	"gopkg.in/yaml.v3"
 )
@@ -353,3 +358,235 @@ func TestLabels(t *testing.T) {
 		})
 	}
 }
+
+func TestRules(t *testing.T) {
+	newTraces := func() pdata.Traces {
+		traces := pdata.NewTraces()
+		rs := traces.ResourceSpans().AppendEmpty()
+		rs.Resource().Attributes().InsertString("service.name", "api")
+		spans := rs.InstrumentationLibrarySpans().AppendEmpty().Spans()
+
+		ok := spans.AppendEmpty()
+		ok.SetName("ok")
+		ok.SetTraceID(pdata.NewTraceID([16]byte{1}))
+		ok.SetEndTimestamp(pdata.Timestamp(time.Millisecond))
+
+		failed := spans.AppendEmpty()
+		failed.SetName("failed")
+		failed.SetTraceID(pdata.NewTraceID([16]byte{2}))
+		failed.SetEndTimestamp(pdata.Timestamp(time.Millisecond))
+		failed.Status().SetCode(pdata.StatusCodeError)
+
+		slow := spans.AppendEmpty()
+		slow.SetName("slow")
+		slow.SetTraceID(pdata.NewTraceID([16]byte{3}))
+		slow.SetEndTimestamp(pdata.Timestamp(time.Minute))
+		slow.Attributes().InsertString("http.method", "POST")
+		return traces
+	}
+
+	tests := []struct {
+		name   string
+		rules  []RuleConfig
+		expect []string
+	}{
+		{
+			name:   "no rules",
+			expect: []string{"ok", "failed", "slow"},
+		},
+		{
+			name:   "errors",
+			rules:  []RuleConfig{{StatusCodes: []string{"ERROR"}}},
+			expect: []string{"failed"},
+		},
+		{
+			name:   "slow spans",
+			rules:  []RuleConfig{{MinDuration: time.Second}},
+			expect: []string{"slow"},
+		},
+		{
+			name: "any rule",
+			rules: []RuleConfig{
+				{StatusCodes: []string{"error"}},
+				{MinDuration: time.Second},
+			},
+			expect: []string{"failed", "slow"},
+		},
+		{
+			name:   "attributes",
+			rules:  []RuleConfig{{Attributes: []AttributeMatcher{{Key: "http.method", Values: []string{"POST"}}}}},
+			expect: []string{"slow"},
+		},
+		{
+			name:   "resource attributes",
+			rules:  []RuleConfig{{Attributes: []AttributeMatcher{{Key: "service.name"}}}},
+			expect: []string{"ok", "failed", "slow"},
+		},
+		{
+			name:  "other services",
+			rules: []RuleConfig{{Services: []string{"db"}}},
+		},
+		{
+			name:  "sampled",
+			rules: []RuleConfig{{SamplingPercentage: float64Ptr(0.0001)}},
+		},
+		{
+			name:  "sampling percentage 0",
+			rules: []RuleConfig{{SamplingPercentage: float64Ptr(0)}},
+		},
+		{
+			name:   "sampling percentage 100",
+			rules:  []RuleConfig{{SamplingPercentage: float64Ptr(100)}},
+			expect: []string{"ok", "failed", "slow"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := newTraceProcessor(&mockConsumer{}, &AutomaticLoggingConfig{
+				Spans: true,
+				Rules: tc.rules,
+			})
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			p.(*automaticLoggingProcessor).logger = log.NewLogfmtLogger(&buf)
+			require.NoError(t, p.ConsumeTraces(context.Background(), newTraces()))
+
+			var logged []string
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				if name := logfmtValue(t, line, defaultSpanNameKey); name != "" {
+					logged = append(logged, name)
+				}
+			}
+			require.Equal(t, tc.expect, logged)
+		})
+	}
+}
+
+func TestRules_SamplingPercentageZero(t *testing.T) {
+	input := util.Untab(`
+		spans: true
+		rules:
+		- sampling_percentage: 0
+		- name: unset
+	`)
+
+	var cfg AutomaticLoggingConfig
+	require.NoError(t, yaml.Unmarshal([]byte(input), &cfg))
+	require.NotNil(t, cfg.Rules[0].SamplingPercentage, "an explicit 0 must not be treated as unset")
+	require.Equal(t, 0.0, *cfg.Rules[0].SamplingPercentage)
+	require.Nil(t, cfg.Rules[1].SamplingPercentage)
+
+	rules, err := newRules(cfg.Rules)
+	require.NoError(t, err)
+	require.True(t, rules[0].sampled)
+	require.Zero(t, rules[0].threshold)
+	require.False(t, rules[1].sampled)
+}
+
+func TestRuleLabels(t *testing.T) {
+	p, err := newTraceProcessor(&mockConsumer{}, &AutomaticLoggingConfig{
+		Spans: true,
+		Rules: []RuleConfig{{
+			StatusCodes: []string{"ERROR"},
+			Labels:      map[string]string{"level": "error"},
+		}},
+	})
+	require.NoError(t, err)
+
+	span := pdata.NewSpan()
+	span.Status().SetCode(pdata.StatusCodeError)
+	labels, ok := p.(*automaticLoggingProcessor).selectSpan(span, pdata.NewResource(), "api")
+	require.True(t, ok)
+	require.Equal(t, model.LabelSet{"level": "error"}, labels)
+}
+
+func TestBadRules(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *AutomaticLoggingConfig
+	}{
+		{
+			name: "unknown status code",
+			cfg:  &AutomaticLoggingConfig{Spans: true, Rules: []RuleConfig{{StatusCodes: []string{"FAILED"}}}},
+		},
+		{
+			name: "sampling percentage",
+			cfg:  &AutomaticLoggingConfig{Spans: true, Rules: []RuleConfig{{SamplingPercentage: float64Ptr(101)}}},
+		},
+		{
+			name: "attribute key",
+			cfg:  &AutomaticLoggingConfig{Spans: true, Rules: []RuleConfig{{Attributes: []AttributeMatcher{{}}}}},
+		},
+		{
+			name: "label name",
+			cfg:  &AutomaticLoggingConfig{Spans: true, Rules: []RuleConfig{{Labels: map[string]string{"a-b": "c"}}}},
+		},
+		{
+			name: "rate limit",
+			cfg:  &AutomaticLoggingConfig{Spans: true, RateLimit: &RateLimitConfig{}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newTraceProcessor(&mockConsumer{}, tc.cfg)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	p, err := newTraceProcessor(&mockConsumer{}, &AutomaticLoggingConfig{
+		Spans:     true,
+		RateLimit: &RateLimitConfig{LinesPerSecond: 0.001, Burst: 2},
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	p.(*automaticLoggingProcessor).logger = log.NewLogfmtLogger(&buf)
+
+	traces := pdata.NewTraces()
+	for _, svc := range []string{"api", "db"} {
+		rs := traces.ResourceSpans().AppendEmpty()
+		rs.Resource().Attributes().InsertString("service.name", svc)
+		spans := rs.InstrumentationLibrarySpans().AppendEmpty().Spans()
+		for i := 0; i < 3; i++ {
+			spans.AppendEmpty().SetName("span")
+		}
+	}
+	require.NoError(t, p.ConsumeTraces(context.Background(), traces))
+
+	lines := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if logfmtValue(t, line, defaultSpanNameKey) != "" {
+			lines[logfmtValue(t, line, defaultServiceKey)]++
+		}
+	}
+	require.Equal(t, map[string]int{"api": 2, "db": 2}, lines)
+}
+
+// logfmtValue returns the value of key in a logfmt line.
+func logfmtValue(t *testing.T, line string, key string) string {
+	t.Helper()
+
+	dec := logfmt.NewDecoder(strings.NewReader(line))
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			if string(dec.Key()) == key {
+				return string(dec.Value())
+			}
+		}
+	}
+	require.NoError(t, dec.Err())
+	return ""
+}
+
+type mockConsumer struct{}
+
+func (m *mockConsumer) Capabilities() consumer.Capabilities { return consumer.Capabilities{} }
+
+func (m *mockConsumer) ConsumeTraces(context.Context, pdata.Traces) error { return nil }
+
+func float64Ptr(f float64) *float64 { return &f }
@@ -34,6 +34,13 @@ type AutomaticLoggingConfig struct {
 	Timeout           time.Duration  `mapstructure:"timeout" yaml:"timeout,omitempty"`
 	Labels            []string       `mapstructure:"labels" yaml:"labels,omitempty"`
 
+	// Rules select the spans which are logged. If set, only spans matching
+	// at least one rule are logged, with the labels of the first matching
+	// rule. If empty, all spans are logged.
+	Rules []RuleConfig `mapstructure:"rules" yaml:"rules,omitempty"`
+	// RateLimit limits the number of lines logged per service.
+	RateLimit *RateLimitConfig `mapstructure:"rate_limit" yaml:"rate_limit,omitempty"`
+
 	// Deprecated fields:
 	LokiName string `mapstructure:"loki_name" yaml:"loki_name,omitempty"` // Superseded by LogsName
 }
@@ -0,0 +1,220 @@
+package automaticloggingprocessor
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"go.opentelemetry.io/collector/model/pdata"
+	"golang.org/x/time/rate"
+)
+
+// RuleConfig selects the spans which are logged. A span matches a rule if it
+// matches all of the rule's conditions.
+type RuleConfig struct {
+	Name string `mapstructure:"name" yaml:"name,omitempty"`
+
+	// StatusCodes matches spans with one of the status codes (UNSET, OK or
+	// ERROR).
+	StatusCodes []string `mapstructure:"status_codes" yaml:"status_codes,omitempty"`
+	// MinDuration matches spans which took at least MinDuration.
+	MinDuration time.Duration `mapstructure:"min_duration" yaml:"min_duration,omitempty"`
+	// Services matches spans from one of the services.
+	Services []string `mapstructure:"services" yaml:"services,omitempty"`
+	// Attributes matches spans whose span or resource attributes match all
+	// of the matchers.
+	Attributes []AttributeMatcher `mapstructure:"attributes" yaml:"attributes,omitempty"`
+	// SamplingPercentage logs the given percentage of traces matching the
+	// rule. Traces are sampled by trace ID, so all lines of a sampled trace
+	// are logged. Defaults to 100 when unset, while 0 logs no traces.
+	SamplingPercentage *float64 `mapstructure:"sampling_percentage" yaml:"sampling_percentage,omitempty"`
+
+	// Labels are added to the lines of spans matching the rule.
+	Labels map[string]string `mapstructure:"labels" yaml:"labels,omitempty"`
+}
+
+// AttributeMatcher matches an attribute against a list of values. If Values
+// is empty, the attribute only needs to be set.
+type AttributeMatcher struct {
+	Key    string   `mapstructure:"key" yaml:"key,omitempty"`
+	Values []string `mapstructure:"values" yaml:"values,omitempty"`
+}
+
+// RateLimitConfig limits the number of lines logged per service.
+type RateLimitConfig struct {
+	LinesPerSecond float64 `mapstructure:"lines_per_second" yaml:"lines_per_second,omitempty"`
+	Burst          int     `mapstructure:"burst" yaml:"burst,omitempty"`
+}
+
+type rule struct {
+	statusCodes map[pdata.StatusCode]struct{}
+	minDuration time.Duration
+	services    map[string]struct{}
+	attributes  []attributeMatcher
+	// threshold is compared against the hash of trace IDs to sample traces.
+	// Traces are kept if their hash is below threshold.
+	threshold uint64
+	sampled   bool
+	labels    model.LabelSet
+}
+
+type attributeMatcher struct {
+	key    string
+	values map[string]struct{}
+}
+
+var statusCodes = map[string]pdata.StatusCode{
+	"UNSET": pdata.StatusCodeUnset,
+	"OK":    pdata.StatusCodeOk,
+	"ERROR": pdata.StatusCodeError,
+}
+
+func newRules(cfgs []RuleConfig) ([]rule, error) {
+	rules := make([]rule, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		name := cfg.Name
+		if name == "" {
+			name = fmt.Sprintf("%d", i)
+		}
+
+		r := rule{minDuration: cfg.MinDuration}
+
+		if len(cfg.StatusCodes) > 0 {
+			r.statusCodes = make(map[pdata.StatusCode]struct{}, len(cfg.StatusCodes))
+			for _, sc := range cfg.StatusCodes {
+				code, ok := statusCodes[strings.ToUpper(sc)]
+				if !ok {
+					return nil, fmt.Errorf("rule %s: unknown status code %q", name, sc)
+				}
+				r.statusCodes[code] = struct{}{}
+			}
+		}
+
+		if len(cfg.Services) > 0 {
+			r.services = make(map[string]struct{}, len(cfg.Services))
+			for _, svc := range cfg.Services {
+				r.services[svc] = struct{}{}
+			}
+		}
+
+		for _, am := range cfg.Attributes {
+			if am.Key == "" {
+				return nil, fmt.Errorf("rule %s: attribute key must be set", name)
+			}
+			m := attributeMatcher{key: am.Key}
+			if len(am.Values) > 0 {
+				m.values = make(map[string]struct{}, len(am.Values))
+				for _, v := range am.Values {
+					m.values[v] = struct{}{}
+				}
+			}
+			r.attributes = append(r.attributes, m)
+		}
+
+		if pct := cfg.SamplingPercentage; pct != nil {
+			if *pct < 0 || *pct > 100 {
+				return nil, fmt.Errorf("rule %s: sampling_percentage must be between 0 and 100", name)
+			}
+			if *pct != 100 {
+				r.sampled = true
+				r.threshold = uint64(*pct / 100 * float64(1<<32))
+			}
+		}
+
+		if len(cfg.Labels) > 0 {
+			r.labels = make(model.LabelSet, len(cfg.Labels))
+			for k, v := range cfg.Labels {
+				ln := model.LabelName(k)
+				if !ln.IsValid() {
+					return nil, fmt.Errorf("rule %s: invalid label name %q", name, k)
+				}
+				r.labels[ln] = model.LabelValue(v)
+			}
+		}
+
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// matches returns true if the span matches the rule.
+func (r *rule) matches(span pdata.Span, resource pdata.Resource, svc string) bool {
+	if r.statusCodes != nil {
+		if _, ok := r.statusCodes[span.Status().Code()]; !ok {
+			return false
+		}
+	}
+	if r.minDuration > 0 && span.EndTimestamp().AsTime().Sub(span.StartTimestamp().AsTime()) < r.minDuration {
+		return false
+	}
+	if r.services != nil {
+		if _, ok := r.services[svc]; !ok {
+			return false
+		}
+	}
+	for _, m := range r.attributes {
+		att, ok := span.Attributes().Get(m.key)
+		if !ok {
+			att, ok = resource.Attributes().Get(m.key)
+		}
+		if !ok {
+			return false
+		}
+		if m.values != nil {
+			if _, ok := m.values[att.AsString()]; !ok {
+				return false
+			}
+		}
+	}
+	if r.sampled && traceIDHash(span.TraceID()) >= r.threshold {
+		return false
+	}
+	return true
+}
+
+// traceIDHash hashes a trace ID to a value in [0, 2^32).
+func traceIDHash(id pdata.TraceID) uint64 {
+	b := id.Bytes()
+	h := fnv.New32a()
+	_, _ = h.Write(b[:])
+	return uint64(h.Sum32())
+}
+
+// serviceLimiter limits the rate of lines logged per service.
+type serviceLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mut      sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func newServiceLimiter(cfg RateLimitConfig) *serviceLimiter {
+	burst := cfg.Burst
+	if burst <= 0 {
+		burst = int(cfg.LinesPerSecond)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	return &serviceLimiter{
+		limit:    rate.Limit(cfg.LinesPerSecond),
+		burst:    burst,
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// Allow returns true if a line for svc may be logged.
+func (l *serviceLimiter) Allow(svc string) bool {
+	l.mut.Lock()
+	lim, ok := l.limiters[svc]
+	if !ok {
+		lim = rate.NewLimiter(l.limit, l.burst)
+		l.limiters[svc] = lim
+	}
+	l.mut.Unlock()
+	return lim.Allow()
+}
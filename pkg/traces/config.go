@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
@@ -35,11 +36,17 @@ import (
 	"go.uber.org/multierr"
 
 	"github.com/grafana/agent/pkg/logs"
+	"github.com/grafana/agent/pkg/traces/adaptivesamplingprocessor"
 	"github.com/grafana/agent/pkg/traces/automaticloggingprocessor"
+	"github.com/grafana/agent/pkg/traces/logscorrelationprocessor"
 	"github.com/grafana/agent/pkg/traces/noopreceiver"
+	"github.com/grafana/agent/pkg/traces/persistentqueueexporter"
 	"github.com/grafana/agent/pkg/traces/promsdprocessor"
+	"github.com/grafana/agent/pkg/traces/pushreceiver"
+	"github.com/grafana/agent/pkg/traces/redactionprocessor"
 	"github.com/grafana/agent/pkg/traces/remotewriteexporter"
 	"github.com/grafana/agent/pkg/traces/servicegraphprocessor"
+	"github.com/grafana/agent/pkg/traces/tracebufferprocessor"
 	"github.com/grafana/agent/pkg/util"
 )
 
@@ -52,8 +59,13 @@ const (
 	// defaultLoadBalancingPort is the default port the agent uses for internal load balancing
 	defaultLoadBalancingPort = "4318"
 	// agent's load balancing options
-	dnsTagName    = "dns"
-	staticTagName = "static"
+	dnsTagName     = "dns"
+	staticTagName  = "static"
+	clusterTagName = "cluster"
+
+	// defaultClusterRefreshInterval is the default interval at which the
+	// cluster resolver looks up the agents in the cluster.
+	defaultClusterRefreshInterval = 30 * time.Second
 
 	// sampling policies
 	alwaysSamplePolicy = "always_sample"
@@ -93,6 +105,26 @@ func (c *Config) Validate(logsConfig *logs.Config) error {
 				return fmt.Errorf("failed to validate automatic_logging for traces config %s: %w", inst.Name, err)
 			}
 		}
+		if inst.LogsCorrelation != nil {
+			if err := inst.LogsCorrelation.Validate(logsConfig); err != nil {
+				return fmt.Errorf("failed to validate logs_correlation for traces config %s: %w", inst.Name, err)
+			}
+		}
+		if inst.Redaction != nil {
+			if err := inst.Redaction.Validate(); err != nil {
+				return fmt.Errorf("failed to validate redaction for traces config %s: %w", inst.Name, err)
+			}
+		}
+		if inst.TraceBuffer != nil {
+			if err := inst.TraceBuffer.Validate(); err != nil {
+				return fmt.Errorf("failed to validate trace_buffer for traces config %s: %w", inst.Name, err)
+			}
+		}
+		if inst.AdaptiveSampling != nil {
+			if err := inst.AdaptiveSampling.Validate(); err != nil {
+				return fmt.Errorf("failed to validate adaptive_sampling for traces config %s: %w", inst.Name, err)
+			}
+		}
 	}
 
 	return nil
@@ -125,9 +157,21 @@ type InstanceConfig struct {
 	// AutomaticLogging
 	AutomaticLogging *automaticloggingprocessor.AutomaticLoggingConfig `yaml:"automatic_logging,omitempty"`
 
+	// LogsCorrelation
+	LogsCorrelation *logscorrelationprocessor.LogsCorrelationConfig `yaml:"logs_correlation,omitempty"`
+
+	// Redaction
+	Redaction *redactionprocessor.RedactionConfig `yaml:"redaction,omitempty"`
+
+	// TraceBuffer keeps recent traces in memory to be looked up through the API
+	TraceBuffer *tracebufferprocessor.TraceBufferConfig `yaml:"trace_buffer,omitempty"`
+
 	// TailSampling defines a sampling strategy for the pipeline
 	TailSampling *tailSamplingConfig `yaml:"tail_sampling,omitempty"`
 
+	// AdaptiveSampling samples traces to meet per-service throughput targets
+	AdaptiveSampling *adaptivesamplingprocessor.AdaptiveSamplingConfig `yaml:"adaptive_sampling,omitempty"`
+
 	// LoadBalancing is used to distribute spans of the same trace to the same agent instance
 	LoadBalancing *loadBalancingConfig `yaml:"load_balancing"`
 
@@ -223,6 +267,7 @@ type RemoteWriteConfig struct {
 	Headers            map[string]string      `yaml:"headers,omitempty"`
 	SendingQueue       map[string]interface{} `yaml:"sending_queue,omitempty"`    // https://github.com/open-telemetry/opentelemetry-collector/blob/7d7ae2eb34b5d387627875c498d7f43619f37ee3/exporter/exporterhelper/queued_retry.go#L30
 	RetryOnFailure     map[string]interface{} `yaml:"retry_on_failure,omitempty"` // https://github.com/open-telemetry/opentelemetry-collector/blob/7d7ae2eb34b5d387627875c498d7f43619f37ee3/exporter/exporterhelper/queued_retry.go#L54
+	PersistentQueue    *PersistentQueueConfig `yaml:"persistent_queue,omitempty"`
 }
 
 // UnmarshalYAML implements yaml.Unmarshaler.
@@ -245,6 +290,40 @@ func (c *RemoteWriteConfig) UnmarshalYAML(unmarshal func(interface{}) error) err
 	return nil
 }
 
+// DefaultPersistentQueueConfig holds the default settings for a
+// PersistentQueueConfig.
+var DefaultPersistentQueueConfig = PersistentQueueConfig{
+	MaxSizeBytes: persistentqueueexporter.DefaultMaxSizeBytes,
+}
+
+// PersistentQueueConfig configures a queue on disk for a remote_write
+// exporter. Spans are written to the queue and sent in order, so they survive
+// backend outages and agent restarts.
+type PersistentQueueConfig struct {
+	// Directory holds the queues of all remote_write entries which use it.
+	Directory string `yaml:"directory"`
+	// MaxSizeBytes bounds the size of the queue on disk.
+	MaxSizeBytes int64 `yaml:"max_size_bytes,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *PersistentQueueConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultPersistentQueueConfig
+
+	type plain PersistentQueueConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+
+	if c.Directory == "" {
+		return fmt.Errorf("persistent_queue must have a directory")
+	}
+	if c.MaxSizeBytes <= 0 {
+		return fmt.Errorf("persistent_queue max_size_bytes must be greater than 0")
+	}
+	return nil
+}
+
 // SpanMetricsConfig controls the configuration of spanmetricsprocessor and the related metrics exporter.
 type SpanMetricsConfig struct {
 	LatencyHistogramBuckets []time.Duration                  `yaml:"latency_histogram_buckets,omitempty"`
@@ -275,6 +354,52 @@ type loadBalancingConfig struct {
 	Resolver map[string]interface{} `yaml:"resolver"`
 	// ReceiverPort is the port the instance will use to receive load balanced traces
 	ReceiverPort string `yaml:"receiver_port"`
+
+	// clusterPeers are the addresses of the agents in the scraping service
+	// cluster, used by the cluster resolver. They are set by the Instance.
+	clusterPeers []string
+}
+
+// clusterResolverConfig configures the cluster resolver, which load balances
+// between the agents in the scraping service cluster.
+type clusterResolverConfig struct {
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+}
+
+// clusterResolver returns the config of the cluster resolver, or nil if the
+// cluster resolver isn't used.
+func (c *loadBalancingConfig) clusterResolver() (*clusterResolverConfig, error) {
+	raw, ok := c.Resolver[clusterTagName]
+	if !ok {
+		return nil, nil
+	}
+
+	cfg := clusterResolverConfig{RefreshInterval: defaultClusterRefreshInterval}
+	if raw != nil {
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			Result:      &cfg,
+			ErrorUnused: true,
+			DecodeHook:  mapstructure.StringToTimeDurationHookFunc(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := decoder.Decode(raw); err != nil {
+			return nil, fmt.Errorf("invalid cluster resolver config: %w", err)
+		}
+	}
+	if cfg.RefreshInterval <= 0 {
+		return nil, fmt.Errorf("cluster resolver refresh_interval must be greater than 0")
+	}
+	return &cfg, nil
+}
+
+// receiverPort returns the port load balanced traces are received on.
+func (c *loadBalancingConfig) receiverPort() string {
+	if c.ReceiverPort != "" {
+		return c.ReceiverPort
+	}
+	return defaultLoadBalancingPort
 }
 
 // exporterConfig defined the config for an otlp exporter for load balancing
@@ -290,6 +415,16 @@ type serviceGraphsConfig struct {
 	Enabled  bool          `yaml:"enabled,omitempty"`
 	Wait     time.Duration `yaml:"wait,omitempty"`
 	MaxItems int           `yaml:"max_items,omitempty"`
+
+	Dimensions                []string `yaml:"dimensions,omitempty"`
+	EnableVirtualNodes        bool     `yaml:"enable_virtual_nodes,omitempty"`
+	VirtualNodePeerAttributes []string `yaml:"virtual_node_peer_attributes,omitempty"`
+
+	// MetricsInstance is the Agent's metrics instance that service graph
+	// metrics are written to. If empty, metrics are exposed by the Agent.
+	MetricsInstance string `yaml:"metrics_instance,omitempty"`
+	// ConstLabels are values that are applied to every service graph metric.
+	ConstLabels prometheus.Labels `yaml:"const_labels,omitempty"`
 }
 
 // exporter builds an OTel exporter from RemoteWriteConfig
@@ -410,11 +545,55 @@ func (c *InstanceConfig) exporters() (map[string]interface{}, error) {
 		if remoteWriteConfig.Oauth2 != nil {
 			exporter["auth"] = map[string]string{"authenticator": getAuthExtensionName(exporterName)}
 		}
+		if pq := remoteWriteConfig.PersistentQueue; pq != nil {
+			// The persistent queue retries failed batches itself, so the
+			// exporter must return errors instead of queueing in memory.
+			exporter["sending_queue"] = map[string]interface{}{"enabled": false}
+
+			// The exporter config is stored as-is, so keep its types the same
+			// as a config loaded from a file.
+			if headers, ok := exporter["headers"].(map[string]string); ok {
+				converted := make(map[string]interface{}, len(headers))
+				for k, v := range headers {
+					converted[k] = v
+				}
+				exporter["headers"] = converted
+			}
+
+			exporters[persistentqueueexporter.TypeStr+"/"+exporterName] = map[string]interface{}{
+				"directory":      filepath.Join(pq.Directory, c.Name, strings.Replace(exporterName, "/", "_", -1)),
+				"max_size_bytes": pq.MaxSizeBytes,
+				"exporter_type":  strings.SplitN(exporterName, "/", 2)[0],
+				"exporter":       exporter,
+			}
+			continue
+		}
 		exporters[exporterName] = exporter
 	}
 	return exporters, nil
 }
 
+// usesPersistentQueue returns true if any remote_write entry uses a
+// persistent queue.
+func (c *InstanceConfig) usesPersistentQueue() bool {
+	for _, rw := range c.RemoteWrite {
+		if rw.PersistentQueue != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// UsesClusterResolver returns true if load_balancing uses the cluster
+// resolver, which requires the scraping service to be enabled.
+func (c *InstanceConfig) UsesClusterResolver() bool {
+	if c.LoadBalancing == nil {
+		return false
+	}
+	_, ok := c.LoadBalancing.Resolver[clusterTagName]
+	return ok
+}
+
 func getAuthExtensionName(exporterName string) string {
 	return fmt.Sprintf("oauth2client/%s", strings.Replace(exporterName, "/", "", -1))
 }
@@ -439,15 +618,39 @@ func (c *InstanceConfig) extensions() (map[string]interface{}, error) {
 	return extensions, nil
 }
 
-func resolver(config map[string]interface{}) (map[string]interface{}, error) {
-	if len(config) == 0 {
-		return nil, fmt.Errorf("must configure one resolver (dns or static)")
+func resolver(lb *loadBalancingConfig) (map[string]interface{}, error) {
+	if len(lb.Resolver) == 0 {
+		return nil, fmt.Errorf("must configure one resolver (dns, static or cluster)")
 	}
 	resolverCfg := make(map[string]interface{})
-	for typ, cfg := range config {
+	for typ, cfg := range lb.Resolver {
 		switch typ {
 		case dnsTagName, staticTagName:
 			resolverCfg[typ] = cfg
+		case clusterTagName:
+			if len(lb.Resolver) > 1 {
+				return nil, fmt.Errorf("the cluster resolver can't be combined with other resolvers")
+			}
+			if _, err := lb.clusterResolver(); err != nil {
+				return nil, err
+			}
+			// The load balancing exporter can't discover peers on its own,
+			// so the peers are passed as a static list. The Instance
+			// rebuilds the pipeline when they change.
+			if len(lb.clusterPeers) == 0 {
+				return nil, fmt.Errorf("cluster resolver found no agents in the cluster")
+			}
+			hostnames := make([]string, 0, len(lb.clusterPeers))
+			for _, addr := range lb.clusterPeers {
+				host, _, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, fmt.Errorf("invalid cluster peer address %q: %w", addr, err)
+				}
+				hostnames = append(hostnames, net.JoinHostPort(host, lb.receiverPort()))
+			}
+			resolverCfg[staticTagName] = map[string]interface{}{
+				"hostnames": hostnames,
+			}
 		default:
 			return nil, fmt.Errorf("unsupported resolver config type: %s", typ)
 		}
@@ -469,7 +672,7 @@ func (c *InstanceConfig) loadBalancingExporter() (map[string]interface{}, error)
 	if err != nil {
 		return nil, err
 	}
-	resolverCfg, err := resolver(c.LoadBalancing.Resolver)
+	resolverCfg, err := resolver(c.LoadBalancing)
 	if err != nil {
 		return nil, err
 	}
@@ -509,6 +712,31 @@ func formatPolicies(cfg []map[string]interface{}) ([]map[string]interface{}, err
 }
 
 func (c *InstanceConfig) otelConfig() (*config.Config, error) {
+	otelMapStructure, err := c.otelConfigMap()
+	if err != nil {
+		return nil, err
+	}
+
+	factories, err := tracingFactories()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create factories: %w", err)
+	}
+
+	if err := validateConfigFromFactories(factories); err != nil {
+		return nil, fmt.Errorf("failed to validate factories: %w", err)
+	}
+
+	configMap := config.NewMapFromStringMap(otelMapStructure)
+	otelCfg, err := configunmarshaler.NewDefault().Unmarshal(configMap, factories)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OTel config: %w", err)
+	}
+
+	return otelCfg, nil
+}
+
+// otelConfigMap builds the raw OTel config from the InstanceConfig.
+func (c *InstanceConfig) otelConfigMap() (map[string]interface{}, error) {
 	otelMapStructure := map[string]interface{}{}
 
 	if len(c.Receivers) == 0 {
@@ -549,6 +777,13 @@ func (c *InstanceConfig) otelConfig() (*config.Config, error) {
 		}
 	}
 
+	if c.Redaction != nil {
+		processorNames = append(processorNames, redactionprocessor.TypeStr)
+		processors[redactionprocessor.TypeStr] = map[string]interface{}{
+			"redaction": c.Redaction,
+		}
+	}
+
 	if c.AutomaticLogging != nil {
 		processorNames = append(processorNames, automaticloggingprocessor.TypeStr)
 		processors[automaticloggingprocessor.TypeStr] = map[string]interface{}{
@@ -556,11 +791,23 @@ func (c *InstanceConfig) otelConfig() (*config.Config, error) {
 		}
 	}
 
+	if c.LogsCorrelation != nil {
+		processorNames = append(processorNames, logscorrelationprocessor.TypeStr)
+		processors[logscorrelationprocessor.TypeStr] = map[string]interface{}{
+			"logs_correlation": c.LogsCorrelation,
+		}
+	}
+
 	if c.Attributes != nil {
 		processors["attributes"] = c.Attributes
 		processorNames = append(processorNames, "attributes")
 	}
 
+	if c.TraceBuffer != nil {
+		processors[tracebufferprocessor.TypeStr] = map[string]interface{}{}
+		processorNames = append(processorNames, tracebufferprocessor.TypeStr)
+	}
+
 	if c.Batch != nil {
 		processors["batch"] = c.Batch
 		processorNames = append(processorNames, "batch")
@@ -632,6 +879,13 @@ func (c *InstanceConfig) otelConfig() (*config.Config, error) {
 		}
 	}
 
+	if c.AdaptiveSampling != nil {
+		processorNames = append(processorNames, adaptivesamplingprocessor.TypeStr)
+		processors[adaptivesamplingprocessor.TypeStr] = map[string]interface{}{
+			"adaptive_sampling": c.AdaptiveSampling,
+		}
+	}
+
 	if c.LoadBalancing != nil {
 		internalExporter, err := c.loadBalancingExporter()
 		if err != nil {
@@ -639,14 +893,10 @@ func (c *InstanceConfig) otelConfig() (*config.Config, error) {
 		}
 		exporters["loadbalancing"] = internalExporter
 
-		receiverPort := defaultLoadBalancingPort
-		if c.LoadBalancing.ReceiverPort != "" {
-			receiverPort = c.LoadBalancing.ReceiverPort
-		}
 		c.Receivers["otlp/lb"] = map[string]interface{}{
 			"protocols": map[string]interface{}{
 				"grpc": map[string]interface{}{
-					"endpoint": net.JoinHostPort("0.0.0.0", receiverPort),
+					"endpoint": net.JoinHostPort("0.0.0.0", c.LoadBalancing.receiverPort()),
 				},
 			},
 		}
@@ -654,8 +904,14 @@ func (c *InstanceConfig) otelConfig() (*config.Config, error) {
 
 	if c.ServiceGraphs != nil && c.ServiceGraphs.Enabled {
 		processors[servicegraphprocessor.TypeStr] = map[string]interface{}{
-			"wait":      c.ServiceGraphs.Wait,
-			"max_items": c.ServiceGraphs.MaxItems,
+			"wait":                         c.ServiceGraphs.Wait,
+			"max_items":                    c.ServiceGraphs.MaxItems,
+			"dimensions":                   c.ServiceGraphs.Dimensions,
+			"enable_virtual_nodes":         c.ServiceGraphs.EnableVirtualNodes,
+			"virtual_node_peer_attributes": c.ServiceGraphs.VirtualNodePeerAttributes,
+			"metrics_instance":             c.ServiceGraphs.MetricsInstance,
+			"const_labels":                 c.ServiceGraphs.ConstLabels,
+			"traces_instance":              c.Name,
 		}
 		processorNames = append(processorNames, servicegraphprocessor.TypeStr)
 	}
@@ -705,23 +961,7 @@ func (c *InstanceConfig) otelConfig() (*config.Config, error) {
 		serviceMap["extensions"] = extensionsNames
 	}
 	otelMapStructure["service"] = serviceMap
-
-	factories, err := tracingFactories()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create factories: %w", err)
-	}
-
-	if err := validateConfigFromFactories(factories); err != nil {
-		return nil, fmt.Errorf("failed to validate factories: %w", err)
-	}
-
-	configMap := config.NewMapFromStringMap(otelMapStructure)
-	otelCfg, err := configunmarshaler.NewDefault().Unmarshal(configMap, factories)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load OTel config: %w", err)
-	}
-
-	return otelCfg, nil
+	return otelMapStructure, nil
 }
 
 // tracingFactories() only creates the needed factories.  if we decide to add support for a new
@@ -741,6 +981,17 @@ func tracingFactories() (component.Factories, error) {
 		opencensusreceiver.NewFactory(),
 		kafkareceiver.NewFactory(),
 		noopreceiver.NewFactory(),
+		pushreceiver.NewFactory(),
+	)
+	if err != nil {
+		return component.Factories{}, err
+	}
+
+	// Exporters which remote_write entries can send a persistent queue through.
+	queuedExporters, err := component.MakeExporterFactoryMap(
+		otlpexporter.NewFactory(),
+		otlphttpexporter.NewFactory(),
+		jaegerexporter.NewFactory(),
 	)
 	if err != nil {
 		return component.Factories{}, err
@@ -753,6 +1004,7 @@ func tracingFactories() (component.Factories, error) {
 		loadbalancingexporter.NewFactory(),
 		prometheusexporter.NewFactory(),
 		remotewriteexporter.NewFactory(),
+		persistentqueueexporter.NewFactory(queuedExporters),
 	)
 	if err != nil {
 		return component.Factories{}, err
@@ -764,7 +1016,11 @@ func tracingFactories() (component.Factories, error) {
 		promsdprocessor.NewFactory(),
 		spanmetricsprocessor.NewFactory(),
 		automaticloggingprocessor.NewFactory(),
+		logscorrelationprocessor.NewFactory(),
+		redactionprocessor.NewFactory(),
+		tracebufferprocessor.NewFactory(),
 		tailsamplingprocessor.NewFactory(),
+		adaptivesamplingprocessor.NewFactory(),
 		servicegraphprocessor.NewFactory(),
 	)
 	if err != nil {
@@ -783,13 +1039,19 @@ func tracingFactories() (component.Factories, error) {
 // true to splitPipelines if this function should split the input pipelines into two
 // sets: before and after load balancing
 func orderProcessors(processors []string, splitPipelines bool) [][]string {
+	// redaction runs first, so that no other processor sees unredacted
+	// values.
 	order := map[string]int{
+		"redaction":         -1,
 		"attributes":        0,
-		"spanmetrics":       1,
-		"service_graphs":    2,
-		"tail_sampling":     3,
-		"automatic_logging": 4,
-		"batch":             5,
+		"trace_buffer":      1,
+		"spanmetrics":       2,
+		"logs_correlation":  3,
+		"service_graphs":    4,
+		"tail_sampling":     5,
+		"adaptive_sampling": 6,
+		"automatic_logging": 7,
+		"batch":             8,
 	}
 
 	sort.Slice(processors, func(i, j int) bool {
@@ -811,6 +1073,7 @@ func orderProcessors(processors []string, splitPipelines bool) [][]string {
 	for i, processor := range processors {
 		if processor == "batch" ||
 			processor == "tail_sampling" ||
+			processor == "adaptive_sampling" ||
 			processor == "automatic_logging" ||
 			processor == "service_graphs" {
 
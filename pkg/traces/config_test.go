@@ -947,6 +947,180 @@ service:
       exporters: ["otlp/0"]
       processors: ["service_graphs"]
       receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "service graphs with virtual nodes and dimensions",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+service_graphs:
+  enabled: true
+  dimensions: [k8s.namespace.name]
+  enable_virtual_nodes: true
+  virtual_node_peer_attributes: [db.name]
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+processors:
+  service_graphs:
+    dimensions: [k8s.namespace.name]
+    enable_virtual_nodes: true
+    virtual_node_peer_attributes: [db.name]
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: ["service_graphs"]
+      receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "redaction",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+attributes:
+  actions:
+  - key: montgomery
+    value: forever
+    action: update
+redaction:
+  rules:
+  - name: email
+    regex: '[\w.+-]+@[\w-]+\.[\w.]+'
+  allowed_keys: [http.method]
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+processors:
+  attributes:
+    actions:
+    - key: montgomery
+      value: forever
+      action: update
+  redaction:
+    redaction:
+      rules:
+      - name: email
+        regex: '[\w.+-]+@[\w-]+\.[\w.]+'
+      allowed_keys: [http.method]
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: ["redaction", "attributes"]
+      receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "adaptive sampling",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+adaptive_sampling:
+  traces_per_second: 10
+  per_operation: true
+  targets:
+  - service: api
+    traces_per_second: 50
+  decision_wait: 10s
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+processors:
+  adaptive_sampling:
+    adaptive_sampling:
+      traces_per_second: 10
+      per_operation: true
+      targets:
+      - service: api
+        traces_per_second: 50
+      decision_wait: 10s
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: ["adaptive_sampling"]
+      receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "service graphs with metrics instance",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+service_graphs:
+  enabled: true
+  metrics_instance: traces
+  const_labels:
+    cluster: prod
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+processors:
+  service_graphs:
+    metrics_instance: traces
+    const_labels:
+      cluster: prod
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: ["service_graphs"]
+      receivers: ["jaeger"]
 `,
 		},
 		{
@@ -980,6 +1154,49 @@ service:
       exporters: ["jaeger/0"]
       processors: []
       receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "persistent queue",
+			cfg: `
+name: default
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - insecure: true
+    endpoint: example.com:12345
+    persistent_queue:
+      directory: /var/lib/agent/traces-queue
+      max_size_bytes: 1048576
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+exporters:
+  persistent_queue/otlp/0:
+    directory: /var/lib/agent/traces-queue/default/otlp_0
+    max_size_bytes: 1048576
+    exporter_type: otlp
+    exporter:
+      endpoint: example.com:12345
+      compression: gzip
+      headers: {}
+      tls:
+        insecure: true
+      sending_queue:
+        enabled: false
+      retry_on_failure:
+        max_elapsed_time: 60s
+service:
+  pipelines:
+    traces:
+      exporters: ["persistent_queue/otlp/0"]
+      processors: []
+      receivers: ["jaeger"]
 `,
 		},
 		{
@@ -1659,6 +1876,69 @@ func TestOrderProcessors(t *testing.T) {
 				{},
 			},
 		},
+		{
+			processors: []string{
+				"batch",
+				"logs_correlation",
+				"tail_sampling",
+				"attributes",
+			},
+			splitPipelines: true,
+			expected: [][]string{
+				{
+					"attributes",
+					"logs_correlation",
+				},
+				{
+					"tail_sampling",
+					"batch",
+				},
+			},
+		},
+		{
+			processors: []string{
+				"automatic_logging",
+				"tail_sampling",
+				"trace_buffer",
+				"attributes",
+				"redaction",
+			},
+			splitPipelines: true,
+			expected: [][]string{
+				{
+					"redaction",
+					"attributes",
+					"trace_buffer",
+				},
+				{
+					"tail_sampling",
+					"automatic_logging",
+				},
+			},
+		},
+		{
+			processors: []string{
+				"batch",
+				"adaptive_sampling",
+				"automatic_logging",
+				"service_graphs",
+				"attributes",
+				"tail_sampling",
+			},
+			splitPipelines: true,
+			expected: [][]string{
+				{
+					"attributes",
+				},
+				{
+					"service_graphs",
+					"tail_sampling",
+					"adaptive_sampling",
+					"automatic_logging",
+					"batch",
+				},
+			},
+		},
 	}
 
 	for _, tc := range tests {
@@ -1681,6 +1961,90 @@ receivers:
 	assert.True(t, strings.Contains(string(data), "<secret>"))
 }
 
+func TestClusterResolver(t *testing.T) {
+	tt := []struct {
+		name             string
+		cfg              string
+		peers            []string
+		expectedResolver map[string]interface{}
+		expectedError    string
+	}{
+		{
+			name: "default port",
+			cfg: `
+resolver:
+  cluster:
+`,
+			peers: []string{"10.0.0.1:9095", "10.0.0.2:9095"},
+			expectedResolver: map[string]interface{}{
+				"static": map[string]interface{}{
+					"hostnames": []string{"10.0.0.1:4318", "10.0.0.2:4318"},
+				},
+			},
+		},
+		{
+			name: "receiver port",
+			cfg: `
+receiver_port: 8080
+resolver:
+  cluster:
+    refresh_interval: 5s
+`,
+			peers: []string{"agent-0:9095"},
+			expectedResolver: map[string]interface{}{
+				"static": map[string]interface{}{
+					"hostnames": []string{"agent-0:8080"},
+				},
+			},
+		},
+		{
+			name: "no peers",
+			cfg: `
+resolver:
+  cluster:
+`,
+			expectedError: "cluster resolver found no agents in the cluster",
+		},
+		{
+			name: "combined with dns",
+			cfg: `
+resolver:
+  cluster:
+  dns:
+    hostname: agent
+`,
+			peers:         []string{"10.0.0.1:9095"},
+			expectedError: "the cluster resolver can't be combined with other resolvers",
+		},
+		{
+			name: "invalid refresh interval",
+			cfg: `
+resolver:
+  cluster:
+    refresh_interval: 0s
+`,
+			peers:         []string{"10.0.0.1:9095"},
+			expectedError: "cluster resolver refresh_interval must be greater than 0",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var lb loadBalancingConfig
+			require.NoError(t, yaml.Unmarshal([]byte(tc.cfg), &lb))
+			lb.clusterPeers = tc.peers
+
+			actual, err := resolver(&lb)
+			if tc.expectedError != "" {
+				require.EqualError(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedResolver, actual)
+		})
+	}
+}
+
 // sortPipelines is a helper function to lexicographically sort a pipeline's exporters
 func sortPipelines(cfg *config.Config) {
 	tracePipeline := cfg.Pipelines[config.NewComponentID(config.TracesDataType)]
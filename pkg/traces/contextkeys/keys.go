@@ -11,4 +11,10 @@ const (
 
 	// PrometheusRegisterer is used to pass prometheus.Registerer through the context
 	PrometheusRegisterer
+
+	// TraceBuffer is used to pass *tracebufferprocessor.Buffer through the context
+	TraceBuffer
+
+	// PushConsumer is used to pass *pushreceiver.Consumer through the context
+	PushConsumer
 )
@@ -0,0 +1,159 @@
+package traces
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/agent/pkg/metrics/cluster/configapi"
+	"github.com/grafana/agent/pkg/traces/tracebufferprocessor"
+	"go.uber.org/zap"
+)
+
+// WireAPI adds API routes to the provided mux router.
+func (t *Traces) WireAPI(r *mux.Router) {
+	r.HandleFunc("/agent/api/v1/traces/instances", t.ListInstancesHandler).Methods("GET")
+	r.HandleFunc("/agent/api/v1/traces/instances/{instance}", t.InstanceStatusHandler).Methods("GET")
+	r.HandleFunc("/agent/api/v1/traces/instances/{instance}/traces", t.SearchTracesHandler).Methods("GET")
+	r.HandleFunc("/agent/api/v1/traces/instances/{instance}/traces/{trace_id}", t.GetTraceHandler).Methods("GET")
+}
+
+// ListInstancesHandler writes the set of currently running instances to the http.ResponseWriter.
+func (t *Traces) ListInstancesHandler(w http.ResponseWriter, _ *http.Request) {
+	t.mut.Lock()
+	instanceNames := make([]string, 0, len(t.instances))
+	for instance := range t.instances {
+		instanceNames = append(instanceNames, instance)
+	}
+	t.mut.Unlock()
+	sort.Strings(instanceNames)
+
+	err := configapi.WriteResponse(w, http.StatusOK, instanceNames)
+	if err != nil {
+		t.logger.Error("failed to write response", zap.Error(err))
+	}
+}
+
+// InstanceStatusHandler writes the status of an instance's pipelines to the
+// http.ResponseWriter.
+func (t *Traces) InstanceStatusHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["instance"]
+
+	t.mut.Lock()
+	inst, ok := t.instances[name]
+	t.mut.Unlock()
+	if !ok {
+		_ = configapi.WriteError(w, http.StatusNotFound, fmt.Errorf("instance %q not found", name))
+		return
+	}
+
+	status, err := inst.Status()
+	if err != nil {
+		_ = configapi.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = configapi.WriteResponse(w, http.StatusOK, status)
+	if err != nil {
+		t.logger.Error("failed to write response", zap.Error(err))
+	}
+}
+
+// GetTraceHandler writes a trace from an instance's trace buffer to the
+// http.ResponseWriter.
+func (t *Traces) GetTraceHandler(w http.ResponseWriter, r *http.Request) {
+	buffer, ok := t.traceBuffer(w, r)
+	if !ok {
+		return
+	}
+
+	traceID := strings.ToLower(mux.Vars(r)["trace_id"])
+	trace, ok := buffer.Get(traceID)
+	if !ok {
+		_ = configapi.WriteError(w, http.StatusNotFound, fmt.Errorf("trace %q not found", traceID))
+		return
+	}
+
+	err := configapi.WriteResponse(w, http.StatusOK, trace)
+	if err != nil {
+		t.logger.Error("failed to write response", zap.Error(err))
+	}
+}
+
+// SearchTracesHandler writes the traces from an instance's trace buffer
+// matching the query parameters to the http.ResponseWriter.
+func (t *Traces) SearchTracesHandler(w http.ResponseWriter, r *http.Request) {
+	buffer, ok := t.traceBuffer(w, r)
+	if !ok {
+		return
+	}
+
+	q, err := parseTraceQuery(r)
+	if err != nil {
+		_ = configapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = configapi.WriteResponse(w, http.StatusOK, buffer.Search(q))
+	if err != nil {
+		t.logger.Error("failed to write response", zap.Error(err))
+	}
+}
+
+// traceBuffer returns the trace buffer of the instance named in the request.
+// An error is written to w if it isn't found.
+func (t *Traces) traceBuffer(w http.ResponseWriter, r *http.Request) (*tracebufferprocessor.Buffer, bool) {
+	name := mux.Vars(r)["instance"]
+
+	t.mut.Lock()
+	inst, ok := t.instances[name]
+	t.mut.Unlock()
+	if !ok {
+		_ = configapi.WriteError(w, http.StatusNotFound, fmt.Errorf("instance %q not found", name))
+		return nil, false
+	}
+
+	buffer := inst.TraceBuffer()
+	if buffer == nil {
+		_ = configapi.WriteError(w, http.StatusNotFound, fmt.Errorf("instance %q does not have trace_buffer configured", name))
+		return nil, false
+	}
+	return buffer, true
+}
+
+func parseTraceQuery(r *http.Request) (tracebufferprocessor.Query, error) {
+	params := r.URL.Query()
+	q := tracebufferprocessor.Query{
+		Service:  params.Get("service"),
+		SpanName: params.Get("name"),
+		Status:   strings.ToUpper(params.Get("status")),
+	}
+
+	switch q.Status {
+	case "", "UNSET", "OK", "ERROR":
+	default:
+		return q, fmt.Errorf("invalid status %q", q.Status)
+	}
+
+	var err error
+	if v := params.Get("min_duration"); v != "" {
+		if q.MinDuration, err = time.ParseDuration(v); err != nil {
+			return q, fmt.Errorf("invalid min_duration: %w", err)
+		}
+	}
+	if v := params.Get("max_duration"); v != "" {
+		if q.MaxDuration, err = time.ParseDuration(v); err != nil {
+			return q, fmt.Errorf("invalid max_duration: %w", err)
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil {
+			return q, fmt.Errorf("invalid limit: %w", err)
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,230 @@
+package traces
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/agent/pkg/traces/tracebufferprocessor"
+	"github.com/grafana/agent/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/logging"
+	"go.opentelemetry.io/collector/model/pdata"
+	"go.opentelemetry.io/collector/service/external/components"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"gopkg.in/yaml.v2"
+)
+
+func TestTraces_API(t *testing.T) {
+	cfgText := util.Untab(`
+configs:
+- name: default
+  receivers:
+    otlp:
+      protocols:
+        grpc:
+          endpoint: 127.0.0.1:0
+  remote_write:
+    - endpoint: 127.0.0.1:1
+      insecure: true
+      basic_auth:
+        username: user
+        password: hunter2
+      headers:
+        x-scope-orgid: tenant
+      sending_queue:
+        queue_size: 42
+  batch:
+    timeout: 100ms
+  attributes:
+    actions:
+    - key: env
+      value: test
+      action: upsert
+	`)
+
+	var cfg Config
+	dec := yaml.NewDecoder(strings.NewReader(cfgText))
+	dec.SetStrict(true)
+	require.NoError(t, dec.Decode(&cfg))
+
+	traces, err := New(nil, nil, nil, prometheus.NewRegistry(), cfg, logrus.InfoLevel, logging.Format{})
+	require.NoError(t, err)
+	t.Cleanup(traces.Stop)
+
+	r := mux.NewRouter()
+	traces.WireAPI(r)
+
+	t.Run("list instances", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", "/agent/api/v1/traces/instances", nil))
+		require.Equal(t, `{"status":"success","data":["default"]}`, rr.Body.String())
+	})
+
+	t.Run("instance status", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", "/agent/api/v1/traces/instances/default", nil))
+		require.Equal(t, 200, rr.Code)
+
+		var resp struct {
+			Data InstanceStatus `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		status := resp.Data
+
+		require.Equal(t, "default", status.Name)
+		require.NotContains(t, rr.Body.String(), "tenant")
+		headers := status.Config["exporters"].(map[string]interface{})["otlp/0"].(map[string]interface{})["headers"]
+		require.Equal(t, map[string]interface{}{
+			"authorization": scrubbedValue,
+			"x-scope-orgid": scrubbedValue,
+		}, headers)
+
+		require.Equal(t, []ReceiverStatus{{
+			Name:      "otlp",
+			Endpoints: []ReceiverEndpoint{{Protocol: "protocols.grpc", Endpoint: "127.0.0.1:0"}},
+		}}, status.Receivers)
+
+		require.Equal(t, []PipelineStatus{{
+			Name:       "traces",
+			Receivers:  []string{"otlp"},
+			Processors: []string{"attributes", "batch"},
+			Exporters:  []string{"otlp/0"},
+		}}, status.Pipelines)
+
+		require.Len(t, status.Exporters, 1)
+		require.Equal(t, "otlp/0", status.Exporters[0].Name)
+		require.True(t, status.Exporters[0].QueueEnabled)
+		require.Equal(t, 42, status.Exporters[0].QueueCapacity)
+	})
+
+	t.Run("unknown instance", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", "/agent/api/v1/traces/instances/missing", nil))
+		require.Equal(t, 404, rr.Code)
+	})
+}
+
+func TestTraces_TraceBufferAPI(t *testing.T) {
+	cfgText := util.Untab(`
+configs:
+- name: default
+  receivers:
+    otlp:
+      protocols:
+        grpc:
+          endpoint: 127.0.0.1:0
+  remote_write:
+    - endpoint: 127.0.0.1:1
+      insecure: true
+  trace_buffer:
+    max_traces: 10
+- name: disabled
+  receivers:
+    otlp:
+      protocols:
+        grpc:
+          endpoint: 127.0.0.1:0
+  remote_write:
+    - endpoint: 127.0.0.1:1
+      insecure: true
+	`)
+
+	var cfg Config
+	require.NoError(t, yaml.Unmarshal([]byte(cfgText), &cfg))
+
+	traces, err := New(nil, nil, nil, prometheus.NewRegistry(), cfg, logrus.InfoLevel, logging.Format{})
+	require.NoError(t, err)
+	t.Cleanup(traces.Stop)
+
+	r := mux.NewRouter()
+	traces.WireAPI(r)
+
+	td := pdata.NewTraces()
+	rs := td.ResourceSpans().AppendEmpty()
+	rs.Resource().Attributes().InsertString("service.name", "api")
+	span := rs.InstrumentationLibrarySpans().AppendEmpty().Spans().AppendEmpty()
+	span.SetTraceID(pdata.NewTraceID([16]byte{0xab}))
+	span.SetName("GET /users")
+	span.Status().SetCode(pdata.StatusCodeError)
+	traces.instances["default"].TraceBuffer().Add(td)
+	traceID := span.TraceID().HexString()
+
+	t.Run("get trace", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", "/agent/api/v1/traces/instances/default/traces/"+strings.ToUpper(traceID), nil))
+		require.Equal(t, 200, rr.Code)
+
+		var resp struct {
+			Data tracebufferprocessor.Trace `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Equal(t, traceID, resp.Data.TraceID)
+		require.Len(t, resp.Data.Spans, 1)
+		require.Equal(t, "GET /users", resp.Data.Spans[0].Name)
+	})
+
+	t.Run("unknown trace", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", "/agent/api/v1/traces/instances/default/traces/00", nil))
+		require.Equal(t, 404, rr.Code)
+	})
+
+	t.Run("search", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", "/agent/api/v1/traces/instances/default/traces?service=api&status=error", nil))
+		require.Equal(t, 200, rr.Code)
+
+		var resp struct {
+			Data []tracebufferprocessor.TraceSummary `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Len(t, resp.Data, 1)
+		require.Equal(t, traceID, resp.Data[0].TraceID)
+
+		rr = httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", "/agent/api/v1/traces/instances/default/traces?service=db", nil))
+		require.Equal(t, `{"status":"success","data":[]}`, rr.Body.String())
+	})
+
+	t.Run("invalid search", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", "/agent/api/v1/traces/instances/default/traces?min_duration=soon", nil))
+		require.Equal(t, 400, rr.Code)
+	})
+
+	t.Run("not configured", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", "/agent/api/v1/traces/instances/disabled/traces", nil))
+		require.Equal(t, 404, rr.Code)
+	})
+}
+
+func TestExportErrors(t *testing.T) {
+	var errs exportErrors
+	core, _ := observer.New(zapcore.InfoLevel)
+	l := errs.WrapLogger(zap.New(core))
+
+	exporterLogger := l.With(zap.String(components.ZapKindKey, components.ZapKindLogExporter)).
+		With(zap.String(components.ZapNameKey, "otlp/0"))
+	receiverLogger := l.With(zap.String(components.ZapKindKey, components.ZapKindReceiver), zap.String(components.ZapNameKey, "otlp"))
+
+	exporterLogger.Warn("retrying", zap.Error(errors.New("temporary")))
+	receiverLogger.Error("failed to receive", zap.Error(errors.New("receiver error")))
+	_, ok := errs.Get("otlp/0")
+	require.False(t, ok)
+	_, ok = errs.Get("otlp")
+	require.False(t, ok)
+
+	exporterLogger.Error("Exporting failed", zap.Error(errors.New("connection refused")))
+	last, ok := errs.Get("otlp/0")
+	require.True(t, ok)
+	require.Equal(t, "Exporting failed: connection refused", last.err)
+}
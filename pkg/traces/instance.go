@@ -10,6 +10,7 @@ import (
 	"go.opencensus.io/stats/view"
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/model/pdata"
 	"go.opentelemetry.io/collector/service/external/builder"
 	"go.opentelemetry.io/collector/service/external/extensions"
 	"go.opentelemetry.io/otel/metric"
@@ -21,9 +22,17 @@ import (
 	"github.com/grafana/agent/pkg/metrics/instance"
 	"github.com/grafana/agent/pkg/traces/automaticloggingprocessor"
 	"github.com/grafana/agent/pkg/traces/contextkeys"
+	"github.com/grafana/agent/pkg/traces/pushreceiver"
+	"github.com/grafana/agent/pkg/traces/tracebufferprocessor"
 	"github.com/grafana/agent/pkg/util"
 )
 
+// clusterPeersRetryInterval is how often agents are looked for in the
+// cluster while the pipeline using the cluster resolver couldn't be built
+// yet, such as right after the agent joined the cluster. It's a variable so
+// tests can override it.
+var clusterPeersRetryInterval = time.Second
+
 // Instance wraps the OpenTelemetry collector to enable tracing pipelines
 type Instance struct {
 	mut         sync.Mutex
@@ -31,6 +40,19 @@ type Instance struct {
 	logger      *zap.Logger
 	metricViews []*view.View
 
+	otelCfg      *config.Config
+	exportErrors exportErrors
+	traceBuffer  *tracebufferprocessor.Buffer
+	// pushConsumer passes traces pushed through ConsumeTraces to the
+	// pipeline. It's attached to the push receiver of each built pipeline.
+	pushConsumer *pushreceiver.Consumer
+
+	peers PeerProvider
+	// clusterPeers are the peers the pipeline was built with, if it uses the
+	// cluster load balancing resolver.
+	clusterPeers      []string
+	stopWatchingPeers context.CancelFunc
+
 	extensions extensions.Extensions
 	exporter   builder.Exporters
 	pipelines  builder.BuiltPipelines
@@ -38,11 +60,12 @@ type Instance struct {
 }
 
 // NewInstance creates and starts an instance of tracing pipelines.
-func NewInstance(logsSubsystem *logs.Logs, reg prometheus.Registerer, cfg InstanceConfig, logger *zap.Logger, promInstanceManager instance.Manager) (*Instance, error) {
+func NewInstance(logsSubsystem *logs.Logs, reg prometheus.Registerer, cfg InstanceConfig, logger *zap.Logger, promInstanceManager instance.Manager, peers PeerProvider) (*Instance, error) {
 	var err error
 
-	instance := &Instance{}
+	instance := &Instance{pushConsumer: &pushreceiver.Consumer{}}
 	instance.logger = logger
+	instance.peers = peers
 	instance.metricViews, err = newMetricViews(reg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create metric views: %w", err)
@@ -57,30 +80,133 @@ func NewInstance(logsSubsystem *logs.Logs, reg prometheus.Registerer, cfg Instan
 // ApplyConfig updates the configuration of the Instance.
 func (i *Instance) ApplyConfig(logsSubsystem *logs.Logs, promInstanceManager instance.Manager, reg prometheus.Registerer, cfg InstanceConfig) error {
 	i.mut.Lock()
-	defer i.mut.Unlock()
-
-	if util.CompareYAML(cfg, i.cfg) {
-		// No config change
+	changed := !util.CompareYAML(cfg, i.cfg)
+	i.mut.Unlock()
+	if !changed {
 		return nil
 	}
+
+	// Getting the cluster peers reads the ring, so it's done before locking
+	// the Instance. It never waits for agents to join the cluster: the peer
+	// watcher builds the pipeline once they do.
+	peers, peersErr := i.resolveClusterPeers(cfg)
+
+	i.mut.Lock()
+	defer i.mut.Unlock()
+
 	i.cfg = cfg
 
 	// Shut down any existing pipeline
+	i.stopPeerWatcher()
 	i.stop()
+	i.clusterPeers = nil
 
-	err := i.buildAndStartPipeline(context.Background(), cfg, logsSubsystem, promInstanceManager, reg)
-	if err != nil {
-		return fmt.Errorf("failed to create pipeline: %w", err)
+	rebuild := func(peers []string) error {
+		return i.buildAndStartPipeline(context.Background(), withClusterPeers(cfg, peers), logsSubsystem, promInstanceManager, reg)
+	}
+
+	if cfg.LoadBalancing != nil {
+		resolver, err := cfg.LoadBalancing.clusterResolver()
+		if err != nil {
+			return err
+		}
+		if resolver != nil {
+			if i.peers == nil {
+				return fmt.Errorf("failed to create pipeline: %w", peersErr)
+			}
+
+			// The peer watcher builds the pipeline once agents are found in
+			// the cluster, so failing to find them isn't fatal.
+			ctx, cancel := context.WithCancel(context.Background())
+			i.stopWatchingPeers = cancel
+			go i.watchPeers(ctx, resolver.RefreshInterval, rebuild)
+
+			if peersErr != nil {
+				i.logger.Warn("failed to get cluster peers, the pipeline will be created once agents are found in the cluster", zap.Error(peersErr))
+				return nil
+			}
+		}
 	}
 
+	if err := rebuild(peers); err != nil {
+		return fmt.Errorf("failed to create pipeline: %w", err)
+	}
+	i.clusterPeers = peers
 	return nil
 }
 
+// watchPeers rebuilds the pipeline with rebuild when the agents in the
+// cluster change, until ctx is canceled. Until the pipeline is built, agents
+// are looked for every clusterPeersRetryInterval instead of every interval.
+func (i *Instance) watchPeers(ctx context.Context, interval time.Duration, rebuild func(peers []string) error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(i.nextPeersCheck(interval)):
+		}
+
+		peers, err := i.peers.ClusterPeers()
+		if err != nil {
+			i.logger.Warn("failed to get cluster peers", zap.Error(err))
+			continue
+		} else if len(peers) == 0 {
+			continue
+		}
+
+		i.mut.Lock()
+		if ctx.Err() == nil && !stringSlicesEqual(peers, i.clusterPeers) {
+			i.logger.Info("cluster peers changed, rebuilding pipeline", zap.Strings("peers", peers))
+			i.stop()
+			// clusterPeers is only set when the pipeline was built, so a
+			// failed build is retried on the next refresh.
+			i.clusterPeers = nil
+			if err := rebuild(peers); err != nil {
+				i.logger.Error("failed to rebuild pipeline", zap.Error(err))
+			} else {
+				i.clusterPeers = peers
+			}
+		}
+		i.mut.Unlock()
+	}
+}
+
+// nextPeersCheck returns how long watchPeers waits before getting the
+// cluster peers again.
+func (i *Instance) nextPeersCheck(interval time.Duration) time.Duration {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	if i.clusterPeers == nil && clusterPeersRetryInterval < interval {
+		return clusterPeersRetryInterval
+	}
+	return interval
+}
+
+func (i *Instance) stopPeerWatcher() {
+	if i.stopWatchingPeers != nil {
+		i.stopWatchingPeers()
+		i.stopWatchingPeers = nil
+	}
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // Stop stops the OpenTelemetry collector subsystem
 func (i *Instance) Stop() {
 	i.mut.Lock()
 	defer i.mut.Unlock()
 
+	i.stopPeerWatcher()
 	i.stop()
 	view.Unregister(i.metricViews...)
 }
@@ -147,6 +273,46 @@ func (i *Instance) stop() {
 	i.pipelines = nil
 	i.exporter = nil
 	i.extensions = nil
+	i.otelCfg = nil
+	i.traceBuffer = nil
+}
+
+// resolveClusterPeers returns the peers used by the cluster load balancing
+// resolver of cfg, or nil if it isn't used.
+func (i *Instance) resolveClusterPeers(cfg InstanceConfig) ([]string, error) {
+	if !cfg.UsesClusterResolver() {
+		return nil, nil
+	}
+	if i.peers == nil {
+		return nil, fmt.Errorf("the cluster resolver requires the agent to run in a cluster")
+	}
+	peers, err := i.peers.ClusterPeers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster peers: %w", err)
+	} else if len(peers) == 0 {
+		return nil, fmt.Errorf("no agents found in the cluster")
+	}
+	return peers, nil
+}
+
+// withClusterPeers returns a copy of cfg whose cluster load balancing
+// resolver uses peers.
+func withClusterPeers(cfg InstanceConfig, peers []string) InstanceConfig {
+	if cfg.LoadBalancing == nil {
+		return cfg
+	}
+	lb := *cfg.LoadBalancing
+	lb.clusterPeers = peers
+	cfg.LoadBalancing = &lb
+	return cfg
+}
+
+// TraceBuffer returns the buffer of recent traces, or nil if the Instance
+// doesn't have trace_buffer configured.
+func (i *Instance) TraceBuffer() *tracebufferprocessor.Buffer {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	return i.traceBuffer
 }
 
 func (i *Instance) buildAndStartPipeline(ctx context.Context, cfg InstanceConfig, logs *logs.Logs, instManager instance.Manager, reg prometheus.Registerer) error {
@@ -164,7 +330,8 @@ func (i *Instance) buildAndStartPipeline(ctx context.Context, cfg InstanceConfig
 		}
 	}
 
-	if cfg.SpanMetrics != nil && len(cfg.SpanMetrics.MetricsInstance) != 0 {
+	if (cfg.SpanMetrics != nil && len(cfg.SpanMetrics.MetricsInstance) != 0) ||
+		(cfg.ServiceGraphs != nil && len(cfg.ServiceGraphs.MetricsInstance) != 0) {
 		ctx = context.WithValue(ctx, contextkeys.Metrics, instManager)
 	}
 
@@ -173,27 +340,38 @@ func (i *Instance) buildAndStartPipeline(ctx context.Context, cfg InstanceConfig
 			"Load balancing is required for those features to properly work in multi agent deployments")
 	}
 
-	if cfg.AutomaticLogging != nil && cfg.AutomaticLogging.Backend != automaticloggingprocessor.BackendStdout {
+	if (cfg.AutomaticLogging != nil && cfg.AutomaticLogging.Backend != automaticloggingprocessor.BackendStdout) || cfg.LogsCorrelation != nil {
 		ctx = context.WithValue(ctx, contextkeys.Logs, logs)
 	}
 
-	if cfg.ServiceGraphs != nil {
+	if cfg.ServiceGraphs != nil || cfg.Redaction != nil || cfg.AdaptiveSampling != nil || cfg.usesPersistentQueue() {
 		ctx = context.WithValue(ctx, contextkeys.PrometheusRegisterer, reg)
 	}
 
+	if cfg.TraceBuffer != nil {
+		i.traceBuffer = tracebufferprocessor.NewBuffer(*cfg.TraceBuffer)
+		ctx = context.WithValue(ctx, contextkeys.TraceBuffer, i.traceBuffer)
+	}
+
 	factories, err := tracingFactories()
 	if err != nil {
 		return fmt.Errorf("failed to load tracing factories: %w", err)
 	}
 
+	addPushReceiver(otelConfig, factories)
+	ctx = context.WithValue(ctx, contextkeys.PushConsumer, i.pushConsumer)
+
 	appinfo := component.BuildInfo{
 		Command:     "agent",
 		Description: "agent",
 		Version:     build.Version,
 	}
 
+	i.otelCfg = otelConfig
+	i.exportErrors.Reset()
+
 	settings := component.TelemetrySettings{
-		Logger:         i.logger,
+		Logger:         i.exportErrors.WrapLogger(i.logger),
 		TracerProvider: trace.NewNoopTracerProvider(),
 		MeterProvider:  metric.NewNoopMeterProvider(),
 	}
@@ -246,6 +424,31 @@ func (i *Instance) buildAndStartPipeline(ctx context.Context, cfg InstanceConfig
 	return i.extensions.NotifyPipelineReady()
 }
 
+// addPushReceiver adds the push receiver to the pipeline receiving traces from
+// the configured receivers.
+func addPushReceiver(otelConfig *config.Config, factories component.Factories) {
+	id := config.NewComponentID(pushreceiver.TypeStr)
+	otelConfig.Receivers[id] = factories.Receivers[pushreceiver.TypeStr].CreateDefaultConfig()
+
+	// The pipeline is split in two when load balancing, with the first one
+	// receiving traces from the configured receivers.
+	for _, pipelineID := range []config.ComponentID{
+		config.NewComponentID(config.TracesDataType),
+		config.NewComponentIDWithName(config.TracesDataType, "0"),
+	} {
+		if p, ok := otelConfig.Pipelines[pipelineID]; ok {
+			p.Receivers = append(p.Receivers, id)
+			return
+		}
+	}
+}
+
+// ConsumeTraces passes td to the instance's pipeline, as if it was received
+// by one of its receivers.
+func (i *Instance) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	return i.pushConsumer.ConsumeTraces(ctx, td)
+}
+
 // ReportFatalError implements component.Host
 func (i *Instance) ReportFatalError(err error) {
 	i.logger.Error("fatal error reported", zap.Error(err))
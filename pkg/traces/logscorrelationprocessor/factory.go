@@ -0,0 +1,75 @@
+package logscorrelationprocessor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/agent/pkg/logs"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/consumer"
+)
+
+// TypeStr is the unique identifier for the Logs Correlation processor.
+const TypeStr = "logs_correlation"
+
+// Config holds the configuration for the Logs Correlation processor.
+type Config struct {
+	config.ProcessorSettings `mapstructure:",squash"`
+
+	CorrelationConfig *LogsCorrelationConfig `mapstructure:"logs_correlation"`
+}
+
+// LogsCorrelationConfig holds config information for logs correlation.
+type LogsCorrelationConfig struct {
+	// LogsName is the name of the logs instance which receives the spans. It
+	// must have trace_correlation configured.
+	LogsName string `mapstructure:"logs_instance_name" yaml:"logs_instance_name,omitempty"`
+}
+
+// Validate ensures that the LogsCorrelationConfig is valid.
+func (c *LogsCorrelationConfig) Validate(logsConfig *logs.Config) error {
+	if c.LogsName == "" {
+		return fmt.Errorf("logs_instance_name must be set")
+	}
+	if logsConfig == nil {
+		return fmt.Errorf("logs instance %s is set but no logs config is provided", c.LogsName)
+	}
+
+	for _, inst := range logsConfig.Configs {
+		if inst.Name != c.LogsName {
+			continue
+		}
+		if inst.TraceCorrelation == nil {
+			return fmt.Errorf("logs config %s does not have trace_correlation configured", c.LogsName)
+		}
+		return nil
+	}
+	return fmt.Errorf("specified logs config %s not found in agent config", c.LogsName)
+}
+
+// NewFactory returns a new factory for the Logs Correlation processor.
+func NewFactory() component.ProcessorFactory {
+	return component.NewProcessorFactory(
+		TypeStr,
+		createDefaultConfig,
+		component.WithTracesProcessor(createTraceProcessor),
+	)
+}
+
+func createDefaultConfig() config.Processor {
+	return &Config{
+		ProcessorSettings: config.NewProcessorSettings(config.NewComponentIDWithName(TypeStr, TypeStr)),
+	}
+}
+
+func createTraceProcessor(
+	_ context.Context,
+	_ component.ProcessorCreateSettings,
+	cfg config.Processor,
+	nextConsumer consumer.Traces,
+) (component.TracesProcessor, error) {
+
+	oCfg := cfg.(*Config)
+	return newTraceProcessor(nextConsumer, oCfg.CorrelationConfig)
+}
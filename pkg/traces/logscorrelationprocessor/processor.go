@@ -0,0 +1,82 @@
+// Package logscorrelationprocessor sends the attributes of spans to a logs
+// instance, which uses them to add trace IDs to log entries.
+package logscorrelationprocessor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/agent/pkg/logs"
+	"github.com/grafana/agent/pkg/traces/contextkeys"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenterror"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+// spanObserver records spans for trace correlation. It is implemented by
+// *logs.Instance.
+type spanObserver interface {
+	ObserveSpan(traceID string, attrs ...pdata.AttributeMap)
+}
+
+type processor struct {
+	nextConsumer consumer.Traces
+	cfg          *LogsCorrelationConfig
+	observer     spanObserver
+}
+
+func newTraceProcessor(nextConsumer consumer.Traces, cfg *LogsCorrelationConfig) (component.TracesProcessor, error) {
+	if nextConsumer == nil {
+		return nil, componenterror.ErrNilNextConsumer
+	}
+	if cfg == nil || cfg.LogsName == "" {
+		return nil, fmt.Errorf("logs correlation processor requires logs_instance_name to be set")
+	}
+
+	return &processor{
+		nextConsumer: nextConsumer,
+		cfg:          cfg,
+	}, nil
+}
+
+func (p *processor) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	for i := 0; i < td.ResourceSpans().Len(); i++ {
+		rs := td.ResourceSpans().At(i)
+		resAttrs := rs.Resource().Attributes()
+
+		for j := 0; j < rs.InstrumentationLibrarySpans().Len(); j++ {
+			spans := rs.InstrumentationLibrarySpans().At(j).Spans()
+
+			for k := 0; k < spans.Len(); k++ {
+				span := spans.At(k)
+				p.observer.ObserveSpan(span.TraceID().HexString(), span.Attributes(), resAttrs)
+			}
+		}
+	}
+
+	return p.nextConsumer.ConsumeTraces(ctx, td)
+}
+
+func (p *processor) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{}
+}
+
+// Start is invoked during service startup.
+func (p *processor) Start(ctx context.Context, _ component.Host) error {
+	logs, ok := ctx.Value(contextkeys.Logs).(*logs.Logs)
+	if !ok {
+		return fmt.Errorf("key does not contain a logs instance")
+	}
+	inst := logs.Instance(p.cfg.LogsName)
+	if inst == nil {
+		return fmt.Errorf("logs instance %s not found", p.cfg.LogsName)
+	}
+	p.observer = inst
+	return nil
+}
+
+// Shutdown is invoked during service shutdown.
+func (p *processor) Shutdown(context.Context) error {
+	return nil
+}
@@ -0,0 +1,87 @@
+package logscorrelationprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/agent/pkg/logs"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+func TestValidate(t *testing.T) {
+	logsConfig := &logs.Config{
+		Configs: []*logs.InstanceConfig{
+			{Name: "plain"},
+			{Name: "correlated", TraceCorrelation: &logs.TraceCorrelationConfig{}},
+		},
+	}
+
+	tt := []struct {
+		name   string
+		cfg    LogsCorrelationConfig
+		expect string
+	}{
+		{name: "valid", cfg: LogsCorrelationConfig{LogsName: "correlated"}},
+		{name: "missing name", expect: "logs_instance_name must be set"},
+		{
+			name:   "not found",
+			cfg:    LogsCorrelationConfig{LogsName: "missing"},
+			expect: "specified logs config missing not found in agent config",
+		},
+		{
+			name:   "not configured",
+			cfg:    LogsCorrelationConfig{LogsName: "plain"},
+			expect: "logs config plain does not have trace_correlation configured",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.cfg.Validate(logsConfig)
+			if tc.expect == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tc.expect)
+		})
+	}
+}
+
+func TestConsumeTraces(t *testing.T) {
+	next := new(consumertest.TracesSink)
+	p, err := newTraceProcessor(next, &LogsCorrelationConfig{LogsName: "default"})
+	require.NoError(t, err)
+
+	obs := &fakeObserver{}
+	p.(*processor).observer = obs
+
+	td := pdata.NewTraces()
+	rs := td.ResourceSpans().AppendEmpty()
+	rs.Resource().Attributes().InsertString("service.name", "checkout")
+	span := rs.InstrumentationLibrarySpans().AppendEmpty().Spans().AppendEmpty()
+	span.SetTraceID(pdata.NewTraceID([16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}))
+	span.Attributes().InsertString("http.request_id", "req-1")
+
+	require.NoError(t, p.ConsumeTraces(context.Background(), td))
+	require.Equal(t, 1, next.SpanCount())
+
+	require.Equal(t, []string{"0102030405060708090a0b0c0d0e0f10"}, obs.traceIDs)
+	v, ok := obs.attrs[0][0].Get("http.request_id")
+	require.True(t, ok)
+	require.Equal(t, "req-1", v.StringVal())
+	v, ok = obs.attrs[0][1].Get("service.name")
+	require.True(t, ok)
+	require.Equal(t, "checkout", v.StringVal())
+}
+
+type fakeObserver struct {
+	traceIDs []string
+	attrs    [][]pdata.AttributeMap
+}
+
+func (o *fakeObserver) ObserveSpan(traceID string, attrs ...pdata.AttributeMap) {
+	o.traceIDs = append(o.traceIDs, traceID)
+	o.attrs = append(o.attrs, attrs)
+}
@@ -0,0 +1,220 @@
+package persistentqueueexporter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/grafana/agent/pkg/traces/contextkeys"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/consumererror"
+	"go.opentelemetry.io/collector/model/otlp"
+	"go.opentelemetry.io/collector/model/pdata"
+	"go.uber.org/zap"
+)
+
+const (
+	minRetryBackoff = time.Second
+	maxRetryBackoff = time.Minute
+)
+
+// persistentQueueExporter writes spans to a queue on disk. Batches are sent
+// in order by an exporter, and are only removed from the queue once they've
+// been sent or failed permanently.
+type persistentQueueExporter struct {
+	logger *zap.Logger
+	cfg    *Config
+	inner  component.TracesExporter
+	queue  *diskQueue
+
+	marshaler   pdata.TracesMarshaler
+	unmarshaler pdata.TracesUnmarshaler
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	reg          prometheus.Registerer
+	collectors   []prometheus.Collector
+	droppedSpans *prometheus.CounterVec
+}
+
+var _ component.TracesExporter = (*persistentQueueExporter)(nil)
+
+func newPersistentQueueExporter(logger *zap.Logger, cfg *Config, inner component.TracesExporter) (*persistentQueueExporter, error) {
+	queue, err := openDiskQueue(cfg.Directory, cfg.MaxSizeBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &persistentQueueExporter{
+		logger:      logger,
+		cfg:         cfg,
+		inner:       inner,
+		queue:       queue,
+		marshaler:   otlp.NewProtobufTracesMarshaler(),
+		unmarshaler: otlp.NewProtobufTracesUnmarshaler(),
+		done:        make(chan struct{}),
+	}, nil
+}
+
+func (e *persistentQueueExporter) Start(ctx context.Context, host component.Host) error {
+	if reg, ok := ctx.Value(contextkeys.PrometheusRegisterer).(prometheus.Registerer); ok && reg != nil {
+		if err := e.registerMetrics(reg); err != nil {
+			return err
+		}
+	}
+
+	if err := e.inner.Start(ctx, host); err != nil {
+		return err
+	}
+
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	go e.run()
+
+	if n := e.queue.Len(); n > 0 {
+		e.logger.Info("replaying persistent queue", zap.Int("batches", n))
+	}
+	return nil
+}
+
+func (e *persistentQueueExporter) registerMetrics(reg prometheus.Registerer) error {
+	constLabels := prometheus.Labels{"exporter": e.cfg.ID().Name()}
+
+	e.droppedSpans = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "traces",
+		Name:        "persistent_queue_dropped_spans_total",
+		Help:        "Total count of spans dropped by the persistent queue",
+		ConstLabels: constLabels,
+	}, []string{"reason"})
+
+	cs := []prometheus.Collector{
+		e.droppedSpans,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   "traces",
+			Name:        "persistent_queue_batches",
+			Help:        "Number of batches in the persistent queue",
+			ConstLabels: constLabels,
+		}, func() float64 { return float64(e.queue.Len()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   "traces",
+			Name:        "persistent_queue_size_bytes",
+			Help:        "Size of the batches in the persistent queue",
+			ConstLabels: constLabels,
+		}, func() float64 { return float64(e.queue.Bytes()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   "traces",
+			Name:        "persistent_queue_oldest_batch_age_seconds",
+			Help:        "Age of the oldest batch in the persistent queue",
+			ConstLabels: constLabels,
+		}, func() float64 { return e.queue.OldestAge().Seconds() }),
+	}
+
+	e.reg = reg
+	for _, c := range cs {
+		if err := reg.Register(c); err != nil {
+			e.unregisterMetrics()
+			return err
+		}
+		e.collectors = append(e.collectors, c)
+	}
+	return nil
+}
+
+func (e *persistentQueueExporter) unregisterMetrics() {
+	for _, c := range e.collectors {
+		e.reg.Unregister(c)
+	}
+	e.collectors = nil
+}
+
+func (e *persistentQueueExporter) Shutdown(ctx context.Context) error {
+	if e.cancel != nil {
+		e.cancel()
+		<-e.done
+	}
+	e.unregisterMetrics()
+	return e.inner.Shutdown(ctx)
+}
+
+func (e *persistentQueueExporter) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{}
+}
+
+func (e *persistentQueueExporter) ConsumeTraces(_ context.Context, td pdata.Traces) error {
+	data, err := e.marshaler.MarshalTraces(td)
+	if err != nil {
+		return consumererror.NewPermanent(fmt.Errorf("failed to marshal spans: %w", err))
+	}
+
+	if err := e.queue.Push(data); err != nil {
+		e.dropped("queue_full", td.SpanCount())
+		return consumererror.NewPermanent(err)
+	}
+	return nil
+}
+
+func (e *persistentQueueExporter) dropped(reason string, spans int) {
+	if e.droppedSpans != nil {
+		e.droppedSpans.WithLabelValues(reason).Add(float64(spans))
+	}
+}
+
+// run sends batches from the queue until the exporter is shut down.
+func (e *persistentQueueExporter) run() {
+	defer close(e.done)
+
+	backoff := minRetryBackoff
+	for {
+		seq, data, ok, err := e.queue.Peek()
+		switch {
+		case err != nil:
+			e.logger.Error("failed to read batch from persistent queue, dropping it", zap.Error(err))
+			e.remove(seq)
+			continue
+		case !ok:
+			select {
+			case <-e.ctx.Done():
+				return
+			case <-e.queue.notify:
+			}
+			continue
+		}
+
+		td, err := e.unmarshaler.UnmarshalTraces(data)
+		if err != nil {
+			e.logger.Error("failed to decode batch from persistent queue, dropping it", zap.Error(err))
+			e.remove(seq)
+			continue
+		}
+
+		err = e.inner.ConsumeTraces(e.ctx, td)
+		switch {
+		case err == nil:
+			backoff = minRetryBackoff
+			e.remove(seq)
+		case consumererror.IsPermanent(err):
+			e.logger.Error("failed to send batch from persistent queue, dropping it", zap.Error(err))
+			e.dropped("permanent_error", td.SpanCount())
+			e.remove(seq)
+		default:
+			e.logger.Warn("failed to send batch from persistent queue, retrying", zap.Error(err), zap.Duration("backoff", backoff))
+			select {
+			case <-e.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > maxRetryBackoff {
+				backoff = maxRetryBackoff
+			}
+		}
+	}
+}
+
+func (e *persistentQueueExporter) remove(seq uint64) {
+	if err := e.queue.Remove(seq); err != nil {
+		e.logger.Error("failed to remove batch from persistent queue", zap.Error(err))
+	}
+}
@@ -0,0 +1,186 @@
+package persistentqueueexporter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/agent/pkg/traces/contextkeys"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/consumererror"
+	"go.opentelemetry.io/collector/model/pdata"
+	"go.uber.org/zap"
+)
+
+func TestDiskQueue(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := openDiskQueue(dir, 10)
+	require.NoError(t, err)
+	require.NoError(t, q.Push([]byte("abc")))
+	require.NoError(t, q.Push([]byte("def")))
+	require.Equal(t, 2, q.Len())
+	require.Equal(t, int64(6), q.Bytes())
+
+	// Batches which don't fit are rejected.
+	require.Equal(t, errQueueFull, q.Push([]byte("ghijk")))
+
+	seq, data, ok, err := q.Peek()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "abc", string(data))
+	require.NoError(t, q.Remove(seq))
+
+	// The queue is kept in order across restarts.
+	require.NoError(t, q.Push([]byte("ghijk")))
+	q, err = openDiskQueue(dir, 10)
+	require.NoError(t, err)
+	require.Equal(t, 2, q.Len())
+	require.Equal(t, int64(8), q.Bytes())
+
+	for _, expect := range []string{"def", "ghijk"} {
+		seq, data, ok, err := q.Peek()
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, expect, string(data))
+		require.NoError(t, q.Remove(seq))
+	}
+
+	_, _, ok, err = q.Peek()
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestPersistentQueueExporter_Replay(t *testing.T) {
+	cfg := testConfig(t)
+
+	// Spans are queued while the backend is unreachable.
+	first, err := newPersistentQueueExporter(zap.NewNop(), cfg, &mockExporter{err: errors.New("connection refused")})
+	require.NoError(t, err)
+	require.NoError(t, first.ConsumeTraces(context.Background(), testTraces("a")))
+	require.NoError(t, first.ConsumeTraces(context.Background(), testTraces("b")))
+	require.NoError(t, first.Shutdown(context.Background()))
+
+	// The queue is sent in order after a restart.
+	inner := &mockExporter{}
+	second, err := newPersistentQueueExporter(zap.NewNop(), cfg, inner)
+	require.NoError(t, err)
+
+	reg := prometheus.NewRegistry()
+	ctx := context.WithValue(context.Background(), contextkeys.PrometheusRegisterer, prometheus.Registerer(reg))
+	require.NoError(t, second.Start(ctx, componenttest.NewNopHost()))
+	defer second.Shutdown(context.Background())
+
+	require.Eventually(t, func() bool { return len(inner.Spans()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"a", "b"}, inner.Spans())
+	require.Eventually(t, func() bool { return second.queue.Len() == 0 }, time.Second, 10*time.Millisecond)
+
+	count, err := testutil.GatherAndCount(reg, "traces_persistent_queue_batches")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
+func TestPersistentQueueExporter_Retry(t *testing.T) {
+	inner := &mockExporter{failures: 1, err: errors.New("unavailable")}
+	exp, err := newPersistentQueueExporter(zap.NewNop(), testConfig(t), inner)
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+	defer exp.Shutdown(context.Background())
+
+	require.NoError(t, exp.ConsumeTraces(context.Background(), testTraces("a")))
+	require.NoError(t, exp.ConsumeTraces(context.Background(), testTraces("b")))
+
+	// The failed batch is retried before sending the next one.
+	require.Eventually(t, func() bool { return len(inner.Spans()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"a", "b"}, inner.Spans())
+}
+
+func TestPersistentQueueExporter_PermanentError(t *testing.T) {
+	inner := &mockExporter{failures: 1, err: consumererror.NewPermanent(errors.New("bad request"))}
+	exp, err := newPersistentQueueExporter(zap.NewNop(), testConfig(t), inner)
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), componenttest.NewNopHost()))
+	defer exp.Shutdown(context.Background())
+
+	require.NoError(t, exp.ConsumeTraces(context.Background(), testTraces("a")))
+	require.NoError(t, exp.ConsumeTraces(context.Background(), testTraces("b")))
+
+	// Batches failing permanently are dropped.
+	require.Eventually(t, func() bool { return len(inner.Spans()) == 1 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"b"}, inner.Spans())
+}
+
+func testConfig(t *testing.T) *Config {
+	cfg := createDefaultConfig().(*Config)
+	cfg.SetIDName("otlp/0")
+	cfg.Directory = t.TempDir()
+	cfg.ExporterType = "otlp"
+	return cfg
+}
+
+func testTraces(name string) pdata.Traces {
+	td := pdata.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().InstrumentationLibrarySpans().AppendEmpty().Spans().AppendEmpty()
+	span.SetName(name)
+	return td
+}
+
+// mockExporter records the names of spans it receives. It fails the first
+// failures calls with err, or all calls if failures is 0 and err is set.
+type mockExporter struct {
+	mut      sync.Mutex
+	failures int
+	err      error
+	spans    []string
+}
+
+var _ component.TracesExporter = (*mockExporter)(nil)
+
+func (m *mockExporter) Start(context.Context, component.Host) error { return nil }
+
+func (m *mockExporter) Shutdown(context.Context) error { return nil }
+
+func (m *mockExporter) Capabilities() consumer.Capabilities { return consumer.Capabilities{} }
+
+func (m *mockExporter) ConsumeTraces(_ context.Context, td pdata.Traces) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if m.err != nil {
+		if m.failures == 0 {
+			return m.err
+		}
+		m.failures--
+		if m.failures == 0 {
+			err := m.err
+			m.err = nil
+			return err
+		}
+		return m.err
+	}
+
+	rss := td.ResourceSpans()
+	for i := 0; i < rss.Len(); i++ {
+		ilss := rss.At(i).InstrumentationLibrarySpans()
+		for j := 0; j < ilss.Len(); j++ {
+			spans := ilss.At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				m.spans = append(m.spans, spans.At(k).Name())
+			}
+		}
+	}
+	return nil
+}
+
+func (m *mockExporter) Spans() []string {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return append([]string{}, m.spans...)
+}
@@ -0,0 +1,108 @@
+package persistentqueueexporter
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config"
+)
+
+const (
+	// TypeStr is the unique identifier for the persistent queue exporter.
+	TypeStr = "persistent_queue"
+
+	// DefaultMaxSizeBytes is the default maximum size of a queue on disk.
+	DefaultMaxSizeBytes = 512 * 1024 * 1024
+)
+
+var _ config.Exporter = (*Config)(nil)
+
+// Config holds the configuration for the persistent queue exporter.
+type Config struct {
+	config.ExporterSettings `mapstructure:",squash"`
+
+	// Directory holds the queue. It must not be shared with other queues.
+	Directory string `mapstructure:"directory"`
+	// MaxSizeBytes bounds the size of the queue on disk. Spans are rejected
+	// once the queue is full.
+	MaxSizeBytes int64 `mapstructure:"max_size_bytes"`
+
+	// ExporterType is the type of the exporter which sends queued spans.
+	ExporterType config.Type `mapstructure:"exporter_type"`
+	// Exporter is the config of the exporter which sends queued spans.
+	Exporter map[string]interface{} `mapstructure:"exporter"`
+}
+
+// Validate implements config.Exporter.
+func (c *Config) Validate() error {
+	if c.Directory == "" {
+		return fmt.Errorf("directory must be set")
+	}
+	if c.MaxSizeBytes <= 0 {
+		return fmt.Errorf("max_size_bytes must be greater than 0")
+	}
+	if c.ExporterType == "" {
+		return fmt.Errorf("exporter_type must be set")
+	}
+	return nil
+}
+
+// NewFactory returns a new factory for the persistent queue exporter.
+// Queued spans are sent by exporters created from exporterFactories.
+func NewFactory(exporterFactories map[config.Type]component.ExporterFactory) component.ExporterFactory {
+	return component.NewExporterFactory(
+		TypeStr,
+		createDefaultConfig,
+		component.WithTracesExporter(func(ctx context.Context, set component.ExporterCreateSettings, cfg config.Exporter) (component.TracesExporter, error) {
+			return createTracesExporter(ctx, set, cfg, exporterFactories)
+		}),
+	)
+}
+
+func createDefaultConfig() config.Exporter {
+	return &Config{
+		ExporterSettings: config.NewExporterSettings(config.NewComponentID(TypeStr)),
+		MaxSizeBytes:     DefaultMaxSizeBytes,
+	}
+}
+
+func createTracesExporter(
+	ctx context.Context,
+	set component.ExporterCreateSettings,
+	cfg config.Exporter,
+	exporterFactories map[config.Type]component.ExporterFactory,
+) (component.TracesExporter, error) {
+
+	eCfg := cfg.(*Config)
+
+	factory, ok := exporterFactories[eCfg.ExporterType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported exporter type %q", eCfg.ExporterType)
+	}
+
+	// The exporter has the name of the queue so that its errors and metrics
+	// can be matched to the remote_write entry.
+	innerCfg := factory.CreateDefaultConfig()
+	innerCfg.SetIDName(eCfg.ID().Name())
+	if err := unmarshal(config.NewMapFromStringMap(eCfg.Exporter), innerCfg); err != nil {
+		return nil, fmt.Errorf("invalid config for exporter %s: %w", eCfg.ExporterType, err)
+	}
+	if err := innerCfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config for exporter %s: %w", eCfg.ExporterType, err)
+	}
+
+	inner, err := factory.CreateTracesExporter(ctx, set, innerCfg)
+	if err != nil {
+		return nil, err
+	}
+	return newPersistentQueueExporter(set.Logger, eCfg, inner)
+}
+
+// unmarshal unmarshals an exporter config the same way the collector does.
+func unmarshal(componentSection *config.Map, intoCfg interface{}) error {
+	if cu, ok := intoCfg.(config.Unmarshallable); ok {
+		return cu.Unmarshal(componentSection)
+	}
+	return componentSection.UnmarshalExact(intoCfg)
+}
@@ -0,0 +1,197 @@
+package persistentqueueexporter
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	batchExt = ".batch"
+	tmpExt   = ".tmp"
+)
+
+// errQueueFull is returned when pushing to a full queue.
+var errQueueFull = errors.New("persistent queue is full")
+
+// diskQueue is a FIFO queue of batches stored as one file per batch. Files
+// are named after a sequence number, so the queue order survives restarts.
+type diskQueue struct {
+	dir      string
+	maxBytes int64
+
+	mut     sync.Mutex
+	items   []queueItem // Oldest first
+	bytes   int64
+	nextSeq uint64
+
+	// notify receives a value when a batch is pushed.
+	notify chan struct{}
+}
+
+type queueItem struct {
+	seq     uint64
+	size    int64
+	created time.Time
+}
+
+// openDiskQueue opens the queue stored in dir, creating dir if it doesn't
+// exist.
+func openDiskQueue(dir string, maxBytes int64) (*diskQueue, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue directory: %w", err)
+	}
+
+	q := &diskQueue{
+		dir:      dir,
+		maxBytes: maxBytes,
+		notify:   make(chan struct{}, 1),
+	}
+	for _, fi := range files {
+		name := fi.Name()
+		switch {
+		case strings.HasSuffix(name, tmpExt):
+			// Left over from a batch which was never fully written.
+			_ = os.Remove(filepath.Join(dir, name))
+		case strings.HasSuffix(name, batchExt):
+			seq, err := strconv.ParseUint(strings.TrimSuffix(name, batchExt), 10, 64)
+			if err != nil {
+				continue
+			}
+			q.items = append(q.items, queueItem{seq: seq, size: fi.Size(), created: fi.ModTime()})
+			q.bytes += fi.Size()
+		}
+	}
+	sort.Slice(q.items, func(i, j int) bool { return q.items[i].seq < q.items[j].seq })
+	if len(q.items) > 0 {
+		q.nextSeq = q.items[len(q.items)-1].seq + 1
+		q.signal()
+	}
+	return q, nil
+}
+
+func (q *diskQueue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, batchExt))
+}
+
+func (q *diskQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Push appends a batch to the queue. errQueueFull is returned if the batch
+// doesn't fit in the queue.
+func (q *diskQueue) Push(data []byte) error {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	size := int64(len(data))
+	if q.bytes+size > q.maxBytes {
+		return errQueueFull
+	}
+
+	seq := q.nextSeq
+	path := q.path(seq)
+	if err := writeFileSync(path+tmpExt, data); err != nil {
+		return err
+	}
+	if err := os.Rename(path+tmpExt, path); err != nil {
+		_ = os.Remove(path + tmpExt)
+		return err
+	}
+
+	q.nextSeq++
+	q.items = append(q.items, queueItem{seq: seq, size: size, created: time.Now()})
+	q.bytes += size
+	q.signal()
+	return nil
+}
+
+// Peek returns the oldest batch in the queue without removing it. ok is
+// false if the queue is empty.
+func (q *diskQueue) Peek() (seq uint64, data []byte, ok bool, err error) {
+	q.mut.Lock()
+	if len(q.items) == 0 {
+		q.mut.Unlock()
+		return 0, nil, false, nil
+	}
+	seq = q.items[0].seq
+	q.mut.Unlock()
+
+	// Only the consumer removes items, so the file can be read unlocked.
+	data, err = ioutil.ReadFile(q.path(seq))
+	return seq, data, true, err
+}
+
+// Remove removes the oldest batch if it has the sequence number seq.
+func (q *diskQueue) Remove(seq uint64) error {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	if len(q.items) == 0 || q.items[0].seq != seq {
+		return nil
+	}
+	// The batch is forgotten even if its file can't be removed, so that a
+	// broken file doesn't block the queue until restart.
+	err := os.Remove(q.path(seq))
+	q.bytes -= q.items[0].size
+	q.items = q.items[1:]
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Len returns the number of batches in the queue.
+func (q *diskQueue) Len() int {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	return len(q.items)
+}
+
+// Bytes returns the size of the batches in the queue.
+func (q *diskQueue) Bytes() int64 {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	return q.bytes
+}
+
+// OldestAge returns how long the oldest batch has been in the queue.
+func (q *diskQueue) OldestAge() time.Duration {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	if len(q.items) == 0 {
+		return 0
+	}
+	return time.Since(q.items[0].created)
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
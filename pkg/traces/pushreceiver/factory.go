@@ -0,0 +1,46 @@
+// Package pushreceiver implements a receiver for traces pushed from within
+// the Agent, such as by integrations.
+package pushreceiver
+
+import (
+	"context"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/consumer"
+)
+
+const (
+	// TypeStr for push receiver.
+	TypeStr = "push"
+)
+
+// NewFactory creates push receiver factory.
+func NewFactory() component.ReceiverFactory {
+	return component.NewReceiverFactory(
+		TypeStr,
+		createDefaultConfig,
+		component.WithTracesReceiver(createTracesReceiver),
+	)
+}
+
+// Config defines configuration for push receiver.
+type Config struct {
+	config.ReceiverSettings `mapstructure:",squash"` // squash ensures fields are correctly decoded in embedded struct.
+}
+
+func createDefaultConfig() config.Receiver {
+	return &Config{
+		ReceiverSettings: config.NewReceiverSettings(config.NewComponentID(TypeStr)),
+	}
+}
+
+func createTracesReceiver(
+	_ context.Context,
+	_ component.ReceiverCreateSettings,
+	_ config.Receiver,
+	next consumer.Traces,
+) (component.TracesReceiver, error) {
+
+	return &pushReceiver{next: next}, nil
+}
@@ -0,0 +1,66 @@
+package pushreceiver
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/grafana/agent/pkg/traces/contextkeys"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+// ErrNotRunning is returned by Consumer when no push receiver is running.
+var ErrNotRunning = errors.New("traces pipeline is not running")
+
+// Consumer passes traces to the pipeline of the running push receiver. It
+// outlives the receivers, so that it can be handed out once and used across
+// pipeline rebuilds.
+type Consumer struct {
+	mut  sync.RWMutex
+	next consumer.Traces
+}
+
+// ConsumeTraces passes td to the pipeline. ErrNotRunning is returned if no
+// push receiver is running.
+func (c *Consumer) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	if c.next == nil {
+		return ErrNotRunning
+	}
+	return c.next.ConsumeTraces(ctx, td)
+}
+
+func (c *Consumer) setNext(next consumer.Traces) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.next = next
+}
+
+type pushReceiver struct {
+	next     consumer.Traces
+	consumer *Consumer
+}
+
+// Start implements the Component interface. The receiver is attached to the
+// *Consumer passed through the context.
+func (r *pushReceiver) Start(ctx context.Context, _ component.Host) error {
+	c, ok := ctx.Value(contextkeys.PushConsumer).(*Consumer)
+	if !ok || c == nil {
+		return errors.New("key does not contain a push consumer")
+	}
+	r.consumer = c
+	r.consumer.setNext(r.next)
+	return nil
+}
+
+// Shutdown implements the Component interface.
+func (r *pushReceiver) Shutdown(context.Context) error {
+	if r.consumer != nil {
+		r.consumer.setNext(nil)
+	}
+	return nil
+}
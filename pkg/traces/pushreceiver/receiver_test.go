@@ -0,0 +1,37 @@
+package pushreceiver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/agent/pkg/traces/contextkeys"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+func TestPushReceiver(t *testing.T) {
+	var (
+		c    Consumer
+		next = new(consumertest.TracesSink)
+		td   = pdata.NewTraces()
+	)
+	td.ResourceSpans().AppendEmpty().InstrumentationLibrarySpans().AppendEmpty().Spans().AppendEmpty()
+
+	require.ErrorIs(t, c.ConsumeTraces(context.Background(), td), ErrNotRunning)
+
+	f := NewFactory()
+	r, err := f.CreateTracesReceiver(context.Background(), componenttest.NewNopReceiverCreateSettings(), f.CreateDefaultConfig(), next)
+	require.NoError(t, err)
+
+	require.Error(t, r.Start(context.Background(), componenttest.NewNopHost()))
+
+	ctx := context.WithValue(context.Background(), contextkeys.PushConsumer, &c)
+	require.NoError(t, r.Start(ctx, componenttest.NewNopHost()))
+	require.NoError(t, c.ConsumeTraces(context.Background(), td))
+	require.Equal(t, 1, next.SpanCount())
+
+	require.NoError(t, r.Shutdown(context.Background()))
+	require.ErrorIs(t, c.ConsumeTraces(context.Background(), td), ErrNotRunning)
+}
@@ -0,0 +1,100 @@
+package redactionprocessor
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/consumer"
+)
+
+const (
+	// TypeStr is the unique identifier for the Redaction processor.
+	TypeStr = "redaction"
+
+	// DefaultReplacement replaces matches of rules without a replacement.
+	DefaultReplacement = "****"
+)
+
+// Config holds the configuration for the Redaction processor.
+type Config struct {
+	config.ProcessorSettings `mapstructure:",squash"`
+
+	RedactionConfig *RedactionConfig `mapstructure:"redaction"`
+}
+
+// RedactionConfig holds config information for redaction.
+type RedactionConfig struct {
+	// Rules replace the values matching a regular expression in span names,
+	// span attributes, event names and event attributes.
+	Rules []RuleConfig `mapstructure:"rules" yaml:"rules,omitempty"`
+
+	// AllowedKeys enables the allow-list mode. If set, span and event
+	// attributes with other keys are removed.
+	AllowedKeys []string `mapstructure:"allowed_keys" yaml:"allowed_keys,omitempty"`
+}
+
+// RuleConfig is a redaction rule.
+type RuleConfig struct {
+	// Name identifies the rule in metrics.
+	Name string `mapstructure:"name" yaml:"name"`
+	// Regex matches the values to redact.
+	Regex string `mapstructure:"regex" yaml:"regex"`
+	// Replacement replaces matches of Regex. It may reference capture groups
+	// with $1 or ${name}. Defaults to DefaultReplacement.
+	Replacement string `mapstructure:"replacement" yaml:"replacement,omitempty"`
+}
+
+// Validate ensures that the RedactionConfig is valid.
+func (c *RedactionConfig) Validate() error {
+	if len(c.Rules) == 0 && len(c.AllowedKeys) == 0 {
+		return fmt.Errorf("at least one of rules and allowed_keys must be set")
+	}
+
+	names := make(map[string]struct{}, len(c.Rules))
+	for i, r := range c.Rules {
+		if r.Name == "" {
+			return fmt.Errorf("rule at index %d is missing a name", i)
+		}
+		if _, exist := names[r.Name]; exist {
+			return fmt.Errorf("found multiple rules with name %s", r.Name)
+		}
+		names[r.Name] = struct{}{}
+
+		if r.Regex == "" {
+			return fmt.Errorf("rule %s is missing a regex", r.Name)
+		}
+		if _, err := regexp.Compile(r.Regex); err != nil {
+			return fmt.Errorf("rule %s has an invalid regex: %w", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// NewFactory returns a new factory for the Redaction processor.
+func NewFactory() component.ProcessorFactory {
+	return component.NewProcessorFactory(
+		TypeStr,
+		createDefaultConfig,
+		component.WithTracesProcessor(createTraceProcessor),
+	)
+}
+
+func createDefaultConfig() config.Processor {
+	return &Config{
+		ProcessorSettings: config.NewProcessorSettings(config.NewComponentIDWithName(TypeStr, TypeStr)),
+	}
+}
+
+func createTraceProcessor(
+	_ context.Context,
+	_ component.ProcessorCreateSettings,
+	cfg config.Processor,
+	nextConsumer consumer.Traces,
+) (component.TracesProcessor, error) {
+
+	oCfg := cfg.(*Config)
+	return newTraceProcessor(nextConsumer, oCfg.RedactionConfig)
+}
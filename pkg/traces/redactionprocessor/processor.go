@@ -0,0 +1,209 @@
+// Package redactionprocessor redacts secrets and personal data from spans
+// before they leave the Agent.
+package redactionprocessor
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/grafana/agent/pkg/traces/contextkeys"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenterror"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+type rule struct {
+	name        string
+	regex       *regexp.Regexp
+	replacement string
+}
+
+type processor struct {
+	nextConsumer consumer.Traces
+
+	rules       []rule
+	allowedKeys map[string]struct{}
+
+	reg               prometheus.Registerer
+	redactionsTotal   *prometheus.CounterVec
+	removedAttributes prometheus.Counter
+}
+
+func newTraceProcessor(nextConsumer consumer.Traces, cfg *RedactionConfig) (component.TracesProcessor, error) {
+	if nextConsumer == nil {
+		return nil, componenterror.ErrNilNextConsumer
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("redaction processor requires a config")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	p := &processor{
+		nextConsumer: nextConsumer,
+
+		redactionsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "traces",
+			Name:      "redaction_redactions_total",
+			Help:      "Total count of values redacted by each rule",
+		}, []string{"rule"}),
+		removedAttributes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "traces",
+			Name:      "redaction_removed_attributes_total",
+			Help:      "Total count of attributes removed because their key isn't allowed",
+		}),
+	}
+
+	for _, r := range cfg.Rules {
+		replacement := r.Replacement
+		if replacement == "" {
+			replacement = DefaultReplacement
+		}
+		p.rules = append(p.rules, rule{
+			name:        r.Name,
+			regex:       regexp.MustCompile(r.Regex), // Checked by Validate
+			replacement: replacement,
+		})
+		p.redactionsTotal.WithLabelValues(r.Name)
+	}
+
+	if len(cfg.AllowedKeys) > 0 {
+		p.allowedKeys = make(map[string]struct{}, len(cfg.AllowedKeys))
+		for _, k := range cfg.AllowedKeys {
+			p.allowedKeys[k] = struct{}{}
+		}
+	}
+
+	return p, nil
+}
+
+func (p *processor) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	for i := 0; i < td.ResourceSpans().Len(); i++ {
+		rs := td.ResourceSpans().At(i)
+
+		for j := 0; j < rs.InstrumentationLibrarySpans().Len(); j++ {
+			spans := rs.InstrumentationLibrarySpans().At(j).Spans()
+
+			for k := 0; k < spans.Len(); k++ {
+				p.redactSpan(spans.At(k))
+			}
+		}
+	}
+
+	return p.nextConsumer.ConsumeTraces(ctx, td)
+}
+
+func (p *processor) redactSpan(span pdata.Span) {
+	if name, ok := p.redactString(span.Name()); ok {
+		span.SetName(name)
+	}
+	p.redactAttributes(span.Attributes())
+
+	for i := 0; i < span.Events().Len(); i++ {
+		event := span.Events().At(i)
+		if name, ok := p.redactString(event.Name()); ok {
+			event.SetName(name)
+		}
+		p.redactAttributes(event.Attributes())
+	}
+}
+
+// redactAttributes removes attributes which aren't allowed and redacts the
+// values of the remaining ones.
+func (p *processor) redactAttributes(attrs pdata.AttributeMap) {
+	if p.allowedKeys != nil {
+		var removed []string
+		attrs.Range(func(k string, _ pdata.AttributeValue) bool {
+			if _, ok := p.allowedKeys[k]; !ok {
+				removed = append(removed, k)
+			}
+			return true
+		})
+		for _, k := range removed {
+			attrs.Delete(k)
+		}
+		p.removedAttributes.Add(float64(len(removed)))
+	}
+
+	if len(p.rules) == 0 {
+		return
+	}
+	attrs.Range(func(_ string, v pdata.AttributeValue) bool {
+		p.redactValue(v)
+		return true
+	})
+}
+
+// redactValue redacts a string value, or the strings nested in an array or
+// map value.
+func (p *processor) redactValue(v pdata.AttributeValue) {
+	switch v.Type() {
+	case pdata.AttributeValueTypeString:
+		if s, ok := p.redactString(v.StringVal()); ok {
+			v.SetStringVal(s)
+		}
+	case pdata.AttributeValueTypeArray:
+		vs := v.SliceVal()
+		for i := 0; i < vs.Len(); i++ {
+			p.redactValue(vs.At(i))
+		}
+	case pdata.AttributeValueTypeMap:
+		v.MapVal().Range(func(_ string, v pdata.AttributeValue) bool {
+			p.redactValue(v)
+			return true
+		})
+	}
+}
+
+// redactString applies all rules to s. ok is false if no rule matched.
+func (p *processor) redactString(s string) (redacted string, ok bool) {
+	redacted = s
+	for _, r := range p.rules {
+		if !r.regex.MatchString(redacted) {
+			continue
+		}
+		redacted = r.regex.ReplaceAllString(redacted, r.replacement)
+		p.redactionsTotal.WithLabelValues(r.name).Inc()
+		ok = true
+	}
+	return redacted, ok
+}
+
+func (p *processor) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{MutatesData: true}
+}
+
+// Start is invoked during service startup.
+func (p *processor) Start(ctx context.Context, _ component.Host) error {
+	reg, ok := ctx.Value(contextkeys.PrometheusRegisterer).(prometheus.Registerer)
+	if !ok || reg == nil {
+		return nil
+	}
+	for _, c := range []prometheus.Collector{p.redactionsTotal, p.removedAttributes} {
+		if err := reg.Register(c); err != nil {
+			p.unregisterMetrics()
+			return err
+		}
+		p.reg = reg
+	}
+	return nil
+}
+
+func (p *processor) unregisterMetrics() {
+	if p.reg == nil {
+		return
+	}
+	p.reg.Unregister(p.redactionsTotal)
+	p.reg.Unregister(p.removedAttributes)
+	p.reg = nil
+}
+
+// Shutdown is invoked during service shutdown.
+func (p *processor) Shutdown(context.Context) error {
+	p.unregisterMetrics()
+	return nil
+}
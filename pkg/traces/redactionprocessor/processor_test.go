@@ -0,0 +1,134 @@
+package redactionprocessor
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/grafana/agent/pkg/traces/contextkeys"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+func TestValidate(t *testing.T) {
+	tt := []struct {
+		name   string
+		cfg    RedactionConfig
+		expect string
+	}{
+		{name: "valid rules", cfg: RedactionConfig{Rules: []RuleConfig{{Name: "email", Regex: `\S+@\S+`}}}},
+		{name: "valid allowed keys", cfg: RedactionConfig{AllowedKeys: []string{"http.method"}}},
+		{name: "empty", expect: "at least one of rules and allowed_keys must be set"},
+		{
+			name:   "missing name",
+			cfg:    RedactionConfig{Rules: []RuleConfig{{Regex: "a"}}},
+			expect: "rule at index 0 is missing a name",
+		},
+		{
+			name:   "duplicate name",
+			cfg:    RedactionConfig{Rules: []RuleConfig{{Name: "a", Regex: "a"}, {Name: "a", Regex: "b"}}},
+			expect: "found multiple rules with name a",
+		},
+		{
+			name:   "missing regex",
+			cfg:    RedactionConfig{Rules: []RuleConfig{{Name: "a"}}},
+			expect: "rule a is missing a regex",
+		},
+		{
+			name:   "invalid regex",
+			cfg:    RedactionConfig{Rules: []RuleConfig{{Name: "a", Regex: "("}}},
+			expect: "rule a has an invalid regex: error parsing regexp: missing closing ): `(`",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.cfg.Validate()
+			if tc.expect == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tc.expect)
+		})
+	}
+}
+
+func TestConsumeTraces(t *testing.T) {
+	next := new(consumertest.TracesSink)
+	p, err := newTraceProcessor(next, &RedactionConfig{
+		Rules: []RuleConfig{
+			{Name: "token", Regex: `token=[^&\s]+`, Replacement: "token=****"},
+			{Name: "email", Regex: `[\w.+-]+@[\w-]+\.[\w.]+`},
+		},
+	})
+	require.NoError(t, err)
+
+	reg := prometheus.NewRegistry()
+	ctx := context.WithValue(context.Background(), contextkeys.PrometheusRegisterer, reg)
+	require.NoError(t, p.Start(ctx, nil))
+
+	td := pdata.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().InstrumentationLibrarySpans().AppendEmpty().Spans().AppendEmpty()
+	span.SetName("GET /reset?token=abc123")
+	span.Attributes().InsertString("http.url", "https://example.com/reset?token=abc123&lang=en")
+	span.Attributes().InsertString("user", "jane.doe@example.com")
+	span.Attributes().InsertInt("http.status_code", 200)
+	emails := pdata.NewAttributeValueArray()
+	emails.SliceVal().AppendEmpty().SetStringVal("john@example.com")
+	span.Attributes().Insert("cc", emails)
+	event := span.Events().AppendEmpty()
+	event.SetName("sent mail to jane.doe@example.com")
+
+	require.NoError(t, p.ConsumeTraces(context.Background(), td))
+	require.Equal(t, 1, next.SpanCount())
+
+	span = next.AllTraces()[0].ResourceSpans().At(0).InstrumentationLibrarySpans().At(0).Spans().At(0)
+	require.Equal(t, "GET /reset?token=****", span.Name())
+	require.Equal(t, map[string]interface{}{
+		"http.url":         "https://example.com/reset?token=****&lang=en",
+		"user":             "****",
+		"http.status_code": int64(200),
+		"cc":               []interface{}{"****"},
+	}, span.Attributes().AsRaw())
+	require.Equal(t, "sent mail to ****", span.Events().At(0).Name())
+
+	expect := `
+		# HELP traces_redaction_redactions_total Total count of values redacted by each rule
+		# TYPE traces_redaction_redactions_total counter
+		traces_redaction_redactions_total{rule="email"} 3
+		traces_redaction_redactions_total{rule="token"} 2
+`
+	require.NoError(t, testutil.GatherAndCompare(reg, bytes.NewBufferString(expect), "traces_redaction_redactions_total"))
+
+	require.NoError(t, p.Shutdown(context.Background()))
+}
+
+func TestAllowedKeys(t *testing.T) {
+	next := new(consumertest.TracesSink)
+	p, err := newTraceProcessor(next, &RedactionConfig{
+		AllowedKeys: []string{"http.method"},
+	})
+	require.NoError(t, err)
+
+	td := pdata.NewTraces()
+	rs := td.ResourceSpans().AppendEmpty()
+	rs.Resource().Attributes().InsertString("service.name", "checkout")
+	span := rs.InstrumentationLibrarySpans().AppendEmpty().Spans().AppendEmpty()
+	span.Attributes().InsertString("http.method", "GET")
+	span.Attributes().InsertString("user.email", "jane.doe@example.com")
+	span.Events().AppendEmpty().Attributes().InsertString("exception.message", "invalid password hunter2")
+
+	require.NoError(t, p.ConsumeTraces(context.Background(), td))
+
+	rs = next.AllTraces()[0].ResourceSpans().At(0)
+	span = rs.InstrumentationLibrarySpans().At(0).Spans().At(0)
+	require.Equal(t, map[string]interface{}{"http.method": "GET"}, span.Attributes().AsRaw())
+	require.Equal(t, 0, span.Events().At(0).Attributes().Len())
+
+	// Resource attributes are kept.
+	require.Equal(t, map[string]interface{}{"service.name": "checkout"}, rs.Resource().Attributes().AsRaw())
+	require.Equal(t, 2.0, testutil.ToFloat64(p.(*processor).removedAttributes))
+}
@@ -3,6 +3,8 @@ package remotewriteexporter
 import (
 	"context"
 	"fmt"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -13,8 +15,10 @@ import (
 	"github.com/go-kit/log/level"
 	"github.com/grafana/agent/pkg/metrics/instance"
 	"github.com/grafana/agent/pkg/traces/contextkeys"
+	"github.com/prometheus/prometheus/model/exemplar"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/model/value"
 	"github.com/prometheus/prometheus/storage"
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/consumer"
@@ -28,10 +32,18 @@ const (
 	countSuffix  = "count"
 	bucketSuffix = "bucket"
 	leStr        = "le"
+	quantileStr  = "quantile"
 	infBucket    = "+Inf"
 	noSuffix     = ""
+	traceIDKey   = "traceID"
+	spanIDKey    = "spanID"
 )
 
+// deltaStaleDuration is how long the state of a series received with delta
+// temporality is kept without receiving data points. The series is marked
+// stale once its state is removed.
+const deltaStaleDuration = 5 * time.Minute
+
 type remoteWriteExporter struct {
 	mtx sync.Mutex
 
@@ -39,8 +51,13 @@ type remoteWriteExporter struct {
 	manager      instance.Manager
 	promInstance string
 
-	constLabels labels.Labels
-	namespace   string
+	constLabels        labels.Labels
+	namespace          string
+	resourceAttributes []string
+
+	// deltas holds the cumulative state of series received with delta
+	// temporality, keyed by series. Protected by mtx.
+	deltas map[string]*deltaState
 
 	logger log.Logger
 }
@@ -55,12 +72,14 @@ func newRemoteWriteExporter(cfg *Config) (component.MetricsExporter, error) {
 	}
 
 	return &remoteWriteExporter{
-		mtx:          sync.Mutex{},
-		done:         atomic.Bool{},
-		constLabels:  ls,
-		namespace:    cfg.Namespace,
-		promInstance: cfg.PromInstance,
-		logger:       logger,
+		mtx:                sync.Mutex{},
+		done:               atomic.Bool{},
+		constLabels:        ls,
+		namespace:          cfg.Namespace,
+		resourceAttributes: cfg.ResourceAttributes,
+		promInstance:       cfg.PromInstance,
+		deltas:             make(map[string]*deltaState),
+		logger:             logger,
 	}, nil
 }
 
@@ -99,59 +118,105 @@ func (e *remoteWriteExporter) ConsumeMetrics(ctx context.Context, md pdata.Metri
 	}
 	app := prom.Appender(ctx)
 
+	// Changes to the delta state are only kept once the samples they
+	// produced are committed, so that a failed batch isn't counted twice
+	// when it's retried.
+	deltas := e.newDeltaBatch()
+	if err := e.appendMetrics(app, deltas, md); err != nil {
+		_ = app.Rollback()
+		return err
+	}
+	e.expireDeltas(app, deltas, time.Now())
+
+	if err := app.Commit(); err != nil {
+		return err
+	}
+	deltas.apply()
+	return nil
+}
+
+func (e *remoteWriteExporter) appendMetrics(app storage.Appender, deltas *deltaBatch, md pdata.Metrics) error {
 	resourceMetrics := md.ResourceMetrics()
 	for i := 0; i < resourceMetrics.Len(); i++ {
 		resourceMetric := resourceMetrics.At(i)
+		resLabels := e.resourceLabels(resourceMetric.Resource().Attributes())
 		instrumentationLibraryMetricsSlice := resourceMetric.InstrumentationLibraryMetrics()
 		for j := 0; j < instrumentationLibraryMetricsSlice.Len(); j++ {
 			metricSlice := instrumentationLibraryMetricsSlice.At(j).Metrics()
 			for k := 0; k < metricSlice.Len(); k++ {
 				switch metric := metricSlice.At(k); metric.DataType() {
 				case pdata.MetricDataTypeGauge:
-					dataPoints := metric.Sum().DataPoints()
-					if err := e.handleNumberDataPoints(app, metric.Name(), dataPoints); err != nil {
+					dataPoints := metric.Gauge().DataPoints()
+					if err := e.handleNumberDataPoints(app, deltas, metric.Name(), resLabels, dataPoints, false); err != nil {
 						return err
 					}
 				case pdata.MetricDataTypeSum:
-					if metric.Sum().AggregationTemporality() != pdata.MetricAggregationTemporalityCumulative {
-						continue // Only cumulative metrics are supported
+					delta, ok := isDelta(metric.Sum().AggregationTemporality())
+					if !ok {
+						continue // Temporality must be known
 					}
 					dataPoints := metric.Sum().DataPoints()
-					if err := e.handleNumberDataPoints(app, metric.Name(), dataPoints); err != nil {
+					if err := e.handleNumberDataPoints(app, deltas, metric.Name(), resLabels, dataPoints, delta); err != nil {
 						return err
 					}
 				case pdata.MetricDataTypeHistogram:
-					if metric.Histogram().AggregationTemporality() != pdata.MetricAggregationTemporalityCumulative {
-						continue // Only cumulative metrics are supported
+					delta, ok := isDelta(metric.Histogram().AggregationTemporality())
+					if !ok {
+						continue // Temporality must be known
 					}
 					dataPoints := metric.Histogram().DataPoints()
-					if err := e.handleHistogramDataPoints(app, metric.Name(), dataPoints); err != nil {
+					if err := e.handleHistogramDataPoints(app, deltas, metric.Name(), resLabels, dataPoints, delta); err != nil {
 						return fmt.Errorf("failed to process metric %s", err)
 					}
 				case pdata.MetricDataTypeSummary:
-					return fmt.Errorf("unsupported metric data type %s", metric.DataType())
+					dataPoints := metric.Summary().DataPoints()
+					if err := e.handleSummaryDataPoints(app, metric.Name(), resLabels, dataPoints); err != nil {
+						return fmt.Errorf("failed to process metric %s", err)
+					}
 				default:
 					return fmt.Errorf("unsupported metric data type %s", metric.DataType())
 				}
 			}
 		}
 	}
+	return nil
+}
 
-	return app.Commit()
+// isDelta returns whether t is delta temporality. ok is false if t is
+// neither delta nor cumulative.
+func isDelta(t pdata.MetricAggregationTemporality) (delta bool, ok bool) {
+	switch t {
+	case pdata.MetricAggregationTemporalityDelta:
+		return true, true
+	case pdata.MetricAggregationTemporalityCumulative:
+		return false, true
+	default:
+		return false, false
+	}
 }
 
-func (e *remoteWriteExporter) handleNumberDataPoints(app storage.Appender, name string, dataPoints pdata.NumberDataPointSlice) error {
+func (e *remoteWriteExporter) handleNumberDataPoints(app storage.Appender, deltas *deltaBatch, name string, resLabels labels.Labels, dataPoints pdata.NumberDataPointSlice, delta bool) error {
 	for ix := 0; ix < dataPoints.Len(); ix++ {
 		dataPoint := dataPoints.At(ix)
-		lbls := e.createLabelSet(name, noSuffix, dataPoint.Attributes(), labels.Labels{})
-		if err := e.appendNumberDataPoint(app, dataPoint, lbls); err != nil {
+		lbls := e.createLabelSet(name, noSuffix, resLabels, dataPoint.Attributes(), labels.Labels{})
+		if err := e.appendNumberDataPoint(app, deltas, dataPoint, lbls, delta); err != nil {
 			return fmt.Errorf("failed to process metric %s", err)
 		}
 	}
 	return nil
 }
 
-func (e *remoteWriteExporter) appendNumberDataPoint(app storage.Appender, dataPoint pdata.NumberDataPoint, labels labels.Labels) error {
+func (e *remoteWriteExporter) appendNumberDataPoint(app storage.Appender, deltas *deltaBatch, dataPoint pdata.NumberDataPoint, lbls labels.Labels, delta bool) error {
+	ts := e.dataPointTimestamp(dataPoint.Timestamp())
+
+	if dataPoint.Flags().HasFlag(pdata.MetricDataPointFlagNoRecordedValue) {
+		if delta {
+			deltas.remove(seriesKey(lbls))
+		}
+		_, err := app.Append(0, lbls, ts, math.Float64frombits(value.StaleNaN))
+		return err
+	}
+
 	var val float64
 	switch dataPoint.ValueType() {
 	case pdata.MetricValueTypeDouble:
@@ -161,58 +226,323 @@ func (e *remoteWriteExporter) appendNumberDataPoint(app storage.Appender, dataPo
 	default:
 		return fmt.Errorf("unknown data point type: %s", dataPoint.ValueType())
 	}
-	ts := e.timestamp()
 
-	_, err := app.Append(0, labels, ts, val)
-	return err
+	if delta {
+		state := deltas.get(seriesKey(lbls))
+		state.sum += val
+		state.series = []labels.Labels{lbls}
+		val = state.sum
+	}
+
+	ref, err := app.Append(0, lbls, ts, val)
+	if err != nil {
+		return err
+	}
+	e.appendExemplars(app, dataPoint.Exemplars(), func(float64) (storage.SeriesRef, labels.Labels) {
+		return ref, lbls
+	})
+	return nil
 }
 
-func (e *remoteWriteExporter) handleHistogramDataPoints(app storage.Appender, name string, dataPoints pdata.HistogramDataPointSlice) error {
+func (e *remoteWriteExporter) handleHistogramDataPoints(app storage.Appender, deltas *deltaBatch, name string, resLabels labels.Labels, dataPoints pdata.HistogramDataPointSlice, delta bool) error {
 	for ix := 0; ix < dataPoints.Len(); ix++ {
 		dataPoint := dataPoints.At(ix)
-		ts := e.timestamp()
+		ts := e.dataPointTimestamp(dataPoint.Timestamp())
+
+		var (
+			sum    = dataPoint.Sum()
+			count  = dataPoint.Count()
+			counts = dataPoint.BucketCounts()
+			bounds = dataPoint.ExplicitBounds()
+		)
+		if len(counts) > len(bounds)+1 {
+			counts = counts[:len(bounds)+1]
+		} else if len(counts) <= len(bounds) {
+			bounds = bounds[:len(counts)]
+		}
+
+		sumLabels := e.createLabelSet(name, sumSuffix, resLabels, dataPoint.Attributes(), labels.Labels{})
+		countLabels := e.createLabelSet(name, countSuffix, resLabels, dataPoint.Attributes(), labels.Labels{})
+		bucketLabels := make([]labels.Labels, 0, len(bounds)+1)
+		for _, eb := range bounds {
+			boundStr := strconv.FormatFloat(eb, 'f', -1, 64)
+			bucketLabels = append(bucketLabels, e.createLabelSet(name, bucketSuffix, resLabels, dataPoint.Attributes(), labels.Labels{{Name: leStr, Value: boundStr}}))
+		}
+		// add le=+Inf bucket
+		bucketLabels = append(bucketLabels, e.createLabelSet(name, bucketSuffix, resLabels, dataPoint.Attributes(), labels.Labels{{Name: leStr, Value: infBucket}}))
+		series := append([]labels.Labels{sumLabels, countLabels}, bucketLabels...)
+
+		key := seriesKey(e.createLabelSet(name, noSuffix, resLabels, dataPoint.Attributes(), labels.Labels{}))
+		if dataPoint.Flags().HasFlag(pdata.MetricDataPointFlagNoRecordedValue) {
+			if delta {
+				deltas.remove(key)
+			}
+			if err := appendStale(app, series, ts); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if delta {
+			state := deltas.get(key)
+			if !equalBounds(state.bounds, bounds) {
+				// Bucket layout changed; start counting from scratch.
+				*state = deltaState{bounds: append([]float64{}, bounds...), lastSeen: state.lastSeen}
+			}
+			if state.buckets == nil {
+				state.buckets = make([]uint64, len(bucketLabels))
+			}
+			state.sum += sum
+			state.count += count
+			for i, c := range counts {
+				state.buckets[i] += c
+			}
+			state.series = series
+
+			sum, count, counts = state.sum, state.count, state.buckets
+		}
 
 		// Append sum value
-		sumLabels := e.createLabelSet(name, sumSuffix, dataPoint.Attributes(), labels.Labels{})
-		if _, err := app.Append(0, sumLabels, ts, dataPoint.Sum()); err != nil {
+		if _, err := app.Append(0, sumLabels, ts, sum); err != nil {
 			return err
 		}
 
 		// Append count value
-		countLabels := e.createLabelSet(name, countSuffix, dataPoint.Attributes(), labels.Labels{})
-		if _, err := app.Append(0, countLabels, ts, float64(dataPoint.Count())); err != nil {
+		if _, err := app.Append(0, countLabels, ts, float64(count)); err != nil {
 			return err
 		}
 
 		var cumulativeCount uint64
-		for ix, eb := range dataPoint.ExplicitBounds() {
-			if ix >= len(dataPoint.BucketCounts()) {
-				break
+		bucketRefs := make([]storage.SeriesRef, len(bucketLabels))
+		for i, lbls := range bucketLabels {
+			if i < len(counts) {
+				cumulativeCount += counts[i]
 			}
-			cumulativeCount += dataPoint.BucketCounts()[ix]
-			boundStr := strconv.FormatFloat(eb, 'f', -1, 64)
-			bucketLabels := e.createLabelSet(name, bucketSuffix, dataPoint.Attributes(), labels.Labels{{Name: leStr, Value: boundStr}})
-			if _, err := app.Append(0, bucketLabels, ts, float64(cumulativeCount)); err != nil {
+			ref, err := app.Append(0, lbls, ts, float64(cumulativeCount))
+			if err != nil {
 				return err
 			}
+			bucketRefs[i] = ref
 		}
-		// add le=+Inf bucket
-		cumulativeCount += dataPoint.BucketCounts()[len(dataPoint.BucketCounts())-1]
-		infBucketLabels := e.createLabelSet(name, bucketSuffix, dataPoint.Attributes(), labels.Labels{{Name: leStr, Value: infBucket}})
-		if _, err := app.Append(0, infBucketLabels, ts, float64(cumulativeCount)); err != nil {
+
+		// Exemplars are attached to the first bucket containing their value.
+		e.appendExemplars(app, dataPoint.Exemplars(), func(v float64) (storage.SeriesRef, labels.Labels) {
+			i := sort.SearchFloat64s(bounds, v)
+			return bucketRefs[i], bucketLabels[i]
+		})
+	}
+	return nil
+}
+
+func (e *remoteWriteExporter) handleSummaryDataPoints(app storage.Appender, name string, resLabels labels.Labels, dataPoints pdata.SummaryDataPointSlice) error {
+	for ix := 0; ix < dataPoints.Len(); ix++ {
+		dataPoint := dataPoints.At(ix)
+		ts := e.dataPointTimestamp(dataPoint.Timestamp())
+
+		sumLabels := e.createLabelSet(name, sumSuffix, resLabels, dataPoint.Attributes(), labels.Labels{})
+		countLabels := e.createLabelSet(name, countSuffix, resLabels, dataPoint.Attributes(), labels.Labels{})
+
+		quantiles := dataPoint.QuantileValues()
+		quantileLabels := make([]labels.Labels, 0, quantiles.Len())
+		for i := 0; i < quantiles.Len(); i++ {
+			q := strconv.FormatFloat(quantiles.At(i).Quantile(), 'f', -1, 64)
+			quantileLabels = append(quantileLabels, e.createLabelSet(name, noSuffix, resLabels, dataPoint.Attributes(), labels.Labels{{Name: quantileStr, Value: q}}))
+		}
+
+		if dataPoint.Flags().HasFlag(pdata.MetricDataPointFlagNoRecordedValue) {
+			series := append([]labels.Labels{sumLabels, countLabels}, quantileLabels...)
+			if err := appendStale(app, series, ts); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := app.Append(0, sumLabels, ts, dataPoint.Sum()); err != nil {
+			return err
+		}
+		if _, err := app.Append(0, countLabels, ts, float64(dataPoint.Count())); err != nil {
+			return err
+		}
+		for i, lbls := range quantileLabels {
+			if _, err := app.Append(0, lbls, ts, quantiles.At(i).Value()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// appendExemplars appends exemplars to the series returned by seriesFor for
+// the exemplar's value. Failing to append an exemplar doesn't fail the
+// metric.
+func (e *remoteWriteExporter) appendExemplars(app storage.Appender, exemplars pdata.ExemplarSlice, seriesFor func(v float64) (storage.SeriesRef, labels.Labels)) {
+	for i := 0; i < exemplars.Len(); i++ {
+		ex := exemplars.At(i)
+
+		var val float64
+		switch ex.ValueType() {
+		case pdata.MetricValueTypeDouble:
+			val = ex.DoubleVal()
+		case pdata.MetricValueTypeInt:
+			val = float64(ex.IntVal())
+		default:
+			continue
+		}
+
+		exLabels := make(labels.Labels, 0, 2+ex.FilteredAttributes().Len())
+		if traceID := ex.TraceID(); !traceID.IsEmpty() {
+			exLabels = append(exLabels, labels.Label{Name: traceIDKey, Value: traceID.HexString()})
+		}
+		if spanID := ex.SpanID(); !spanID.IsEmpty() {
+			exLabels = append(exLabels, labels.Label{Name: spanIDKey, Value: spanID.HexString()})
+		}
+		ex.FilteredAttributes().Range(func(k string, v pdata.AttributeValue) bool {
+			exLabels = append(exLabels, labels.Label{Name: strings.Replace(k, ".", "_", -1), Value: v.AsString()})
+			return true
+		})
+		sort.Sort(exLabels)
+
+		ref, lbls := seriesFor(val)
+		_, err := app.AppendExemplar(ref, lbls, exemplar.Exemplar{
+			Labels: exLabels,
+			Value:  val,
+			Ts:     e.dataPointTimestamp(ex.Timestamp()),
+			HasTs:  true,
+		})
+		if err != nil {
+			level.Debug(e.logger).Log("msg", "failed to append exemplar", "err", err)
+		}
+	}
+}
+
+// newDeltaBatch returns a deltaBatch staging changes to the delta state of
+// e.
+func (e *remoteWriteExporter) newDeltaBatch() *deltaBatch {
+	if e.deltas == nil {
+		e.deltas = make(map[string]*deltaState)
+	}
+	return &deltaBatch{
+		base:    e.deltas,
+		updated: make(map[string]*deltaState),
+		removed: make(map[string]struct{}),
+	}
+}
+
+// expireDeltas removes the state of delta series which haven't received data
+// points for deltaStaleDuration, and marks their series as stale.
+func (e *remoteWriteExporter) expireDeltas(app storage.Appender, deltas *deltaBatch, now time.Time) {
+	for key, state := range deltas.base {
+		if _, updated := deltas.updated[key]; updated {
+			continue
+		}
+		if now.Sub(state.lastSeen) < deltaStaleDuration {
+			continue
+		}
+		if err := appendStale(app, state.series, convertTimeStamp(now)); err != nil {
+			level.Debug(e.logger).Log("msg", "failed to append stale marker", "err", err)
+		}
+		deltas.remove(key)
+	}
+}
+
+// deltaBatch stages the changes a batch of metrics makes to the delta state.
+// The changes are applied to the state once the batch is committed.
+type deltaBatch struct {
+	base    map[string]*deltaState
+	updated map[string]*deltaState
+	removed map[string]struct{}
+}
+
+// get returns the cumulative state of a series received with delta
+// temporality, creating it if it doesn't exist. The returned state is a copy
+// which may be modified.
+func (b *deltaBatch) get(key string) *deltaState {
+	state, ok := b.updated[key]
+	if !ok {
+		state = &deltaState{}
+		if prev, ok := b.base[key]; ok {
+			if _, removed := b.removed[key]; !removed {
+				state = prev.copy()
+			}
+		}
+		b.updated[key] = state
+		delete(b.removed, key)
+	}
+	state.lastSeen = time.Now()
+	return state
+}
+
+// remove removes the state of a series.
+func (b *deltaBatch) remove(key string) {
+	delete(b.updated, key)
+	b.removed[key] = struct{}{}
+}
+
+// apply applies the staged changes to the delta state.
+func (b *deltaBatch) apply() {
+	for key := range b.removed {
+		delete(b.base, key)
+	}
+	for key, state := range b.updated {
+		b.base[key] = state
+	}
+}
+
+// deltaState holds the cumulative values of a series received with delta
+// temporality.
+type deltaState struct {
+	series   []labels.Labels // Series written for the state
+	sum      float64
+	count    uint64
+	bounds   []float64
+	buckets  []uint64
+	lastSeen time.Time
+}
+
+func (s *deltaState) copy() *deltaState {
+	res := *s
+	res.bounds = append([]float64(nil), s.bounds...)
+	res.buckets = append([]uint64(nil), s.buckets...)
+	return &res
+}
+
+func appendStale(app storage.Appender, series []labels.Labels, ts int64) error {
+	for _, lbls := range series {
+		if _, err := app.Append(0, lbls, ts, math.Float64frombits(value.StaleNaN)); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (e *remoteWriteExporter) createLabelSet(name, suffix string, labelMap pdata.AttributeMap, customLabels labels.Labels) labels.Labels {
-	ls := make(labels.Labels, 0, labelMap.Len()+1+len(e.constLabels)+len(customLabels))
-	// Labels from spanmetrics processor
+func equalBounds(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// seriesKey returns a unique key for a set of labels, regardless of their
+// order.
+func seriesKey(ls labels.Labels) string {
+	sorted := ls.Copy()
+	sort.Sort(sorted)
+	return sorted.String()
+}
+
+func (e *remoteWriteExporter) createLabelSet(name, suffix string, resLabels labels.Labels, labelMap pdata.AttributeMap, customLabels labels.Labels) labels.Labels {
+	ls := make(labels.Labels, 0, labelMap.Len()+1+len(e.constLabels)+len(customLabels)+len(resLabels))
+	// Labels from spanmetrics processor or receivers. Attributes aren't always
+	// strings when coming from receivers.
 	labelMap.Range(func(k string, v pdata.AttributeValue) bool {
 		ls = append(ls, labels.Label{
 			Name:  strings.Replace(k, ".", "_", -1),
-			Value: v.StringVal(),
+			Value: v.AsString(),
 		})
 		return true
 	})
@@ -225,21 +555,76 @@ func (e *remoteWriteExporter) createLabelSet(name, suffix string, labelMap pdata
 	ls = append(ls, e.constLabels...)
 	// Custom labels
 	ls = append(ls, customLabels...)
+	// Resource labels, unless another label has the same name
+	for _, l := range resLabels {
+		if !hasLabel(ls, l.Name) {
+			ls = append(ls, l)
+		}
+	}
 	return ls
 }
 
+// resourceLabels returns the labels for the resource attributes listed in
+// e.resourceAttributes which are set.
+func (e *remoteWriteExporter) resourceLabels(attrs pdata.AttributeMap) labels.Labels {
+	var ls labels.Labels
+	for _, name := range e.resourceAttributes {
+		v, ok := attrs.Get(name)
+		if !ok {
+			continue
+		}
+		ls = append(ls, labels.Label{
+			Name:  strings.Replace(name, ".", "_", -1),
+			Value: v.AsString(),
+		})
+	}
+	return ls
+}
+
+func hasLabel(ls labels.Labels, name string) bool {
+	for _, l := range ls {
+		if l.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (e *remoteWriteExporter) timestamp() int64 {
 	return convertTimeStamp(time.Now())
 }
 
+// dataPointTimestamp returns the timestamp in ms of a data point, falling
+// back to the current time if the data point has no timestamp.
+func (e *remoteWriteExporter) dataPointTimestamp(ts pdata.Timestamp) int64 {
+	if ts == 0 {
+		return e.timestamp()
+	}
+	return convertTimeStamp(ts.AsTime())
+}
+
 // convertTimeStamp converts time.Time to timestamp in ms
 func convertTimeStamp(t time.Time) int64 {
 	return timestamp.FromTime(t)
 }
 
+// metricName builds the name of a metric. Characters which aren't valid in
+// Prometheus metric names, like the dots used by OTel receivers, are replaced
+// with underscores. namespace may be empty.
 func metricName(namespace, metric, suffix string) string {
+	name := strings.Map(sanitizeRune, metric)
+	if len(namespace) != 0 {
+		name = fmt.Sprintf("%s_%s", namespace, name)
+	}
 	if len(suffix) != 0 {
-		return fmt.Sprintf("%s_%s_%s", namespace, metric, suffix)
+		name = fmt.Sprintf("%s_%s", name, suffix)
+	}
+	return name
+}
+
+func sanitizeRune(r rune) rune {
+	if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == ':' {
+		return r
 	}
-	return fmt.Sprintf("%s_%s", namespace, metric)
+	return '_'
 }
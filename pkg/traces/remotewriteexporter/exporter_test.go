@@ -3,12 +3,14 @@ package remotewriteexporter
 import (
 	"context"
 	"fmt"
+	"math"
 	"testing"
 	"time"
 
 	"github.com/grafana/agent/pkg/metrics/instance"
 	"github.com/prometheus/prometheus/model/exemplar"
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/stretchr/testify/require"
 	"go.opentelemetry.io/collector/model/pdata"
@@ -71,6 +73,7 @@ func TestRemoteWriteExporter_ConsumeMetrics(t *testing.T) {
 	calls := manager.instance.GetAppended(callsMetric)
 	require.Equal(t, len(calls), 1)
 	require.Equal(t, calls[0].v, sumValue)
+	require.Equal(t, calls[0].t, ts.UnixMilli())
 	require.Equal(t, calls[0].l, labels.Labels{{Name: nameLabelKey, Value: "traces_spanmetrics_calls_total"}})
 
 	// Verify _sum
@@ -103,6 +106,252 @@ func TestRemoteWriteExporter_ConsumeMetrics(t *testing.T) {
 	}
 }
 
+func TestRemoteWriteExporter_Summary(t *testing.T) {
+	manager := &mockManager{}
+	exp := remoteWriteExporter{manager: manager, namespace: "traces", promInstance: "traces"}
+
+	metrics := pdata.NewMetrics()
+	ilm := metrics.ResourceMetrics().AppendEmpty().InstrumentationLibraryMetrics().AppendEmpty()
+
+	m := ilm.Metrics().AppendEmpty()
+	m.SetDataType(pdata.MetricDataTypeSummary)
+	m.SetName("request_duration")
+
+	dp := m.Summary().DataPoints().AppendEmpty()
+	dp.Attributes().InsertString("service.name", "api")
+	dp.SetSum(12.5)
+	dp.SetCount(10)
+	q := dp.QuantileValues().AppendEmpty()
+	q.SetQuantile(0.5)
+	q.SetValue(1)
+	q = dp.QuantileValues().AppendEmpty()
+	q.SetQuantile(0.99)
+	q.SetValue(2.5)
+
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), metrics))
+
+	sum := manager.instance.GetAppended("traces_request_duration_sum")
+	require.Len(t, sum, 1)
+	require.Equal(t, 12.5, sum[0].v)
+	require.Equal(t, "api", sum[0].l.Get("service_name"))
+
+	count := manager.instance.GetAppended("traces_request_duration_count")
+	require.Len(t, count, 1)
+	require.Equal(t, 10.0, count[0].v)
+
+	quantiles := manager.instance.GetAppended("traces_request_duration")
+	require.Len(t, quantiles, 2)
+	require.Equal(t, "0.5", quantiles[0].l.Get(quantileStr))
+	require.Equal(t, 1.0, quantiles[0].v)
+	require.Equal(t, "0.99", quantiles[1].l.Get(quantileStr))
+	require.Equal(t, 2.5, quantiles[1].v)
+}
+
+func TestRemoteWriteExporter_Delta(t *testing.T) {
+	manager := &mockManager{}
+	exp := remoteWriteExporter{manager: manager, namespace: "traces", promInstance: "traces"}
+
+	deltaMetrics := func(calls float64, bucketCounts []uint64) pdata.Metrics {
+		metrics := pdata.NewMetrics()
+		ilm := metrics.ResourceMetrics().AppendEmpty().InstrumentationLibraryMetrics().AppendEmpty()
+
+		sm := ilm.Metrics().AppendEmpty()
+		sm.SetDataType(pdata.MetricDataTypeSum)
+		sm.SetName("spanmetrics_calls_total")
+		sm.Sum().SetAggregationTemporality(pdata.MetricAggregationTemporalityDelta)
+		sm.Sum().DataPoints().AppendEmpty().SetDoubleVal(calls)
+
+		hm := ilm.Metrics().AppendEmpty()
+		hm.SetDataType(pdata.MetricDataTypeHistogram)
+		hm.SetName("spanmetrics_latency")
+		hm.Histogram().SetAggregationTemporality(pdata.MetricAggregationTemporalityDelta)
+		hdp := hm.Histogram().DataPoints().AppendEmpty()
+		hdp.SetExplicitBounds([]float64{1})
+		hdp.SetBucketCounts(bucketCounts)
+		hdp.SetCount(bucketCounts[0] + bucketCounts[1])
+		hdp.SetSum(float64(bucketCounts[0] + bucketCounts[1]))
+		return metrics
+	}
+
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), deltaMetrics(2, []uint64{1, 1})))
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), deltaMetrics(3, []uint64{2, 0})))
+
+	calls := manager.instance.GetAppended(callsMetric)
+	require.Len(t, calls, 2)
+	require.Equal(t, 2.0, calls[0].v)
+	require.Equal(t, 5.0, calls[1].v)
+
+	count := manager.instance.GetAppended(countMetric)
+	require.Len(t, count, 2)
+	require.Equal(t, 4.0, count[1].v)
+
+	buckets := manager.instance.GetAppended(bucketMetric)
+	require.Len(t, buckets, 4)
+	require.Equal(t, 3.0, buckets[2].v) // le="1"
+	require.Equal(t, 4.0, buckets[3].v) // le="+Inf"
+
+	// Series which stop receiving data points are marked stale.
+	for _, state := range exp.deltas {
+		state.lastSeen = time.Now().Add(-deltaStaleDuration)
+	}
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), pdata.NewMetrics()))
+	require.Empty(t, exp.deltas)
+
+	calls = manager.instance.GetAppended(callsMetric)
+	require.Len(t, calls, 3)
+	require.True(t, value.IsStaleNaN(calls[2].v))
+	buckets = manager.instance.GetAppended(bucketMetric)
+	require.Len(t, buckets, 6)
+	require.True(t, value.IsStaleNaN(buckets[5].v))
+}
+
+func TestRemoteWriteExporter_DeltaRollback(t *testing.T) {
+	manager := &mockManager{}
+	exp := remoteWriteExporter{manager: manager, namespace: "traces", promInstance: "traces"}
+
+	deltaMetrics := func(calls float64) pdata.Metrics {
+		metrics := pdata.NewMetrics()
+		sm := metrics.ResourceMetrics().AppendEmpty().InstrumentationLibraryMetrics().AppendEmpty().Metrics().AppendEmpty()
+		sm.SetDataType(pdata.MetricDataTypeSum)
+		sm.SetName("spanmetrics_calls_total")
+		sm.Sum().SetAggregationTemporality(pdata.MetricAggregationTemporalityDelta)
+		sm.Sum().DataPoints().AppendEmpty().SetDoubleVal(calls)
+		return metrics
+	}
+
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), deltaMetrics(2)))
+
+	// A failed batch doesn't change the delta state, so retrying it doesn't
+	// count it twice.
+	manager.instance.appender.err = fmt.Errorf("append failed")
+	require.Error(t, exp.ConsumeMetrics(context.Background(), deltaMetrics(3)))
+	manager.instance.appender.err = nil
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), deltaMetrics(3)))
+
+	calls := manager.instance.GetAppended(callsMetric)
+	require.Equal(t, 5.0, calls[len(calls)-1].v)
+}
+
+func TestRemoteWriteExporter_ResourceAttributes(t *testing.T) {
+	manager := &mockManager{}
+	exp := remoteWriteExporter{
+		manager:            manager,
+		promInstance:       "traces",
+		resourceAttributes: []string{"service.name", "service.instance.id"},
+	}
+
+	// Two resources sending the same metric with the same attributes must
+	// be written to different series.
+	metrics := pdata.NewMetrics()
+	for _, id := range []string{"a", "b"} {
+		rm := metrics.ResourceMetrics().AppendEmpty()
+		rm.Resource().Attributes().InsertString("service.name", "checkout")
+		rm.Resource().Attributes().InsertString("service.instance.id", id)
+		rm.Resource().Attributes().InsertString("process.pid", "1234")
+
+		gm := rm.InstrumentationLibraryMetrics().AppendEmpty().Metrics().AppendEmpty()
+		gm.SetDataType(pdata.MetricDataTypeGauge)
+		gm.SetName("queue_size")
+		dp := gm.Gauge().DataPoints().AppendEmpty()
+		dp.Attributes().InsertString("queue", "orders")
+		dp.SetIntVal(1)
+	}
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), metrics))
+
+	series := manager.instance.GetAppended("queue_size")
+	require.Len(t, series, 2)
+	for i, id := range []string{"a", "b"} {
+		require.Equal(t, labels.Labels{
+			{Name: "queue", Value: "orders"},
+			{Name: nameLabelKey, Value: "queue_size"},
+			{Name: "service_name", Value: "checkout"},
+			{Name: "service_instance_id", Value: id},
+		}, series[i].l)
+	}
+}
+
+func TestRemoteWriteExporter_Exemplars(t *testing.T) {
+	var (
+		traceID = pdata.NewTraceID([16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16})
+		spanID  = pdata.NewSpanID([8]byte{1, 2, 3, 4, 5, 6, 7, 8})
+		ts      = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	)
+
+	manager := &mockManager{}
+	exp := remoteWriteExporter{manager: manager, namespace: "traces", promInstance: "traces"}
+
+	metrics := pdata.NewMetrics()
+	ilm := metrics.ResourceMetrics().AppendEmpty().InstrumentationLibraryMetrics().AppendEmpty()
+
+	hm := ilm.Metrics().AppendEmpty()
+	hm.SetDataType(pdata.MetricDataTypeHistogram)
+	hm.SetName("spanmetrics_latency")
+	hm.Histogram().SetAggregationTemporality(pdata.MetricAggregationTemporalityCumulative)
+
+	hdp := hm.Histogram().DataPoints().AppendEmpty()
+	hdp.SetExplicitBounds([]float64{1, 2.5, 5})
+	hdp.SetBucketCounts([]uint64{0, 1, 0, 0})
+	hdp.SetCount(1)
+	hdp.SetSum(2)
+
+	ex := hdp.Exemplars().AppendEmpty()
+	ex.SetDoubleVal(2)
+	ex.SetTimestamp(pdata.NewTimestampFromTime(ts))
+	ex.SetTraceID(traceID)
+	ex.SetSpanID(spanID)
+
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), metrics))
+
+	exemplars := manager.instance.appender.appendedExemplars
+	require.Len(t, exemplars, 1)
+	require.Equal(t, "2.5", exemplars[0].l.Get(leStr))
+	require.Equal(t, exemplar.Exemplar{
+		Labels: labels.Labels{
+			{Name: spanIDKey, Value: spanID.HexString()},
+			{Name: traceIDKey, Value: traceID.HexString()},
+		},
+		Value: 2,
+		Ts:    ts.UnixMilli(),
+		HasTs: true,
+	}, exemplars[0].e)
+}
+
+func TestRemoteWriteExporter_NoRecordedValue(t *testing.T) {
+	manager := &mockManager{}
+	exp := remoteWriteExporter{manager: manager, namespace: "traces", promInstance: "traces"}
+
+	metrics := pdata.NewMetrics()
+	ilm := metrics.ResourceMetrics().AppendEmpty().InstrumentationLibraryMetrics().AppendEmpty()
+
+	gm := ilm.Metrics().AppendEmpty()
+	gm.SetDataType(pdata.MetricDataTypeGauge)
+	gm.SetName("queue_size")
+	dp := gm.Gauge().DataPoints().AppendEmpty()
+	dp.SetFlags(pdata.NewMetricDataPointFlags(pdata.MetricDataPointFlagNoRecordedValue))
+
+	require.NoError(t, exp.ConsumeMetrics(context.Background(), metrics))
+
+	appended := manager.instance.GetAppended("traces_queue_size")
+	require.Len(t, appended, 1)
+	require.Equal(t, math.Float64bits(appended[0].v), value.StaleNaN)
+}
+
+func TestMetricName(t *testing.T) {
+	tt := []struct {
+		namespace, metric, suffix string
+		expect                    string
+	}{
+		{"traces_spanmetrics", "latency", "bucket", "traces_spanmetrics_latency_bucket"},
+		{"traces_spanmetrics", "calls_total", "", "traces_spanmetrics_calls_total"},
+		{"", "system.cpu.load_average.1m", "", "system_cpu_load_average_1m"},
+		{"otel", "http.server-duration", "sum", "otel_http_server_duration_sum"},
+	}
+
+	for _, tc := range tt {
+		require.Equal(t, tc.expect, metricName(tc.namespace, tc.metric, tc.suffix))
+	}
+}
+
 type mockManager struct {
 	instance *mockInstance
 }
@@ -146,8 +395,17 @@ type metric struct {
 	v float64
 }
 
+type exemplarSample struct {
+	l labels.Labels
+	e exemplar.Exemplar
+}
+
 type mockAppender struct {
-	appendedMetrics []metric
+	appendedMetrics   []metric
+	appendedExemplars []exemplarSample
+
+	// err is returned by Append when set.
+	err error
 }
 
 func (a *mockAppender) GetAppended(n string) []metric {
@@ -161,6 +419,9 @@ func (a *mockAppender) GetAppended(n string) []metric {
 }
 
 func (a *mockAppender) Append(_ storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	if a.err != nil {
+		return 0, a.err
+	}
 	a.appendedMetrics = append(a.appendedMetrics, metric{l: l, t: t, v: v})
 	return 0, nil
 }
@@ -169,6 +430,7 @@ func (a *mockAppender) Commit() error { return nil }
 
 func (a *mockAppender) Rollback() error { return nil }
 
-func (a *mockAppender) AppendExemplar(_ storage.SeriesRef, _ labels.Labels, _ exemplar.Exemplar) (storage.SeriesRef, error) {
+func (a *mockAppender) AppendExemplar(_ storage.SeriesRef, l labels.Labels, e exemplar.Exemplar) (storage.SeriesRef, error) {
+	a.appendedExemplars = append(a.appendedExemplars, exemplarSample{l: l, e: e})
 	return 0, nil
 }
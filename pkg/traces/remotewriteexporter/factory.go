@@ -22,6 +22,11 @@ type Config struct {
 	ConstLabels  prometheus.Labels `mapstructure:"const_labels"`
 	Namespace    string            `mapstructure:"namespace"`
 	PromInstance string            `mapstructure:"metrics_instance"`
+
+	// ResourceAttributes are the resource attributes added as labels to
+	// every series, with dots replaced by underscores. They identify where
+	// metrics come from when a receiver gets metrics from multiple sources.
+	ResourceAttributes []string `mapstructure:"resource_attributes"`
 }
 
 // NewFactory returns a new factory for the Prometheus remote write processor.
@@ -4,9 +4,11 @@ import (
 	"context"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/config"
 	"go.opentelemetry.io/collector/consumer"
+	semconv "go.opentelemetry.io/collector/model/semconv/v1.6.1"
 )
 
 const (
@@ -21,6 +23,14 @@ const (
 	DefaultWorkers = 10
 )
 
+// DefaultVirtualNodePeerAttributes are the default span attributes used to
+// name virtual nodes.
+var DefaultVirtualNodePeerAttributes = []string{
+	semconv.AttributePeerService,
+	semconv.AttributeDBSystem,
+	semconv.AttributeMessagingSystem,
+}
+
 // Config holds the configuration for the Prometheus service graph processor.
 type Config struct {
 	config.ProcessorSettings `mapstructure:",squash"`
@@ -31,6 +41,28 @@ type Config struct {
 	Workers int `mapstructure:"workers"`
 
 	SuccessCodes *successCodes `mapstructure:"success_codes"`
+
+	// Dimensions are span or resource attributes added as labels to edges.
+	// Each dimension adds a label for the client and the server side.
+	Dimensions []string `mapstructure:"dimensions"`
+
+	// EnableVirtualNodes records edges to nodes which don't send spans, such
+	// as databases. Client spans which aren't paired within Wait are
+	// recorded as edges to the value of the first of
+	// VirtualNodePeerAttributes found in the span.
+	EnableVirtualNodes        bool     `mapstructure:"enable_virtual_nodes"`
+	VirtualNodePeerAttributes []string `mapstructure:"virtual_node_peer_attributes"`
+
+	// MetricsInstance is the metrics instance metrics are written to. If
+	// empty, metrics are exposed by the agent's registry instead.
+	MetricsInstance string `mapstructure:"metrics_instance"`
+	// ConstLabels are added to all metrics.
+	ConstLabels prometheus.Labels `mapstructure:"const_labels"`
+	// TracesInstance is the name of the traces instance running the
+	// processor. Metrics written to MetricsInstance have it as the
+	// traces_instance label, so that several traces instances can write to
+	// the same metrics instance.
+	TracesInstance string `mapstructure:"traces_instance"`
 }
 
 type successCodes struct {
@@ -9,8 +9,10 @@ import (
 	util "github.com/cortexproject/cortex/pkg/util/log"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/grafana/agent/pkg/metrics/instance"
 	"github.com/grafana/agent/pkg/traces/contextkeys"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/util/strutil"
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/model/pdata"
@@ -33,6 +35,13 @@ type edge struct {
 	serverService, clientService string
 	serverLatency, clientLatency time.Duration
 
+	// Values of the configured dimensions for each side of the edge.
+	clientDimensions, serverDimensions []string
+
+	// peerNode is the name of the virtual node the client called, used if
+	// no server span is received.
+	peerNode string
+
 	// If either the client or the server spans have status code error,
 	// the edge will be considered as failed.
 	failed bool
@@ -49,6 +58,12 @@ func newEdge(key string, ttl time.Duration) *edge {
 	}
 }
 
+// isVirtual returns true if the edge can be recorded as an edge to a virtual
+// node.
+func (e *edge) isVirtual() bool {
+	return len(e.clientService) != 0 && len(e.serverService) == 0 && len(e.peerNode) != 0
+}
+
 // isCompleted returns true if the corresponding client and server
 // pair spans have been processed for the given edge
 func (e *edge) isCompleted() bool {
@@ -61,6 +76,10 @@ func (e *edge) isExpired() bool {
 
 var _ component.TracesProcessor = (*processor)(nil)
 
+// tracesInstanceLabel is the label identifying the traces instance of
+// metrics written to a metrics instance.
+const tracesInstanceLabel = "traces_instance"
+
 type processor struct {
 	nextConsumer consumer.Traces
 	reg          prometheus.Registerer
@@ -70,6 +89,16 @@ type processor struct {
 	wait     time.Duration
 	maxItems int
 
+	dimensions       []string
+	virtualNodes     bool
+	virtualNodeAttrs []string
+
+	metricsInstance string
+	constLabels     prometheus.Labels
+	tracesInstance  string
+	// writer writes metrics to metricsInstance, if set.
+	writer *metricsWriter
+
 	// completed edges are pushed through this channel to be processed.
 	collectCh chan string
 
@@ -99,6 +128,9 @@ func newProcessor(nextConsumer consumer.Traces, cfg *Config) *processor {
 	if cfg.Workers == 0 {
 		cfg.Workers = DefaultWorkers
 	}
+	if len(cfg.VirtualNodePeerAttributes) == 0 {
+		cfg.VirtualNodePeerAttributes = DefaultVirtualNodePeerAttributes
+	}
 
 	var (
 		httpSuccessCodeMap = make(map[int]struct{})
@@ -119,6 +151,12 @@ func newProcessor(nextConsumer consumer.Traces, cfg *Config) *processor {
 
 		wait:               cfg.Wait,
 		maxItems:           cfg.MaxItems,
+		dimensions:         cfg.Dimensions,
+		virtualNodes:       cfg.EnableVirtualNodes,
+		virtualNodeAttrs:   cfg.VirtualNodePeerAttributes,
+		metricsInstance:    cfg.MetricsInstance,
+		constLabels:        cfg.ConstLabels,
+		tracesInstance:     cfg.TracesInstance,
 		httpSuccessCodeMap: httpSuccessCodeMap,
 		grpcSuccessCodeMap: grpcSuccessCodeMap,
 
@@ -148,37 +186,69 @@ func (p *processor) Start(ctx context.Context, _ component.Host) error {
 	// initialize store
 	p.store = newStore(p.wait, p.maxItems, p.collectEdge)
 
+	if p.metricsInstance != "" {
+		manager, ok := ctx.Value(contextkeys.Metrics).(instance.Manager)
+		if !ok || manager == nil {
+			return fmt.Errorf("key does not contain a metrics instance manager")
+		}
+
+		// Metrics are kept in a registry of their own, which is written to
+		// the metrics instance instead of being exposed by the agent. They
+		// are labeled with the traces instance, since series from several
+		// traces instances would otherwise collide in the metrics instance.
+		labels := prometheus.Labels{tracesInstanceLabel: p.tracesInstance}
+		for name, value := range p.constLabels {
+			if name != tracesInstanceLabel {
+				labels[name] = value
+			}
+		}
+		registry := prometheus.NewRegistry()
+		p.reg = prometheus.WrapRegistererWith(labels, registry)
+		if err := p.registerMetrics(); err != nil {
+			return err
+		}
+		p.writer = newMetricsWriter(p.logger, manager, p.metricsInstance, registry)
+		return nil
+	}
+
 	reg, ok := ctx.Value(contextkeys.PrometheusRegisterer).(prometheus.Registerer)
 	if !ok || reg == nil {
 		return fmt.Errorf("key does not contain a prometheus registerer")
 	}
-	p.reg = reg
+	p.reg = prometheus.WrapRegistererWith(p.constLabels, reg)
 	return p.registerMetrics()
 }
 
 func (p *processor) registerMetrics() error {
+	edgeLabels := []string{"client", "server"}
+	for _, side := range []string{"client", "server"} {
+		for _, d := range p.dimensions {
+			edgeLabels = append(edgeLabels, side+"_"+strutil.SanitizeLabelName(d))
+		}
+	}
+
 	p.serviceGraphRequestTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: "traces",
 		Name:      "service_graph_request_total",
 		Help:      "Total count of requests between two nodes",
-	}, []string{"client", "server"})
+	}, edgeLabels)
 	p.serviceGraphRequestFailedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: "traces",
 		Name:      "service_graph_request_failed_total",
 		Help:      "Total count of failed requests between two nodes",
-	}, []string{"client", "server"})
+	}, edgeLabels)
 	p.serviceGraphRequestServerHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace: "traces",
 		Name:      "service_graph_request_server_seconds",
 		Help:      "Time for a request between two nodes as seen from the server",
 		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
-	}, []string{"client", "server"})
+	}, edgeLabels)
 	p.serviceGraphRequestClientHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace: "traces",
 		Name:      "service_graph_request_client_seconds",
 		Help:      "Time for a request between two nodes as seen from the client",
 		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
-	}, []string{"client", "server"})
+	}, edgeLabels)
 	p.serviceGraphUnpairedSpansTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: "traces",
 		Name:      "service_graph_unpaired_spans_total",
@@ -210,6 +280,9 @@ func (p *processor) registerMetrics() error {
 
 func (p *processor) Shutdown(context.Context) error {
 	close(p.closeCh)
+	if p.writer != nil {
+		p.writer.Stop()
+	}
 	p.unregisterMetrics()
 	return nil
 }
@@ -221,6 +294,7 @@ func (p *processor) unregisterMetrics() {
 		p.serviceGraphRequestServerHistogram,
 		p.serviceGraphRequestClientHistogram,
 		p.serviceGraphUnpairedSpansTotal,
+		p.serviceGraphDroppedSpansTotal,
 	}
 
 	for _, c := range cs {
@@ -252,12 +326,21 @@ func (p *processor) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
 // Returns true if the edge is completed or expired and should be deleted.
 func (p *processor) collectEdge(e *edge) {
 	if e.isCompleted() {
-		p.serviceGraphRequestTotal.WithLabelValues(e.clientService, e.serverService).Inc()
+		labels := p.edgeLabelValues(e, e.serverService)
+		p.serviceGraphRequestTotal.WithLabelValues(labels...).Inc()
+		if e.failed {
+			p.serviceGraphRequestFailedTotal.WithLabelValues(labels...).Inc()
+		}
+		p.serviceGraphRequestServerHistogram.WithLabelValues(labels...).Observe(e.serverLatency.Seconds())
+		p.serviceGraphRequestClientHistogram.WithLabelValues(labels...).Observe(e.clientLatency.Seconds())
+	} else if e.isExpired() && p.virtualNodes && e.isVirtual() {
+		// Virtual nodes don't send spans, so there's no server latency.
+		labels := p.edgeLabelValues(e, e.peerNode)
+		p.serviceGraphRequestTotal.WithLabelValues(labels...).Inc()
 		if e.failed {
-			p.serviceGraphRequestFailedTotal.WithLabelValues(e.clientService, e.serverService).Inc()
+			p.serviceGraphRequestFailedTotal.WithLabelValues(labels...).Inc()
 		}
-		p.serviceGraphRequestServerHistogram.WithLabelValues(e.clientService, e.serverService).Observe(e.serverLatency.Seconds())
-		p.serviceGraphRequestClientHistogram.WithLabelValues(e.clientService, e.serverService).Observe(e.clientLatency.Seconds())
+		p.serviceGraphRequestClientHistogram.WithLabelValues(labels...).Observe(e.clientLatency.Seconds())
 	} else if e.isExpired() {
 		p.serviceGraphUnpairedSpansTotal.WithLabelValues(e.clientService, e.serverService).Inc()
 	}
@@ -288,6 +371,10 @@ func (p *processor) consume(trace pdata.Traces) error {
 					edge, err := p.store.upsertEdge(k, func(e *edge) {
 						e.clientService = svc.StringVal()
 						e.clientLatency = spanDuration(span)
+						e.clientDimensions = p.dimensionValues(span, rSpan.Resource())
+						if p.virtualNodes {
+							e.peerNode = p.peerNode(span)
+						}
 						e.failed = e.failed || p.spanFailed(span) // keep request as failed if any span is failed
 					})
 
@@ -311,6 +398,7 @@ func (p *processor) consume(trace pdata.Traces) error {
 					edge, err := p.store.upsertEdge(k, func(e *edge) {
 						e.serverService = svc.StringVal()
 						e.serverLatency = spanDuration(span)
+						e.serverDimensions = p.dimensionValues(span, rSpan.Resource())
 						e.failed = e.failed || p.spanFailed(span) // keep request as failed if any span is failed
 					})
 
@@ -362,6 +450,56 @@ func (p *processor) spanFailed(span pdata.Span) bool {
 	return span.Status().Code() == pdata.StatusCodeError
 }
 
+// edgeLabelValues returns the label values for an edge between the client
+// of e and server.
+func (p *processor) edgeLabelValues(e *edge, server string) []string {
+	values := make([]string, 0, 2+2*len(p.dimensions))
+	values = append(values, e.clientService, server)
+	values = appendDimensions(values, e.clientDimensions, len(p.dimensions))
+	values = appendDimensions(values, e.serverDimensions, len(p.dimensions))
+	return values
+}
+
+// appendDimensions appends n dimension values to values, using empty values
+// for missing dimensions.
+func appendDimensions(values, dims []string, n int) []string {
+	for i := 0; i < n; i++ {
+		var v string
+		if i < len(dims) {
+			v = dims[i]
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+// dimensionValues returns the values of the configured dimensions for a span.
+// Span attributes take precedence over resource attributes.
+func (p *processor) dimensionValues(span pdata.Span, resource pdata.Resource) []string {
+	if len(p.dimensions) == 0 {
+		return nil
+	}
+	values := make([]string, len(p.dimensions))
+	for i, d := range p.dimensions {
+		if v, ok := span.Attributes().Get(d); ok {
+			values[i] = v.AsString()
+		} else if v, ok := resource.Attributes().Get(d); ok {
+			values[i] = v.AsString()
+		}
+	}
+	return values
+}
+
+// peerNode returns the name of the virtual node called by a client span.
+func (p *processor) peerNode(span pdata.Span) string {
+	for _, attr := range p.virtualNodeAttrs {
+		if v, ok := span.Attributes().Get(attr); ok && v.AsString() != "" {
+			return v.AsString()
+		}
+	}
+	return ""
+}
+
 func spanDuration(span pdata.Span) time.Duration {
 	return span.EndTimestamp().AsTime().Sub(span.StartTimestamp().AsTime())
 }
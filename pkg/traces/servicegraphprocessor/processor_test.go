@@ -15,6 +15,7 @@ import (
 	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/model/otlp"
 	"go.opentelemetry.io/collector/model/pdata"
+	semconv "go.opentelemetry.io/collector/model/semconv/v1.6.1"
 )
 
 const (
@@ -97,6 +98,62 @@ func TestConsumeMetrics(t *testing.T) {
 	}
 }
 
+func TestVirtualNodesAndDimensions(t *testing.T) {
+	p := newProcessor(&mockConsumer{}, &Config{
+		Wait:               -time.Millisecond,
+		Dimensions:         []string{"k8s.namespace.name"},
+		EnableVirtualNodes: true,
+	})
+	close(p.closeCh) // Don't collect any edges, leave that to the test.
+
+	reg := prometheus.NewRegistry()
+	ctx := context.WithValue(context.Background(), contextkeys.PrometheusRegisterer, reg)
+	require.NoError(t, p.Start(ctx, nil))
+
+	traces := pdata.NewTraces()
+	rs := traces.ResourceSpans().AppendEmpty()
+	rs.Resource().Attributes().InsertString(semconv.AttributeServiceName, "app")
+	rs.Resource().Attributes().InsertString("k8s.namespace.name", "prod")
+	spans := rs.InstrumentationLibrarySpans().AppendEmpty().Spans()
+
+	// A call to an uninstrumented database.
+	db := spans.AppendEmpty()
+	db.SetKind(pdata.SpanKindClient)
+	db.SetTraceID(pdata.NewTraceID([16]byte{1}))
+	db.SetSpanID(pdata.NewSpanID([8]byte{1}))
+	db.SetStartTimestamp(pdata.NewTimestampFromTime(time.Unix(0, 0)))
+	db.SetEndTimestamp(pdata.NewTimestampFromTime(time.Unix(1, 0)))
+	db.Attributes().InsertString(semconv.AttributeDBSystem, "postgresql")
+
+	// A call to a service which didn't send its span has no virtual node.
+	unknown := spans.AppendEmpty()
+	unknown.SetKind(pdata.SpanKindClient)
+	unknown.SetTraceID(pdata.NewTraceID([16]byte{2}))
+	unknown.SetSpanID(pdata.NewSpanID([8]byte{2}))
+
+	require.NoError(t, p.ConsumeTraces(context.Background(), traces))
+	collectMetrics(p)
+
+	expect := `
+		# HELP traces_service_graph_request_total Total count of requests between two nodes
+		# TYPE traces_service_graph_request_total counter
+		traces_service_graph_request_total{client="app",client_k8s_namespace_name="prod",server="postgresql",server_k8s_namespace_name=""} 1
+		# HELP traces_service_graph_unpaired_spans_total Total count of unpaired spans
+		# TYPE traces_service_graph_unpaired_spans_total counter
+		traces_service_graph_unpaired_spans_total{client="app",server=""} 1
+`
+	err := testutil.GatherAndCompare(reg, bytes.NewBufferString(expect),
+		"traces_service_graph_request_total", "traces_service_graph_unpaired_spans_total")
+	require.NoError(t, err)
+
+	count, err := testutil.GatherAndCount(reg, "traces_service_graph_request_client_seconds")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	count, err = testutil.GatherAndCount(reg, "traces_service_graph_request_server_seconds")
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+}
+
 func traceSamples(t *testing.T, path string) pdata.Traces {
 	b, err := ioutil.ReadFile(path)
 	require.NoError(t, err)
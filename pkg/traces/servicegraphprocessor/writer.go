@@ -0,0 +1,165 @@
+package servicegraphprocessor
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// writeInterval is how often metrics are written to a metrics instance.
+const writeInterval = 15 * time.Second
+
+// metricsWriter periodically writes the metrics gathered from a registry to
+// a metrics instance.
+type metricsWriter struct {
+	logger       log.Logger
+	manager      instance.Manager
+	instanceName string
+	gatherer     prometheus.Gatherer
+
+	stopCh chan struct{}
+	done   chan struct{}
+}
+
+func newMetricsWriter(logger log.Logger, manager instance.Manager, instanceName string, gatherer prometheus.Gatherer) *metricsWriter {
+	w := &metricsWriter{
+		logger:       logger,
+		manager:      manager,
+		instanceName: instanceName,
+		gatherer:     gatherer,
+
+		stopCh: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *metricsWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(writeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case <-ticker.C:
+			if err := w.write(context.Background()); err != nil {
+				level.Warn(w.logger).Log("msg", "failed to write service graph metrics", "err", err)
+			}
+		}
+	}
+}
+
+// Stop stops the writer after writing the metrics one last time.
+func (w *metricsWriter) Stop() {
+	close(w.stopCh)
+	<-w.done
+
+	if err := w.write(context.Background()); err != nil {
+		level.Warn(w.logger).Log("msg", "failed to write service graph metrics", "err", err)
+	}
+}
+
+// write appends the current value of all gathered series to the metrics
+// instance.
+func (w *metricsWriter) write(ctx context.Context) error {
+	mfs, err := w.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %w", err)
+	}
+
+	inst, err := w.manager.GetInstance(w.instanceName)
+	if err != nil {
+		return fmt.Errorf("failed to get metrics instance: %w", err)
+	}
+
+	app := inst.Appender(ctx)
+	ts := timestamp.FromTime(time.Now())
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			if err := appendMetric(app, mf.GetName(), mf.GetType(), m, ts); err != nil {
+				_ = app.Rollback()
+				return err
+			}
+		}
+	}
+	return app.Commit()
+}
+
+// appendMetric appends the series of a metric in the same form they would
+// be scraped.
+func appendMetric(app storage.Appender, name string, typ dto.MetricType, m *dto.Metric, ts int64) error {
+	series := func(suffix string, extra ...labels.Label) labels.Labels {
+		ls := make([]labels.Label, 0, len(m.GetLabel())+1+len(extra))
+		ls = append(ls, labels.Label{Name: labels.MetricName, Value: name + suffix})
+		for _, lp := range m.GetLabel() {
+			ls = append(ls, labels.Label{Name: lp.GetName(), Value: lp.GetValue()})
+		}
+		ls = append(ls, extra...)
+		return labels.New(ls...)
+	}
+	add := func(ls labels.Labels, v float64) error {
+		_, err := app.Append(0, ls, ts, v)
+		return err
+	}
+
+	switch typ {
+	case dto.MetricType_COUNTER:
+		return add(series(""), m.GetCounter().GetValue())
+	case dto.MetricType_GAUGE:
+		return add(series(""), m.GetGauge().GetValue())
+	case dto.MetricType_UNTYPED:
+		return add(series(""), m.GetUntyped().GetValue())
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		for _, b := range h.GetBucket() {
+			if math.IsInf(b.GetUpperBound(), +1) {
+				continue // Added below
+			}
+			le := labels.Label{Name: labels.BucketLabel, Value: formatFloat(b.GetUpperBound())}
+			if err := add(series("_bucket", le), float64(b.GetCumulativeCount())); err != nil {
+				return err
+			}
+		}
+		inf := labels.Label{Name: labels.BucketLabel, Value: "+Inf"}
+		if err := add(series("_bucket", inf), float64(h.GetSampleCount())); err != nil {
+			return err
+		}
+		if err := add(series("_sum"), h.GetSampleSum()); err != nil {
+			return err
+		}
+		return add(series("_count"), float64(h.GetSampleCount()))
+	case dto.MetricType_SUMMARY:
+		s := m.GetSummary()
+		for _, q := range s.GetQuantile() {
+			quantile := labels.Label{Name: "quantile", Value: formatFloat(q.GetQuantile())}
+			if err := add(series("", quantile), q.GetValue()); err != nil {
+				return err
+			}
+		}
+		if err := add(series("_sum"), s.GetSampleSum()); err != nil {
+			return err
+		}
+		return add(series("_count"), float64(s.GetSampleCount()))
+	default:
+		return fmt.Errorf("unsupported metric type %s", typ)
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
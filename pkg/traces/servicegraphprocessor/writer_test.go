@@ -0,0 +1,166 @@
+package servicegraphprocessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/grafana/agent/pkg/traces/contextkeys"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsInstance(t *testing.T) {
+	p := newProcessor(&mockConsumer{}, &Config{
+		Wait:            time.Hour,
+		MetricsInstance: "traces",
+		ConstLabels:     prometheus.Labels{"cluster": "prod"},
+		TracesInstance:  "default",
+	})
+	close(p.closeCh) // Don't collect any edges, leave that to the test.
+
+	app := &mockAppender{}
+	manager := &mockManager{instance: &mockInstance{appender: app}}
+	ctx := context.WithValue(context.Background(), contextkeys.Metrics, manager)
+	require.NoError(t, p.Start(ctx, nil))
+	defer p.writer.Stop()
+
+	traces := traceSamples(t, traceSamplePath)
+	require.NoError(t, p.ConsumeTraces(context.Background(), traces))
+	collectMetrics(p)
+
+	require.NoError(t, p.writer.write(context.Background()))
+
+	total := app.series(labels.FromStrings(
+		"__name__", "traces_service_graph_request_total",
+		"client", "app",
+		"cluster", "prod",
+		"server", "db",
+		"traces_instance", "default",
+	))
+	require.Equal(t, []float64{3}, total)
+
+	inf := app.series(labels.FromStrings(
+		"__name__", "traces_service_graph_request_server_seconds_bucket",
+		"client", "app",
+		"cluster", "prod",
+		"le", "+Inf",
+		"server", "db",
+		"traces_instance", "default",
+	))
+	require.Equal(t, []float64{3}, inf)
+
+	count := app.series(labels.FromStrings(
+		"__name__", "traces_service_graph_request_server_seconds_count",
+		"client", "app",
+		"cluster", "prod",
+		"server", "db",
+		"traces_instance", "default",
+	))
+	require.Equal(t, []float64{3}, count)
+}
+
+func TestMetricsInstance_SharedByTracesInstances(t *testing.T) {
+	app := &mockAppender{}
+	manager := &mockManager{instance: &mockInstance{appender: app}}
+	ctx := context.WithValue(context.Background(), contextkeys.Metrics, manager)
+
+	for _, name := range []string{"a", "b"} {
+		p := newProcessor(&mockConsumer{}, &Config{
+			Wait:            time.Hour,
+			MetricsInstance: "traces",
+			ConstLabels:     prometheus.Labels{"cluster": "prod"},
+			TracesInstance:  name,
+		})
+		close(p.closeCh)
+		require.NoError(t, p.Start(ctx, nil))
+		defer p.writer.Stop()
+
+		traces := traceSamples(t, traceSamplePath)
+		require.NoError(t, p.ConsumeTraces(context.Background(), traces))
+		collectMetrics(p)
+		require.NoError(t, p.writer.write(context.Background()))
+	}
+
+	for _, name := range []string{"a", "b"} {
+		total := app.series(labels.FromStrings(
+			"__name__", "traces_service_graph_request_total",
+			"client", "app",
+			"cluster", "prod",
+			"server", "db",
+			"traces_instance", name,
+		))
+		require.Equal(t, []float64{3}, total, "traces instance %s", name)
+	}
+}
+
+func TestMetricsInstance_NoManager(t *testing.T) {
+	p := newProcessor(&mockConsumer{}, &Config{MetricsInstance: "traces"})
+	defer close(p.closeCh)
+
+	require.Error(t, p.Start(context.Background(), nil))
+}
+
+type mockManager struct {
+	instance *mockInstance
+}
+
+func (m *mockManager) GetInstance(string) (instance.ManagedInstance, error) {
+	return m.instance, nil
+}
+
+func (m *mockManager) ListInstances() map[string]instance.ManagedInstance { return nil }
+
+func (m *mockManager) ListConfigs() map[string]instance.Config { return nil }
+
+func (m *mockManager) ApplyConfig(_ instance.Config) error { return nil }
+
+func (m *mockManager) DeleteConfig(_ string) error { return nil }
+
+func (m *mockManager) Stop() {}
+
+type mockInstance struct {
+	instance.NoOpInstance
+	appender *mockAppender
+}
+
+func (m *mockInstance) Appender(_ context.Context) storage.Appender {
+	return m.appender
+}
+
+type sample struct {
+	l labels.Labels
+	v float64
+}
+
+type mockAppender struct {
+	samples []sample
+}
+
+// series returns the values appended for the series with labels l.
+func (a *mockAppender) series(l labels.Labels) []float64 {
+	var vs []float64
+	for _, s := range a.samples {
+		if labels.Equal(s.l, l) {
+			vs = append(vs, s.v)
+		}
+	}
+	return vs
+}
+
+func (a *mockAppender) Append(_ storage.SeriesRef, l labels.Labels, _ int64, v float64) (storage.SeriesRef, error) {
+	a.samples = append(a.samples, sample{l: l, v: v})
+	return 0, nil
+}
+
+func (a *mockAppender) Commit() error { return nil }
+
+func (a *mockAppender) Rollback() error { return nil }
+
+func (a *mockAppender) AppendExemplar(_ storage.SeriesRef, _ labels.Labels, _ exemplar.Exemplar) (storage.SeriesRef, error) {
+	return 0, nil
+}
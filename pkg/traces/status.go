@@ -0,0 +1,388 @@
+package traces
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/agent/pkg/traces/pushreceiver"
+	"go.opencensus.io/metric/metricproducer"
+	"go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/exporter/exporterhelper"
+	"go.opentelemetry.io/collector/service/external/components"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// scrubbedValue replaces secrets in configs returned by the API.
+const scrubbedValue = "<secret>"
+
+// secretKeys are config keys whose values are scrubbed from configs returned
+// by the API. All values of headers are scrubbed too, since they usually hold
+// credentials.
+var secretKeys = map[string]bool{
+	"api_key":       true,
+	"bearer_token":  true,
+	"client_secret": true,
+	"password":      true,
+	"secret":        true,
+	"token":         true,
+}
+
+// queueSizeMetric is the name of the gauge reporting the current size of
+// exporter sending queues.
+const queueSizeMetric = "exporter/queue_size"
+
+// InstanceStatus describes the pipelines of a running Instance.
+type InstanceStatus struct {
+	Name string `json:"name"`
+
+	// Config is the generated OTel config, with secrets scrubbed.
+	Config map[string]interface{} `json:"config"`
+
+	Receivers []ReceiverStatus `json:"receivers"`
+	Pipelines []PipelineStatus `json:"pipelines"`
+	Exporters []ExporterStatus `json:"exporters"`
+}
+
+// ReceiverStatus describes a receiver and the addresses it listens on.
+type ReceiverStatus struct {
+	Name      string             `json:"name"`
+	Endpoints []ReceiverEndpoint `json:"endpoints"`
+}
+
+// ReceiverEndpoint is an address a receiver listens on for a protocol.
+type ReceiverEndpoint struct {
+	Protocol string `json:"protocol,omitempty"`
+	Endpoint string `json:"endpoint"`
+}
+
+// PipelineStatus describes a pipeline. Processors are listed in the order
+// they process data.
+type PipelineStatus struct {
+	Name       string   `json:"name"`
+	Receivers  []string `json:"receivers"`
+	Processors []string `json:"processors"`
+	Exporters  []string `json:"exporters"`
+}
+
+// ExporterStatus describes the sending queue and the errors of an exporter.
+type ExporterStatus struct {
+	Name string `json:"name"`
+
+	QueueEnabled  bool `json:"queue_enabled"`
+	QueueCapacity int  `json:"queue_capacity,omitempty"`
+	// QueueSize is the current number of batches in the sending queue. The
+	// collector tracks queues by exporter name, so it's shared by exporters
+	// with the same name in different instances.
+	QueueSize *int64 `json:"queue_size,omitempty"`
+
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+// Status returns the status of the Instance's pipelines.
+func (i *Instance) Status() (InstanceStatus, error) {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+
+	status := InstanceStatus{Name: i.cfg.Name}
+
+	cfg := withClusterPeers(i.cfg, i.clusterPeers)
+	rawConfig, err := cfg.otelConfigMap()
+	if err != nil {
+		return status, fmt.Errorf("failed to generate OTel config: %w", err)
+	}
+	status.Config, _ = scrubConfig(rawConfig, "").(map[string]interface{})
+
+	if i.otelCfg == nil {
+		return status, nil
+	}
+
+	for id, r := range i.otelCfg.Receivers {
+		if isPushReceiver(id) {
+			continue
+		}
+		status.Receivers = append(status.Receivers, ReceiverStatus{
+			Name:      id.String(),
+			Endpoints: receiverEndpoints(reflect.ValueOf(r), "", 0),
+		})
+	}
+	sort.Slice(status.Receivers, func(a, b int) bool { return status.Receivers[a].Name < status.Receivers[b].Name })
+
+	for id, p := range i.otelCfg.Service.Pipelines {
+		status.Pipelines = append(status.Pipelines, PipelineStatus{
+			Name:       id.String(),
+			Receivers:  componentNames(p.Receivers),
+			Processors: componentNames(p.Processors),
+			Exporters:  componentNames(p.Exporters),
+		})
+	}
+	sort.Slice(status.Pipelines, func(a, b int) bool { return status.Pipelines[a].Name < status.Pipelines[b].Name })
+
+	queueSizes := exporterQueueSizes()
+	for id, e := range i.otelCfg.Exporters {
+		es := ExporterStatus{Name: id.String()}
+		if q, ok := findQueueSettings(reflect.ValueOf(e), 0); ok {
+			es.QueueEnabled = q.Enabled
+			es.QueueCapacity = q.QueueSize
+		}
+		if size, ok := queueSizes[es.Name]; ok && es.QueueEnabled {
+			es.QueueSize = &size
+		}
+		if last, ok := i.exportErrors.Get(es.Name); ok {
+			es.LastError = last.err
+			es.LastErrorTime = &last.time
+		}
+		status.Exporters = append(status.Exporters, es)
+	}
+	sort.Slice(status.Exporters, func(a, b int) bool { return status.Exporters[a].Name < status.Exporters[b].Name })
+
+	return status, nil
+}
+
+func componentNames(ids []config.ComponentID) []string {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if isPushReceiver(id) {
+			continue
+		}
+		names = append(names, id.String())
+	}
+	return names
+}
+
+// isPushReceiver returns true if id is the receiver added to every instance
+// for traces pushed by the Agent itself. It's not part of the user's config,
+// so it's hidden from the API.
+func isPushReceiver(id config.ComponentID) bool {
+	return id.Type() == pushreceiver.TypeStr
+}
+
+// scrubConfig returns a copy of v with secrets replaced and all maps converted
+// to map[string]interface{} so it can be encoded as JSON. key is the key v was
+// found at.
+func scrubConfig(v interface{}, key string) interface{} {
+	if v == nil {
+		return nil
+	}
+	if secretKeys[key] {
+		return scrubbedValue
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map:
+		out := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			k := fmt.Sprint(iter.Key().Interface())
+			if key == "headers" {
+				out[k] = scrubbedValue
+				continue
+			}
+			out[k] = scrubConfig(iter.Value().Interface(), k)
+		}
+		return out
+	case reflect.Slice:
+		out := make([]interface{}, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			out[i] = scrubConfig(rv.Index(i).Interface(), key)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// receiverEndpoints finds the endpoints set in a receiver config. Protocols
+// are named by the path of config keys leading to the endpoint.
+func receiverEndpoints(v reflect.Value, path string, depth int) []ReceiverEndpoint {
+	const maxDepth = 6
+	if depth > maxDepth {
+		return nil
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var endpoints []ReceiverEndpoint
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // Unexported
+		}
+
+		tag := strings.Split(f.Tag.Get("mapstructure"), ",")
+		fieldPath := path
+		if tag[0] != "" && !(len(tag) > 1 && tag[1] == "squash") {
+			fieldPath = strings.TrimPrefix(path+"."+tag[0], ".")
+		}
+
+		fv := v.Field(i)
+		if f.Name == "Endpoint" && fv.Kind() == reflect.String {
+			if fv.String() != "" {
+				endpoints = append(endpoints, ReceiverEndpoint{Protocol: path, Endpoint: fv.String()})
+			}
+			continue
+		}
+		endpoints = append(endpoints, receiverEndpoints(fv, fieldPath, depth+1)...)
+	}
+	sort.Slice(endpoints, func(a, b int) bool { return endpoints[a].Protocol < endpoints[b].Protocol })
+	return endpoints
+}
+
+// findQueueSettings finds the sending queue settings in an exporter config.
+func findQueueSettings(v reflect.Value, depth int) (exporterhelper.QueueSettings, bool) {
+	const maxDepth = 3
+	if depth > maxDepth {
+		return exporterhelper.QueueSettings{}, false
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return exporterhelper.QueueSettings{}, false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return exporterhelper.QueueSettings{}, false
+	}
+	if q, ok := v.Interface().(exporterhelper.QueueSettings); ok {
+		return q, true
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).PkgPath != "" {
+			continue // Unexported
+		}
+		if q, ok := findQueueSettings(v.Field(i), depth+1); ok {
+			return q, true
+		}
+	}
+	return exporterhelper.QueueSettings{}, false
+}
+
+// exporterQueueSizes reads the current size of exporter sending queues,
+// keyed by exporter name.
+func exporterQueueSizes() map[string]int64 {
+	sizes := make(map[string]int64)
+	for _, p := range metricproducer.GlobalManager().GetAll() {
+		for _, m := range p.Read() {
+			if m.Descriptor.Name != queueSizeMetric {
+				continue
+			}
+			for _, ts := range m.TimeSeries {
+				if len(ts.LabelValues) == 0 || len(ts.Points) == 0 {
+					continue
+				}
+				if size, ok := ts.Points[len(ts.Points)-1].Value.(int64); ok {
+					sizes[ts.LabelValues[0].Value] = size
+				}
+			}
+		}
+	}
+	return sizes
+}
+
+// exportErrors records the last error logged by each exporter.
+type exportErrors struct {
+	mut  sync.Mutex
+	last map[string]exportError
+}
+
+type exportError struct {
+	err  string
+	time time.Time
+}
+
+// Get returns the last error logged by the exporter with the given name.
+func (e *exportErrors) Get(name string) (exportError, bool) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	last, ok := e.last[name]
+	return last, ok
+}
+
+// Reset forgets all recorded errors.
+func (e *exportErrors) Reset() {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.last = nil
+}
+
+func (e *exportErrors) record(name string, err exportError) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if e.last == nil {
+		e.last = make(map[string]exportError)
+	}
+	e.last[name] = err
+}
+
+// WrapLogger returns a logger which records errors logged by exporters
+// created with it.
+func (e *exportErrors) WrapLogger(l *zap.Logger) *zap.Logger {
+	return l.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return &exportErrorCore{Core: c, errs: e}
+	}))
+}
+
+// exportErrorCore is a zapcore.Core which records errors logged by exporters.
+// Exporter loggers are identified by the fields added by the collector.
+type exportErrorCore struct {
+	zapcore.Core
+	errs *exportErrors
+
+	kind, name string
+}
+
+func (c *exportErrorCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.Core = c.Core.With(fields)
+	for _, f := range fields {
+		switch f.Key {
+		case components.ZapKindKey:
+			clone.kind = f.String
+		case components.ZapNameKey:
+			clone.name = f.String
+		}
+	}
+	return &clone
+}
+
+func (c *exportErrorCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.kind == components.ZapKindLogExporter && ent.Level >= zapcore.ErrorLevel {
+		ce = ce.AddCore(ent, recordCore{c})
+	}
+	return c.Core.Check(ent, ce)
+}
+
+// recordCore records entries written to it without passing them to the
+// wrapped core, which checks entries on its own.
+type recordCore struct{ *exportErrorCore }
+
+func (c recordCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	msg := ent.Message
+	for _, f := range fields {
+		if err, ok := f.Interface.(error); ok && f.Type == zapcore.ErrorType {
+			msg = fmt.Sprintf("%s: %s", msg, err)
+			break
+		}
+	}
+	c.errs.record(c.name, exportError{err: msg, time: ent.Time})
+	return nil
+}
+
+func (c recordCore) Sync() error { return nil }
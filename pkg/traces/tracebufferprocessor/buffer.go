@@ -0,0 +1,269 @@
+package tracebufferprocessor
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/model/pdata"
+	semconv "go.opentelemetry.io/collector/model/semconv/v1.6.1"
+)
+
+// DefaultSearchLimit is the default number of traces returned by Search.
+const DefaultSearchLimit = 20
+
+// Trace is a trace kept in a Buffer.
+type Trace struct {
+	TraceID string `json:"trace_id"`
+	// ReceivedAt is when the first span of the trace was received.
+	ReceivedAt time.Time `json:"received_at"`
+	Spans      []Span    `json:"spans"`
+	// DroppedSpans is the number of spans which didn't fit in the buffer.
+	DroppedSpans int `json:"dropped_spans,omitempty"`
+}
+
+// Span is a span of a Trace, with the attributes it had when it passed
+// through the processor.
+type Span struct {
+	SpanID        string        `json:"span_id"`
+	ParentSpanID  string        `json:"parent_span_id,omitempty"`
+	Service       string        `json:"service"`
+	Name          string        `json:"name"`
+	Kind          string        `json:"kind"`
+	StartTime     time.Time     `json:"start_time"`
+	EndTime       time.Time     `json:"end_time"`
+	Duration      time.Duration `json:"duration_ns"`
+	Status        string        `json:"status"`
+	StatusMessage string        `json:"status_message,omitempty"`
+
+	Attributes         map[string]interface{} `json:"attributes,omitempty"`
+	ResourceAttributes map[string]interface{} `json:"resource_attributes,omitempty"`
+	Events             []Event                `json:"events,omitempty"`
+}
+
+// Event is an event of a Span.
+type Event struct {
+	Name       string                 `json:"name"`
+	Time       time.Time              `json:"time"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// TraceSummary summarizes a Trace found by Search.
+type TraceSummary struct {
+	TraceID    string    `json:"trace_id"`
+	ReceivedAt time.Time `json:"received_at"`
+	Services   []string  `json:"services"`
+	SpanCount  int       `json:"span_count"`
+	// RootSpan is the name of the trace's root span, if it was received.
+	RootSpan string `json:"root_span,omitempty"`
+}
+
+// Query selects the traces returned by Search. A trace matches if any of
+// its spans matches all of the set fields.
+type Query struct {
+	Service     string
+	SpanName    string
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	// Status is one of UNSET, OK and ERROR.
+	Status string
+	// Limit is the maximum number of traces returned. Defaults to
+	// DefaultSearchLimit.
+	Limit int
+}
+
+func (q Query) matches(s *Span) bool {
+	switch {
+	case q.Service != "" && q.Service != s.Service:
+		return false
+	case q.SpanName != "" && q.SpanName != s.Name:
+		return false
+	case q.MinDuration > 0 && s.Duration < q.MinDuration:
+		return false
+	case q.MaxDuration > 0 && s.Duration > q.MaxDuration:
+		return false
+	case q.Status != "" && q.Status != s.Status:
+		return false
+	}
+	return true
+}
+
+// Buffer keeps the most recently received traces in memory. Once full, the
+// traces received first are evicted.
+type Buffer struct {
+	maxTraces, maxSpans int
+
+	mut    sync.RWMutex
+	traces map[string]*list.Element
+	// order holds *Trace, newest first.
+	order *list.List
+}
+
+// NewBuffer creates a new Buffer.
+func NewBuffer(cfg TraceBufferConfig) *Buffer {
+	if cfg.MaxTraces == 0 {
+		cfg.MaxTraces = DefaultMaxTraces
+	}
+	if cfg.MaxSpansPerTrace == 0 {
+		cfg.MaxSpansPerTrace = DefaultMaxSpansPerTrace
+	}
+
+	return &Buffer{
+		maxTraces: cfg.MaxTraces,
+		maxSpans:  cfg.MaxSpansPerTrace,
+		traces:    make(map[string]*list.Element),
+		order:     list.New(),
+	}
+}
+
+// Add adds the spans of td to the buffer.
+func (b *Buffer) Add(td pdata.Traces) {
+	now := time.Now()
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	for i := 0; i < td.ResourceSpans().Len(); i++ {
+		rs := td.ResourceSpans().At(i)
+		resAttrs := rs.Resource().Attributes().AsRaw()
+
+		var svc string
+		if att, ok := rs.Resource().Attributes().Get(semconv.AttributeServiceName); ok {
+			svc = att.AsString()
+		}
+
+		for j := 0; j < rs.InstrumentationLibrarySpans().Len(); j++ {
+			spans := rs.InstrumentationLibrarySpans().At(j).Spans()
+
+			for k := 0; k < spans.Len(); k++ {
+				span := spans.At(k)
+				t := b.trace(span.TraceID().HexString(), now)
+				if len(t.Spans) >= b.maxSpans {
+					t.DroppedSpans++
+					continue
+				}
+				t.Spans = append(t.Spans, newSpan(span, svc, resAttrs))
+			}
+		}
+	}
+}
+
+// trace returns the trace with the given ID, adding it if it isn't in the
+// buffer yet. Must be called with mut held.
+func (b *Buffer) trace(id string, now time.Time) *Trace {
+	if e, ok := b.traces[id]; ok {
+		return e.Value.(*Trace)
+	}
+
+	for b.order.Len() >= b.maxTraces {
+		oldest := b.order.Back()
+		b.order.Remove(oldest)
+		delete(b.traces, oldest.Value.(*Trace).TraceID)
+	}
+
+	t := &Trace{TraceID: id, ReceivedAt: now}
+	b.traces[id] = b.order.PushFront(t)
+	return t
+}
+
+func newSpan(span pdata.Span, svc string, resAttrs map[string]interface{}) Span {
+	s := Span{
+		SpanID:             span.SpanID().HexString(),
+		ParentSpanID:       span.ParentSpanID().HexString(),
+		Service:            svc,
+		Name:               span.Name(),
+		Kind:               span.Kind().String(),
+		StartTime:          span.StartTimestamp().AsTime(),
+		EndTime:            span.EndTimestamp().AsTime(),
+		Duration:           span.EndTimestamp().AsTime().Sub(span.StartTimestamp().AsTime()),
+		Status:             statusString(span.Status().Code()),
+		StatusMessage:      span.Status().Message(),
+		Attributes:         span.Attributes().AsRaw(),
+		ResourceAttributes: resAttrs,
+	}
+	for i := 0; i < span.Events().Len(); i++ {
+		e := span.Events().At(i)
+		s.Events = append(s.Events, Event{
+			Name:       e.Name(),
+			Time:       e.Timestamp().AsTime(),
+			Attributes: e.Attributes().AsRaw(),
+		})
+	}
+	return s
+}
+
+func statusString(code pdata.StatusCode) string {
+	switch code {
+	case pdata.StatusCodeOk:
+		return "OK"
+	case pdata.StatusCodeError:
+		return "ERROR"
+	default:
+		return "UNSET"
+	}
+}
+
+// Get returns a copy of the trace with the given hex-encoded ID.
+func (b *Buffer) Get(traceID string) (Trace, bool) {
+	b.mut.RLock()
+	defer b.mut.RUnlock()
+
+	e, ok := b.traces[traceID]
+	if !ok {
+		return Trace{}, false
+	}
+	t := *e.Value.(*Trace)
+	t.Spans = append([]Span(nil), t.Spans...)
+	return t, true
+}
+
+// Search returns the most recently received traces matching q, newest
+// first.
+func (b *Buffer) Search(q Query) []TraceSummary {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+
+	b.mut.RLock()
+	defer b.mut.RUnlock()
+
+	res := []TraceSummary{}
+	for e := b.order.Front(); e != nil && len(res) < limit; e = e.Next() {
+		t := e.Value.(*Trace)
+
+		var matched bool
+		for i := range t.Spans {
+			if q.matches(&t.Spans[i]) {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			res = append(res, summarize(t))
+		}
+	}
+	return res
+}
+
+func summarize(t *Trace) TraceSummary {
+	s := TraceSummary{
+		TraceID:    t.TraceID,
+		ReceivedAt: t.ReceivedAt,
+		SpanCount:  len(t.Spans) + t.DroppedSpans,
+	}
+
+	services := make(map[string]struct{})
+	for _, span := range t.Spans {
+		if span.ParentSpanID == "" {
+			s.RootSpan = span.Name
+		}
+		if _, ok := services[span.Service]; !ok {
+			services[span.Service] = struct{}{}
+			s.Services = append(s.Services, span.Service)
+		}
+	}
+	sort.Strings(s.Services)
+	return s
+}
@@ -0,0 +1,155 @@
+package tracebufferprocessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/agent/pkg/traces/contextkeys"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+func TestProcessor(t *testing.T) {
+	next := new(consumertest.TracesSink)
+	p, err := newTraceProcessor(next)
+	require.NoError(t, err)
+
+	buffer := NewBuffer(TraceBufferConfig{})
+	ctx := context.WithValue(context.Background(), contextkeys.TraceBuffer, buffer)
+	require.NoError(t, p.Start(ctx, nil))
+
+	td := pdata.NewTraces()
+	addSpan(td, "api", 1, 1, 0, "GET /", time.Second, pdata.StatusCodeUnset)
+	require.NoError(t, p.ConsumeTraces(context.Background(), td))
+	require.Equal(t, 1, next.SpanCount())
+
+	trace, ok := buffer.Get(traceID(1))
+	require.True(t, ok)
+	require.Len(t, trace.Spans, 1)
+}
+
+func TestProcessor_NoBuffer(t *testing.T) {
+	p, err := newTraceProcessor(new(consumertest.TracesSink))
+	require.NoError(t, err)
+	require.Error(t, p.Start(context.Background(), nil))
+}
+
+func TestBuffer_Get(t *testing.T) {
+	b := NewBuffer(TraceBufferConfig{})
+
+	td := pdata.NewTraces()
+	span := addSpan(td, "api", 1, 2, 1, "GET /users", 1500*time.Millisecond, pdata.StatusCodeError)
+	span.Attributes().InsertString("k8s.pod.name", "api-0")
+	span.Events().AppendEmpty().SetName("exception")
+	b.Add(td)
+
+	trace, ok := b.Get(traceID(1))
+	require.True(t, ok)
+	require.Equal(t, traceID(1), trace.TraceID)
+	require.Len(t, trace.Spans, 1)
+
+	s := trace.Spans[0]
+	require.Equal(t, "0200000000000000", s.SpanID)
+	require.Equal(t, "0100000000000000", s.ParentSpanID)
+	require.Equal(t, "api", s.Service)
+	require.Equal(t, "GET /users", s.Name)
+	require.Equal(t, 1500*time.Millisecond, s.Duration)
+	require.Equal(t, "ERROR", s.Status)
+	require.Equal(t, map[string]interface{}{"k8s.pod.name": "api-0"}, s.Attributes)
+	require.Equal(t, map[string]interface{}{"service.name": "api"}, s.ResourceAttributes)
+	require.Len(t, s.Events, 1)
+
+	_, ok = b.Get(traceID(2))
+	require.False(t, ok)
+}
+
+func TestBuffer_Limits(t *testing.T) {
+	b := NewBuffer(TraceBufferConfig{MaxTraces: 2, MaxSpansPerTrace: 2})
+
+	td := pdata.NewTraces()
+	for i := byte(1); i <= 3; i++ {
+		addSpan(td, "api", 1, i, 0, "span", time.Second, pdata.StatusCodeUnset)
+	}
+	addSpan(td, "api", 2, 1, 0, "span", time.Second, pdata.StatusCodeUnset)
+	addSpan(td, "api", 3, 1, 0, "span", time.Second, pdata.StatusCodeUnset)
+	b.Add(td)
+
+	// The first trace is evicted.
+	_, ok := b.Get(traceID(1))
+	require.False(t, ok)
+	_, ok = b.Get(traceID(2))
+	require.True(t, ok)
+	_, ok = b.Get(traceID(3))
+	require.True(t, ok)
+
+	b = NewBuffer(TraceBufferConfig{MaxSpansPerTrace: 2})
+	b.Add(td)
+	trace, ok := b.Get(traceID(1))
+	require.True(t, ok)
+	require.Len(t, trace.Spans, 2)
+	require.Equal(t, 1, trace.DroppedSpans)
+}
+
+func TestBuffer_Search(t *testing.T) {
+	b := NewBuffer(TraceBufferConfig{})
+
+	td := pdata.NewTraces()
+	addSpan(td, "lb", 1, 1, 0, "GET /", 2*time.Second, pdata.StatusCodeUnset)
+	addSpan(td, "api", 1, 2, 1, "GET /users", time.Second, pdata.StatusCodeError)
+	addSpan(td, "api", 2, 1, 0, "GET /health", time.Millisecond, pdata.StatusCodeOk)
+	b.Add(td)
+
+	tt := []struct {
+		name   string
+		query  Query
+		expect []string
+	}{
+		{name: "all", expect: []string{traceID(2), traceID(1)}},
+		{name: "limit", query: Query{Limit: 1}, expect: []string{traceID(2)}},
+		{name: "service", query: Query{Service: "lb"}, expect: []string{traceID(1)}},
+		{name: "span name", query: Query{SpanName: "GET /health"}, expect: []string{traceID(2)}},
+		{name: "min duration", query: Query{MinDuration: time.Second}, expect: []string{traceID(1)}},
+		{name: "max duration", query: Query{MaxDuration: time.Second}, expect: []string{traceID(2), traceID(1)}},
+		{name: "status", query: Query{Status: "ERROR"}, expect: []string{traceID(1)}},
+		{name: "all fields of a span", query: Query{Service: "lb", Status: "ERROR"}, expect: []string{}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ids := []string{}
+			for _, s := range b.Search(tc.query) {
+				ids = append(ids, s.TraceID)
+			}
+			require.Equal(t, tc.expect, ids)
+		})
+	}
+
+	summaries := b.Search(Query{Service: "lb"})
+	require.Equal(t, []string{"api", "lb"}, summaries[0].Services)
+	require.Equal(t, 2, summaries[0].SpanCount)
+	require.Equal(t, "GET /", summaries[0].RootSpan)
+}
+
+func addSpan(td pdata.Traces, svc string, trace, span, parent byte, name string, dur time.Duration, code pdata.StatusCode) pdata.Span {
+	rs := td.ResourceSpans().AppendEmpty()
+	rs.Resource().Attributes().InsertString("service.name", svc)
+
+	s := rs.InstrumentationLibrarySpans().AppendEmpty().Spans().AppendEmpty()
+	s.SetTraceID(pdata.NewTraceID([16]byte{trace}))
+	s.SetSpanID(pdata.NewSpanID([8]byte{span}))
+	if parent != 0 {
+		s.SetParentSpanID(pdata.NewSpanID([8]byte{parent}))
+	}
+	s.SetName(name)
+	start := time.Unix(0, 0)
+	s.SetStartTimestamp(pdata.NewTimestampFromTime(start))
+	s.SetEndTimestamp(pdata.NewTimestampFromTime(start.Add(dur)))
+	s.Status().SetCode(code)
+	return s
+}
+
+func traceID(b byte) string {
+	return pdata.NewTraceID([16]byte{b}).HexString()
+}
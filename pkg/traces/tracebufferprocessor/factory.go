@@ -0,0 +1,72 @@
+package tracebufferprocessor
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/consumer"
+)
+
+const (
+	// TypeStr is the unique identifier for the Trace Buffer processor.
+	TypeStr = "trace_buffer"
+
+	// DefaultMaxTraces is the default number of traces kept in a Buffer.
+	DefaultMaxTraces = 1000
+	// DefaultMaxSpansPerTrace is the default number of spans kept per trace.
+	DefaultMaxSpansPerTrace = 1000
+)
+
+// Config holds the configuration for the Trace Buffer processor. The buffer
+// itself is passed to the processor through the context.
+type Config struct {
+	config.ProcessorSettings `mapstructure:",squash"`
+}
+
+// TraceBufferConfig holds config information for a Buffer.
+type TraceBufferConfig struct {
+	// MaxTraces is the number of traces kept in the buffer. The oldest
+	// traces are evicted first.
+	MaxTraces int `yaml:"max_traces,omitempty"`
+	// MaxSpansPerTrace is the number of spans kept per trace. Later spans
+	// are dropped.
+	MaxSpansPerTrace int `yaml:"max_spans_per_trace,omitempty"`
+}
+
+// Validate ensures that the TraceBufferConfig is valid.
+func (c *TraceBufferConfig) Validate() error {
+	if c.MaxTraces < 0 {
+		return fmt.Errorf("max_traces must not be negative")
+	}
+	if c.MaxSpansPerTrace < 0 {
+		return fmt.Errorf("max_spans_per_trace must not be negative")
+	}
+	return nil
+}
+
+// NewFactory returns a new factory for the Trace Buffer processor.
+func NewFactory() component.ProcessorFactory {
+	return component.NewProcessorFactory(
+		TypeStr,
+		createDefaultConfig,
+		component.WithTracesProcessor(createTraceProcessor),
+	)
+}
+
+func createDefaultConfig() config.Processor {
+	return &Config{
+		ProcessorSettings: config.NewProcessorSettings(config.NewComponentIDWithName(TypeStr, TypeStr)),
+	}
+}
+
+func createTraceProcessor(
+	_ context.Context,
+	_ component.ProcessorCreateSettings,
+	_ config.Processor,
+	nextConsumer consumer.Traces,
+) (component.TracesProcessor, error) {
+
+	return newTraceProcessor(nextConsumer)
+}
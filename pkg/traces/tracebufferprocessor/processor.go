@@ -0,0 +1,51 @@
+// Package tracebufferprocessor keeps the most recent traces received by a
+// traces instance in memory, so that ingestion can be confirmed through the
+// API.
+package tracebufferprocessor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/agent/pkg/traces/contextkeys"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenterror"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+type processor struct {
+	nextConsumer consumer.Traces
+	buffer       *Buffer
+}
+
+func newTraceProcessor(nextConsumer consumer.Traces) (component.TracesProcessor, error) {
+	if nextConsumer == nil {
+		return nil, componenterror.ErrNilNextConsumer
+	}
+	return &processor{nextConsumer: nextConsumer}, nil
+}
+
+func (p *processor) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	p.buffer.Add(td)
+	return p.nextConsumer.ConsumeTraces(ctx, td)
+}
+
+func (p *processor) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{}
+}
+
+// Start is invoked during service startup.
+func (p *processor) Start(ctx context.Context, _ component.Host) error {
+	buffer, ok := ctx.Value(contextkeys.TraceBuffer).(*Buffer)
+	if !ok || buffer == nil {
+		return fmt.Errorf("key does not contain a trace buffer")
+	}
+	p.buffer = buffer
+	return nil
+}
+
+// Shutdown is invoked during service shutdown.
+func (p *processor) Shutdown(context.Context) error {
+	return nil
+}
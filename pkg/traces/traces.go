@@ -31,10 +31,19 @@ type Traces struct {
 	reg      prom_client.Registerer
 
 	promInstanceManager instance.Manager
+	peers               PeerProvider
 }
 
-// New creates and starts trace collection.
-func New(logsSubsystem *logs.Logs, promInstanceManager instance.Manager, reg prom_client.Registerer, cfg Config, level logrus.Level, fmt logging.Format) (*Traces, error) {
+// PeerProvider provides the addresses of the agents in the cluster. It is
+// used by the cluster load balancing resolver, and is implemented by
+// *metrics.Agent.
+type PeerProvider interface {
+	ClusterPeers() ([]string, error)
+}
+
+// New creates and starts trace collection. peers may be nil if the agent
+// doesn't run in a cluster.
+func New(logsSubsystem *logs.Logs, promInstanceManager instance.Manager, peers PeerProvider, reg prom_client.Registerer, cfg Config, level logrus.Level, fmt logging.Format) (*Traces, error) {
 	var leveller logLeveller
 
 	traces := &Traces{
@@ -43,6 +52,7 @@ func New(logsSubsystem *logs.Logs, promInstanceManager instance.Manager, reg pro
 		logger:              newLogger(&leveller, fmt),
 		reg:                 reg,
 		promInstanceManager: promInstanceManager,
+		peers:               peers,
 	}
 	if err := traces.ApplyConfig(logsSubsystem, promInstanceManager, cfg, level); err != nil {
 		return nil, err
@@ -80,7 +90,7 @@ func (t *Traces) ApplyConfig(logsSubsystem *logs.Logs, promInstanceManager insta
 			instLogger = t.logger.With(zap.String("traces_config", c.Name))
 		)
 
-		inst, err := NewInstance(logsSubsystem, instReg, c, instLogger, t.promInstanceManager)
+		inst, err := NewInstance(logsSubsystem, instReg, c, instLogger, t.promInstanceManager, t.peers)
 		if err != nil {
 			return fmt.Errorf("failed to create tracing instance %s: %w", c.Name, err)
 		}
@@ -100,6 +110,14 @@ func (t *Traces) ApplyConfig(logsSubsystem *logs.Logs, promInstanceManager insta
 	return nil
 }
 
+// Instance is used to retrieve a named Traces instance
+func (t *Traces) Instance(name string) *Instance {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	return t.instances[name]
+}
+
 // Stop stops the OpenTelemetry collector subsystem
 func (t *Traces) Stop() {
 	t.mut.Lock()
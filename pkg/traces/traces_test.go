@@ -1,8 +1,10 @@
 package traces
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -48,7 +50,7 @@ configs:
 	var loggingLevel logging.Level
 	require.NoError(t, loggingLevel.Set("debug"))
 
-	traces, err := New(nil, nil, prometheus.NewRegistry(), cfg, logrus.InfoLevel, logging.Format{})
+	traces, err := New(nil, nil, nil, prometheus.NewRegistry(), cfg, logrus.InfoLevel, logging.Format{})
 	require.NoError(t, err)
 	t.Cleanup(traces.Stop)
 
@@ -65,6 +67,53 @@ configs:
 	}
 }
 
+func TestTraces_ConsumeTraces(t *testing.T) {
+	tracesCh := make(chan pdata.Traces)
+	tracesAddr := traceutils.NewTestServer(t, func(t pdata.Traces) {
+		tracesCh <- t
+	})
+
+	tracesCfgText := util.Untab(fmt.Sprintf(`
+configs:
+- name: default
+  receivers:
+    jaeger:
+      protocols:
+        thrift_compact:
+  remote_write:
+  	- endpoint: %s
+      insecure: true
+  batch:
+    timeout: 100ms
+    send_batch_size: 1
+	`, tracesAddr))
+
+	var cfg Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(tracesCfgText), &cfg))
+
+	traces, err := New(nil, nil, nil, prometheus.NewRegistry(), cfg, logrus.InfoLevel, logging.Format{})
+	require.NoError(t, err)
+	t.Cleanup(traces.Stop)
+
+	require.Nil(t, traces.Instance("missing"))
+	inst := traces.Instance("default")
+	require.NotNil(t, inst)
+
+	td := pdata.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().InstrumentationLibrarySpans().AppendEmpty().Spans().AppendEmpty()
+	span.SetTraceID(pdata.NewTraceID([16]byte{1}))
+	span.SetSpanID(pdata.NewSpanID([8]byte{1}))
+	span.SetName("pushed-span")
+	require.NoError(t, inst.ConsumeTraces(context.Background(), td))
+
+	select {
+	case <-time.After(30 * time.Second):
+		require.Fail(t, "failed to receive a span after 30 seconds")
+	case tr := <-tracesCh:
+		require.Equal(t, 1, tr.SpanCount())
+	}
+}
+
 func TestTraceWithSpanmetricsConfig(t *testing.T) {
 	tracesCfgText := util.Untab(`
 configs:
@@ -93,7 +142,7 @@ configs:
 	var loggingLevel logging.Level
 	require.NoError(t, loggingLevel.Set("debug"))
 
-	traces, err := New(nil, nil, prometheus.NewRegistry(), cfg, logrus.InfoLevel, logging.Format{})
+	traces, err := New(nil, nil, nil, prometheus.NewRegistry(), cfg, logrus.InfoLevel, logging.Format{})
 	require.NoError(t, err)
 	t.Cleanup(traces.Stop)
 }
@@ -127,7 +176,7 @@ configs:
 	err := dec.Decode(&cfg)
 	require.NoError(t, err)
 
-	traces, err := New(nil, nil, prometheus.NewRegistry(), cfg, logrus.DebugLevel, logging.Format{})
+	traces, err := New(nil, nil, nil, prometheus.NewRegistry(), cfg, logrus.DebugLevel, logging.Format{})
 	require.NoError(t, err)
 	t.Cleanup(traces.Stop)
 
@@ -169,6 +218,118 @@ configs:
 	}
 }
 
+func TestTrace_ClusterResolver(t *testing.T) {
+	tracesCfgText := util.Untab(`
+configs:
+- name: default
+  receivers:
+    jaeger:
+      protocols:
+        thrift_compact:
+  remote_write:
+  	- endpoint: 127.0.0.1:80
+  	  insecure: true
+  load_balancing:
+    receiver_port: 0
+    exporter:
+      insecure: true
+    resolver:
+      cluster:
+        refresh_interval: 50ms
+`)
+
+	var cfg Config
+	dec := yaml.NewDecoder(strings.NewReader(tracesCfgText))
+	dec.SetStrict(true)
+	err := dec.Decode(&cfg)
+	require.NoError(t, err)
+
+	peers := &mockPeerProvider{peers: []string{"10.0.0.1:12345"}}
+	traces, err := New(nil, nil, peers, prometheus.NewRegistry(), cfg, logrus.InfoLevel, logging.Format{})
+	require.NoError(t, err)
+	t.Cleanup(traces.Stop)
+
+	clusterPeers := func() []string {
+		i := traces.instances["default"]
+		i.mut.Lock()
+		defer i.mut.Unlock()
+		return i.clusterPeers
+	}
+	require.Equal(t, []string{"10.0.0.1:12345"}, clusterPeers())
+
+	peers.Set([]string{"10.0.0.1:12345", "10.0.0.2:12345"})
+	require.Eventually(t, func() bool {
+		return len(clusterPeers()) == 2
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestTrace_ClusterResolver_EmptyCluster(t *testing.T) {
+	tracesCfgText := util.Untab(`
+configs:
+- name: default
+  receivers:
+    jaeger:
+      protocols:
+        thrift_compact:
+  remote_write:
+  	- endpoint: 127.0.0.1:80
+  	  insecure: true
+  load_balancing:
+    receiver_port: 0
+    exporter:
+      insecure: true
+    resolver:
+      cluster:
+        refresh_interval: 1h
+`)
+
+	var cfg Config
+	dec := yaml.NewDecoder(strings.NewReader(tracesCfgText))
+	dec.SetStrict(true)
+	err := dec.Decode(&cfg)
+	require.NoError(t, err)
+
+	oldRetry := clusterPeersRetryInterval
+	clusterPeersRetryInterval = 10 * time.Millisecond
+	t.Cleanup(func() { clusterPeersRetryInterval = oldRetry })
+
+	// The instance is created right away without a pipeline while the
+	// cluster is empty, and builds it once agents join the cluster.
+	peers := &mockPeerProvider{}
+	start := time.Now()
+	traces, err := New(nil, nil, peers, prometheus.NewRegistry(), cfg, logrus.InfoLevel, logging.Format{})
+	require.NoError(t, err)
+	t.Cleanup(traces.Stop)
+	require.Less(t, time.Since(start), time.Second, "creating the instance must not wait for agents to join")
+
+	inst := traces.instances["default"]
+	_, err = inst.Status()
+	require.Error(t, err)
+
+	peers.Set([]string{"10.0.0.1:12345"})
+	require.Eventually(t, func() bool {
+		status, err := inst.Status()
+		return err == nil && len(status.Pipelines) > 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+type mockPeerProvider struct {
+	mut   sync.Mutex
+	peers []string
+}
+
+func (m *mockPeerProvider) Set(peers []string) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.peers = peers
+}
+
+func (m *mockPeerProvider) ClusterPeers() ([]string, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.peers, nil
+}
+
 func testJaegerTracer(t *testing.T) opentracing.Tracer {
 	t.Helper()
 
@@ -0,0 +1,75 @@
+package util
+
+import (
+	"sort"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewZapLogger returns a *zap.Logger which writes all of its log lines to l.
+// It is used to pass a go-kit logger to OpenTelemetry Collector components.
+func NewZapLogger(l log.Logger) *zap.Logger {
+	return zap.New(&zapCore{l: l})
+}
+
+// zapCore implements zapcore.Core by forwarding entries to a go-kit logger.
+// Leveled filtering is left to l.
+type zapCore struct {
+	l      log.Logger
+	fields []zapcore.Field
+}
+
+func (c *zapCore) Enabled(zapcore.Level) bool { return true }
+
+func (c *zapCore) With(fields []zapcore.Field) zapcore.Core {
+	return &zapCore{
+		l:      c.l,
+		fields: append(append([]zapcore.Field{}, c.fields...), fields...),
+	}
+}
+
+func (c *zapCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return ce.AddCore(e, c)
+}
+
+func (c *zapCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	keyvals := make([]interface{}, 0, 2+len(enc.Fields)*2)
+	keyvals = append(keyvals, "msg", e.Message)
+	if e.LoggerName != "" {
+		keyvals = append(keyvals, "logger", e.LoggerName)
+	}
+	keys := make([]string, 0, len(enc.Fields))
+	for k := range enc.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		keyvals = append(keyvals, k, enc.Fields[k])
+	}
+
+	var logger log.Logger
+	switch {
+	case e.Level <= zapcore.DebugLevel:
+		logger = level.Debug(c.l)
+	case e.Level == zapcore.InfoLevel:
+		logger = level.Info(c.l)
+	case e.Level == zapcore.WarnLevel:
+		logger = level.Warn(c.l)
+	default:
+		logger = level.Error(c.l)
+	}
+	return logger.Log(keyvals...)
+}
+
+func (c *zapCore) Sync() error { return nil }